@@ -1,11 +1,12 @@
 package weSubDatabase
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"strconv"
-	"sync"
-	"time"
+	"strings"
 )
 
 // ===============
@@ -18,8 +19,10 @@ import (
 //	Debug		*log.Logger	"调试输出"
 //	options		[]IsShowPrintO	"配置"
 //		IsShowPrint	bool		"是否输出到控制台"
+//		Context		context.Context		"调用方的上下文"
+//		AddedRows	*[]AddedRow		"接收每一行的插入结果, ID 来自 encryptedKey"
 //	return 1	[]int64		"插入的行数"
-//	return 2	[]error		"错误信息"
+//	return 2	Errors		"错误信息"
 //
 // ===============
 //
@@ -31,17 +34,26 @@ import (
 //	Debug		*log.Logger	"debug output"
 //	options		[]IsShowPrintO	"Configuration"
 //		IsShowPrint	bool		"Whether to output to the console"
+//		Context		context.Context		"Context of the caller"
+//		AddedRows	*[]AddedRow		"Receives the insert result of every row,
+//								 	IDs come from encryptedKey"
 //	return 1	[]int64		"Number of rows inserted"
-//	return 2	[]error		"Error message"
-func (s *Setting) AddForPrimary(table string, encryptedKey []string, keys []string, value [][]string, Debug *log.Logger, options ...IsShowPrintO) ([]int64, []error) {
+//	return 2	Errors		"Error message"
+func (s *Setting) AddForPrimary(table string, encryptedKey []string, keys []string, value [][]string, Debug *log.Logger, options ...IsShowPrintO) (inserts []int64, errs Errors) {
 	option := &Option{
 		IsShowPrint: false,
 	}
 	for _, o := range options {
 		o(option)
 	}
+	logger := s.logger(Debug, option.IsShowPrint)
+	ctx, span := s.startCall(option, "AddForPrimary", table)
+	defer func() { endSpan(span, errs.Err()) }()
 	if len(encryptedKey) != len(value) {
-		return nil, []error{fmt.Errorf("the `encryptedKey` and `value` lengths are inconsistent")}
+		return nil, Errors{fmt.Errorf("%w: the `encryptedKey` and `value` lengths are inconsistent", ErrInvalidArgument)}
+	}
+	if err := checkValues(keys, value); err != nil {
+		return nil, Errors{err}
 	}
 
 	sortList := [][]int{}
@@ -49,101 +61,24 @@ func (s *Setting) AddForPrimary(table string, encryptedKey []string, keys []stri
 		sortList = append(sortList, []int{})
 	}
 
+	// 主键由调用方给出, 插入结果不需要 LastInsertId
+	// The primary keys are given by the caller, the insert results do not need LastInsertId
+	known := make([]AddedRow, len(encryptedKey))
 	for i, v := range encryptedKey {
-		_, dbIstr, err := s.SEKey.Decrypt(v)
+		idStr, dbIstr, err := s.SEKey.Decrypt(v)
 		if err != nil {
-			return nil, []error{fmt.Errorf("decrype err: %v", err)}
+			return nil, Errors{fmt.Errorf("%w: encryptedKey[%d]: %v", ErrInvalidKey, i, err)}
 		}
 		dbI, err := strconv.Atoi(dbIstr)
-		if err != nil {
-			return nil, []error{fmt.Errorf("dbI string to int err: %v", err)}
+		if err != nil || dbI < 0 || dbI >= len(s.SqlConfigs) {
+			return nil, Errors{fmt.Errorf("%w: encryptedKey[%d] has no database", ErrInvalidKey, i)}
 		}
 		sortList[dbI] = append(sortList[dbI], i)
+		known[i].ID, _ = strconv.ParseInt(idStr, 10, 64)
+		known[i].EncryptedID = v
 	}
 
-	sqlKeys := ""
-	sqlValList := []string{}
-	var isContinues []bool
-	for i := 0; i < len(s.ConnectFailTime); i++ {
-		isContinues = append(isContinues, s.IsRetryConnect(i))
-	}
-	var (
-		inserts []int64
-		errs    []error
-	)
-	for i := 0; i < len(isContinues); i++ {
-		inserts = append(inserts, -1)
-		errs = append(errs, nil)
-	}
-	for _, v := range keys {
-		if sqlKeys != "" {
-			sqlKeys += ","
-		}
-		sqlKeys += "`" + v + "`"
-	}
-
-	for _, v := range sortList {
-		sqlval := ""
-		for _, vv := range v {
-			sqlv := ""
-			for _, vvv := range value[vv] {
-				if sqlv != "" {
-					sqlv += ","
-				}
-				sqlv += "'" + vvv + "'"
-			}
-			if sqlval != "" {
-				sqlval += ","
-			}
-			sqlval += "(" + sqlv + ")"
-		}
-		sqlValList = append(sqlValList, sqlval)
-	}
-
-	var wg *sync.WaitGroup = new(sync.WaitGroup)
-	for i := 0; i < len(sqlValList); i++ {
-		if sqlValList[i] == "(" {
-			continue
-		}
-		if !isContinues[i] {
-			continue
-		}
-		wg.Add(1)
-		sqlStr := fmt.Sprintf("INSERT INTO `%s` (%s) VALUES %s", table, sqlKeys, sqlValList[i])
-		reInsert := make(chan int64)
-		reErr := make(chan error)
-		go s.go_add(i, sqlStr, reInsert, reErr, option.IsShowPrint, Debug)
-		rI := false
-		rE := false
-		for {
-			select {
-			case insert := <-reInsert:
-				if !rI {
-					inserts[i] = insert
-					rI = true
-					close(reInsert)
-				}
-			case err := <-reErr:
-				if !rE {
-					errs[i] = err
-					rE = true
-					close(reErr)
-				}
-			}
-			if rI && rE {
-				break
-			}
-			time.Sleep(time.Millisecond * 50)
-		}
-		wg.Done()
-	}
-	wg.Wait()
-	for _, v := range errs {
-		if v != nil {
-			return inserts, errs
-		}
-	}
-	return inserts, nil
+	return s.addShards(ctx, table, keys, value, nil, sortList, known, option, logger)
 }
 
 // ===============
@@ -155,8 +90,11 @@ func (s *Setting) AddForPrimary(table string, encryptedKey []string, keys []stri
 //	Debug		*log.Logger	"调试输出"
 //	options		[]IsShowPrintO	"配置"
 //		IsShowPrint	bool		"是否输出到控制台"
+//		Context		context.Context		"调用方的上下文"
+//		AddedRows	*[]AddedRow		"接收每一行的插入结果"
+//		IDFallback	IDFallback		"多行插入的ID可能不连续时的处理方式"
 //	return 1	[]int64		"插入的行数"
-//	return 2	[]error		"错误信息"
+//	return 2	Errors		"错误信息"
 //
 // ===============
 //
@@ -167,133 +105,343 @@ func (s *Setting) AddForPrimary(table string, encryptedKey []string, keys []stri
 //	Debug		*log.Logger	"debug output"
 //	options		[]IsShowPrintO	"Configuration"
 //		IsShowPrint	bool		"Whether to output to the console"
+//		Context		context.Context		"Context of the caller"
+//		AddedRows	*[]AddedRow		"Receives the insert result of every row"
+//		IDFallback	IDFallback		"What to do when the IDs of a
+//								 	multi-row insert may not be consecutive"
 //	return 1	[]int64		"Number of rows inserted"
-//	return 2	[]error		"Error message"
-func (s *Setting) Add(table string, keys []string, values [][]string, Debug *log.Logger, options ...IsShowPrintO) ([]int64, []error) {
+//	return 2	Errors		"Error message"
+func (s *Setting) Add(table string, keys []string, values [][]string, Debug *log.Logger, options ...IsShowPrintO) (inserts []int64, errs Errors) {
 	option := &Option{
 		IsShowPrint: false,
 	}
 	for _, o := range options {
 		o(option)
 	}
-	sqlKeys := ""
-	sqlValList := []string{}
+	logger := s.logger(Debug, option.IsShowPrint)
+	ctx, span := s.startCall(option, "Add", table)
+	defer func() { endSpan(span, errs.Err()) }()
+	if err := checkValues(keys, values); err != nil {
+		return nil, Errors{err}
+	}
 	var isContinues []bool
+	isAnyContinue := false
 	for i := 0; i < len(s.ConnectFailTime); i++ {
 		isContinues = append(isContinues, s.IsRetryConnect(i))
+		isAnyContinue = isAnyContinue || isContinues[i]
 	}
-	var (
-		inserts []int64
-		errs    []error
-	)
-	for i := 0; i < len(isContinues); i++ {
-		inserts = append(inserts, -1)
-		errs = append(errs, nil)
+	if !isAnyContinue && len(values) > 0 {
+		return nil, Errors{fmt.Errorf("%w: no database can be connected", ErrShardUnavailable)}
 	}
+	sortList := make([][]int, len(s.SqlConfigs))
 	for i := 0; i < len(values); i++ {
-		val := values[i]
-		if len(keys) != len(val) {
-			return nil, []error{fmt.Errorf("values[%d]与keys 对象数目不一致", i)}
-		}
-		sqlVal := ""
-		for i := 0; i < len(val); i++ {
-			if errStr := CheckString(val[i]); len(errStr) > 0 {
-				errs = append(errs, fmt.Errorf("SQL injection: %s ==> %s", val[i], errStr))
-				continue
-			}
-			if sqlVal != "" {
-				sqlVal += ","
-			}
-			sqlVal += "'" + val[i] + "'"
-		}
+		sqlI := s.nextShard(isContinues)
+		sortList[sqlI] = append(sortList[sqlI], i)
+	}
+	return s.addShards(ctx, table, keys, values, nil, sortList, nil, option, logger)
+}
+
+// 轮流选择下一个可以连接的数据库, isContinues 中至少要有一个 true
+//
+// Pick the next database that can be connected in turn, isContinues must hold at least one true
+func (s *Setting) nextShard(isContinues []bool) int {
+	for {
 		sqlI := s.NextDBID
 		s.NextDBID++
 		if s.NextDBID >= s.DBMaxNum {
 			s.NextDBID = 0
 		}
-		if !isContinues[sqlI] {
-			i--
-			continue
-		}
-		if sqlI >= len(sqlValList) {
-			temp := sqlI - len(sqlValList) + 1
-			for i := 0; i < temp; i++ {
-				sqlValList = append(sqlValList, "(")
-			}
-		}
-		if sqlI <= s.DBMaxNum && sqlValList[sqlI] != "(" {
-			sqlValList[sqlI] += ",("
+		if isContinues[sqlI] {
+			return sqlI
 		}
-		sqlValList[sqlI] += sqlVal + ")"
 	}
-	for i := 0; i < len(keys); i++ {
+}
+
+// ===============
+//
+//	按 InsertChunkRows 和 InsertChunkBytes 分批向各数据库插入
+//	ctx		context.Context	"父 span 的上下文"
+//	table		string		"表名"
+//	keys		[]string	"键名"
+//	values		[][]string	"值"
+//	nulls		[][]bool	"与 values 对应, 为 true 的值绑定为 NULL, 可以为 nil"
+//	sortList	[][]int		"每个数据库插入的行在 values 中的位置"
+//	known		[]AddedRow	"调用方已知的每一行的主键, 为 nil 时由 LastInsertId 计算"
+//	option		*Option		"配置, AddedRows 不为 nil 时写入每一行的结果"
+//	logger		*slog.Logger	"日志对象"
+//	return 1	[]int64		"每个数据库第一批的 LastInsertId, 未插入的数据库为 -1"
+//	return 2	Errors		"错误信息"
+//
+// ===============
+//
+//	Insert into each database in chunks of InsertChunkRows and InsertChunkBytes
+//	ctx		context.Context	"Context of the parent span"
+//	table		string		"Table name"
+//	keys		[]string	"Key names"
+//	values		[][]string	"Values"
+//	nulls		[][]bool	"Matching values, the values that are true
+//					 	are bound as NULL, can be nil"
+//	sortList	[][]int		"Location in values of the rows inserted
+//					 	into each database"
+//	known		[]AddedRow	"Primary key of every row known by the caller,
+//					 	computed from LastInsertId when nil"
+//	option		*Option		"Configuration, the result of every row is
+//					 	written when AddedRows is not nil"
+//	logger		*slog.Logger	"Logger"
+//	return 1	[]int64		"LastInsertId of the first chunk of each
+//					 	database, -1 for databases not inserted into"
+//	return 2	Errors		"Error message"
+func (s *Setting) addShards(ctx context.Context, table string, keys []string, values [][]string, nulls [][]bool, sortList [][]int, known []AddedRow, option *Option, logger *slog.Logger) (inserts []int64, errs Errors) {
+	sqlKeys := ""
+	for _, v := range keys {
 		if sqlKeys != "" {
 			sqlKeys += ","
 		}
-		sqlKeys += "`" + keys[i] + "`"
+		sqlKeys += "`" + v + "`"
 	}
-
-	var wg *sync.WaitGroup = new(sync.WaitGroup)
-	for i := 0; i < len(sqlValList); i++ {
-		if sqlValList[i] == "(" {
+	rowSQL := "(" + placeholders(len(keys)) + ")"
+	added := make([]AddedRow, len(values))
+	for i := range added {
+		if known != nil {
+			added[i] = known[i]
+		}
+		added[i].Shard = -1
+	}
+	meta := stmtMeta{op: "add", table: table}
+	for i := 0; i < len(s.SqlConfigs); i++ {
+		inserts = append(inserts, -1)
+	}
+	for i, items := range sortList {
+		if len(items) == 0 {
 			continue
 		}
-		if !isContinues[i] {
+		for _, item := range items {
+			added[item].Shard = i
+		}
+		if !s.IsRetryConnect(i) {
+			err := s.shardError(i, "", ErrShardUnavailable)
+			errs = append(errs, err)
+			for _, item := range items {
+				added[item].Err = err
+			}
 			continue
 		}
-		wg.Add(1)
-		sqlStr := fmt.Sprintf("INSERT INTO `%s` (%s) VALUES %s", table, sqlKeys, sqlValList[i])
-		reInsert := make(chan int64)
-		reErr := make(chan error)
-		go s.go_add(i, sqlStr, reInsert, reErr, option.IsShowPrint, Debug)
-		rI := false
-		rE := false
-		for {
-			select {
-			case insert := <-reInsert:
-				if !rI {
-					inserts[i] = insert
-					rI = true
-					close(reInsert)
+		chunks := chunkRows(items, values, s.InsertChunkRows, s.InsertChunkBytes)
+		autoInc := autoIncrement{lockMode: 1, step: 1}
+		if option.AddedRows != nil && known == nil {
+			var err error
+			if autoInc, err = s.autoIncrement(ctx, i, logger); err != nil {
+				logger.Warn("mysql auto increment settings unknown, IDs may not be consecutive", "shard", i, "error", err)
+				autoInc = autoIncrement{lockMode: -1, step: 1}
+			}
+			if !autoInc.consecutive() && option.IDFallback == IDFallbackSingleRow {
+				chunks = chunkRows(items, values, 1, 0)
+			}
+		}
+		for c, chunk := range chunks {
+			sqlStr := fmt.Sprintf("INSERT INTO `%s` (%s) VALUES %s", table, sqlKeys, strings.TrimSuffix(strings.Repeat(rowSQL+",", len(chunk)), ","))
+			args := make([]interface{}, 0, len(chunk)*len(keys))
+			for _, item := range chunk {
+				for k, v := range values[item] {
+					if nulls != nil && nulls[item][k] {
+						args = append(args, nil)
+					} else {
+						args = append(args, v)
+					}
 				}
-			case err := <-reErr:
-				if !rE {
-					errs[i] = err
-					rE = true
-					close(reErr)
+			}
+			reInsert := make(chan int64)
+			reErr := make(chan error)
+			go s.go_add(ctx, i, meta, sqlStr, args, reInsert, reErr, logger)
+			insert := <-reInsert
+			if err := <-reErr; err != nil {
+				errs = append(errs, err)
+				for _, item := range chunk {
+					added[item].Err = err
 				}
+				continue
+			}
+			if c == 0 {
+				inserts[i] = insert
+			}
+			if known != nil {
+				continue
 			}
-			if rI && rE {
-				break
+			for k, item := range chunk {
+				if k > 0 && !autoInc.consecutive() {
+					break
+				}
+				added[item].setID(s, insert, int64(k)*autoInc.step)
 			}
-			time.Sleep(time.Millisecond * 50)
 		}
-		wg.Done()
 	}
-	wg.Wait()
-	for _, v := range errs {
-		if v != nil {
-			return inserts, errs
-		}
+	if option.AddedRows != nil {
+		*option.AddedRows = added
+	}
+	if len(errs) > 0 {
+		return inserts, errs
 	}
 	return inserts, nil
 }
 
-func (s *Setting) go_add(i int, sqlStr string, reInsert chan<- int64, reErr chan<- error, IsShowPrint bool, Debug *log.Logger) {
-	mI, err := s.MysqlIsRun(i, OLIsShowPrint(IsShowPrint))
+func (s *Setting) go_add(ctx context.Context, i int, meta stmtMeta, sqlStr string, args []interface{}, reInsert chan<- int64, reErr chan<- error, logger *slog.Logger) {
+	ctx, span := s.startStmt(ctx, i, meta, sqlStr)
+	mI, err := s.MysqlIsRun(i, olLogger(logger))
 	if err != nil {
+		endSpan(span, err)
 		s.MysqlClose(mI)
 		reInsert <- -1
-		reErr <- err
+		reErr <- s.shardError(i, "", err)
 		return
 	}
-	lastInsertId, _, err := s.MySQLDB[mI].ExecCMD(sqlStr, Debug, OIsShowPrint(IsShowPrint))
-	s.MysqlClose(mI, OIsShowPrint(IsShowPrint))
+	db := s.MySQLDB[mI]
+	stmt := db.statement(ctx, meta, sqlStr, args, false)
+	err = db.run(stmt, s.observeHook(logger))
+	lastInsertId := stmt.LastInsertId
+	endSpan(span, err)
+	s.reportQuery(db, err)
+	s.MysqlClose(mI, IsShowPrintO(olLogger(logger)))
 	if err != nil {
 		reInsert <- lastInsertId
-		reErr <- err
+		reErr <- s.shardError(i, sqlStr, err)
 		return
 	}
+	s.markWrite(i)
 	reInsert <- lastInsertId
 	reErr <- err
 }
+
+// ===============
+//
+//	检查每一行的值与键名数目一致
+//	值作为参数绑定, 不需要 CheckString 检查
+//	keys		[]string	"键名"
+//	values		[][]string	"值"
+//	return		error		"错误信息"
+//
+// ===============
+//
+//	Check that every row has as many values as keys
+//	Values are bound as arguments and need no CheckString check
+//	keys		[]string	"key name"
+//	values		[][]string	"value"
+//	return		error		"Error message"
+func checkValues(keys []string, values [][]string) error {
+	for i, val := range values {
+		if len(keys) != len(val) {
+			return fmt.Errorf("%w: values[%d] and keys have different lengths", ErrInvalidArgument, i)
+		}
+	}
+	return nil
+}
+
+// Add 和 AddForPrimary 中一行的插入结果
+//
+// Insert result of one row of Add and AddForPrimary
+type AddedRow struct {
+	//	插入的数据库在配置中的位置, -1 为没有分配数据库
+	//
+	//	Location of the database inserted into in the configuration, -1 when
+	//	no database was assigned
+	Shard int
+	//	自增主键, 0 为未知
+	//
+	//	Auto increment primary key, 0 when unknown
+	ID int64
+	//	用 SEKey 加密后的主键, ID 未知或没有 SEKey 时为空字符串
+	//
+	//	Primary key encrypted with SEKey, empty when ID is unknown or there is no SEKey
+	EncryptedID string
+	//	插入这一行的错误信息
+	//
+	//	Error message of inserting this row
+	Err error
+}
+
+// ===============
+//
+//	接收 Add 和 AddForPrimary 每一行的插入结果, 顺序与 values 相同
+//	Add 的 ID 由 LastInsertId 计算, 会先读取各数据库的 innodb_autoinc_lock_mode,
+//	为 2 时多行插入的ID可能不连续, 按 IDFallback 处理
+//	AddForPrimary 的 ID 和 EncryptedID 来自 encryptedKey
+//	AddedRows	*[]AddedRow	"插入结束后写入每一行的结果"
+//
+// ===============
+//
+//	Receive the insert result of every row of Add and AddForPrimary, in the
+//	order of values
+//	IDs of Add are computed from LastInsertId, innodb_autoinc_lock_mode of
+//	each database is read first, when it is 2 the IDs of a multi-row insert
+//	may not be consecutive and IDFallback applies
+//	ID and EncryptedID of AddForPrimary come from encryptedKey
+//	AddedRows	*[]AddedRow	"The result of every row is written after
+//									the insert"
+func OAddedRows(AddedRows *[]AddedRow) IsShowPrintO {
+	return func(o *Option) {
+		o.AddedRows = AddedRows
+	}
+}
+
+// 多行 INSERT 的 LastInsertId 为第一行的ID, innodb_autoinc_lock_mode 为 0 或 1 时
+// 之后的行依次加 auto_increment_increment
+//
+// LastInsertId of a multi-row INSERT is the ID of the first row, when
+// innodb_autoinc_lock_mode is 0 or 1 the following rows add auto_increment_increment each
+func (r *AddedRow) setID(s *Setting, lastInsertId int64, offset int64) {
+	if lastInsertId <= 0 {
+		return
+	}
+	r.ID = lastInsertId + offset
+	if s.SEKey != nil {
+		r.EncryptedID = s.SEKey.Encrypt(strconv.FormatInt(r.ID, 10), strconv.Itoa(r.Shard))
+	}
+}
+
+// ===============
+//
+//	把一个数据库的行分批, 每批不超过 maxRows 行和 maxBytes 字节的值
+//	单行超过 maxBytes 时单独成批, 每批的参数不超过 maxPlaceholders
+//	items		[]int		"行在 values 中的位置"
+//	values		[][]string	"值"
+//	maxRows		int		"每批的最大行数, 0 为只按参数数目限制"
+//	maxBytes	int		"每批的值的最大字节数, 0 为不限制"
+//	return		[][]int		"每批的行在 values 中的位置"
+//
+// ===============
+//
+//	Split the rows of one database into chunks of at most maxRows rows and
+//	maxBytes bytes of values
+//	A row above maxBytes is a chunk on its own, each chunk has at most
+//	maxPlaceholders arguments
+//	items		[]int		"Location of the rows in values"
+//	values		[][]string	"Values"
+//	maxRows		int		"Maximum rows per chunk, 0 is only limited by
+//					 	the number of arguments"
+//	maxBytes	int		"Maximum bytes of values per chunk, 0 is unlimited"
+//	return		[][]int		"Location in values of the rows of each chunk"
+func chunkRows(items []int, values [][]string, maxRows int, maxBytes int) [][]int {
+	if len(items) > 0 && len(values[items[0]]) > 0 {
+		if limit := maxPlaceholders / len(values[items[0]]); maxRows <= 0 || maxRows > limit {
+			maxRows = limit
+		}
+	}
+	chunks := [][]int{}
+	chunk := []int{}
+	size := 0
+	for _, item := range items {
+		rowSize := 0
+		for _, v := range values[item] {
+			rowSize += len(v)
+		}
+		if len(chunk) > 0 && ((maxRows > 0 && len(chunk) >= maxRows) || (maxBytes > 0 && size+rowSize > maxBytes)) {
+			chunks = append(chunks, chunk)
+			chunk, size = []int{}, 0
+		}
+		chunk = append(chunk, item)
+		size += rowSize
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
@@ -0,0 +1,595 @@
+package weSubDatabase
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 聚合函数
+//
+// Aggregate functions
+const (
+	AggCount = "COUNT"
+	AggSum   = "SUM"
+	AggMin   = "MIN"
+	AggMax   = "MAX"
+	AggAvg   = "AVG"
+)
+
+// 一个聚合列
+//
+// An aggregate column
+type Aggregate struct {
+	//	聚合函数, 如 AggCount, AggSum, AggMin, AggMax, AggAvg
+	//
+	//	Aggregate function, such as AggCount, AggSum, AggMin, AggMax, AggAvg
+	Func string
+	//	字段, COUNT 可以为 * 或空字符串
+	//
+	//	Column, can be * or empty for COUNT
+	Column string
+	//	结果中的字段名, 为空时为 "函数(字段)", 如 COUNT(*)
+	//
+	//	Column name in the result, "FUNC(column)" such as COUNT(*) when empty
+	As string
+}
+
+// 合并之后的过滤条件
+//
+// Filter applied after the merge
+type Having struct {
+	//	结果中的字段名
+	//
+	//	Column name in the result
+	Column string
+	//	比较运算符: =, !=, <>, >, >=, <, <=
+	//
+	//	Comparison operator: =, !=, <>, >, >=, <, <=
+	Op string
+	//	比较的值, 两边都是数字时按数字比较
+	//
+	//	Value to compare with, compared as numbers when both sides are numbers
+	Value string
+}
+
+// 一个聚合列在各数据库上的部分聚合
+//
+// Partial aggregates of one aggregate column on each database
+type aggPlan struct {
+	agg Aggregate
+	// 部分聚合的字段名, AVG 为 SUM 和 COUNT 两个
+	// Column names of the partial aggregates, SUM and COUNT for AVG
+	parts []string
+}
+
+// ===============
+//
+//	根据 *Setting 从数据库集中进行聚合查询
+//	各数据库执行部分聚合, AVG 改写为 SUM 和 COUNT, 合并后再计算
+//	HAVING, ORDER BY 和 LIMIT 在合并之后执行
+//	table		string			"表名"
+//	aggregates	[]Aggregate		"聚合列"
+//	groupBy		[]string		"分组字段, 可以为 nil"
+//	where		string			"查询条件"
+//	having		[]Having		"合并之后的过滤条件, 全部满足时保留"
+//	order		string			"合并之后的排序, 如 "total DESC,name ASC"
+//											字段为结果中的字段名"
+//	limit		string			"合并之后的分页, 如 "10" 或 "20,10"
+//											空字符串为不分页"
+//	Debug		*log.Logger		"调试日志对象"
+//	options		[]IsShowPrintO		"配置"
+//		IsShowPrint	bool			"是否输出到控制台"
+//		Context		context.Context		"调用方的上下文"
+//		IsReadPrimary	bool			"是否只从主库读取"
+//		PartialPolicy	PartialPolicy		"部分结果策略"
+//		Quorum		int			"法定数目"
+//		Report		*ShardReport		"接收各数据库的应答情况"
+//	return 1	[]map[string]string	"每组一行, 包含分组字段和聚合列"
+//	return 2	Errors			"错误信息"
+//
+// ===============
+//
+//	According to *Setting, run an aggregate query on the database set
+//	Each database runs partial aggregates, AVG is rewritten into SUM and
+//	COUNT, the merged values are then computed
+//	HAVING, ORDER BY and LIMIT are applied after the merge
+//	table		string			"Table name"
+//	aggregates	[]Aggregate		"Aggregate columns"
+//	groupBy		[]string		"Group by columns, can be nil"
+//	where		string			"Query condition"
+//	having		[]Having		"Filters after the merge, a group
+//											is kept when all are met"
+//	order		string			"Sorting after the merge, such as
+//											"total DESC,name ASC", the
+//											columns are result columns"
+//	limit		string			"Paging after the merge, such as
+//											"10" or "20,10", no paging
+//											when empty"
+//	Debug		*log.Logger		"Debug log object"
+//	options		[]IsShowPrintO		"Configuration"
+//		IsShowPrint	bool			"Whether to output to the
+//											console"
+//		Context		context.Context		"Context of the caller"
+//		IsReadPrimary	bool			"Whether to read only from
+//											the primary"
+//		PartialPolicy	PartialPolicy		"Partial result policy"
+//		Quorum		int			"Quorum"
+//		Report		*ShardReport		"Receives how each database
+//											answered"
+//	return 1	[]map[string]string	"One row per group with the group
+//											by columns and aggregate
+//											columns"
+//	return 2	Errors			"Error message"
+func (s *Setting) Aggregate(table string, aggregates []Aggregate, groupBy []string, where string, having []Having, order string, limit string, Debug *log.Logger, options ...IsShowPrintO) (groups []map[string]string, errs Errors) {
+	option := &Option{
+		IsShowPrint: false,
+	}
+	for _, o := range options {
+		o(option)
+	}
+	logger := s.logger(Debug, option.IsShowPrint)
+	ctx, span := s.startCall(option, "Aggregate", table)
+	defer func() { endSpan(span, errs.Err()) }()
+
+	sqlStr, plans, groupCols, err := aggregateSQL(table, aggregates, groupBy, s.aliveWhere(table, where, option))
+	if err != nil {
+		return nil, Errors{err}
+	}
+	columns := append([]string{}, groupCols...)
+	for _, p := range plans {
+		columns = append(columns, p.agg.As)
+	}
+	for _, h := range having {
+		if !containsString(columns, h.Column) {
+			return nil, Errors{fmt.Errorf("%w: having column %q is not in the result", ErrInvalidArgument, h.Column)}
+		}
+		if _, ok := compareOps[h.Op]; !ok {
+			return nil, Errors{fmt.Errorf("%w: having operator %q", ErrInvalidArgument, h.Op)}
+		}
+	}
+	orders, err := parseOrders(order, columns)
+	if err != nil {
+		return nil, Errors{err}
+	}
+	offset, count, err := parseLimit(limit)
+	if err != nil {
+		return nil, Errors{err}
+	}
+
+	isContinues, report := s.readableShards(nil)
+	if err := option.checkSkipped(report); err != nil {
+		return nil, Errors{err}
+	}
+	sqlStrs := make([]string, len(s.SqlConfigs))
+	for i := 0; i < len(s.SqlConfigs); i++ {
+		if isContinues[i] {
+			sqlStrs[i] = sqlStr
+		}
+	}
+	queryDatas, errs := s.queryShards(ctx, stmtMeta{op: "query", table: table}, sqlStrs, nil, report, option, logger)
+	if err := option.finishReport(report); err != nil {
+		return nil, append(errs, err)
+	}
+
+	groups = mergeAggregates(queryDatas, groupCols, plans)
+	groups = filterHaving(groups, having)
+	sortRows(groups, orders)
+	if offset >= len(groups) {
+		groups = []map[string]string{}
+	} else {
+		groups = groups[offset:]
+		if count >= 0 && count < len(groups) {
+			groups = groups[:count]
+		}
+	}
+	if len(errs) > 0 {
+		return groups, errs
+	}
+	return groups, nil
+}
+
+// ===============
+//
+//	生成各数据库执行的部分聚合SQL指令
+//	table		string		"表名"
+//	aggregates	[]Aggregate	"聚合列"
+//	groupBy		[]string	"分组字段"
+//	where		string		"查询条件"
+//	return 1	string		"SQL指令"
+//	return 2	[]aggPlan	"各聚合列的部分聚合"
+//	return 3	[]string	"去掉反引号的分组字段"
+//	return 4	error		"错误信息"
+//
+// ===============
+//
+//	Create the SQL instruction of the partial aggregates run on each database
+//	table		string		"Table name"
+//	aggregates	[]Aggregate	"Aggregate columns"
+//	groupBy		[]string	"Group by columns"
+//	where		string		"Query condition"
+//	return 1	string		"SQL instruction"
+//	return 2	[]aggPlan	"Partial aggregates of each aggregate column"
+//	return 3	[]string	"Group by columns without backticks"
+//	return 4	error		"Error message"
+func aggregateSQL(table string, aggregates []Aggregate, groupBy []string, where string) (string, []aggPlan, []string, error) {
+	if len(aggregates) == 0 {
+		return "", nil, nil, fmt.Errorf("%w: no aggregate column", ErrInvalidArgument)
+	}
+	var (
+		selects   []string
+		groupCols []string
+		plans     []aggPlan
+	)
+	for _, g := range groupBy {
+		g = strings.ReplaceAll(strings.TrimSpace(g), "`", "")
+		if !isIdentifier(g) {
+			return "", nil, nil, fmt.Errorf("%w: group by column %q", ErrInvalidArgument, g)
+		}
+		groupCols = append(groupCols, g)
+		selects = append(selects, "`"+g+"`")
+	}
+	for i, agg := range aggregates {
+		agg.Func = strings.ToUpper(strings.TrimSpace(agg.Func))
+		column := strings.ReplaceAll(strings.TrimSpace(agg.Column), "`", "")
+		if column == "" && agg.Func == AggCount {
+			column = "*"
+		}
+		if (column == "*" && agg.Func != AggCount) || (column != "*" && !isIdentifier(column)) {
+			return "", nil, nil, fmt.Errorf("%w: aggregate column %q", ErrInvalidArgument, agg.Column)
+		}
+		quoted := column
+		if column != "*" {
+			quoted = "`" + column + "`"
+		}
+		if agg.As == "" {
+			agg.As = agg.Func + "(" + column + ")"
+		}
+		p := aggPlan{agg: agg}
+		switch agg.Func {
+		case AggCount, AggSum, AggMin, AggMax:
+			p.parts = []string{fmt.Sprintf("agg%d", i)}
+			selects = append(selects, agg.Func+"("+quoted+") AS `"+p.parts[0]+"`")
+		case AggAvg:
+			p.parts = []string{fmt.Sprintf("agg%d_sum", i), fmt.Sprintf("agg%d_count", i)}
+			selects = append(selects,
+				"SUM("+quoted+") AS `"+p.parts[0]+"`",
+				"COUNT("+quoted+") AS `"+p.parts[1]+"`",
+			)
+		default:
+			return "", nil, nil, fmt.Errorf("%w: aggregate function %q", ErrInvalidArgument, agg.Func)
+		}
+		plans = append(plans, p)
+	}
+	sqlStr := "SELECT " + strings.Join(selects, ",") + " FROM `" + table + "`"
+	if where != "" {
+		sqlStr += " WHERE " + where
+	}
+	if len(groupCols) > 0 {
+		sqlStr += " GROUP BY `" + strings.Join(groupCols, "`,`") + "`"
+	}
+	return sqlStr, plans, groupCols, nil
+}
+
+// 一组的合并状态
+//
+// Merge state of one group
+type aggState struct {
+	row    map[string]string
+	ints   []int64
+	floats []float64
+	isInt  []bool
+	counts []int64
+	values []string
+	isSet  []bool
+}
+
+// ===============
+//
+//	合并各数据库的部分聚合
+//	queryDatas	[]map[string]string	"各数据库的部分聚合"
+//	groupCols	[]string		"分组字段"
+//	plans		[]aggPlan		"各聚合列的部分聚合"
+//	return		[]map[string]string	"每组一行, 按首次出现的顺序"
+//
+// ===============
+//
+//	Merge the partial aggregates of each database
+//	queryDatas	[]map[string]string	"Partial aggregates of each database"
+//	groupCols	[]string		"Group by columns"
+//	plans		[]aggPlan		"Partial aggregates of each aggregate column"
+//	return		[]map[string]string	"One row per group, in order of first appearance"
+func mergeAggregates(queryDatas []map[string]string, groupCols []string, plans []aggPlan) []map[string]string {
+	var keys []string
+	states := map[string]*aggState{}
+	for _, qd := range queryDatas {
+		vals := make([]string, len(groupCols))
+		for i, g := range groupCols {
+			vals[i] = qd[g]
+		}
+		key := strings.Join(vals, "\x00")
+		st, ok := states[key]
+		if !ok {
+			st = &aggState{
+				row:    map[string]string{},
+				ints:   make([]int64, len(plans)),
+				floats: make([]float64, len(plans)),
+				isInt:  make([]bool, len(plans)),
+				counts: make([]int64, len(plans)),
+				values: make([]string, len(plans)),
+				isSet:  make([]bool, len(plans)),
+			}
+			for i, g := range groupCols {
+				st.row[g] = vals[i]
+			}
+			for i := range plans {
+				st.isInt[i] = true
+			}
+			states[key] = st
+			keys = append(keys, key)
+		}
+		for i, p := range plans {
+			v := qd[p.parts[0]]
+			switch p.agg.Func {
+			case AggCount:
+				n, _ := strconv.ParseInt(v, 10, 64)
+				st.ints[i] += n
+				st.isSet[i] = true
+			case AggSum, AggAvg:
+				if v == "" {
+					break
+				}
+				st.isSet[i] = true
+				if n, err := strconv.ParseInt(v, 10, 64); err == nil && st.isInt[i] {
+					st.ints[i] += n
+				} else if f, err := strconv.ParseFloat(v, 64); err == nil {
+					if st.isInt[i] {
+						st.floats[i] = float64(st.ints[i])
+						st.isInt[i] = false
+					}
+					st.floats[i] += f
+				}
+				if p.agg.Func == AggAvg {
+					n, _ := strconv.ParseInt(qd[p.parts[1]], 10, 64)
+					st.counts[i] += n
+				}
+			case AggMin, AggMax:
+				if v == "" {
+					break
+				}
+				c := compareValues(v, st.values[i])
+				if !st.isSet[i] || (p.agg.Func == AggMin && c < 0) || (p.agg.Func == AggMax && c > 0) {
+					st.values[i] = v
+					st.isSet[i] = true
+				}
+			}
+		}
+	}
+	groups := make([]map[string]string, 0, len(keys))
+	for _, key := range keys {
+		st := states[key]
+		for i, p := range plans {
+			v := ""
+			switch p.agg.Func {
+			case AggCount:
+				v = strconv.FormatInt(st.ints[i], 10)
+			case AggSum:
+				if st.isSet[i] {
+					v = formatSum(st, i)
+				}
+			case AggAvg:
+				if st.isSet[i] && st.counts[i] > 0 {
+					sum := st.floats[i]
+					if st.isInt[i] {
+						sum = float64(st.ints[i])
+					}
+					v = strconv.FormatFloat(sum/float64(st.counts[i]), 'f', -1, 64)
+				}
+			case AggMin, AggMax:
+				v = st.values[i]
+			}
+			st.row[p.agg.As] = v
+		}
+		groups = append(groups, st.row)
+	}
+	return groups
+}
+
+func formatSum(st *aggState, i int) string {
+	if st.isInt[i] {
+		return strconv.FormatInt(st.ints[i], 10)
+	}
+	return strconv.FormatFloat(st.floats[i], 'f', -1, 64)
+}
+
+// ===============
+//
+//	比较两个值, 两边都是数字时按数字比较, 否则按字符串比较
+//	a		string	"值 a"
+//	b		string	"值 b"
+//	return		int	"a < b 时为 -1, 相等时为 0, a > b 时为 1"
+//
+// ===============
+//
+//	Compare two values, as numbers when both sides are numbers, otherwise as strings
+//	a		string	"Value a"
+//	b		string	"Value b"
+//	return		int	"-1 when a < b, 0 when equal, 1 when a > b"
+func compareValues(a string, b string) int {
+	fa, errA := strconv.ParseFloat(a, 64)
+	fb, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a, b)
+}
+
+var compareOps = map[string]func(c int) bool{
+	"=":  func(c int) bool { return c == 0 },
+	"!=": func(c int) bool { return c != 0 },
+	"<>": func(c int) bool { return c != 0 },
+	">":  func(c int) bool { return c > 0 },
+	">=": func(c int) bool { return c >= 0 },
+	"<":  func(c int) bool { return c < 0 },
+	"<=": func(c int) bool { return c <= 0 },
+}
+
+func filterHaving(groups []map[string]string, having []Having) []map[string]string {
+	if len(having) == 0 {
+		return groups
+	}
+	kept := groups[:0]
+	for _, g := range groups {
+		ok := true
+		for _, h := range having {
+			if !compareOps[h.Op](compareValues(g[h.Column], h.Value)) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			kept = append(kept, g)
+		}
+	}
+	return kept
+}
+
+// 合并之后的一个排序字段
+//
+// One sort column after the merge
+type rowOrder struct {
+	column string
+	desc   bool
+}
+
+// ===============
+//
+//	解析合并之后的排序
+//	order		string		"排序, 如 "total DESC,name ASC""
+//	columns		[]string	"结果中的字段名, 为 nil 时不检查"
+//	return 1	[]rowOrder	"排序字段"
+//	return 2	error		"错误信息"
+//
+// ===============
+//
+//	Parse the sorting after the merge
+//	order		string		"Sorting, such as "total DESC,name ASC""
+//	columns		[]string	"Column names in the result, not checked when nil"
+//	return 1	[]rowOrder	"Sort columns"
+//	return 2	error		"Error message"
+func parseOrders(order string, columns []string) ([]rowOrder, error) {
+	var orders []rowOrder
+	if strings.TrimSpace(order) == "" {
+		return nil, nil
+	}
+	for _, item := range strings.Split(order, ",") {
+		fields := strings.Fields(item)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, fmt.Errorf("%w: %q, expected \"column ASC|DESC\"", ErrInvalidOrder, order)
+		}
+		o := rowOrder{column: strings.ReplaceAll(fields[0], "`", "")}
+		if len(fields) == 2 {
+			switch strings.ToUpper(fields[1]) {
+			case "ASC":
+			case "DESC":
+				o.desc = true
+			default:
+				return nil, fmt.Errorf("%w: %q, expected \"column ASC|DESC\"", ErrInvalidOrder, order)
+			}
+		}
+		if columns != nil && !containsString(columns, o.column) {
+			return nil, fmt.Errorf("%w: %q is not in the result", ErrInvalidOrder, o.column)
+		}
+		orders = append(orders, o)
+	}
+	return orders, nil
+}
+
+func sortRows(rows []map[string]string, orders []rowOrder) {
+	if len(orders) == 0 {
+		return
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, o := range orders {
+			c := compareValues(rows[i][o.column], rows[j][o.column])
+			if c == 0 {
+				continue
+			}
+			if o.desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+}
+
+// ===============
+//
+//	解析合并之后的分页
+//	limit		string	"分页, 如 "10" 或 "20,10", 空字符串为不分页"
+//	return 1	int	"跳过的行数"
+//	return 2	int	"行数, -1 为不限制"
+//	return 3	error	"错误信息"
+//
+// ===============
+//
+//	Parse the paging after the merge
+//	limit		string	"Paging, such as "10" or "20,10", no paging when empty"
+//	return 1	int	"Rows skipped"
+//	return 2	int	"Row count, -1 is unlimited"
+//	return 3	error	"Error message"
+func parseLimit(limit string) (int, int, error) {
+	limit = strings.ReplaceAll(limit, " ", "")
+	if limit == "" {
+		return 0, -1, nil
+	}
+	ls := strings.Split(limit, ",")
+	if len(ls) > 2 {
+		return 0, 0, fmt.Errorf("%w: limit %q", ErrInvalidArgument, limit)
+	}
+	nums := make([]int, len(ls))
+	for i, l := range ls {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("%w: limit %q", ErrInvalidArgument, limit)
+		}
+		nums[i] = n
+	}
+	if len(nums) == 1 {
+		return 0, nums[0], nil
+	}
+	return nums[0], nums[1], nil
+}
+
+func containsString(list []string, str string) bool {
+	for _, v := range list {
+		if v == str {
+			return true
+		}
+	}
+	return false
+}
+
+// 是否为字段名, 只允许字母, 数字, _ 和 $
+//
+// Whether it is a column name, only letters, digits, _ and $ are allowed
+func isIdentifier(str string) bool {
+	if str == "" {
+		return false
+	}
+	for _, c := range str {
+		if !(c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package weSubDatabase
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAggregateSQL(t *testing.T) {
+	sqlStr, plans, groupCols, err := aggregateSQL("data", []Aggregate{
+		{Func: AggCount},
+		{Func: "avg", Column: "`price`", As: "avg_price"},
+	}, []string{"`shop`"}, "`price` > 0")
+	if err != nil {
+		t.Error("aggregateSQL failed:", err)
+		return
+	}
+	want := "SELECT `shop`,COUNT(*) AS `agg0`,SUM(`price`) AS `agg1_sum`,COUNT(`price`) AS `agg1_count` FROM `data` WHERE `price` > 0 GROUP BY `shop`"
+	if sqlStr != want {
+		t.Errorf("aggregateSQL = %q, want %q", sqlStr, want)
+	}
+	if len(plans) != 2 || plans[0].agg.As != "COUNT(*)" || len(groupCols) != 1 || groupCols[0] != "shop" {
+		t.Error("plans:", plans, groupCols)
+	}
+	for _, aggs := range [][]Aggregate{nil, {{Func: AggSum, Column: "*"}}, {{Func: "MEDIAN", Column: "a"}}, {{Func: AggMax, Column: "a;drop"}}} {
+		if _, _, _, err := aggregateSQL("data", aggs, nil, ""); !errors.Is(err, ErrInvalidArgument) {
+			t.Error("invalid aggregates accepted:", aggs, err)
+		}
+	}
+}
+
+func TestMergeAggregates(t *testing.T) {
+	_, plans, groupCols, err := aggregateSQL("data", []Aggregate{
+		{Func: AggCount, As: "n"},
+		{Func: AggSum, Column: "price", As: "total"},
+		{Func: AggAvg, Column: "price", As: "avg"},
+		{Func: AggMin, Column: "price", As: "min"},
+		{Func: AggMax, Column: "name", As: "last"},
+	}, []string{"shop"}, "")
+	if err != nil {
+		t.Error("aggregateSQL failed:", err)
+		return
+	}
+	shardRows := []map[string]string{
+		{"db": "0", "shop": "a", "agg0": "2", "agg1": "10", "agg2_sum": "10", "agg2_count": "2", "agg3": "4", "agg4": "x"},
+		{"db": "0", "shop": "b", "agg0": "1", "agg1": "9", "agg2_sum": "9", "agg2_count": "1", "agg3": "9", "agg4": "y"},
+		{"db": "1", "shop": "a", "agg0": "1", "agg1": "2.5", "agg2_sum": "2.5", "agg2_count": "1", "agg3": "2.5", "agg4": "z"},
+		{"db": "1", "shop": "c", "agg0": "0", "agg1": "", "agg2_sum": "", "agg2_count": "0", "agg3": "", "agg4": ""},
+	}
+	groups := mergeAggregates(shardRows, groupCols, plans)
+	if len(groups) != 3 {
+		t.Error("groups:", groups)
+		return
+	}
+	a := groups[0]
+	if a["shop"] != "a" || a["n"] != "3" || a["total"] != "12.5" || a["avg"] != "4.166666666666667" || a["min"] != "2.5" || a["last"] != "z" {
+		t.Error("group a:", a)
+	}
+	if groups[1]["total"] != "9" || groups[2]["n"] != "0" || groups[2]["total"] != "" || groups[2]["avg"] != "" {
+		t.Error("groups b, c:", groups[1], groups[2])
+	}
+	if _, ok := a["db"]; ok {
+		t.Error("merged groups should not have the db field")
+	}
+
+	groups = filterHaving(groups, []Having{{Column: "n", Op: ">=", Value: "1"}})
+	orders, err := parseOrders("total DESC", []string{"shop", "n", "total"})
+	if err != nil {
+		t.Error("parseOrders failed:", err)
+		return
+	}
+	sortRows(groups, orders)
+	if len(groups) != 2 || groups[0]["shop"] != "a" || groups[1]["shop"] != "b" {
+		t.Error("having and order:", groups)
+	}
+	if _, err := parseOrders("price DESC", []string{"shop"}); !errors.Is(err, ErrInvalidOrder) {
+		t.Error("order on a missing column accepted:", err)
+	}
+	if offset, count, err := parseLimit("20, 10"); err != nil || offset != 20 || count != 10 {
+		t.Error("parseLimit:", offset, count, err)
+	}
+	if _, _, err := parseLimit("a"); !errors.Is(err, ErrInvalidArgument) {
+		t.Error("invalid limit accepted:", err)
+	}
+}
@@ -0,0 +1,109 @@
+package weSubDatabase
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+)
+
+// 多行插入的ID可能不连续时 (innodb_autoinc_lock_mode = 2) 的处理方式
+//
+// What to do when the IDs of a multi-row insert may not be consecutive
+// (innodb_autoinc_lock_mode = 2)
+type IDFallback int
+
+const (
+	//	每行单独插入, 每行的ID都来自 LastInsertId
+	//
+	//	Each row is inserted on its own, the ID of every row comes from LastInsertId
+	IDFallbackSingleRow IDFallback = iota
+	//	仍然多行插入, 只返回每批第一行的ID, 其他行的ID为 0
+	//
+	//	Rows are still inserted together, only the ID of the first row of each
+	//	chunk is returned, the others are 0
+	IDFallbackFirstRow
+)
+
+// 一个数据库的自增设置
+//
+// Auto increment settings of one database
+type autoIncrement struct {
+	//	innodb_autoinc_lock_mode, 0 traditional, 1 consecutive, 2 interleaved
+	lockMode int
+	//	auto_increment_increment
+	step int64
+}
+
+// 多行插入的ID是否连续
+//
+// Whether the IDs of a multi-row insert are consecutive
+func (a autoIncrement) consecutive() bool {
+	return a.lockMode == 0 || a.lockMode == 1
+}
+
+// ===============
+//
+//	设置多行插入的ID可能不连续时的处理方式, 用于 OAddedRows
+//	IDFallback	IDFallback	"处理方式"
+//
+// ===============
+//
+//	Set what to do when the IDs of a multi-row insert may not be consecutive,
+//	used with OAddedRows
+//	IDFallback	IDFallback	"What to do"
+func OIDFallback(IDFallback IDFallback) IsShowPrintO {
+	return func(o *Option) {
+		o.IDFallback = IDFallback
+	}
+}
+
+// ===============
+//
+//	读取数据库的自增设置, 成功后缓存
+//	ctx		context.Context	"父 span 的上下文"
+//	i		int		"数据库在配置中的位置"
+//	logger		*slog.Logger	"日志对象"
+//	return 1	autoIncrement	"自增设置"
+//	return 2	error		"错误信息"
+//
+// ===============
+//
+//	Read the auto increment settings of the database, cached once read
+//	ctx		context.Context	"Context of the parent span"
+//	i		int		"Location of the database in the configuration"
+//	logger		*slog.Logger	"Logger"
+//	return 1	autoIncrement	"Auto increment settings"
+//	return 2	error		"Error message"
+func (s *Setting) autoIncrement(ctx context.Context, i int, logger *slog.Logger) (autoIncrement, error) {
+	s.autoIncMu.Lock()
+	a, ok := s.autoInc[i]
+	s.autoIncMu.Unlock()
+	if ok {
+		return a, nil
+	}
+	reqd := make(chan []map[string]string)
+	reerr := make(chan error)
+	go s.go_query(ctx, i, stmtMeta{op: "query"}, "SELECT @@innodb_autoinc_lock_mode AS lock_mode, @@auto_increment_increment AS step", nil, reqd, reerr, true, logger)
+	qd := <-reqd
+	if err := <-reerr; err != nil {
+		return a, err
+	}
+	if len(qd) != 1 {
+		return a, fmt.Errorf("auto increment settings of database %d not found", i)
+	}
+	var err error
+	if a.lockMode, err = strconv.Atoi(qd[0]["lock_mode"]); err != nil {
+		return a, fmt.Errorf("innodb_autoinc_lock_mode: %w", err)
+	}
+	if a.step, err = strconv.ParseInt(qd[0]["step"], 10, 64); err != nil || a.step < 1 {
+		a.step = 1
+	}
+	s.autoIncMu.Lock()
+	if s.autoInc == nil {
+		s.autoInc = map[int]autoIncrement{}
+	}
+	s.autoInc[i] = a
+	s.autoIncMu.Unlock()
+	return a, nil
+}
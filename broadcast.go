@@ -0,0 +1,616 @@
+package weSubDatabase
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ===============
+//
+//	设置 VerifyBroadcast 作为标准的数据库, 默认为 0
+//	SourceShard	int	"数据库在配置中的位置"
+//
+// ===============
+//
+//	Set the database VerifyBroadcast treats as the source of truth, 0 by default
+//	SourceShard	int	"Location of the database in the configuration"
+func OSourceShard(SourceShard int) IsShowPrintO {
+	return func(o *Option) {
+		o.SourceShard = SourceShard
+	}
+}
+
+// ===============
+//
+//	VerifyBroadcast 用标准数据库的数据修复不一致的分块
+//	Repair		bool	"是否修复"
+//
+// ===============
+//
+//	VerifyBroadcast repairs the differing chunks from the source of truth
+//	Repair		bool	"Whether to repair"
+func ORepair(Repair bool) IsShowPrintO {
+	return func(o *Option) {
+		o.Repair = Repair
+	}
+}
+
+// 一个分块的行数和校验和
+//
+// Row count and checksum of one chunk
+type chunkSum struct {
+	count int64
+	crc   string
+}
+
+// 与标准数据库不一致的分块
+//
+// Chunk that differs from the source of truth
+type BroadcastChunk struct {
+	//	主键范围的开始, 包含
+	//
+	//	Start of the primary key range, inclusive
+	Start int64
+	//	主键范围的结束, 不包含
+	//
+	//	End of the primary key range, exclusive
+	End int64
+	//	每个数据库上的行数, 没有读取的数据库为 -1
+	//
+	//	Rows on each database, -1 for databases not read
+	Rows []int64
+	//	每个数据库上的校验和, 没有读取的数据库为空字符串
+	//
+	//	Checksum on each database, empty for databases not read
+	Checksums []string
+	//	与标准数据库不一致的数据库
+	//
+	//	Databases that differ from the source of truth
+	Shards []int
+	//	已修复的数据库
+	//
+	//	Databases that were repaired
+	Repaired []int
+}
+
+// 广播表的检查结果
+//
+// Result of checking a broadcast table
+type BroadcastReport struct {
+	//	表名
+	//
+	//	Table name
+	Table string
+	//	标准数据库
+	//
+	//	Source of truth
+	Source int
+	//	每个分块的主键范围大小
+	//
+	//	Size of the primary key range of each chunk
+	ChunkSize int64
+	//	成功读取校验和的数据库
+	//
+	//	Databases whose checksums were read
+	Checked []int
+	//	不一致的分块, 按主键排序
+	//
+	//	Differing chunks, sorted by primary key
+	Chunks []BroadcastChunk
+}
+
+// ===============
+//
+//	与标准数据库不一致的数据库
+//	return		[]int		"数据库在配置中的位置"
+//
+// ===============
+//
+//	Databases that differ from the source of truth
+//	return		[]int		"Location of the databases in the configuration"
+func (r *BroadcastReport) Differs() []int {
+	differs := []int{}
+	for _, chunk := range r.Chunks {
+		for _, i := range chunk.Shards {
+			if !containsInt(differs, i) {
+				differs = append(differs, i)
+			}
+		}
+	}
+	sort.Ints(differs)
+	return differs
+}
+
+// ===============
+//
+//	检查复制到每个数据库的广播表 (如字典表, 配置表) 是否一致
+//	按整数主键分块, 每个数据库用 BIT_XOR(CRC32(CONCAT_WS(...))) 计算每个分块的校验和,
+//	每条指令用 WHERE 主键 BETWEEN 只计算一批分块, 不扫描整张表,
+//	与标准数据库比较, Repair 时用标准数据库的数据修复不一致的分块:
+//	先删除标准数据库中不存在的行, 再用 INSERT ... ON DUPLICATE KEY UPDATE 写入标准数据库的行,
+//	已存在的行只覆盖比较的字段, 不在事务中执行
+//	校验和从主库读取, 包含软删除的行
+//	主键不是整数字段时返回 ErrInvalidArgument
+//	table		string			"表名"
+//	primaryKey	string			"整数主键"
+//	columns		[]string		"比较的字段, nil 为标准数据库中表的所有字段"
+//	chunkSize	int64			"每个分块的主键范围大小, Repair 时不超过 65533,
+//						 	写入的行按占位符数量分成多条指令"
+//	Debug		*log.Logger		"调试输出"
+//	options		[]IsShowPrintO		"配置"
+//		IsShowPrint	bool			"是否输出到控制台"
+//		Context		context.Context		"调用方的上下文"
+//		SourceShard	int			"标准数据库, 默认为 0"
+//		Repair		bool			"是否修复不一致的分块"
+//	return 1	BroadcastReport		"检查结果"
+//	return 2	Errors			"错误信息"
+//
+// ===============
+//
+//	Check that a broadcast table copied to every database (such as a
+//	dictionary or configuration table) is the same everywhere
+//	The table is split into chunks by its integer primary key, each database
+//	computes the checksum of each chunk with BIT_XOR(CRC32(CONCAT_WS(...)))
+//	and it is compared with the source of truth. Each instruction only
+//	computes one batch of chunks with WHERE primary key BETWEEN instead of
+//	scanning the whole table. With Repair the differing
+//	chunks are repaired from the source of truth: rows not on the source of
+//	truth are deleted first, then its rows are written with INSERT ... ON
+//	DUPLICATE KEY UPDATE, existing rows only have the compared columns
+//	overwritten, not in a transaction
+//	Checksums are read from the primaries and include soft-deleted rows
+//	ErrInvalidArgument is returned when the primary key is not an integer column
+//	table		string			"Table name"
+//	primaryKey	string			"Integer primary key"
+//	columns		[]string		"Columns compared, nil for all columns of
+//						 	the table on the source of truth"
+//	chunkSize	int64			"Size of the primary key range of each
+//						 	chunk, at most 65533 with Repair, the
+//						 	rows written are split into several
+//						 	instructions by the number of placeholders"
+//	Debug		*log.Logger		"Debug output"
+//	options		[]IsShowPrintO		"Configuration"
+//		IsShowPrint	bool			"Whether to output to the console"
+//		Context		context.Context		"Context of the caller"
+//		SourceShard	int			"Source of truth, 0 by default"
+//		Repair		bool			"Whether to repair the differing chunks"
+//	return 1	BroadcastReport		"Check result"
+//	return 2	Errors			"Error message"
+func (s *Setting) VerifyBroadcast(table string, primaryKey string, columns []string, chunkSize int64, Debug *log.Logger, options ...IsShowPrintO) (report BroadcastReport, errs Errors) {
+	option := &Option{
+		IsShowPrint: false,
+	}
+	for _, o := range options {
+		o(option)
+	}
+	logger := s.logger(Debug, option.IsShowPrint)
+	ctx, span := s.startCall(option, "VerifyBroadcast", table)
+	defer func() { endSpan(span, errs.Err()) }()
+	source := option.SourceShard
+	report = BroadcastReport{Table: table, Source: source, ChunkSize: chunkSize}
+	if !isIdentifier(table) || !isIdentifier(primaryKey) {
+		return report, Errors{fmt.Errorf("%w: table %q or primary key %q", ErrInvalidArgument, table, primaryKey)}
+	}
+	if source < 0 || source >= len(s.SqlConfigs) {
+		return report, Errors{fmt.Errorf("%w: source shard %d", ErrOutOfRange, source)}
+	}
+	if chunkSize <= 0 || (option.Repair && chunkSize > maxPlaceholders-2) {
+		return report, Errors{fmt.Errorf("%w: chunk size %d", ErrInvalidArgument, chunkSize)}
+	}
+	tableCols, types, err := s.tableColumns(ctx, source, table, logger)
+	if err != nil {
+		return report, Errors{s.shardError(source, "", err)}
+	}
+	if !isIntegerType(types[primaryKey]) {
+		return report, Errors{fmt.Errorf("%w: primary key %q is not an integer column", ErrInvalidArgument, primaryKey)}
+	}
+	if columns == nil {
+		columns = tableCols
+	}
+	for _, col := range columns {
+		if !isIdentifier(col) {
+			return report, Errors{fmt.Errorf("%w: column %q", ErrInvalidArgument, col)}
+		}
+	}
+	if !containsString(columns, primaryKey) {
+		columns = append([]string{primaryKey}, columns...)
+	}
+
+	// 先读取每个数据库的主键范围, 再按范围分批计算校验和, 每条指令只扫描一批分块
+	// The primary key range of each database is read first, then the
+	// checksums are computed batch by batch, each instruction scans one batch of chunks
+	sums := make([]map[int64]chunkSum, len(s.SqlConfigs))
+	var first, last int64
+	hasRows := false
+	for i := range s.SqlConfigs {
+		if !s.IsRetryConnect(i) {
+			errs = append(errs, s.shardError(i, "", ErrShardUnavailable))
+			continue
+		}
+		reqd := make(chan []map[string]string)
+		reerr := make(chan error)
+		go s.go_query(ctx, i, stmtMeta{op: "query", table: table}, "SELECT MIN(`"+primaryKey+"`) AS lo, MAX(`"+primaryKey+"`) AS hi FROM `"+table+"`", nil, reqd, reerr, true, logger)
+		qd := <-reqd
+		if err := <-reerr; err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		sums[i] = map[int64]chunkSum{}
+		if len(qd) == 0 || qd[0]["lo"] == "" {
+			continue
+		}
+		lo, _ := strconv.ParseInt(qd[0]["lo"], 10, 64)
+		hi, _ := strconv.ParseInt(qd[0]["hi"], 10, 64)
+		if !hasRows || lo < first {
+			first = lo
+		}
+		if !hasRows || hi > last {
+			last = hi
+		}
+		hasRows = true
+	}
+	sqlStr := checksumSQL(table, primaryKey, columns)
+	meta := stmtMeta{op: "checksum", table: table}
+	for _, batch := range checksumBatches(first, last, chunkSize, hasRows) {
+		for i := range s.SqlConfigs {
+			if sums[i] == nil {
+				continue
+			}
+			reqd := make(chan []map[string]string)
+			reerr := make(chan error)
+			go s.go_query(ctx, i, meta, sqlStr, []interface{}{chunkSize, batch[0], batch[1]}, reqd, reerr, true, logger)
+			qd := <-reqd
+			if err := <-reerr; err != nil {
+				errs = append(errs, err)
+				sums[i] = nil
+				continue
+			}
+			for _, row := range qd {
+				chunk, err := strconv.ParseInt(row["chunk"], 10, 64)
+				if err != nil {
+					continue
+				}
+				count, _ := strconv.ParseInt(row["count"], 10, 64)
+				sums[i][chunk] = chunkSum{count: count, crc: row["crc"]}
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	for i := range sums {
+		if sums[i] != nil {
+			report.Checked = append(report.Checked, i)
+		}
+	}
+	if sums[source] == nil {
+		return report, errs
+	}
+	report.Chunks = diffChunks(sums, source, chunkSize)
+	if differs := report.Differs(); len(differs) > 0 {
+		logger.Warn("mysql broadcast table differs", "table", table, "source", source, "shards", differs, "chunks", len(report.Chunks))
+	}
+	if option.Repair {
+		for k := range report.Chunks {
+			chunk := &report.Chunks[k]
+			repaired, repairErrs := s.repairChunk(ctx, table, primaryKey, columns, source, chunk.Start, chunk.End, chunk.Shards, logger)
+			chunk.Repaired = repaired
+			errs = append(errs, repairErrs...)
+			if ctx.Err() != nil {
+				break
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return report, errs
+	}
+	return report, nil
+}
+
+// 从 INFORMATION_SCHEMA 读取表的字段和字段类型, 字段按定义的顺序
+//
+// Read the columns of the table and their data types from INFORMATION_SCHEMA,
+// the columns are in the defined order
+func (s *Setting) tableColumns(ctx context.Context, i int, table string, logger *slog.Logger) ([]string, map[string]string, error) {
+	if !s.IsRetryConnect(i) {
+		return nil, nil, ErrShardUnavailable
+	}
+	reqd := make(chan []map[string]string)
+	reerr := make(chan error)
+	go s.go_query(ctx, i, stmtMeta{op: "query", table: "information_schema"}, "SELECT COLUMN_NAME AS name, DATA_TYPE AS type FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=? ORDER BY ORDINAL_POSITION", []interface{}{table}, reqd, reerr, true, logger)
+	qd := <-reqd
+	if err := <-reerr; err != nil {
+		return nil, nil, err
+	}
+	if len(qd) == 0 {
+		return nil, nil, fmt.Errorf("%w: table %q not found", ErrInvalidArgument, table)
+	}
+	columns := []string{}
+	types := map[string]string{}
+	for _, row := range qd {
+		columns = append(columns, row["name"])
+		types[row["name"]] = strings.ToLower(row["type"])
+	}
+	return columns, types, nil
+}
+
+// 分块需要整数主键
+//
+// Chunking needs an integer primary key
+func isIntegerType(dataType string) bool {
+	switch dataType {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint":
+		return true
+	}
+	return false
+}
+
+// 每条校验和指令计算的分块数
+//
+// Chunks computed by each checksum instruction
+const checksumBatchChunks = 1000
+
+// ===============
+//
+//	把主键范围按分块对齐后分批, 每批最多 checksumBatchChunks 个分块
+//	first		int64		"最小的主键"
+//	last		int64		"最大的主键"
+//	chunkSize	int64		"分块大小"
+//	hasRows		bool		"是否有行, 为 false 时没有批次"
+//	return		[][2]int64	"每批的主键范围, 两端都包含"
+//
+// ===============
+//
+//	Split the primary key range into batches aligned to the chunks, each
+//	batch holds at most checksumBatchChunks chunks
+//	first		int64		"Smallest primary key"
+//	last		int64		"Largest primary key"
+//	chunkSize	int64		"Chunk size"
+//	hasRows		bool		"Whether there are rows, no batches when false"
+//	return		[][2]int64	"Primary key range of each batch, both ends inclusive"
+func checksumBatches(first int64, last int64, chunkSize int64, hasRows bool) [][2]int64 {
+	batches := [][2]int64{}
+	if !hasRows {
+		return batches
+	}
+	floorDiv := func(a int64) int64 {
+		q := a / chunkSize
+		if a%chunkSize != 0 && a < 0 {
+			q--
+		}
+		return q
+	}
+	end := floorDiv(last)
+	for chunk := floorDiv(first); chunk <= end; chunk += checksumBatchChunks {
+		next := chunk + checksumBatchChunks
+		if next > end {
+			next = end + 1
+		}
+		batches = append(batches, [2]int64{chunk * chunkSize, next*chunkSize - 1})
+	}
+	return batches
+}
+
+// ===============
+//
+//	生成按主键分块计算校验和的指令, 参数为分块大小和主键范围的两端
+//	CONCAT_WS 会跳过 NULL, 所以附加每个字段是否为 NULL 的标记
+//	table		string		"表名"
+//	primaryKey	string		"整数主键"
+//	columns		[]string	"比较的字段"
+//	return		string		"SQL指令, 结果字段为 chunk, count, crc"
+//
+// ===============
+//
+//	Create the instruction computing the checksum of each primary key chunk,
+//	the arguments are the chunk size and both ends of the primary key range
+//	CONCAT_WS skips NULL, so a flag of whether each column is NULL is appended
+//	table		string		"Table name"
+//	primaryKey	string		"Integer primary key"
+//	columns		[]string	"Columns compared"
+//	return		string		"SQL instruction, the result columns are
+//					 	chunk, count and crc"
+func checksumSQL(table string, primaryKey string, columns []string) string {
+	quoted := []string{}
+	nulls := []string{}
+	for _, col := range columns {
+		quoted = append(quoted, "`"+col+"`")
+		nulls = append(nulls, "ISNULL(`"+col+"`)")
+	}
+	row := "CONCAT_WS('#'," + strings.Join(quoted, ",") + ",CONCAT(" + strings.Join(nulls, ",") + "))"
+	return "SELECT FLOOR(`" + primaryKey + "`/?) AS chunk, COUNT(*) AS count, BIT_XOR(CRC32(" + row + ")) AS crc FROM `" + table + "` WHERE `" + primaryKey + "` BETWEEN ? AND ? GROUP BY chunk ORDER BY chunk"
+}
+
+// ===============
+//
+//	比较每个数据库与标准数据库的分块
+//	sums		[]map[int64]chunkSum	"每个数据库的分块, 没有读取的为 nil"
+//	source		int			"标准数据库"
+//	chunkSize	int64			"分块大小"
+//	return		[]BroadcastChunk	"不一致的分块, 按主键排序"
+//
+// ===============
+//
+//	Compare the chunks of each database with the source of truth
+//	sums		[]map[int64]chunkSum	"Chunks of each database, nil when not read"
+//	source		int			"Source of truth"
+//	chunkSize	int64			"Chunk size"
+//	return		[]BroadcastChunk	"Differing chunks, sorted by primary key"
+func diffChunks(sums []map[int64]chunkSum, source int, chunkSize int64) []BroadcastChunk {
+	keys := []int64{}
+	for _, shardSums := range sums {
+		for chunk := range shardSums {
+			if !containsVersion(keys, chunk) {
+				keys = append(keys, chunk)
+			}
+		}
+	}
+	sort.Slice(keys, func(a, b int) bool { return keys[a] < keys[b] })
+	chunks := []BroadcastChunk{}
+	for _, key := range keys {
+		chunk := BroadcastChunk{
+			Start:     key * chunkSize,
+			End:       (key + 1) * chunkSize,
+			Rows:      make([]int64, len(sums)),
+			Checksums: make([]string, len(sums)),
+		}
+		want := sums[source][key]
+		for i, shardSums := range sums {
+			chunk.Rows[i] = -1
+			if shardSums == nil {
+				continue
+			}
+			got := shardSums[key]
+			chunk.Rows[i], chunk.Checksums[i] = got.count, got.crc
+			if got != want {
+				chunk.Shards = append(chunk.Shards, i)
+			}
+		}
+		if len(chunk.Shards) > 0 {
+			chunks = append(chunks, chunk)
+		}
+	}
+	return chunks
+}
+
+// ===============
+//
+//	用标准数据库的行修复一个分块
+//	ctx		context.Context	"父 span 的上下文"
+//	table		string		"表名"
+//	primaryKey	string		"整数主键"
+//	columns		[]string	"字段"
+//	source		int		"标准数据库"
+//	start		int64		"主键范围的开始, 包含"
+//	end		int64		"主键范围的结束, 不包含"
+//	targets		[]int		"需要修复的数据库"
+//	logger		*slog.Logger	"日志对象"
+//	return 1	[]int		"已修复的数据库"
+//	return 2	Errors		"错误信息"
+//
+// ===============
+//
+//	Repair a chunk from the rows of the source of truth
+//	ctx		context.Context	"Context of the parent span"
+//	table		string		"Table name"
+//	primaryKey	string		"Integer primary key"
+//	columns		[]string	"Columns"
+//	source		int		"Source of truth"
+//	start		int64		"Start of the primary key range, inclusive"
+//	end		int64		"End of the primary key range, exclusive"
+//	targets		[]int		"Databases to repair"
+//	logger		*slog.Logger	"Logger"
+//	return 1	[]int		"Databases that were repaired"
+//	return 2	Errors		"Error message"
+func (s *Setting) repairChunk(ctx context.Context, table string, primaryKey string, columns []string, source int, start int64, end int64, targets []int, logger *slog.Logger) ([]int, Errors) {
+	selects := []string{}
+	for k, col := range columns {
+		selects = append(selects, "`"+col+"`", "ISNULL(`"+col+"`) AS `_null_"+strconv.Itoa(k)+"`")
+	}
+	reqd := make(chan []map[string]string)
+	reerr := make(chan error)
+	go s.go_query(ctx, source, stmtMeta{op: "query", table: table}, "SELECT "+strings.Join(selects, ",")+" FROM `"+table+"` WHERE `"+primaryKey+"`>=? AND `"+primaryKey+"`<?", []interface{}{start, end}, reqd, reerr, true, logger)
+	qd := <-reqd
+	if err := <-reerr; err != nil {
+		return nil, Errors{err}
+	}
+	values := make([][]string, len(qd))
+	items := make([]int, len(qd))
+	ids := []interface{}{}
+	for r, row := range qd {
+		items[r] = r
+		for _, col := range columns {
+			values[r] = append(values[r], row[col])
+		}
+		ids = append(ids, row[primaryKey])
+	}
+	deleteSQL, deleteArgs := repairDeleteSQL(table, primaryKey, start, end, ids)
+	repaired := []int{}
+	var errs Errors
+	meta := stmtMeta{op: "repair", table: table}
+	for _, i := range targets {
+		if i == source {
+			continue
+		}
+		if err := s.execOne(ctx, i, meta, deleteSQL, deleteArgs, logger); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		var err error
+		for _, chunk := range chunkRows(items, values, s.InsertChunkRows, s.InsertChunkBytes) {
+			sqlStr, args := repairUpsertSQL(table, primaryKey, columns, chunk, qd)
+			if err = s.execOne(ctx, i, meta, sqlStr, args, logger); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		repaired = append(repaired, i)
+	}
+	if len(repaired) > 0 {
+		logger.Info("mysql broadcast chunk repaired", "table", table, "start", start, "end", end, "shards", repaired, "rows", len(qd))
+	}
+	return repaired, errs
+}
+
+// 删除分块中标准数据库没有的行
+//
+// Delete the rows of the chunk that the source of truth does not have
+func repairDeleteSQL(table string, primaryKey string, start int64, end int64, ids []interface{}) (string, []interface{}) {
+	sqlStr := "DELETE FROM `" + table + "` WHERE `" + primaryKey + "`>=? AND `" + primaryKey + "`<?"
+	args := []interface{}{start, end}
+	if len(ids) > 0 {
+		sqlStr += " AND `" + primaryKey + "` NOT IN (" + placeholders(len(ids)) + ")"
+		args = append(args, ids...)
+	}
+	return sqlStr, args
+}
+
+// 写入标准数据库的行, 已存在的行只更新 columns 中的字段, 其他字段不变,
+// NULL 按读取时的标记绑定为 nil
+//
+// Write the rows of the source of truth, existing rows only have the fields
+// in columns updated and keep their other fields, NULL is bound as nil by the
+// flag read with the row
+func repairUpsertSQL(table string, primaryKey string, columns []string, chunk []int, rows []map[string]string) (string, []interface{}) {
+	quoted := []string{}
+	sets := []string{}
+	for _, col := range columns {
+		quoted = append(quoted, "`"+col+"`")
+		if col != primaryKey {
+			sets = append(sets, "`"+col+"`=VALUES(`"+col+"`)")
+		}
+	}
+	if len(sets) == 0 {
+		sets = append(sets, "`"+primaryKey+"`=`"+primaryKey+"`")
+	}
+	rowSQL := "(" + placeholders(len(columns)) + ")"
+	args := make([]interface{}, 0, len(chunk)*len(columns))
+	for _, item := range chunk {
+		for k, col := range columns {
+			if rows[item]["_null_"+strconv.Itoa(k)] == "1" {
+				args = append(args, nil)
+			} else {
+				args = append(args, rows[item][col])
+			}
+		}
+	}
+	sqlStr := "INSERT INTO `" + table + "` (" + strings.Join(quoted, ",") + ") VALUES " + strings.TrimSuffix(strings.Repeat(rowSQL+",", len(chunk)), ",") + " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ",")
+	return sqlStr, args
+}
+
+func containsInt(list []int, v int) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
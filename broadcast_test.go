@@ -0,0 +1,105 @@
+package weSubDatabase
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestBroadcastChecksumSQL(t *testing.T) {
+	got := checksumSQL("dict", "id", []string{"id", "name"})
+	want := "SELECT FLOOR(`id`/?) AS chunk, COUNT(*) AS count, BIT_XOR(CRC32(CONCAT_WS('#',`id`,`name`,CONCAT(ISNULL(`id`),ISNULL(`name`))))) AS crc FROM `dict` WHERE `id` BETWEEN ? AND ? GROUP BY chunk ORDER BY chunk"
+	if got != want {
+		t.Error("checksumSQL:", got)
+	}
+}
+
+func TestBroadcastIntegerType(t *testing.T) {
+	if !isIntegerType("bigint") || !isIntegerType("int") || isIntegerType("varchar") || isIntegerType("") {
+		t.Error("isIntegerType")
+	}
+}
+
+func TestBroadcastBatches(t *testing.T) {
+	batches := checksumBatches(-5, 250000, 100, true)
+	if len(batches) != 3 || batches[0] != [2]int64{-100, 99899} || batches[2] != [2]int64{199900, 250099} {
+		t.Error("checksumBatches:", batches)
+	}
+	if batches := checksumBatches(0, 0, 100, false); len(batches) != 0 {
+		t.Error("no rows:", batches)
+	}
+}
+
+func TestBroadcastDiffChunks(t *testing.T) {
+	sums := []map[int64]chunkSum{
+		{0: {2, "11"}, 1: {1, "22"}},
+		{0: {2, "11"}, 1: {1, "23"}},
+		nil,
+		{0: {2, "11"}, 1: {1, "22"}, 5: {1, "99"}},
+	}
+	got := diffChunks(sums, 0, 100)
+	want := []BroadcastChunk{
+		{Start: 100, End: 200, Rows: []int64{1, 1, -1, 1}, Checksums: []string{"22", "23", "", "22"}, Shards: []int{1}},
+		{Start: 500, End: 600, Rows: []int64{0, 0, -1, 1}, Checksums: []string{"", "", "", "99"}, Shards: []int{3}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatal("diffChunks:", got)
+	}
+	report := BroadcastReport{Chunks: got}
+	if differs := report.Differs(); !reflect.DeepEqual(differs, []int{1, 3}) {
+		t.Error("Differs:", differs)
+	}
+}
+
+func TestBroadcastRepairSQL(t *testing.T) {
+	sqlStr, args := repairDeleteSQL("dict", "id", 0, 100, []interface{}{"1", "2"})
+	if sqlStr != "DELETE FROM `dict` WHERE `id`>=? AND `id`<? AND `id` NOT IN (?,?)" || len(args) != 4 {
+		t.Error("repairDeleteSQL:", sqlStr, args)
+	}
+	rows := []map[string]string{
+		{"id": "1", "name": "a", "_null_0": "0", "_null_1": "0"},
+		{"id": "2", "name": "", "_null_0": "0", "_null_1": "1"},
+	}
+	sqlStr, args = repairUpsertSQL("dict", "id", []string{"id", "name"}, []int{0, 1}, rows)
+	if sqlStr != "INSERT INTO `dict` (`id`,`name`) VALUES (?,?),(?,?) ON DUPLICATE KEY UPDATE `name`=VALUES(`name`)" {
+		t.Error("repairUpsertSQL:", sqlStr)
+	}
+	if want := []interface{}{"1", "a", "2", nil}; !reflect.DeepEqual(args, want) {
+		t.Error("repairUpsertSQL args:", args)
+	}
+	// 只比较主键时不覆盖其他字段
+	// Other fields are not overwritten when only the primary key is compared
+	sqlStr, _ = repairUpsertSQL("dict", "id", []string{"id"}, []int{0}, rows)
+	if sqlStr != "INSERT INTO `dict` (`id`) VALUES (?) ON DUPLICATE KEY UPDATE `id`=`id`" {
+		t.Error("repairUpsertSQL primary key only:", sqlStr)
+	}
+}
+
+func TestBroadcastRepairChunks(t *testing.T) {
+	// 最大的分块按占位符数量拆分成多条指令
+	// The largest chunk is split into several instructions by the number of placeholders
+	columns := []string{"id", "name", "value"}
+	rows := make([]map[string]string, maxPlaceholders-2)
+	values := make([][]string, len(rows))
+	items := make([]int, len(rows))
+	for r := range rows {
+		rows[r] = map[string]string{"id": strconv.Itoa(r), "name": "a", "value": "b"}
+		values[r] = []string{strconv.Itoa(r), "a", "b"}
+		items[r] = r
+	}
+	chunks := chunkRows(items, values, 0, 0)
+	if len(chunks) != 3 {
+		t.Error("chunks:", len(chunks))
+	}
+	total := 0
+	for _, chunk := range chunks {
+		_, args := repairUpsertSQL("dict", "id", columns, chunk, rows)
+		if len(args) > maxPlaceholders {
+			t.Error("placeholders:", len(args))
+		}
+		total += len(chunk)
+	}
+	if total != len(rows) {
+		t.Error("rows:", total)
+	}
+}
@@ -0,0 +1,817 @@
+package weSubDatabase
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// 分片查询构造器, 生成带参数的SQL指令, 值不会拼接到SQL指令中
+// 方法可以链式调用, 出错时记录第一个错误, 在执行时返回
+//
+//	rows, errs := s.Table("data").
+//		Select("id", "name").
+//		Where("`time` > ?", "2023-07-01").
+//		In("type", 1, 2).
+//		OrderBy("id", "DESC").
+//		Limit(10).
+//		Query(nil)
+//
+// Query builder for shards, creates SQL instructions with arguments, values
+// are never concatenated into the SQL instruction
+// Methods can be chained, the first error is recorded and returned when run
+type Builder struct {
+	s          *Setting
+	table      string
+	columns    []string
+	where      string
+	args       []interface{}
+	sets       []string
+	setArgs    []interface{}
+	orders     []rowOrder
+	limit      int
+	offset     int
+	shards     []bool
+	primaryKey string
+	ids        [][]string
+	err        error
+}
+
+// ===============
+//
+//	创建表的查询构造器
+//	table		string		"表名"
+//	return		*Builder	"查询构造器"
+//
+// ===============
+//
+//	Create a query builder of the table
+//	table		string		"Table name"
+//	return		*Builder	"Query builder"
+func (s *Setting) Table(table string) *Builder {
+	b := &Builder{s: s, table: strings.ReplaceAll(table, "`", ""), limit: -1}
+	if !isIdentifier(b.table) {
+		b.fail(fmt.Errorf("%w: table %q", ErrInvalidArgument, table))
+	}
+	return b
+}
+
+func (b *Builder) fail(err error) *Builder {
+	if b.err == nil {
+		b.err = err
+	}
+	return b
+}
+
+// 检查并加上反引号
+//
+// Check the column and add backticks
+func (b *Builder) column(col string) string {
+	col = strings.ReplaceAll(strings.TrimSpace(col), "`", "")
+	if !isIdentifier(col) {
+		b.fail(fmt.Errorf("%w: column %q", ErrInvalidArgument, col))
+	}
+	return "`" + col + "`"
+}
+
+// ===============
+//
+//	查询的字段, 不调用时为 *
+//	cols		...string	"字段名"
+//	return		*Builder	"查询构造器"
+//
+// ===============
+//
+//	Columns to query, * when not called
+//	cols		...string	"Column names"
+//	return		*Builder	"Query builder"
+func (b *Builder) Select(cols ...string) *Builder {
+	for _, col := range cols {
+		b.columns = append(b.columns, b.column(col))
+	}
+	return b
+}
+
+// ===============
+//
+//	添加查询条件, 与已有条件以 AND 连接, 条件中的 ? 对应参数
+//	expr		string		"条件, 如 "`time` > ?""
+//	args		...interface{}	"参数"
+//	return		*Builder	"查询构造器"
+//
+// ===============
+//
+//	Add a condition joined to the existing ones with AND, each ? in the
+//	condition matches an argument
+//	expr		string		"Condition, such as "`time` > ?""
+//	args		...interface{}	"Arguments"
+//	return		*Builder	"Query builder"
+func (b *Builder) Where(expr string, args ...interface{}) *Builder {
+	return b.cond("AND", expr, args)
+}
+
+// ===============
+//
+//	与 Where 相同, 以 AND 连接
+//	expr		string		"条件"
+//	args		...interface{}	"参数"
+//	return		*Builder	"查询构造器"
+//
+// ===============
+//
+//	Same as Where, joined with AND
+//	expr		string		"Condition"
+//	args		...interface{}	"Arguments"
+//	return		*Builder	"Query builder"
+func (b *Builder) And(expr string, args ...interface{}) *Builder {
+	return b.cond("AND", expr, args)
+}
+
+// ===============
+//
+//	添加查询条件, 与已有条件以 OR 连接, 按 SQL 的优先级 AND 先于 OR
+//	expr		string		"条件"
+//	args		...interface{}	"参数"
+//	return		*Builder	"查询构造器"
+//
+// ===============
+//
+//	Add a condition joined to the existing ones with OR, AND binds tighter
+//	than OR as in SQL
+//	expr		string		"Condition"
+//	args		...interface{}	"Arguments"
+//	return		*Builder	"Query builder"
+func (b *Builder) Or(expr string, args ...interface{}) *Builder {
+	return b.cond("OR", expr, args)
+}
+
+func (b *Builder) cond(op string, expr string, args []interface{}) *Builder {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return b.fail(fmt.Errorf("%w: empty condition", ErrInvalidArgument))
+	}
+	if n := countPlaceholders(expr); n != len(args) {
+		return b.fail(fmt.Errorf("%w: %q has %d placeholders but %d arguments", ErrInvalidArgument, expr, n, len(args)))
+	}
+	if b.where != "" {
+		b.where += " " + op + " "
+	}
+	b.where += "(" + expr + ")"
+	b.args = append(b.args, args...)
+	return b
+}
+
+// ===============
+//
+//	添加 IN 条件, 以 AND 连接, 没有值时不匹配任何行
+//	col		string		"字段名"
+//	values		...interface{}	"值"
+//	return		*Builder	"查询构造器"
+//
+// ===============
+//
+//	Add an IN condition joined with AND, no row matches when there is no value
+//	col		string		"Column name"
+//	values		...interface{}	"Values"
+//	return		*Builder	"Query builder"
+func (b *Builder) In(col string, values ...interface{}) *Builder {
+	if len(values) == 0 {
+		return b.cond("AND", "1=0", nil)
+	}
+	return b.cond("AND", b.column(col)+" IN ("+placeholders(len(values))+")", values)
+}
+
+// ===============
+//
+//	添加 BETWEEN 条件, 以 AND 连接
+//	col		string		"字段名"
+//	from		interface{}	"最小值"
+//	to		interface{}	"最大值"
+//	return		*Builder	"查询构造器"
+//
+// ===============
+//
+//	Add a BETWEEN condition joined with AND
+//	col		string		"Column name"
+//	from		interface{}	"Minimum"
+//	to		interface{}	"Maximum"
+//	return		*Builder	"Query builder"
+func (b *Builder) Between(col string, from interface{}, to interface{}) *Builder {
+	return b.cond("AND", b.column(col)+" BETWEEN ? AND ?", []interface{}{from, to})
+}
+
+// ===============
+//
+//	按加密后的主键过滤, 只查询主键所在的数据库, 查询结果的主键会被加密
+//	primaryKey	string		"主键"
+//	ids		...string	"加密后的主键"
+//	return		*Builder	"查询构造器"
+//
+// ===============
+//
+//	Filter by encrypted primary keys, only the databases of the keys are
+//	queried, the primary key of the query result is encrypted
+//	primaryKey	string		"Primary key"
+//	ids		...string	"Encrypted primary keys"
+//	return		*Builder	"Query builder"
+func (b *Builder) WhereID(primaryKey string, ids ...string) *Builder {
+	b.primaryKey = strings.ReplaceAll(strings.TrimSpace(primaryKey), "`", "")
+	b.column(b.primaryKey)
+	if b.s.SEKey == nil {
+		return b.fail(fmt.Errorf("%w: no key to decrypt ids", ErrInvalidKey))
+	}
+	dbIList, idList, _ := b.s.DecryptID(b.primaryKey, ids)
+	n := 0
+	for _, l := range idList {
+		n += len(l)
+	}
+	if n != len(ids) {
+		return b.fail(fmt.Errorf("%w: %d of %d ids", ErrInvalidKey, len(ids)-n, len(ids)))
+	}
+	b.ids = idList
+	return b.restrict(dbIList)
+}
+
+// ===============
+//
+//	只查询指定的数据库, 多次调用时取交集
+//	shards		...int		"数据库在配置中的位置"
+//	return		*Builder	"查询构造器"
+//
+// ===============
+//
+//	Only query the given databases, the intersection is used when called more than once
+//	shards		...int		"Location of the databases in the configuration"
+//	return		*Builder	"Query builder"
+func (b *Builder) Shards(shards ...int) *Builder {
+	targets := make([]bool, len(b.s.SqlConfigs))
+	for _, i := range shards {
+		if i < 0 || i >= len(targets) {
+			return b.fail(fmt.Errorf("%w: shard %d", ErrOutOfRange, i))
+		}
+		targets[i] = true
+	}
+	return b.restrict(targets)
+}
+
+func (b *Builder) restrict(targets []bool) *Builder {
+	if b.shards == nil {
+		b.shards = targets
+		return b
+	}
+	for i := range b.shards {
+		b.shards[i] = b.shards[i] && targets[i]
+	}
+	return b
+}
+
+// ===============
+//
+//	排序, 可以多次调用, 各数据库排序后合并
+//	col		string		"字段名"
+//	dir		string		"ASC 或 DESC, 空字符串为 ASC"
+//	return		*Builder	"查询构造器"
+//
+// ===============
+//
+//	Sorting, can be called more than once, each database sorts and the
+//	results are merged
+//	col		string		"Column name"
+//	dir		string		"ASC or DESC, ASC when empty"
+//	return		*Builder	"Query builder"
+func (b *Builder) OrderBy(col string, dir string) *Builder {
+	o := rowOrder{column: strings.Trim(b.column(col), "`")}
+	switch strings.ToUpper(strings.TrimSpace(dir)) {
+	case "", "ASC":
+	case "DESC":
+		o.desc = true
+	default:
+		return b.fail(fmt.Errorf("%w: %q, expected ASC or DESC", ErrInvalidOrder, dir))
+	}
+	b.orders = append(b.orders, o)
+	return b
+}
+
+// ===============
+//
+//	合并后返回的最大行数
+//	limit		int		"行数"
+//	return		*Builder	"查询构造器"
+//
+// ===============
+//
+//	Maximum number of rows returned after the merge
+//	limit		int		"Row count"
+//	return		*Builder	"Query builder"
+func (b *Builder) Limit(limit int) *Builder {
+	if limit < 0 {
+		return b.fail(fmt.Errorf("%w: limit %d", ErrInvalidArgument, limit))
+	}
+	b.limit = limit
+	return b
+}
+
+// ===============
+//
+//	合并后跳过的行数
+//	offset		int		"行数"
+//	return		*Builder	"查询构造器"
+//
+// ===============
+//
+//	Number of rows skipped after the merge
+//	offset		int		"Row count"
+//	return		*Builder	"Query builder"
+func (b *Builder) Offset(offset int) *Builder {
+	if offset < 0 {
+		return b.fail(fmt.Errorf("%w: offset %d", ErrInvalidArgument, offset))
+	}
+	b.offset = offset
+	return b
+}
+
+// ===============
+//
+//	更新时设置的字段, 用于 Update
+//	col		string		"字段名"
+//	value		interface{}	"值"
+//	return		*Builder	"查询构造器"
+//
+// ===============
+//
+//	Column set by Update
+//	col		string		"Column name"
+//	value		interface{}	"Value"
+//	return		*Builder	"Query builder"
+func (b *Builder) Set(col string, value interface{}) *Builder {
+	b.sets = append(b.sets, b.column(col)+"=?")
+	b.setArgs = append(b.setArgs, value)
+	return b
+}
+
+// ===============
+//
+//	生成各数据库的查询指令
+//	每个数据库查询 LIMIT offset+limit 行, 合并后再跳过 offset 行
+//	options		[]IsShowPrintO	"配置"
+//		Distinct	bool		"是否使用 SELECT DISTINCT"
+//		WithDeleted	bool		"是否包含软删除的行"
+//	return 1	[]string	"每个数据库的SQL指令, 不查询的数据库为空字符串"
+//	return 2	[][]interface{}	"每个数据库绑定的参数"
+//	return 3	error		"错误信息"
+//
+// ===============
+//
+//	Create the query instruction of each database
+//	Each database queries LIMIT offset+limit rows, offset rows are skipped
+//	after the merge
+//	options		[]IsShowPrintO	"Configuration"
+//		Distinct	bool		"Whether to use SELECT DISTINCT"
+//		WithDeleted	bool		"Whether to include soft-deleted rows"
+//	return 1	[]string	"SQL instruction of each database, empty for
+//					 	databases not queried"
+//	return 2	[][]interface{}	"Arguments bound on each database"
+//	return 3	error		"Error message"
+func (b *Builder) SelectSQL(options ...IsShowPrintO) ([]string, [][]interface{}, error) {
+	option := &Option{}
+	for _, o := range options {
+		o(option)
+	}
+	return b.selectSQL(option)
+}
+
+// 按已经读取的配置生成各数据库的查询指令, 见 SelectSQL
+//
+// Create the query instruction of each database with the configuration
+// already read, see SelectSQL
+func (b *Builder) selectSQL(option *Option) ([]string, [][]interface{}, error) {
+	if b.err != nil {
+		return nil, nil, b.err
+	}
+	cols := "*"
+	if len(b.columns) > 0 {
+		cols = strings.Join(b.columns, ",")
+		for _, o := range b.orders {
+			if !containsString(b.columns, "`"+o.column+"`") {
+				return nil, nil, fmt.Errorf("%w: %q is not selected", ErrInvalidOrder, o.column)
+			}
+		}
+	}
+	tail := ""
+	if len(b.orders) > 0 {
+		var orders []string
+		for _, o := range b.orders {
+			dir := " ASC"
+			if o.desc {
+				dir = " DESC"
+			}
+			orders = append(orders, "`"+o.column+"`"+dir)
+		}
+		tail += " ORDER BY " + strings.Join(orders, ",")
+	}
+	if b.limit >= 0 {
+		tail += " LIMIT " + strconv.Itoa(b.offset+b.limit)
+	}
+	if option.Distinct {
+		cols = "DISTINCT " + cols
+	}
+	return b.shardSQL("SELECT "+cols+" FROM `"+b.table+"`", nil, b.s.aliveWhere(b.table, "", option), tail)
+}
+
+// ===============
+//
+//	生成各数据库的更新指令, 必须有查询条件
+//	return 1	[]string	"每个数据库的SQL指令, 不更新的数据库为空字符串"
+//	return 2	[][]interface{}	"每个数据库绑定的参数"
+//	return 3	error		"错误信息"
+//
+// ===============
+//
+//	Create the update instruction of each database, a condition is required
+//	return 1	[]string	"SQL instruction of each database, empty for
+//					 	databases not updated"
+//	return 2	[][]interface{}	"Arguments bound on each database"
+//	return 3	error		"Error message"
+func (b *Builder) UpdateSQL() ([]string, [][]interface{}, error) {
+	if b.err == nil && len(b.sets) == 0 {
+		return nil, nil, fmt.Errorf("%w: no column to set", ErrInvalidArgument)
+	}
+	if err := b.checkWrite(); err != nil {
+		return nil, nil, err
+	}
+	return b.shardSQL("UPDATE `"+b.table+"` SET "+strings.Join(b.sets, ","), b.setArgs, "", "")
+}
+
+// ===============
+//
+//	生成各数据库的删除指令, 必须有查询条件, 表设置了软删除时为标记删除
+//	return 1	[]string	"每个数据库的SQL指令, 不删除的数据库为空字符串"
+//	return 2	[][]interface{}	"每个数据库绑定的参数"
+//	return 3	error		"错误信息"
+//
+// ===============
+//
+//	Create the delete instruction of each database, a condition is required,
+//	rows are marked as deleted when the table has soft delete
+//	return 1	[]string	"SQL instruction of each database, empty for
+//					 	databases not deleted from"
+//	return 2	[][]interface{}	"Arguments bound on each database"
+//	return 3	error		"Error message"
+func (b *Builder) DeleteSQL() ([]string, [][]interface{}, error) {
+	if err := b.checkWrite(); err != nil {
+		return nil, nil, err
+	}
+	if sd, ok := b.s.GetSoftDelete(b.table); ok {
+		return b.shardSQL("UPDATE `"+b.table+"` SET "+sd.deleted(), nil, sd.alive(), "")
+	}
+	return b.shardSQL("DELETE FROM `"+b.table+"`", nil, "", "")
+}
+
+// 更新和删除不能分页且必须有条件
+//
+// Update and delete cannot be paged and require a condition
+func (b *Builder) checkWrite() error {
+	if b.err != nil {
+		return b.err
+	}
+	if len(b.orders) > 0 || b.limit >= 0 || b.offset > 0 {
+		return fmt.Errorf("%w: OrderBy, Limit and Offset are not supported across shards for writes", ErrInvalidArgument)
+	}
+	if b.where == "" && b.ids == nil {
+		return fmt.Errorf("%w: a condition is required", ErrInvalidArgument)
+	}
+	return nil
+}
+
+// 加上条件, 主键过滤和软删除条件 alive, 生成各数据库的SQL指令
+//
+// Add the condition, the primary key filter and the soft delete condition
+// alive, create the SQL instruction of each database
+func (b *Builder) shardSQL(head string, headArgs []interface{}, alive string, tail string) ([]string, [][]interface{}, error) {
+	sqlStrs := make([]string, len(b.s.SqlConfigs))
+	args := make([][]interface{}, len(b.s.SqlConfigs))
+	for i := range sqlStrs {
+		if b.shards != nil && !b.shards[i] {
+			continue
+		}
+		where := b.where
+		shardArgs := append(append([]interface{}{}, headArgs...), b.args...)
+		if b.ids != nil {
+			if where != "" {
+				where = "(" + where + ") AND "
+			}
+			where += "`" + b.primaryKey + "` IN (" + placeholders(len(b.ids[i])) + ")"
+			for _, id := range b.ids[i] {
+				shardArgs = append(shardArgs, id)
+			}
+		}
+		if alive != "" {
+			if where != "" {
+				where = "(" + where + ") AND "
+			}
+			where += alive
+		}
+		sqlStr := head
+		if where != "" {
+			sqlStr += " WHERE " + where
+		}
+		sqlStrs[i] = sqlStr + tail
+		args[i] = shardArgs
+	}
+	return sqlStrs, args, nil
+}
+
+// ===============
+//
+//	执行查询, 合并各数据库的结果后排序和分页
+//	Debug		*log.Logger		"调试日志对象"
+//	options		[]IsShowPrintO		"配置"
+//		IsShowPrint	bool			"是否输出到控制台"
+//		Context		context.Context		"调用方的上下文"
+//		IsReadPrimary	bool			"是否只从主库读取"
+//		PartialPolicy	PartialPolicy		"部分结果策略"
+//		Quorum		int			"法定数目"
+//		Report		*ShardReport		"接收各数据库的应答情况"
+//		Distinct	bool			"是否去掉各数据库之间重复的行"
+//		WithDeleted	bool			"是否包含软删除的行"
+//	return 1	[]map[string]string	"查询结果"
+//	return 2	Errors			"错误信息"
+//
+// ===============
+//
+//	Run the query, the results of the databases are merged, sorted and paged
+//	Debug		*log.Logger		"Debug log object"
+//	options		[]IsShowPrintO		"Configuration"
+//		IsShowPrint	bool			"Whether to output to the
+//											console"
+//		Context		context.Context		"Context of the caller"
+//		IsReadPrimary	bool			"Whether to read only from
+//											the primary"
+//		PartialPolicy	PartialPolicy		"Partial result policy"
+//		Quorum		int			"Quorum"
+//		Report		*ShardReport		"Receives how each database
+//											answered"
+//		Distinct	bool			"Whether to remove rows repeated
+//											across the databases"
+//		WithDeleted	bool			"Whether to include soft-deleted
+//											rows"
+//	return 1	[]map[string]string	"Query result"
+//	return 2	Errors			"Error message"
+func (b *Builder) Query(Debug *log.Logger, options ...IsShowPrintO) (queryDatas []map[string]string, errs Errors) {
+	s := b.s
+	option := &Option{
+		IsShowPrint: false,
+	}
+	for _, o := range options {
+		o(option)
+	}
+	logger := s.logger(Debug, option.IsShowPrint)
+	ctx, span := s.startCall(option, "Query", b.table)
+	defer func() { endSpan(span, errs.Err()) }()
+	sqlStrs, args, err := b.selectSQL(option)
+	if err != nil {
+		return nil, Errors{err}
+	}
+	isContinues, report := s.readableShards(b.shards)
+	if err := option.checkSkipped(report); err != nil {
+		return nil, Errors{err}
+	}
+	for i := range sqlStrs {
+		if !isContinues[i] {
+			sqlStrs[i] = ""
+		}
+	}
+	queryDatas, errs = s.queryShards(ctx, stmtMeta{op: "query", table: b.table}, sqlStrs, args, report, option, logger)
+	if err := option.finishReport(report); err != nil {
+		return nil, append(errs, err)
+	}
+	if option.Distinct {
+		queryDatas = distinctRows(queryDatas, b.primaryKey)
+	}
+	sortRows(queryDatas, b.orders)
+	if b.offset >= len(queryDatas) {
+		queryDatas = []map[string]string{}
+	} else {
+		queryDatas = queryDatas[b.offset:]
+	}
+	if b.limit >= 0 && b.limit < len(queryDatas) {
+		queryDatas = queryDatas[:b.limit]
+	}
+	queryDatas = s.EncryptPrimaryKey(queryDatas, b.primaryKey)
+	if len(errs) > 0 {
+		return queryDatas, errs
+	}
+	return queryDatas, nil
+}
+
+// ===============
+//
+//	流式执行查询, 见 Setting.QueryRows
+//	Debug		*log.Logger		"调试日志对象"
+//	options		[]IsShowPrintO		"配置"
+//		IsShowPrint	bool			"是否输出到控制台"
+//		Context		context.Context		"调用方的上下文"
+//		IsReadPrimary	bool			"是否只从主库读取"
+//		PartialPolicy	PartialPolicy		"部分结果策略"
+//		Quorum		int			"法定数目"
+//		Report		*ShardReport		"接收各数据库的应答情况"
+//	return 1	*Rows			"查询结果"
+//	return 2	error			"错误信息"
+//
+// ===============
+//
+//	Run the query as a stream, see Setting.QueryRows
+//	Debug		*log.Logger		"Debug log object"
+//	options		[]IsShowPrintO		"Configuration"
+//		IsShowPrint	bool			"Whether to output to the
+//											console"
+//		Context		context.Context		"Context of the caller"
+//		IsReadPrimary	bool			"Whether to read only from
+//											the primary"
+//		PartialPolicy	PartialPolicy		"Partial result policy"
+//		Quorum		int			"Quorum"
+//		Report		*ShardReport		"Receives how each database
+//											answered"
+//	return 1	*Rows			"Query result"
+//	return 2	error			"Error message"
+func (b *Builder) Rows(Debug *log.Logger, options ...IsShowPrintO) (*Rows, error) {
+	s := b.s
+	option := &Option{
+		IsShowPrint: false,
+	}
+	for _, o := range options {
+		o(option)
+	}
+	logger := s.logger(Debug, option.IsShowPrint)
+	sqlStrs, args, err := b.selectSQL(&Option{WithDeleted: option.WithDeleted})
+	if err != nil {
+		return nil, err
+	}
+	ctx, span := s.startCall(option, "QueryRows", b.table)
+	isContinues, report := s.readableShards(b.shards)
+	if err := option.checkSkipped(report); err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	for i := range sqlStrs {
+		if !isContinues[i] {
+			sqlStrs[i] = ""
+		}
+	}
+	r := s.openRows(ctx, span, stmtMeta{op: "query", table: b.table}, sqlStrs, args, b.orders, b.primaryKey, option, report, logger)
+	r.skip, r.limit = b.offset, b.limit
+	return r, nil
+}
+
+// ===============
+//
+//	执行更新
+//	Debug		*log.Logger	"调试日志对象"
+//	options		[]IsShowPrintO	"配置"
+//		IsShowPrint	bool		"是否输出到控制台"
+//		Context		context.Context	"调用方的上下文"
+//	return 1	[]int64		"每个数据库更新的行数, 未更新的数据库为 -1"
+//	return 2	Errors		"错误信息"
+//
+// ===============
+//
+//	Run the update
+//	Debug		*log.Logger	"Debug log object"
+//	options		[]IsShowPrintO	"Configuration"
+//		IsShowPrint	bool		"Whether to output to the console"
+//		Context		context.Context	"Context of the caller"
+//	return 1	[]int64		"Rows updated on each database, -1 for
+//					 	databases not updated"
+//	return 2	Errors		"Error message"
+func (b *Builder) Update(Debug *log.Logger, options ...IsShowPrintO) (reInt []int64, errs Errors) {
+	return b.exec("Update", "update", b.UpdateSQL, Debug, options)
+}
+
+// ===============
+//
+//	执行删除
+//	Debug		*log.Logger	"调试日志对象"
+//	options		[]IsShowPrintO	"配置"
+//		IsShowPrint	bool		"是否输出到控制台"
+//		Context		context.Context	"调用方的上下文"
+//	return 1	[]int64		"每个数据库删除的行数, 未删除的数据库为 -1"
+//	return 2	Errors		"错误信息"
+//
+// ===============
+//
+//	Run the delete
+//	Debug		*log.Logger	"Debug log object"
+//	options		[]IsShowPrintO	"Configuration"
+//		IsShowPrint	bool		"Whether to output to the console"
+//		Context		context.Context	"Context of the caller"
+//	return 1	[]int64		"Rows deleted on each database, -1 for
+//					 	databases not deleted from"
+//	return 2	Errors		"Error message"
+func (b *Builder) Delete(Debug *log.Logger, options ...IsShowPrintO) (reInt []int64, errs Errors) {
+	return b.exec("Delete", "delete", b.DeleteSQL, Debug, options)
+}
+
+func (b *Builder) exec(name string, op string, build func() ([]string, [][]interface{}, error), Debug *log.Logger, options []IsShowPrintO) (reInt []int64, errs Errors) {
+	s := b.s
+	option := &Option{
+		IsShowPrint: false,
+	}
+	for _, o := range options {
+		o(option)
+	}
+	logger := s.logger(Debug, option.IsShowPrint)
+	ctx, span := s.startCall(option, name, b.table)
+	defer func() { endSpan(span, errs.Err()) }()
+	sqlStrs, args, err := build()
+	if err != nil {
+		return nil, Errors{err}
+	}
+	return s.execShards(ctx, stmtMeta{op: op, table: b.table}, sqlStrs, args, logger)
+}
+
+// ===============
+//
+//	向多个数据库分发更新或删除
+//	ctx		context.Context	"父 span 的上下文"
+//	meta		stmtMeta	"语句的描述"
+//	sqlStrs		[]string	"每个数据库的SQL指令, 空字符串为不执行"
+//	args		[][]interface{}	"每个数据库绑定的参数, 可以为 nil"
+//	logger		*slog.Logger	"日志对象"
+//	return 1	[]int64		"每个数据库影响的行数, 未执行的数据库为 -1"
+//	return 2	Errors		"错误信息"
+//
+// ===============
+//
+//	Fan an update or delete out to several databases
+//	ctx		context.Context	"Context of the parent span"
+//	meta		stmtMeta	"Description of the statement"
+//	sqlStrs		[]string	"SQL instruction of each database, not run
+//					 	when empty"
+//	args		[][]interface{}	"Arguments bound on each database, can be nil"
+//	logger		*slog.Logger	"Logger"
+//	return 1	[]int64		"Rows affected on each database, -1 for
+//					 	databases not run"
+//	return 2	Errors		"Error message"
+func (s *Setting) execShards(ctx context.Context, meta stmtMeta, sqlStrs []string, args [][]interface{}, logger *slog.Logger) ([]int64, Errors) {
+	var errs Errors
+	reInt := make([]int64, len(sqlStrs))
+	for i, sqlStr := range sqlStrs {
+		reInt[i] = -1
+		if sqlStr == "" {
+			continue
+		}
+		if !s.IsRetryConnect(i) {
+			errs = append(errs, s.shardError(i, "", ErrShardUnavailable))
+			continue
+		}
+		var shardArgs []interface{}
+		if i < len(args) {
+			shardArgs = args[i]
+		}
+		chanRA := make(chan int64)
+		chanErr := make(chan error)
+		go s.go_exec(ctx, i, meta, sqlStr, shardArgs, nil, chanRA, chanErr, logger)
+		reInt[i] = <-chanRA
+		if err := <-chanErr; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return reInt, errs
+	}
+	return reInt, nil
+}
+
+// 统计引号之外的 ?
+//
+// Count the ? outside quotes
+func countPlaceholders(expr string) int {
+	n := 0
+	var quote byte
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?':
+			n++
+		}
+	}
+	return n
+}
+
+func placeholders(n int) string {
+	if n <= 0 {
+		return "NULL"
+	}
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
@@ -0,0 +1,72 @@
+package weSubDatabase
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBuilderSQL(t *testing.T) {
+	s := &Setting{SqlConfigs: make([]SQLConfig, 3)}
+
+	sqlStrs, args, err := s.Table("data").
+		Select("id", "name").
+		Where("`time` > ?", "2023-07-01").
+		Or("`name` = '?'").
+		In("type", 1, 2).
+		Between("score", 10, 20).
+		OrderBy("id", "desc").
+		Limit(10).
+		Offset(5).
+		Shards(0, 2).
+		SelectSQL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "SELECT `id`,`name` FROM `data` WHERE (`time` > ?) OR (`name` = '?') AND (`type` IN (?,?)) AND (`score` BETWEEN ? AND ?) ORDER BY `id` DESC LIMIT 15"
+	if sqlStrs[0] != want || sqlStrs[1] != "" || sqlStrs[2] != want {
+		t.Error("select:", sqlStrs)
+	}
+	if !reflect.DeepEqual(args[0], []interface{}{"2023-07-01", 1, 2, 10, 20}) || args[1] != nil {
+		t.Error("select args:", args)
+	}
+
+	sqlStrs, args, err = s.Table("data").Set("name", "a").Where("`id` = ?", 1).UpdateSQL()
+	if err != nil || sqlStrs[1] != "UPDATE `data` SET `name`=? WHERE (`id` = ?)" || !reflect.DeepEqual(args[1], []interface{}{"a", 1}) {
+		t.Error("update:", sqlStrs, args, err)
+	}
+	sqlStrs, _, err = s.Table("data").In("id").DeleteSQL()
+	if err != nil || sqlStrs[0] != "DELETE FROM `data` WHERE (1=0)" {
+		t.Error("delete:", sqlStrs, err)
+	}
+
+	for name, b := range map[string]*Builder{
+		"placeholders": s.Table("data").Where("`id` = ? OR `id` = ?", 1),
+		"column":       s.Table("data").Select("id; DROP TABLE data"),
+		"table":        s.Table("data`; --"),
+		"no where":     s.Table("data").Set("name", "a"),
+		"limit write":  s.Table("data").Set("name", "a").Where("1=1").Limit(1),
+		"shard":        s.Table("data").Where("1=1").Shards(3),
+	} {
+		if _, _, err := b.UpdateSQL(); err == nil {
+			t.Error(name, "should fail")
+		}
+	}
+	// 配置只影响这一次生成的指令, 不保存在 Builder 中
+	// Options only affect this instruction and are not kept in the Builder
+	b := s.Table("data").Select("name")
+	sqlStrs, _, err = b.SelectSQL(ODistinct(true))
+	if err != nil || sqlStrs[0] != "SELECT DISTINCT `name` FROM `data`" {
+		t.Error("distinct:", sqlStrs, err)
+	}
+	sqlStrs, _, err = b.SelectSQL()
+	if err != nil || sqlStrs[0] != "SELECT `name` FROM `data`" {
+		t.Error("distinct kept in the builder:", sqlStrs, err)
+	}
+	if _, _, err := s.Table("data").Select("id").OrderBy("name", "").SelectSQL(); !errors.Is(err, ErrInvalidOrder) {
+		t.Error("order not selected:", err)
+	}
+	if _, _, err := s.Table("data").OrderBy("id", "up").SelectSQL(); !errors.Is(err, ErrInvalidOrder) {
+		t.Error("order direction:", err)
+	}
+}
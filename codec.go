@@ -0,0 +1,320 @@
+package weSubDatabase
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// 编码时记录按 base64 写入的字段的字段, 字段名用 , 连接
+// BLOB, BINARY 等字段的值不是合法的 UTF-8 时按 base64 写入, 解码时还原
+//
+// Field recording the columns written as base64 on encoding, the names are
+// joined with ,
+// Values of BLOB, BINARY and similar columns that are not valid UTF-8 are
+// written as base64 and restored on decoding
+const ExportBase64Column = "_base64"
+
+// 导出时写入一行的格式, 本包提供 NDJSON 和 CSV, Parquet 由子包 parquet 提供
+//
+// Format writing one row on export, NDJSON and CSV are provided by this
+// package and Parquet by the parquet sub-package
+type RowEncoder interface {
+	//	写入一行
+	//
+	//	Write one row
+	Encode(row map[string]string) error
+	//	写入缓冲的数据, Export 结束时调用
+	//
+	//	Write the buffered data, called at the end of Export
+	Flush() error
+}
+
+// 导入时读取一行的格式, 读取完时返回 io.EOF
+//
+// Format reading one row on import, io.EOF is returned at the end
+type RowDecoder interface {
+	//	读取一行
+	//
+	//	Read one row
+	Decode() (map[string]string, error)
+}
+
+type ndjsonEncoder struct {
+	w *bufio.Writer
+}
+
+// ===============
+//
+//	每行一个 JSON 对象, 字段按名称排序
+//	_null 字段中的字段写为 null, 不写入 _null 字段
+//	不是合法 UTF-8 的值按 base64 写入, 字段记录在 _base64 字段中
+//	w		io.Writer	"输出"
+//	return		RowEncoder	"编码器"
+//
+// ===============
+//
+//	One JSON object per line, the fields are sorted by name
+//	The columns in the _null field are written as null, the _null field
+//	itself is not written
+//	Values that are not valid UTF-8 are written as base64 and listed in the
+//	_base64 field
+//	w		io.Writer	"Output"
+//	return		RowEncoder	"Encoder"
+func NewNDJSONEncoder(w io.Writer) RowEncoder {
+	return &ndjsonEncoder{w: bufio.NewWriter(w)}
+}
+
+func (e *ndjsonEncoder) Encode(row map[string]string) error {
+	row = encodeBinary(row, func(v string) bool { return !utf8.ValidString(v) })
+	obj := make(map[string]*string, len(row))
+	for k, v := range row {
+		if k != ExportNullColumn && (k != ExportBase64Column || v != "") {
+			v := v
+			obj[k] = &v
+		}
+	}
+	if nulls := row[ExportNullColumn]; nulls != "" {
+		for _, col := range strings.Split(nulls, ",") {
+			obj[col] = nil
+		}
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	if _, err := e.w.Write(data); err != nil {
+		return err
+	}
+	return e.w.WriteByte('\n')
+}
+
+func (e *ndjsonEncoder) Flush() error {
+	return e.w.Flush()
+}
+
+type ndjsonDecoder struct {
+	d *json.Decoder
+}
+
+// ===============
+//
+//	读取 NewNDJSONEncoder 写入的行
+//	值为 null 的字段读取为空字符串, 并记录在 _null 字段中
+//	_base64 字段中的字段按 base64 解码, 不返回 _base64 字段
+//	r		io.Reader	"输入"
+//	return		RowDecoder	"解码器"
+//
+// ===============
+//
+//	Read the rows written by NewNDJSONEncoder
+//	Fields whose value is null are read as empty strings and recorded in the
+//	_null field
+//	The columns in the _base64 field are decoded from base64, the _base64
+//	field itself is not returned
+//	r		io.Reader	"Input"
+//	return		RowDecoder	"Decoder"
+func NewNDJSONDecoder(r io.Reader) RowDecoder {
+	return &ndjsonDecoder{d: json.NewDecoder(r)}
+}
+
+func (d *ndjsonDecoder) Decode() (map[string]string, error) {
+	obj := map[string]*string{}
+	if err := d.d.Decode(&obj); err != nil {
+		return nil, err
+	}
+	row := make(map[string]string, len(obj)+1)
+	nulls := []string{}
+	for k, v := range obj {
+		if v == nil {
+			row[k] = ""
+			nulls = append(nulls, k)
+			continue
+		}
+		row[k] = *v
+	}
+	if len(nulls) > 0 {
+		sort.Strings(nulls)
+		row[ExportNullColumn] = strings.Join(nulls, ",")
+	}
+	if err := decodeBinary(row); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+type csvEncoder struct {
+	w       *csv.Writer
+	columns []string
+	header  bool
+}
+
+// ===============
+//
+//	CSV, 第一行为字段名
+//	CSV 不区分 NULL 和空字符串, NULL 的字段记录在 _null 列中
+//	不是合法 UTF-8 或含有 \r 的值按 base64 写入, 字段记录在 _base64 列中
+//	w		io.Writer	"输出"
+//	columns		[]string	"字段和顺序, nil 时使用第一行按名称排序的字段,
+//					 	行中有 _null 字段而 columns 中没有时加在最后,
+//					 	columns 中没有 _base64 时加在最后"
+//	return		RowEncoder	"编码器"
+//
+// ===============
+//
+//	CSV, the first line holds the field names
+//	CSV does not tell NULL from an empty string, the NULL columns are
+//	recorded in the _null column
+//	Values that are not valid UTF-8 or contain \r are written as base64 and
+//	listed in the _base64 column
+//	w		io.Writer	"Output"
+//	columns		[]string	"Fields and their order, nil uses the fields
+//					 	of the first row sorted by name, the _null
+//					 	field is added last when the row has it and
+//					 	columns does not, _base64 is added last
+//					 	when columns does not have it"
+//	return		RowEncoder	"Encoder"
+func NewCSVEncoder(w io.Writer, columns []string) RowEncoder {
+	return &csvEncoder{w: csv.NewWriter(w), columns: columns}
+}
+
+func (e *csvEncoder) Encode(row map[string]string) error {
+	// csv.Reader 把 \r\n 读取为 \n, 含有 \r 的值也按 base64 写入
+	// csv.Reader reads \r\n as \n, values containing \r are written as base64 too
+	row = encodeBinary(row, func(v string) bool { return !utf8.ValidString(v) || strings.ContainsRune(v, '\r') })
+	if !e.header {
+		if e.columns == nil {
+			for col := range row {
+				if col != ExportBase64Column {
+					e.columns = append(e.columns, col)
+				}
+			}
+			sort.Strings(e.columns)
+		} else if _, ok := row[ExportNullColumn]; ok && !containsString(e.columns, ExportNullColumn) {
+			e.columns = append(append([]string{}, e.columns...), ExportNullColumn)
+		}
+		if !containsString(e.columns, ExportBase64Column) {
+			e.columns = append(append([]string{}, e.columns...), ExportBase64Column)
+		}
+		if err := e.w.Write(e.columns); err != nil {
+			return err
+		}
+		e.header = true
+	}
+	record := make([]string, len(e.columns))
+	n := 0
+	for k, col := range e.columns {
+		if v, ok := row[col]; ok {
+			record[k] = v
+			n++
+		}
+	}
+	if n != len(row) {
+		return fmt.Errorf("%w: the row has fields that are not in the CSV header", ErrInvalidArgument)
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type csvDecoder struct {
+	r       *csv.Reader
+	columns []string
+}
+
+// ===============
+//
+//	读取第一行为字段名的 CSV
+//	r		io.Reader	"输入"
+//	return		RowDecoder	"解码器"
+//
+// ===============
+//
+//	Read CSV whose first line holds the field names
+//	r		io.Reader	"Input"
+//	return		RowDecoder	"Decoder"
+func NewCSVDecoder(r io.Reader) RowDecoder {
+	return &csvDecoder{r: csv.NewReader(r)}
+}
+
+func (d *csvDecoder) Decode() (map[string]string, error) {
+	if d.columns == nil {
+		header, err := d.r.Read()
+		if err != nil {
+			return nil, err
+		}
+		d.columns = header
+	}
+	record, err := d.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	row := make(map[string]string, len(d.columns))
+	for k, col := range d.columns {
+		row[col] = record[k]
+	}
+	if err := decodeBinary(row); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// ===============
+//
+//	把需要的值编码为 base64, 字段记录在 _base64 字段中, 没有时为空字符串
+//	row		map[string]string	"一行, 不会被修改"
+//	isBinary	func(string) bool	"值是否需要编码"
+//	return		map[string]string	"编码后的行"
+//
+// ===============
+//
+//	Encode the values that need it as base64, the columns are recorded in
+//	the _base64 field, which is empty when there are none
+//	row		map[string]string	"A row, it is not changed"
+//	isBinary	func(string) bool	"Whether a value needs encoding"
+//	return		map[string]string	"Encoded row"
+func encodeBinary(row map[string]string, isBinary func(string) bool) map[string]string {
+	out := make(map[string]string, len(row)+1)
+	binary := []string{}
+	for k, v := range row {
+		if k != ExportNullColumn && isBinary(v) {
+			v = base64.StdEncoding.EncodeToString([]byte(v))
+			binary = append(binary, k)
+		}
+		out[k] = v
+	}
+	sort.Strings(binary)
+	out[ExportBase64Column] = strings.Join(binary, ",")
+	return out
+}
+
+// 取出 _base64 字段, 把其中的字段按 base64 解码
+//
+// Take out the _base64 field and decode the columns in it from base64
+func decodeBinary(row map[string]string) error {
+	v, ok := row[ExportBase64Column]
+	if !ok {
+		return nil
+	}
+	delete(row, ExportBase64Column)
+	if v == "" {
+		return nil
+	}
+	for _, col := range strings.Split(v, ",") {
+		data, err := base64.StdEncoding.DecodeString(row[col])
+		if err != nil {
+			return fmt.Errorf("%w: %s column %q: %v", ErrInvalidArgument, ExportBase64Column, col, err)
+		}
+		row[col] = string(data)
+	}
+	return nil
+}
@@ -1,7 +1,6 @@
 package weSubDatabase
 
 import (
-	"fmt"
 	"log"
 	"sync"
 	"time"
@@ -10,6 +9,7 @@ import (
 // ===============
 //
 //	自动根据 *Setting 向下一个数据库中的指定表删除数据
+//	表设置了软删除时只标记删除, 见 SetSoftDelete
 //	table			string		"表名"
 //	forKey			string		"键名"
 //	ids			[]string	"值"
@@ -17,12 +17,14 @@ import (
 //	options			[]IsPrimaryKeyO	"配置"
 //		IsPrimaryKey	bool		"是否为主键"
 //		IsShowPrint		bool		"是否输出到控制台"
+//		Context		context.Context		"调用方的上下文"
 //	return 1		[]int64		"删除的行数"
-//	return 2		[]error		"错误信息"
+//	return 2		Errors		"错误信息"
 //
 // ===============
 //
 //	Automatically delete data from the specified table in the next database according to *Setting
+//	Rows are only marked as deleted when the table has soft delete, see SetSoftDelete
 //	table			string		"table name"
 //	forKey			string		"key name"
 //	ids			[]string	"value"
@@ -32,9 +34,10 @@ import (
 //											key"
 //		IsShowPrint		bool		"Whether to output to the
 //											console"
+//		Context		context.Context		"Context of the caller"
 //	return 1		[]int64		"Number of rows deleted"
-//	return 2		[]error		"Error message"
-func (s *Setting) Delete(table string, forKey string, ids []string, Debug *log.Logger, options ...IsPrimaryKeyO) ([]int64, []error) {
+//	return 2		Errors		"Error message"
+func (s *Setting) Delete(table string, forKey string, ids []string, Debug *log.Logger, options ...IsPrimaryKeyO) (reInt []int64, errs Errors) {
 	option := &Option{
 		IsPrimaryKey: true,
 		IsShowPrint:  false,
@@ -42,12 +45,13 @@ func (s *Setting) Delete(table string, forKey string, ids []string, Debug *log.L
 	for _, o := range options {
 		o(option)
 	}
+	logger := s.logger(Debug, option.IsShowPrint)
+	ctx, span := s.startCall(option, "Delete", table)
+	defer func() { endSpan(span, errs.Err()) }()
 
 	var (
 		dbIList []bool
 		idList  [][]string
-		reInt   []int64
-		errs    []error
 	)
 	if option.IsPrimaryKey {
 		dbIList, idList, _ = s.DecryptID(forKey, ids)
@@ -59,24 +63,23 @@ func (s *Setting) Delete(table string, forKey string, ids []string, Debug *log.L
 	}
 	for i := 0; i < len(s.SqlConfigs); i++ {
 		reInt = append(reInt, -1)
-		errs = append(errs, nil)
-	}
-	if option.IsShowPrint {
-		fmt.Println("=================")
-		fmt.Println(dbIList)
-		fmt.Println(idList)
-		fmt.Println("=================")
 	}
+	sd, isSoft := s.GetSoftDelete(table)
+	logger.Debug("mysql delete targets", "table", table, "shards", dbIList, "soft", isSoft)
 	var wg sync.WaitGroup
 	for sqlI := 0; sqlI < len(s.SqlConfigs); sqlI++ {
-		if !s.IsRetryConnect(sqlI) {
+		if !dbIList[sqlI] {
 			continue
 		}
-		if !dbIList[sqlI] {
+		if !s.IsRetryConnect(sqlI) {
+			errs = append(errs, s.shardError(sqlI, "", ErrShardUnavailable))
 			continue
 		}
 		wg.Add(1)
 		sqlStr := "DELETE FROM `" + table + "` WHERE `" + forKey + "` IN ("
+		if isSoft {
+			sqlStr = "UPDATE `" + table + "` SET " + sd.deleted() + " WHERE " + sd.alive() + " AND `" + forKey + "` IN ("
+		}
 		where := ""
 		for i := 0; i < len(idList[sqlI]); i++ {
 			if where != "" {
@@ -85,12 +88,9 @@ func (s *Setting) Delete(table string, forKey string, ids []string, Debug *log.L
 			where += "'" + idList[sqlI][i] + "'"
 		}
 		sqlStr += where + ");"
-		if option.IsShowPrint {
-			fmt.Println("[", s.SqlConfigs[sqlI].DB, "]:", sqlStr)
-		}
 		chanRA := make(chan int64)
 		chanErr := make(chan error)
-		go s.go_exec(sqlI, sqlStr, nil, chanRA, chanErr, option.IsShowPrint, Debug)
+		go s.go_exec(ctx, sqlI, stmtMeta{op: "delete", table: table}, sqlStr, nil, nil, chanRA, chanErr, logger)
 		rRA := false
 		rE := false
 		for {
@@ -103,7 +103,9 @@ func (s *Setting) Delete(table string, forKey string, ids []string, Debug *log.L
 				}
 			case reErr := <-chanErr:
 				if !rE {
-					errs = append(errs, reErr)
+					if reErr != nil {
+						errs = append(errs, reErr)
+					}
 					close(chanErr)
 					rE = true
 				}
@@ -116,10 +118,8 @@ func (s *Setting) Delete(table string, forKey string, ids []string, Debug *log.L
 		wg.Done()
 	}
 	wg.Wait()
-	for _, v := range errs {
-		if v != nil {
-			return nil, errs
-		}
+	if len(errs) > 0 {
+		return reInt, errs
 	}
 	return reInt, nil
 }
@@ -0,0 +1,378 @@
+package weSubDatabase
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// COUNT(DISTINCT) 的计算方式
+//
+// How COUNT(DISTINCT) is computed
+type DistinctMode int
+
+const (
+	//	精确: 各数据库返回不重复的值, 合并后计数
+	//
+	//	Exact: each database returns its distinct values, they are counted after the merge
+	DistinctExact DistinctMode = iota
+	//	近似: 各数据库返回 HyperLogLog 的寄存器, 合并后估算, 标准误差约 1.6%
+	//
+	//	Approximate: each database returns HyperLogLog registers, the count
+	//	is estimated after the merge, the standard error is about 1.6%
+	DistinctApprox
+)
+
+// HyperLogLog 的精度, 寄存器数目为 2^hllPrecision
+//
+// Precision of HyperLogLog, there are 2^hllPrecision registers
+const hllPrecision = 12
+
+// ===============
+//
+//	去重查询: 在 SQL 中加入 DISTINCT, 合并后去掉各数据库之间重复的行
+//	比较时忽略 db 字段, 保留第一次出现的行
+//	分页在各数据库上执行, 去重后的行数可能少于分页的行数
+//	Distinct	bool	"是否去重"
+//
+// ===============
+//
+//	Distinct query: DISTINCT is added to the SQL, rows repeated across the
+//	databases are removed after the merge
+//	The db field is ignored when comparing, the first row is kept
+//	Paging runs on each database, there may be fewer rows than the page size
+//	after de-duplication
+//	Distinct	bool	"Whether to de-duplicate"
+func ODistinct(Distinct bool) IsShowPrintO {
+	return func(o *Option) {
+		o.Distinct = Distinct
+	}
+}
+
+// 在查询字段前加入 DISTINCT
+//
+// Add DISTINCT before the query fields
+func distinctFrom(from string) string {
+	if from == "" {
+		from = "*"
+	}
+	trimmed := strings.TrimSpace(from)
+	if len(trimmed) >= 8 && strings.EqualFold(trimmed[:8], "DISTINCT") {
+		return from
+	}
+	return "DISTINCT " + from
+}
+
+// ===============
+//
+//	去掉重复的行, 比较时忽略 db 字段
+//	行中有主键时比较 db 字段, 不同数据库中主键相同的行是不同的数据
+//	queryDatas	[]map[string]string	"查询结果"
+//	primaryKey	string			"主键, 可以为空字符串"
+//	return		[]map[string]string	"去重后的结果, 保留第一次出现的行"
+//
+// ===============
+//
+//	Remove repeated rows, the db field is ignored when comparing
+//	The db field is compared when the row has the primary key, rows with the
+//	same primary key on different databases are different data
+//	queryDatas	[]map[string]string	"Query result"
+//	primaryKey	string			"Primary key, can be empty"
+//	return		[]map[string]string	"De-duplicated result, the first row is kept"
+func distinctRows(queryDatas []map[string]string, primaryKey string) []map[string]string {
+	seen := map[string]bool{}
+	rows := queryDatas[:0]
+	for _, qd := range queryDatas {
+		key := rowKey(qd)
+		if _, ok := qd[primaryKey]; ok && primaryKey != "" {
+			key = strconv.Quote(qd["db"]) + ":" + key
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		rows = append(rows, qd)
+	}
+	return rows
+}
+
+func rowKey(row map[string]string) string {
+	cols := make([]string, 0, len(row))
+	for k := range row {
+		if k != "db" {
+			cols = append(cols, k)
+		}
+	}
+	sort.Strings(cols)
+	var b strings.Builder
+	for _, k := range cols {
+		b.WriteString(strconv.Quote(k))
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(row[k]))
+		b.WriteByte(',')
+	}
+	return b.String()
+}
+
+// ===============
+//
+//	根据 *Setting 从数据库集中统计不重复的值, 即 COUNT(DISTINCT column)
+//	同一个值在多个数据库中只计数一次, NULL 不计数
+//	table		string			"表名"
+//	column		string			"统计的字段"
+//	groupBy		[]string		"分组字段, 可以为 nil"
+//	where		string			"查询条件"
+//	mode		DistinctMode		"计算方式, DistinctExact 或 DistinctApprox"
+//	Debug		*log.Logger		"调试日志对象"
+//	options		[]IsShowPrintO		"配置"
+//		IsShowPrint	bool			"是否输出到控制台"
+//		Context		context.Context		"调用方的上下文"
+//		IsReadPrimary	bool			"是否只从主库读取"
+//		PartialPolicy	PartialPolicy		"部分结果策略"
+//		Quorum		int			"法定数目"
+//		Report		*ShardReport		"接收各数据库的应答情况"
+//	return 1	[]map[string]string	"每组一行, 包含分组字段和
+//											COUNT(DISTINCT column)"
+//	return 2	Errors			"错误信息"
+//
+// ===============
+//
+//	According to *Setting, count the distinct values on the database set,
+//	that is COUNT(DISTINCT column)
+//	A value on several databases is counted once, NULL is not counted
+//	table		string			"Table name"
+//	column		string			"Column to count"
+//	groupBy		[]string		"Group by columns, can be nil"
+//	where		string			"Query condition"
+//	mode		DistinctMode		"How it is computed, DistinctExact
+//											or DistinctApprox"
+//	Debug		*log.Logger		"Debug log object"
+//	options		[]IsShowPrintO		"Configuration"
+//		IsShowPrint	bool			"Whether to output to the
+//											console"
+//		Context		context.Context		"Context of the caller"
+//		IsReadPrimary	bool			"Whether to read only from
+//											the primary"
+//		PartialPolicy	PartialPolicy		"Partial result policy"
+//		Quorum		int			"Quorum"
+//		Report		*ShardReport		"Receives how each database
+//											answered"
+//	return 1	[]map[string]string	"One row per group with the group
+//											by columns and
+//											COUNT(DISTINCT column)"
+//	return 2	Errors			"Error message"
+func (s *Setting) CountDistinct(table string, column string, groupBy []string, where string, mode DistinctMode, Debug *log.Logger, options ...IsShowPrintO) (counts []map[string]string, errs Errors) {
+	option := &Option{
+		IsShowPrint: false,
+	}
+	for _, o := range options {
+		o(option)
+	}
+	logger := s.logger(Debug, option.IsShowPrint)
+	ctx, span := s.startCall(option, "CountDistinct", table)
+	defer func() { endSpan(span, errs.Err()) }()
+
+	sqlStr, groupCols, err := countDistinctSQL(table, column, groupBy, s.aliveWhere(table, where, option), mode)
+	if err != nil {
+		return nil, Errors{err}
+	}
+	isContinues, report := s.readableShards(nil)
+	if err := option.checkSkipped(report); err != nil {
+		return nil, Errors{err}
+	}
+	sqlStrs := make([]string, len(s.SqlConfigs))
+	for i := 0; i < len(s.SqlConfigs); i++ {
+		if isContinues[i] {
+			sqlStrs[i] = sqlStr
+		}
+	}
+	queryDatas, errs := s.queryShards(ctx, stmtMeta{op: "query", table: table}, sqlStrs, nil, report, option, logger)
+	if err := option.finishReport(report); err != nil {
+		return nil, append(errs, err)
+	}
+	as := "COUNT(DISTINCT " + strings.ReplaceAll(strings.TrimSpace(column), "`", "") + ")"
+	if mode == DistinctApprox {
+		counts = mergeHLL(queryDatas, groupCols, as)
+	} else {
+		counts = mergeDistinct(queryDatas, groupCols, as)
+	}
+	if len(errs) > 0 {
+		return counts, errs
+	}
+	return counts, nil
+}
+
+// ===============
+//
+//	生成各数据库执行的SQL指令
+//	精确: SELECT DISTINCT 分组字段, 统计的字段
+//	近似: 每组每个寄存器的最大秩, 哈希为 MD5 的前 64 位
+//	table		string		"表名"
+//	column		string		"统计的字段"
+//	groupBy		[]string	"分组字段"
+//	where		string		"查询条件"
+//	mode		DistinctMode	"计算方式"
+//	return 1	string		"SQL指令"
+//	return 2	[]string	"去掉反引号的分组字段"
+//	return 3	error		"错误信息"
+//
+// ===============
+//
+//	Create the SQL instruction run on each database
+//	Exact: SELECT DISTINCT group by columns, counted column
+//	Approximate: the maximum rank of each register of each group, the hash
+//	is the first 64 bits of MD5
+//	table		string		"Table name"
+//	column		string		"Column to count"
+//	groupBy		[]string	"Group by columns"
+//	where		string		"Query condition"
+//	mode		DistinctMode	"How it is computed"
+//	return 1	string		"SQL instruction"
+//	return 2	[]string	"Group by columns without backticks"
+//	return 3	error		"Error message"
+func countDistinctSQL(table string, column string, groupBy []string, where string, mode DistinctMode) (string, []string, error) {
+	column = strings.ReplaceAll(strings.TrimSpace(column), "`", "")
+	if !isIdentifier(column) {
+		return "", nil, fmt.Errorf("%w: distinct column %q", ErrInvalidArgument, column)
+	}
+	var groupCols, selects []string
+	for _, g := range groupBy {
+		g = strings.ReplaceAll(strings.TrimSpace(g), "`", "")
+		if !isIdentifier(g) {
+			return "", nil, fmt.Errorf("%w: group by column %q", ErrInvalidArgument, g)
+		}
+		groupCols = append(groupCols, g)
+		selects = append(selects, "`"+g+"`")
+	}
+	cond := "`" + column + "` IS NOT NULL"
+	if where != "" {
+		cond = "(" + where + ") AND " + cond
+	}
+	switch mode {
+	case DistinctExact:
+		selects = append(selects, "`"+column+"` AS `distinct_value`")
+		return "SELECT DISTINCT " + strings.Join(selects, ",") + " FROM `" + table + "` WHERE " + cond, groupCols, nil
+	case DistinctApprox:
+		width := strconv.Itoa(64 - hllPrecision)
+		hash := "CAST(CONV(LEFT(MD5(`" + column + "`),16),16,10) AS UNSIGNED)"
+		w := "(" + hash + " >> " + strconv.Itoa(hllPrecision) + ")"
+		inner := append(append([]string{}, selects...),
+			hash+" & "+strconv.Itoa(1<<hllPrecision-1)+" AS `bucket`",
+			"IF("+w+" = 0, "+width+" + 1, "+width+" - LENGTH(BIN("+w+")) + 1) AS `rank`",
+		)
+		outer := append(append([]string{}, selects...), "`bucket`", "MAX(`rank`) AS `rank`")
+		sqlStr := "SELECT " + strings.Join(outer, ",") + " FROM (SELECT " + strings.Join(inner, ",") +
+			" FROM `" + table + "` WHERE " + cond + ") AS `hll` GROUP BY " + strings.Join(append(selects, "`bucket`"), ",")
+		return sqlStr, groupCols, nil
+	}
+	return "", nil, fmt.Errorf("%w: distinct mode %d", ErrInvalidArgument, mode)
+}
+
+// 分组的键和分组字段
+//
+// Key and group by columns of a group
+func groupKey(qd map[string]string, groupCols []string) (string, map[string]string) {
+	row := map[string]string{}
+	vals := make([]string, len(groupCols))
+	for i, g := range groupCols {
+		vals[i] = qd[g]
+		row[g] = qd[g]
+	}
+	return strings.Join(vals, "\x00"), row
+}
+
+// 精确合并: 每组不重复的值计数
+//
+// Exact merge: count the distinct values of each group
+func mergeDistinct(queryDatas []map[string]string, groupCols []string, as string) []map[string]string {
+	var keys []string
+	rows := map[string]map[string]string{}
+	values := map[string]map[string]bool{}
+	for _, qd := range queryDatas {
+		key, row := groupKey(qd, groupCols)
+		if _, ok := rows[key]; !ok {
+			rows[key] = row
+			values[key] = map[string]bool{}
+			keys = append(keys, key)
+		}
+		values[key][qd["distinct_value"]] = true
+	}
+	return finishCounts(keys, rows, groupCols, as, func(key string) int64 {
+		return int64(len(values[key]))
+	})
+}
+
+// 近似合并: 每个寄存器取各数据库的最大值后估算
+//
+// Approximate merge: take the maximum of each register across the databases, then estimate
+func mergeHLL(queryDatas []map[string]string, groupCols []string, as string) []map[string]string {
+	var keys []string
+	rows := map[string]map[string]string{}
+	registers := map[string][]uint8{}
+	for _, qd := range queryDatas {
+		key, row := groupKey(qd, groupCols)
+		if _, ok := rows[key]; !ok {
+			rows[key] = row
+			registers[key] = make([]uint8, 1<<hllPrecision)
+			keys = append(keys, key)
+		}
+		bucket, err := strconv.Atoi(qd["bucket"])
+		if err != nil || bucket < 0 || bucket >= 1<<hllPrecision {
+			continue
+		}
+		rank, err := strconv.Atoi(qd["rank"])
+		if err != nil || rank < 0 || rank > 64 {
+			continue
+		}
+		if uint8(rank) > registers[key][bucket] {
+			registers[key][bucket] = uint8(rank)
+		}
+	}
+	return finishCounts(keys, rows, groupCols, as, func(key string) int64 {
+		return hllEstimate(registers[key])
+	})
+}
+
+func finishCounts(keys []string, rows map[string]map[string]string, groupCols []string, as string, count func(key string) int64) []map[string]string {
+	counts := make([]map[string]string, 0, len(keys))
+	for _, key := range keys {
+		row := rows[key]
+		row[as] = strconv.FormatInt(count(key), 10)
+		counts = append(counts, row)
+	}
+	if len(counts) == 0 && len(groupCols) == 0 {
+		counts = append(counts, map[string]string{as: "0"})
+	}
+	return counts
+}
+
+// ===============
+//
+//	HyperLogLog 估算, 基数较小时使用线性计数
+//	registers	[]uint8	"寄存器, 数目为 2 的幂"
+//	return		int64	"估算的基数"
+//
+// ===============
+//
+//	HyperLogLog estimate, linear counting is used for small cardinalities
+//	registers	[]uint8	"Registers, the number is a power of 2"
+//	return		int64	"Estimated cardinality"
+func hllEstimate(registers []uint8) int64 {
+	m := float64(len(registers))
+	sum := 0.0
+	zeros := 0
+	for _, r := range registers {
+		sum += math.Pow(2, -float64(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(math.Round(estimate))
+}
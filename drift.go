@@ -0,0 +1,556 @@
+package weSubDatabase
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// 结构差异的类型
+//
+// Kind of a schema difference
+type DriftKind int
+
+const (
+	//	缺少表
+	//
+	//	The table is missing
+	DriftMissingTable DriftKind = iota
+	//	多出的表
+	//
+	//	The table is not in the reference
+	DriftExtraTable
+	//	缺少字段
+	//
+	//	The column is missing
+	DriftMissingColumn
+	//	多出的字段
+	//
+	//	The column is not in the reference
+	DriftExtraColumn
+	//	字段类型或是否可为 NULL 不同
+	//
+	//	The column type or nullability differs
+	DriftColumnType
+	//	缺少索引
+	//
+	//	The index is missing
+	DriftMissingIndex
+	//	多出的索引
+	//
+	//	The index is not in the reference
+	DriftExtraIndex
+	//	索引的字段或唯一性不同
+	//
+	//	The columns or uniqueness of the index differ
+	DriftIndex
+	//	表或字段的字符集, 排序规则不同
+	//
+	//	The charset or collation of the table or column differs
+	DriftCharset
+	//	表的存储引擎不同
+	//
+	//	The storage engine of the table differs
+	DriftTableOption
+)
+
+func (k DriftKind) String() string {
+	switch k {
+	case DriftMissingTable:
+		return "missing_table"
+	case DriftExtraTable:
+		return "extra_table"
+	case DriftMissingColumn:
+		return "missing_column"
+	case DriftExtraColumn:
+		return "extra_column"
+	case DriftColumnType:
+		return "column_type"
+	case DriftMissingIndex:
+		return "missing_index"
+	case DriftExtraIndex:
+		return "extra_index"
+	case DriftIndex:
+		return "index"
+	case DriftCharset:
+		return "charset"
+	case DriftTableOption:
+		return "table_option"
+	default:
+		return "unknown"
+	}
+}
+
+func (k DriftKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// 一个数据库与参照之间的一处差异
+//
+// One difference between a database and the reference
+type SchemaDiff struct {
+	//	数据库在配置中的位置
+	//
+	//	Location of the database in the configuration
+	Shard int
+	//	数据库名
+	//
+	//	Database name
+	DB string
+	//	差异的类型
+	//
+	//	Kind of the difference
+	Kind DriftKind
+	//	表名
+	//
+	//	Table name
+	Table string
+	//	字段名或索引名, 表的差异时为空
+	//
+	//	Column or index name, empty for differences of the table
+	Name string
+	//	参照的定义, 缺少时为空
+	//
+	//	Definition in the reference, empty when missing
+	Want string
+	//	这个数据库的定义, 缺少时为空
+	//
+	//	Definition on this database, empty when missing
+	Got string
+}
+
+// 结构漂移检查的结果
+//
+// Result of the schema drift check
+type DriftReport struct {
+	//	参照的数据库, -1 为按多数数据库的定义比较
+	//
+	//	Reference database, -1 compares with the definition of most databases
+	Reference int
+	//	成功读取结构的数据库
+	//
+	//	Databases whose schema was read
+	Checked []int
+	//	差异, 按数据库, 表, 名称排序
+	//
+	//	Differences, sorted by database, table and name
+	Diffs []SchemaDiff
+}
+
+// ===============
+//
+//	有差异的数据库
+//	return		[]int		"数据库在配置中的位置"
+//
+// ===============
+//
+//	Databases with differences
+//	return		[]int		"Location of the databases in the configuration"
+func (r *DriftReport) Drifted() []int {
+	drifted := []int{}
+	for _, diff := range r.Diffs {
+		if len(drifted) == 0 || drifted[len(drifted)-1] != diff.Shard {
+			drifted = append(drifted, diff.Shard)
+		}
+	}
+	return drifted
+}
+
+// 结构中的一项: 表, 表选项, 字段, 字段字符集或索引
+//
+// One item of a schema: table, table option, column, column charset or index
+type schemaItem struct {
+	kind  int
+	table string
+	name  string
+}
+
+const (
+	itemTable = iota
+	itemEngine
+	itemTableCharset
+	itemColumn
+	itemColumnCharset
+	itemIndex
+)
+
+// 一个数据库的结构, 值为项的定义
+//
+// Schema of one database, the values are the definitions of the items
+type shardSchema map[schemaItem]string
+
+// ===============
+//
+//	读取所有数据库的 INFORMATION_SCHEMA, 比较表, 字段, 索引和字符集
+//	跨库 Query 合并结果时要求各数据库的字段一致, 可在启动时检查
+//	tables		[]string		"检查的表, 空为所有表"
+//	reference	int			"参照的数据库, -1 为按多数数据库的定义比较"
+//	Debug		*log.Logger		"调试输出"
+//	options		[]IsShowPrintO		"配置"
+//		IsShowPrint	bool			"是否输出到控制台"
+//		Context		context.Context		"调用方的上下文"
+//	return 1	DriftReport		"差异报告"
+//	return 2	Errors			"错误信息, 读取失败的数据库不参与比较"
+//
+// ===============
+//
+//	Read INFORMATION_SCHEMA of all databases and compare the tables,
+//	columns, indexes and charsets
+//	Merging the results of a cross-database Query needs the same columns on
+//	each database, this can be checked on startup
+//	tables		[]string		"Tables to check, empty for all tables"
+//	reference	int			"Reference database, -1 compares with
+//						 	the definition of most databases"
+//	Debug		*log.Logger		"Debug output"
+//	options		[]IsShowPrintO		"Configuration"
+//		IsShowPrint	bool			"Whether to output to the console"
+//		Context		context.Context		"Context of the caller"
+//	return 1	DriftReport		"Difference report"
+//	return 2	Errors			"Error message, databases that cannot
+//						 	be read are left out of the comparison"
+func (s *Setting) SchemaDrift(tables []string, reference int, Debug *log.Logger, options ...IsShowPrintO) (report DriftReport, errs Errors) {
+	option := &Option{
+		IsShowPrint: false,
+	}
+	for _, o := range options {
+		o(option)
+	}
+	logger := s.logger(Debug, option.IsShowPrint)
+	ctx, span := s.startCall(option, "SchemaDrift", "information_schema")
+	defer func() { endSpan(span, errs.Err()) }()
+	report.Reference = reference
+	if reference < -1 || reference >= len(s.SqlConfigs) {
+		return report, Errors{fmt.Errorf("%w: reference %d", ErrOutOfRange, reference)}
+	}
+	for _, table := range tables {
+		if !isIdentifier(table) {
+			return report, Errors{fmt.Errorf("%w: table %q", ErrInvalidArgument, table)}
+		}
+	}
+	schemas := make([]shardSchema, len(s.SqlConfigs))
+	for i := range s.SqlConfigs {
+		schema, err := s.readSchema(ctx, i, tables, logger)
+		if err != nil {
+			errs = append(errs, s.shardError(i, "", err))
+			continue
+		}
+		schemas[i] = schema
+		report.Checked = append(report.Checked, i)
+	}
+	if reference >= 0 && schemas[reference] == nil {
+		return report, errs
+	}
+	report.Diffs = diffSchemas(schemas, reference)
+	for k := range report.Diffs {
+		report.Diffs[k].DB = s.SqlConfigs[report.Diffs[k].Shard].DB
+	}
+	if drifted := report.Drifted(); len(drifted) > 0 {
+		logger.Warn("mysql schema drift", "shards", drifted, "diffs", len(report.Diffs))
+	}
+	if len(errs) > 0 {
+		return report, errs
+	}
+	return report, nil
+}
+
+// ===============
+//
+//	从主库读取一个数据库的表, 字段和索引
+//	ctx		context.Context	"父 span 的上下文"
+//	i		int		"数据库在配置中的位置"
+//	tables		[]string	"读取的表, 空为所有表"
+//	logger		*slog.Logger	"日志对象"
+//	return 1	shardSchema	"数据库的结构"
+//	return 2	error		"错误信息"
+//
+// ===============
+//
+//	Read the tables, columns and indexes of one database from the primary
+//	ctx		context.Context	"Context of the parent span"
+//	i		int		"Location of the database in the configuration"
+//	tables		[]string	"Tables to read, empty for all tables"
+//	logger		*slog.Logger	"Logger"
+//	return 1	shardSchema	"Schema of the database"
+//	return 2	error		"Error message"
+func (s *Setting) readSchema(ctx context.Context, i int, tables []string, logger *slog.Logger) (shardSchema, error) {
+	if !s.IsRetryConnect(i) {
+		return nil, ErrShardUnavailable
+	}
+	filter := ""
+	var args []interface{}
+	if len(tables) > 0 {
+		filter = " AND TABLE_NAME IN (" + placeholders(len(tables)) + ")"
+		for _, table := range tables {
+			args = append(args, table)
+		}
+	}
+	queries := []string{
+		"SELECT TABLE_NAME AS tbl, ENGINE AS engine, TABLE_COLLATION AS collation FROM INFORMATION_SCHEMA.TABLES WHERE TABLE_SCHEMA=DATABASE() AND TABLE_TYPE='BASE TABLE'" + filter,
+		"SELECT TABLE_NAME AS tbl, COLUMN_NAME AS name, COLUMN_TYPE AS type, IS_NULLABLE AS nullable, CHARACTER_SET_NAME AS charset, COLLATION_NAME AS collation FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA=DATABASE()" + filter,
+		"SELECT TABLE_NAME AS tbl, INDEX_NAME AS name, NON_UNIQUE AS non_unique, COLUMN_NAME AS col FROM INFORMATION_SCHEMA.STATISTICS WHERE TABLE_SCHEMA=DATABASE()" + filter + " ORDER BY TABLE_NAME, INDEX_NAME, SEQ_IN_INDEX",
+	}
+	results := [][]map[string]string{}
+	meta := stmtMeta{op: "query", table: "information_schema"}
+	for _, sqlStr := range queries {
+		reqd := make(chan []map[string]string)
+		reerr := make(chan error)
+		go s.go_query(ctx, i, meta, sqlStr, args, reqd, reerr, true, logger)
+		qd := <-reqd
+		if err := <-reerr; err != nil {
+			return nil, err
+		}
+		results = append(results, qd)
+	}
+	return buildSchema(results[0], results[1], results[2]), nil
+}
+
+// ===============
+//
+//	把 INFORMATION_SCHEMA 的查询结果转为结构项
+//	tableRows	[]map[string]string	"TABLES 的结果"
+//	columnRows	[]map[string]string	"COLUMNS 的结果"
+//	indexRows	[]map[string]string	"按索引和顺序排序的 STATISTICS 的结果"
+//	return		shardSchema		"数据库的结构"
+//
+// ===============
+//
+//	Turn the INFORMATION_SCHEMA results into schema items
+//	tableRows	[]map[string]string	"Result of TABLES"
+//	columnRows	[]map[string]string	"Result of COLUMNS"
+//	indexRows	[]map[string]string	"Result of STATISTICS sorted by
+//						 	index and sequence"
+//	return		shardSchema		"Schema of the database"
+func buildSchema(tableRows []map[string]string, columnRows []map[string]string, indexRows []map[string]string) shardSchema {
+	schema := shardSchema{}
+	for _, row := range tableRows {
+		table := row["tbl"]
+		schema[schemaItem{itemTable, table, ""}] = ""
+		schema[schemaItem{itemEngine, table, ""}] = row["engine"]
+		schema[schemaItem{itemTableCharset, table, ""}] = row["collation"]
+	}
+	for _, row := range columnRows {
+		table := row["tbl"]
+		if _, ok := schema[schemaItem{itemTable, table, ""}]; !ok {
+			// 视图的字段
+			// Columns of a view
+			continue
+		}
+		def := row["type"]
+		if row["nullable"] == "YES" {
+			def += " NULL"
+		} else {
+			def += " NOT NULL"
+		}
+		schema[schemaItem{itemColumn, table, row["name"]}] = def
+		if row["charset"] != "" {
+			schema[schemaItem{itemColumnCharset, table, row["name"]}] = row["charset"] + " " + row["collation"]
+		}
+	}
+	indexes := map[schemaItem][]string{}
+	unique := map[schemaItem]bool{}
+	for _, row := range indexRows {
+		item := schemaItem{itemIndex, row["tbl"], row["name"]}
+		indexes[item] = append(indexes[item], row["col"])
+		unique[item] = row["non_unique"] == "0"
+	}
+	for item, cols := range indexes {
+		def := "(" + strings.Join(cols, ",") + ")"
+		if unique[item] {
+			def = "UNIQUE " + def
+		}
+		schema[item] = def
+	}
+	return schema
+}
+
+// ===============
+//
+//	比较每个数据库与参照的结构, 缺少或多出的表不再比较其中的项,
+//	字段类型不同时不再比较字段的字符集
+//	schemas		[]shardSchema	"每个数据库的结构, 未读取的为 nil"
+//	reference	int		"参照的数据库, -1 为按多数数据库的定义比较"
+//	return		[]SchemaDiff	"按数据库, 表, 名称排序的差异"
+//
+// ===============
+//
+//	Compare the schema of each database with the reference, the items of a
+//	missing or extra table are not compared, and the charset of a column is
+//	not compared when its type differs
+//	schemas		[]shardSchema	"Schema of each database, nil when not read"
+//	reference	int		"Reference database, -1 compares with the
+//					 	definition of most databases"
+//	return		[]SchemaDiff	"Differences sorted by database, table and name"
+func diffSchemas(schemas []shardSchema, reference int) []SchemaDiff {
+	var want shardSchema
+	if reference >= 0 {
+		want = schemas[reference]
+	} else {
+		want = majoritySchema(schemas)
+	}
+	diffs := []SchemaDiff{}
+	for i, got := range schemas {
+		if got == nil || i == reference {
+			continue
+		}
+		shardDiffs := []SchemaDiff{}
+		skip := map[schemaItem]bool{}
+		add := func(kind DriftKind, item schemaItem, w string, g string) {
+			shardDiffs = append(shardDiffs, SchemaDiff{Shard: i, Kind: kind, Table: item.table, Name: item.name, Want: w, Got: g})
+		}
+		// 先比较表和字段, 以便跳过其中的项
+		// Tables and columns are compared first so their items can be skipped
+		for _, kind := range []int{itemTable, itemColumn} {
+			for item, w := range want {
+				if item.kind != kind || skip[schemaItem{itemTable, item.table, ""}] {
+					continue
+				}
+				g, ok := got[item]
+				switch {
+				case !ok && kind == itemTable:
+					add(DriftMissingTable, item, "", "")
+				case !ok:
+					add(DriftMissingColumn, item, w, "")
+				case w != g:
+					add(DriftColumnType, item, w, g)
+				default:
+					continue
+				}
+				skip[item] = true
+			}
+			for item, g := range got {
+				if item.kind != kind || skip[schemaItem{itemTable, item.table, ""}] {
+					continue
+				}
+				if _, ok := want[item]; ok {
+					continue
+				}
+				if kind == itemTable {
+					add(DriftExtraTable, item, "", "")
+				} else {
+					add(DriftExtraColumn, item, "", g)
+				}
+				skip[item] = true
+			}
+		}
+		for _, item := range unionItems(want, got) {
+			if item.kind == itemTable || item.kind == itemColumn || skip[schemaItem{itemTable, item.table, ""}] {
+				continue
+			}
+			w, wok := want[item]
+			g, gok := got[item]
+			if wok == gok && w == g {
+				continue
+			}
+			switch item.kind {
+			case itemEngine:
+				add(DriftTableOption, item, w, g)
+			case itemTableCharset:
+				add(DriftCharset, item, w, g)
+			case itemColumnCharset:
+				if !skip[schemaItem{itemColumn, item.table, item.name}] {
+					add(DriftCharset, item, w, g)
+				}
+			case itemIndex:
+				if !gok {
+					add(DriftMissingIndex, item, w, "")
+				} else if !wok {
+					add(DriftExtraIndex, item, "", g)
+				} else {
+					add(DriftIndex, item, w, g)
+				}
+			}
+		}
+		sort.SliceStable(shardDiffs, func(a, b int) bool {
+			if shardDiffs[a].Table != shardDiffs[b].Table {
+				return shardDiffs[a].Table < shardDiffs[b].Table
+			}
+			if shardDiffs[a].Name != shardDiffs[b].Name {
+				return shardDiffs[a].Name < shardDiffs[b].Name
+			}
+			return shardDiffs[a].Kind < shardDiffs[b].Kind
+		})
+		diffs = append(diffs, shardDiffs...)
+	}
+	return diffs
+}
+
+// ===============
+//
+//	按多数数据库生成参照结构: 超过一半数据库存在的项保留,
+//	定义取最多数据库相同的一个, 数目相同时取位置靠前的数据库的定义
+//	schemas		[]shardSchema	"每个数据库的结构, 未读取的为 nil"
+//	return		shardSchema	"参照结构"
+//
+// ===============
+//
+//	Build the reference schema from most databases: items on more than half
+//	of the databases are kept, with the definition shared by the most
+//	databases, or the one of the earlier database on a tie
+//	schemas		[]shardSchema	"Schema of each database, nil when not read"
+//	return		shardSchema	"Reference schema"
+func majoritySchema(schemas []shardSchema) shardSchema {
+	n := 0
+	counts := map[schemaItem]map[string]int{}
+	first := map[schemaItem][]string{}
+	for _, schema := range schemas {
+		if schema == nil {
+			continue
+		}
+		n++
+		for item, def := range schema {
+			if counts[item] == nil {
+				counts[item] = map[string]int{}
+			}
+			if counts[item][def] == 0 {
+				first[item] = append(first[item], def)
+			}
+			counts[item][def]++
+		}
+	}
+	want := shardSchema{}
+	for item, defs := range counts {
+		total := 0
+		for _, c := range defs {
+			total += c
+		}
+		if total*2 <= n {
+			continue
+		}
+		best := ""
+		for k, def := range first[item] {
+			if k == 0 || defs[def] > defs[best] {
+				best = def
+			}
+		}
+		want[item] = best
+	}
+	return want
+}
+
+// 两个结构中所有的项, 排序以便结果稳定
+//
+// All items of two schemas, sorted so the result is stable
+func unionItems(a shardSchema, b shardSchema) []schemaItem {
+	items := []schemaItem{}
+	for item := range a {
+		items = append(items, item)
+	}
+	for item := range b {
+		if _, ok := a[item]; !ok {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(x, y int) bool {
+		if items[x].table != items[y].table {
+			return items[x].table < items[y].table
+		}
+		if items[x].name != items[y].name {
+			return items[x].name < items[y].name
+		}
+		return items[x].kind < items[y].kind
+	})
+	return items
+}
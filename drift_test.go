@@ -0,0 +1,61 @@
+package weSubDatabase
+
+import (
+	"reflect"
+	"testing"
+)
+
+func driftSchema(columnType string, withIndex bool, collation string) shardSchema {
+	tables := []map[string]string{
+		{"tbl": "data", "engine": "InnoDB", "collation": collation},
+	}
+	columns := []map[string]string{
+		{"tbl": "data", "name": "id", "type": "bigint", "nullable": "NO"},
+		{"tbl": "data", "name": "name", "type": columnType, "nullable": "YES", "charset": "utf8mb4", "collation": collation},
+	}
+	indexes := []map[string]string{
+		{"tbl": "data", "name": "PRIMARY", "non_unique": "0", "col": "id"},
+	}
+	if withIndex {
+		indexes = append(indexes, map[string]string{"tbl": "data", "name": "idx_name", "non_unique": "1", "col": "name"})
+	}
+	return buildSchema(tables, columns, indexes)
+}
+
+func TestDriftReference(t *testing.T) {
+	schemas := []shardSchema{
+		driftSchema("varchar(32)", true, "utf8mb4_general_ci"),
+		driftSchema("varchar(64)", false, "utf8mb4_general_ci"),
+		nil,
+		buildSchema(nil, nil, nil),
+	}
+	got := diffSchemas(schemas, 0)
+	want := []SchemaDiff{
+		{Shard: 1, Kind: DriftMissingIndex, Table: "data", Name: "idx_name", Want: "(name)"},
+		{Shard: 1, Kind: DriftColumnType, Table: "data", Name: "name", Want: "varchar(32) NULL", Got: "varchar(64) NULL"},
+		{Shard: 3, Kind: DriftMissingTable, Table: "data"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatal("diffSchemas:", got)
+	}
+	report := DriftReport{Diffs: got}
+	if shards := report.Drifted(); !reflect.DeepEqual(shards, []int{1, 3}) {
+		t.Error("Drifted:", shards)
+	}
+}
+
+func TestDriftMajority(t *testing.T) {
+	schemas := []shardSchema{
+		driftSchema("varchar(32)", true, "utf8mb4_general_ci"),
+		driftSchema("varchar(32)", true, "utf8mb4_bin"),
+		driftSchema("varchar(32)", true, "utf8mb4_general_ci"),
+	}
+	got := diffSchemas(schemas, -1)
+	want := []SchemaDiff{
+		{Shard: 1, Kind: DriftCharset, Table: "data", Want: "utf8mb4_general_ci", Got: "utf8mb4_bin"},
+		{Shard: 1, Kind: DriftCharset, Table: "data", Name: "name", Want: "utf8mb4 utf8mb4_general_ci", Got: "utf8mb4 utf8mb4_bin"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatal("diffSchemas:", got)
+	}
+}
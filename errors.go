@@ -0,0 +1,154 @@
+package weSubDatabase
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	//	检测到SQL注入
+	//
+	//	SQL injection detected
+	ErrInjectionDetected = errors.New("SQL injection detected")
+	//	连接池已满
+	//
+	//	The connection pool is exhausted
+	ErrPoolExhausted = errors.New("connection pool exhausted")
+	//	数据库不可用 (熔断中)
+	//
+	//	The database is unavailable (circuit breaker open)
+	ErrShardUnavailable = errors.New("shard unavailable")
+	//	结果不完整
+	//
+	//	The result is incomplete
+	ErrIncompleteResult = errors.New("incomplete result")
+	//	配置为空
+	//
+	//	The configuration is empty
+	ErrConfigEmpty = errors.New("configuration is empty")
+	//	位置或ID超出范围
+	//
+	//	Location or ID out of range
+	ErrOutOfRange = errors.New("out of range")
+	//	参数不正确
+	//
+	//	Invalid argument
+	ErrInvalidArgument = errors.New("invalid argument")
+	//	排序参数不正确
+	//
+	//	Invalid order
+	ErrInvalidOrder = errors.New("invalid order")
+	//	加密的键无法解密
+	//
+	//	The encrypted key cannot be decrypted
+	ErrInvalidKey = errors.New("invalid encrypted key")
+	//	语句被钩子否决
+	//
+	//	The statement was vetoed by a hook
+	ErrStatementVetoed = errors.New("statement vetoed")
+	//	版本不一致, 行已被其他调用修改或不存在
+	//
+	//	Version mismatch, the row was changed by another caller or does not exist
+	ErrVersionConflict = errors.New("version conflict")
+	//	数据库的结构版本落后于已注册的迁移
+	//
+	//	The schema version of the database is behind the registered migrations
+	ErrSchemaBehind = errors.New("schema behind")
+)
+
+// 单个数据库上的错误
+//
+// Error on a single database
+type ShardError struct {
+	//	数据库在配置中的位置
+	//
+	//	Location of the database in the configuration
+	Shard int
+	//	数据库名
+	//
+	//	Database name
+	DB string
+	//	执行的SQL指令, 未执行时为空
+	//
+	//	SQL instruction that was run, empty when not run
+	SQL string
+	//	原始错误
+	//
+	//	Original error
+	Err error
+}
+
+func (e *ShardError) Error() string {
+	return fmt.Sprintf("shard %d (%s): %v", e.Shard, e.DB, e.Err)
+}
+
+func (e *ShardError) Unwrap() error {
+	return e.Err
+}
+
+// ===============
+//
+//	生成数据库上的错误, err 为 nil 时返回 nil
+//	shard		int	"数据库在配置中的位置"
+//	sqlStr		string	"执行的SQL指令"
+//	err		error	"原始错误"
+//	return		error	"*ShardError"
+//
+// ===============
+//
+//	Create an error on a database, nil is returned when err is nil
+//	shard		int	"Location of the database in the configuration"
+//	sqlStr		string	"SQL instruction that was run"
+//	err		error	"Original error"
+//	return		error	"*ShardError"
+func (s *Setting) shardError(shard int, sqlStr string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var shardErr *ShardError
+	if errors.As(err, &shardErr) {
+		return err
+	}
+	name := ""
+	if shard >= 0 && shard < len(s.SqlConfigs) {
+		name = s.SqlConfigs[shard].DB
+	}
+	return &ShardError{Shard: shard, DB: name, SQL: sqlStr, Err: err}
+}
+
+// 分发操作的错误集合, 每一项都不为 nil
+// 数据库上的错误为 *ShardError, 可以使用 errors.Is 和 errors.As 检查
+//
+// Errors of a fan-out operation, no item is nil
+// Errors on a database are *ShardError and can be checked with errors.Is
+// and errors.As
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e Errors) Unwrap() []error {
+	return e
+}
+
+// ===============
+//
+//	转换为 error, 没有错误时返回 nil
+//	return		error	"错误信息"
+//
+// ===============
+//
+//	Convert to error, nil is returned when there is no error
+//	return		error	"Error message"
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,48 @@
+package weSubDatabase
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestShardErrors(t *testing.T) {
+	sqlSetting, err := New(testJsonStr)
+	if err != nil {
+		t.Error("initialization failed:", err)
+		return
+	}
+	err = sqlSetting.shardError(1, "SELECT 1", ErrPoolExhausted)
+	var shardErr *ShardError
+	if !errors.As(err, &shardErr) || shardErr.Shard != 1 || shardErr.DB != sqlSetting.SqlConfigs[1].DB {
+		t.Error("shard error:", err)
+	}
+	if sqlSetting.shardError(2, "", err) != err {
+		t.Error("shard error should not be wrapped twice")
+	}
+	errs := Errors{err}
+	if !errors.Is(errs, ErrPoolExhausted) || !errors.As(errs, &shardErr) {
+		t.Error("Errors should unwrap to its items:", errs)
+	}
+	if (Errors{}).Err() != nil {
+		t.Error("empty Errors should be nil")
+	}
+
+	_, errs = sqlSetting.Add("data", []string{"name"}, [][]string{{"a", "b"}}, nil)
+	if len(errs) != 1 || !errors.Is(errs, ErrInvalidArgument) {
+		t.Error("values of the wrong length should be rejected before inserting:", errs)
+	}
+
+	tn := time.Now()
+	for i := range sqlSetting.ConnectFailTime {
+		sqlSetting.ConnectFailTime[i] = &tn
+	}
+	_, errs = sqlSetting.Add("data", []string{"name"}, [][]string{{"a"}, {"b"}}, nil)
+	if !errors.Is(errs, ErrShardUnavailable) {
+		t.Error("Add should fail when no database is available:", errs)
+	}
+	_, errs = sqlSetting.Delete("data", "id", []string{"1"}, nil, OIPKIsPrimaryKey(false))
+	if len(errs) != len(sqlSetting.SqlConfigs) || !errors.Is(errs[0], ErrShardUnavailable) {
+		t.Error("Delete should report the unavailable databases:", errs)
+	}
+}
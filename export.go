@@ -0,0 +1,406 @@
+package weSubDatabase
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 导出时记录行所在数据库的字段, 不加密主键时写入
+//
+// Field recording the database of a row on export, written when the primary key is not encrypted
+const ExportShardColumn = "_shard"
+
+// 导出时记录值为 NULL 的字段的字段, 字段名用 , 连接, 没有 NULL 时为空字符串
+// NDJSON 编码器把这些字段写为 JSON null, 解码时还原; CSV 中作为一列保留
+//
+// Field recording the columns whose value is NULL on export, the names are
+// joined with , and it is empty when there is no NULL
+// The NDJSON encoder writes those columns as JSON null and the decoder
+// restores the field; in CSV it is kept as a column
+const ExportNullColumn = "_null"
+
+// Import 每次读取的行数
+//
+// Rows read by Import at a time
+const importBatchRows = 1000
+
+// ===============
+//
+//	从所有数据库流式导出一张表, 包含软删除的行
+//	primaryKey 不为空时主键用 SEKey 加密, 否则每行带有 _shard 字段记录所在的数据库
+//	值为 NULL 的字段记录在 _null 字段中, Import 时绑定为 NULL
+//	table		string			"表名"
+//	primaryKey	string			"主键, 空字符串时不加密"
+//	enc		RowEncoder		"输出格式, 如 NewNDJSONEncoder, NewCSVEncoder,
+//						 	parquet.NewEncoder"
+//	Debug		*log.Logger		"调试输出"
+//	options		[]IsShowPrintO		"配置"
+//		IsShowPrint	bool			"是否输出到控制台"
+//		Context		context.Context		"调用方的上下文"
+//		IsReadPrimary	bool			"是否只从主库读取"
+//		WithDeleted	bool			"是否包含软删除的行, 默认为 true"
+//	return 1	int64			"导出的行数"
+//	return 2	Errors			"错误信息"
+//
+// ===============
+//
+//	Export a table from all databases as a stream, soft-deleted rows included
+//	When primaryKey is not empty the primary key is encrypted with SEKey,
+//	otherwise each row has a _shard field recording its database
+//	Columns whose value is NULL are recorded in the _null field, Import binds
+//	them as NULL
+//	table		string			"Table name"
+//	primaryKey	string			"Primary key, not encrypted when empty"
+//	enc		RowEncoder		"Output format, such as NewNDJSONEncoder,
+//						 	NewCSVEncoder and parquet.NewEncoder"
+//	Debug		*log.Logger		"Debug output"
+//	options		[]IsShowPrintO		"Configuration"
+//		IsShowPrint	bool			"Whether to output to the console"
+//		Context		context.Context		"Context of the caller"
+//		IsReadPrimary	bool			"Whether to read only from the primary"
+//		WithDeleted	bool			"Whether to include soft-deleted
+//							 	rows, true by default"
+//	return 1	int64			"Rows exported"
+//	return 2	Errors			"Error message"
+func (s *Setting) Export(table string, primaryKey string, enc RowEncoder, Debug *log.Logger, options ...IsShowPrintO) (n int64, errs Errors) {
+	options = append([]IsShowPrintO{OWithDeleted(true)}, options...)
+	options = append(options, func(o *Option) { o.nullColumn = ExportNullColumn })
+	option := &Option{
+		IsShowPrint: false,
+	}
+	for _, o := range options {
+		o(option)
+	}
+	logger := s.logger(Debug, option.IsShowPrint)
+	if !isIdentifier(table) || (primaryKey != "" && !isIdentifier(primaryKey)) {
+		return 0, Errors{fmt.Errorf("%w: table %q or primary key %q", ErrInvalidArgument, table, primaryKey)}
+	}
+	if primaryKey != "" && s.SEKey == nil {
+		return 0, Errors{fmt.Errorf("%w: no key to encrypt ids", ErrInvalidKey)}
+	}
+	rows, err := s.QueryRows(table, "", primaryKey, "", "", Debug, options...)
+	if err != nil {
+		return 0, Errors{err}
+	}
+	defer rows.Close()
+	for rows.Next() {
+		row := rows.Row()
+		if primaryKey == "" {
+			row[ExportShardColumn] = row["db"]
+			delete(row, "db")
+		}
+		if err := enc.Encode(row); err != nil {
+			return n, Errors{fmt.Errorf("row %d: %w", n, err)}
+		}
+		n++
+	}
+	errs = rows.Err()
+	if err := enc.Flush(); err != nil {
+		errs = append(errs, err)
+	}
+	logger.Info("mysql export", "table", table, "rows", n)
+	if len(errs) > 0 {
+		return n, errs
+	}
+	return n, nil
+}
+
+// 导入时读取的一行
+//
+// One row read on import
+type importedRow struct {
+	row map[string]string
+	// 原来所在的数据库, -1 为需要重新分配
+	// Database the row was on, -1 when it has to be assigned again
+	origin int
+	// 值为 NULL 的字段
+	// Columns whose value is NULL
+	nulls []string
+	err   error
+}
+
+// ===============
+//
+//	把 Export 导出的行导入所有数据库, 按 InsertChunkRows 和 InsertChunkBytes 分批插入
+//	行原来所在的数据库 (_shard 字段或加密的主键) 在当前配置中存在时插入该数据库, 主键不变;
+//	否则按 Add 的方式轮流分配数据库, 并去掉主键由数据库生成新的主键
+//	值作为参数绑定, 不做 CheckString 检查, _null 字段中的字段绑定为 NULL
+//	table		string			"表名"
+//	primaryKey	string			"主键, 空字符串时按 _shard 字段分配"
+//	dec		RowDecoder		"输入格式, 如 NewNDJSONDecoder, NewCSVDecoder,
+//						 	parquet.NewDecoder"
+//	Debug		*log.Logger		"调试输出"
+//	options		[]IsShowPrintO		"配置"
+//		IsShowPrint	bool			"是否输出到控制台"
+//		Context		context.Context		"调用方的上下文"
+//		AddedRows	*[]AddedRow		"按读取的顺序接收每一行的插入结果"
+//	return 1	[]int64			"每个数据库插入的行数"
+//	return 2	Errors			"错误信息, 无法读取的行跳过, 格式错误时停止"
+//
+// ===============
+//
+//	Import the rows exported by Export into all databases, inserting in
+//	chunks of InsertChunkRows and InsertChunkBytes
+//	A row whose database (the _shard field or the encrypted primary key)
+//	exists in this configuration goes to that database with the same primary
+//	key; otherwise it is assigned in turn like Add, and the primary key is
+//	dropped so the database creates a new one
+//	Values are bound as arguments and not checked with CheckString, the
+//	columns in the _null field are bound as NULL
+//	table		string			"Table name"
+//	primaryKey	string			"Primary key, rows are assigned by the
+//						 	_shard field when empty"
+//	dec		RowDecoder		"Input format, such as NewNDJSONDecoder,
+//						 	NewCSVDecoder and parquet.NewDecoder"
+//	Debug		*log.Logger		"Debug output"
+//	options		[]IsShowPrintO		"Configuration"
+//		IsShowPrint	bool			"Whether to output to the console"
+//		Context		context.Context		"Context of the caller"
+//		AddedRows	*[]AddedRow		"Receives the insert result of every
+//							 	row in the order they were read"
+//	return 1	[]int64			"Rows inserted into each database"
+//	return 2	Errors			"Error message, rows that cannot be read
+//						 	are skipped, a format error stops the import"
+func (s *Setting) Import(table string, primaryKey string, dec RowDecoder, Debug *log.Logger, options ...IsShowPrintO) (counts []int64, errs Errors) {
+	option := &Option{
+		IsShowPrint: false,
+	}
+	for _, o := range options {
+		o(option)
+	}
+	logger := s.logger(Debug, option.IsShowPrint)
+	ctx, span := s.startCall(option, "Import", table)
+	defer func() { endSpan(span, errs.Err()) }()
+	if !isIdentifier(table) || (primaryKey != "" && !isIdentifier(primaryKey)) {
+		return nil, Errors{fmt.Errorf("%w: table %q or primary key %q", ErrInvalidArgument, table, primaryKey)}
+	}
+	counts = make([]int64, len(s.SqlConfigs))
+	added := []AddedRow{}
+	line := 0
+	for eof := false; !eof && ctx.Err() == nil; {
+		batch := []importedRow{}
+		for len(batch) < importBatchRows {
+			row, err := dec.Decode()
+			if err == io.EOF {
+				eof = true
+				break
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("row %d: %w", line, err))
+				eof = true
+				break
+			}
+			nulls := importNulls(row)
+			origin, err := s.importOrigin(row, primaryKey)
+			if err != nil {
+				err = fmt.Errorf("row %d: %w", line, err)
+				errs = append(errs, err)
+			}
+			batch = append(batch, importedRow{row: row, origin: origin, nulls: nulls, err: err})
+			line++
+		}
+		batchAdded, batchErrs := s.importRows(ctx, table, batch, option, counts, logger)
+		added = append(added, batchAdded...)
+		errs = append(errs, batchErrs...)
+	}
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	if option.AddedRows != nil {
+		*option.AddedRows = added
+	}
+	logger.Info("mysql import", "table", table, "rows", line, "counts", counts)
+	if len(errs) > 0 {
+		return counts, errs
+	}
+	return counts, nil
+}
+
+// 取出 _null 字段记录的 NULL 字段, 不在行中的字段补为空字符串
+//
+// Take out the NULL columns recorded in the _null field, columns missing from
+// the row are added as empty strings
+func importNulls(row map[string]string) []string {
+	v, ok := row[ExportNullColumn]
+	if !ok {
+		return nil
+	}
+	delete(row, ExportNullColumn)
+	if v == "" {
+		return nil
+	}
+	nulls := strings.Split(v, ",")
+	for _, col := range nulls {
+		if _, ok := row[col]; !ok {
+			row[col] = ""
+		}
+	}
+	return nulls
+}
+
+// ===============
+//
+//	取出行原来所在的数据库, 需要重新分配时去掉主键
+//	row		map[string]string	"读取的行, 会被修改"
+//	primaryKey	string			"主键"
+//	return 1	int			"原来所在的数据库, -1 为需要重新分配"
+//	return 2	error			"错误信息"
+//
+// ===============
+//
+//	Take out the database the row was on, the primary key is dropped when
+//	it has to be assigned again
+//	row		map[string]string	"Row read, it is changed"
+//	primaryKey	string			"Primary key"
+//	return 1	int			"Database the row was on, -1 when it has
+//						 	to be assigned again"
+//	return 2	error			"Error message"
+func (s *Setting) importOrigin(row map[string]string, primaryKey string) (int, error) {
+	origin := -1
+	if v, ok := row[ExportShardColumn]; ok {
+		delete(row, ExportShardColumn)
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return -1, fmt.Errorf("%w: %s %q", ErrInvalidArgument, ExportShardColumn, v)
+		}
+		origin = n
+	} else if primaryKey != "" && row[primaryKey] != "" {
+		if s.SEKey == nil {
+			return -1, fmt.Errorf("%w: no key to decrypt ids", ErrInvalidKey)
+		}
+		id, dbI, err := s.SEKey.Decrypt(row[primaryKey])
+		if err != nil {
+			return -1, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+		row[primaryKey] = id
+		if origin, err = strconv.Atoi(dbI); err != nil {
+			origin = -1
+		}
+	}
+	if origin < 0 || origin >= len(s.SqlConfigs) {
+		origin = -1
+		if primaryKey != "" {
+			delete(row, primaryKey)
+		}
+	}
+	if len(row) == 0 {
+		return -1, fmt.Errorf("%w: empty row", ErrInvalidArgument)
+	}
+	for col := range row {
+		if !isIdentifier(col) {
+			return -1, fmt.Errorf("%w: %q is not a valid name", ErrInvalidArgument, col)
+		}
+	}
+	return origin, nil
+}
+
+// ===============
+//
+//	插入一批行, 字段相同的行一起插入
+//	ctx		context.Context	"父 span 的上下文"
+//	table		string		"表名"
+//	batch		[]importedRow	"读取的行"
+//	option		*Option		"配置"
+//	counts		[]int64		"每个数据库插入的行数, 插入成功的行会加到这里"
+//	logger		*slog.Logger	"日志对象"
+//	return 1	[]AddedRow	"与 batch 对应的插入结果"
+//	return 2	Errors		"错误信息"
+//
+// ===============
+//
+//	Insert a batch of rows, rows with the same fields are inserted together
+//	ctx		context.Context	"Context of the parent span"
+//	table		string		"Table name"
+//	batch		[]importedRow	"Rows read"
+//	option		*Option		"Configuration"
+//	counts		[]int64		"Rows inserted into each database, inserted
+//					 	rows are added here"
+//	logger		*slog.Logger	"Logger"
+//	return 1	[]AddedRow	"Insert results matching batch"
+//	return 2	Errors		"Error message"
+func (s *Setting) importRows(ctx context.Context, table string, batch []importedRow, option *Option, counts []int64, logger *slog.Logger) ([]AddedRow, Errors) {
+	var errs Errors
+	added := make([]AddedRow, len(batch))
+	isContinues := make([]bool, len(s.SqlConfigs))
+	isAnyContinue := false
+	for i := range isContinues {
+		isContinues[i] = s.IsRetryConnect(i)
+		isAnyContinue = isAnyContinue || isContinues[i]
+	}
+	type group struct {
+		keys  []string
+		items []int
+	}
+	groups := map[string]*group{}
+	order := []string{}
+	for item, r := range batch {
+		added[item] = AddedRow{Shard: -1, Err: r.err}
+		if r.err != nil {
+			continue
+		}
+		if r.origin < 0 && !isAnyContinue {
+			added[item].Err = fmt.Errorf("%w: no database can be connected", ErrShardUnavailable)
+			if len(errs) == 0 {
+				errs = append(errs, added[item].Err)
+			}
+			continue
+		}
+		keys := make([]string, 0, len(r.row))
+		for col := range r.row {
+			keys = append(keys, col)
+		}
+		sort.Strings(keys)
+		sig := strings.Join(keys, ",")
+		g, ok := groups[sig]
+		if !ok {
+			g = &group{keys: keys}
+			groups[sig] = g
+			order = append(order, sig)
+		}
+		g.items = append(g.items, item)
+	}
+	for _, sig := range order {
+		g := groups[sig]
+		values := make([][]string, len(g.items))
+		nulls := make([][]bool, len(g.items))
+		sortList := make([][]int, len(s.SqlConfigs))
+		for k, item := range g.items {
+			r := batch[item]
+			for _, col := range g.keys {
+				values[k] = append(values[k], r.row[col])
+				nulls[k] = append(nulls[k], containsString(r.nulls, col))
+			}
+			shard := r.origin
+			if shard < 0 {
+				shard = s.nextShard(isContinues)
+			}
+			sortList[shard] = append(sortList[shard], k)
+		}
+		groupAdded := []AddedRow{}
+		groupOption := *option
+		groupOption.AddedRows = &groupAdded
+		if option.AddedRows == nil {
+			// 不需要ID时不要逐行插入
+			// Do not insert row by row when the IDs are not needed
+			groupOption.IDFallback = IDFallbackFirstRow
+		}
+		_, groupErrs := s.addShards(ctx, table, g.keys, values, nulls, sortList, nil, &groupOption, logger)
+		errs = append(errs, groupErrs...)
+		for k, item := range g.items {
+			added[item] = groupAdded[k]
+			if groupAdded[k].Err == nil && groupAdded[k].Shard >= 0 {
+				counts[groupAdded[k].Shard]++
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return added, errs
+}
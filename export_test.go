@@ -0,0 +1,101 @@
+package weSubDatabase
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestExportCodecs(t *testing.T) {
+	rows := []map[string]string{
+		{"id": "1", "name": "a,\"b\"\nc", "note": "", "_shard": "0", "_null": "note"},
+		{"id": "2", "name": "", "note": "", "_shard": "1", "_null": "note"},
+		{"id": "3", "name": "\xff\x00\r\n", "note": "", "_shard": "1", "_null": "note"},
+		{"id": "4", "name": "a\r\nb", "note": "", "_shard": "1", "_null": "note"},
+	}
+	codecs := map[string]func(*bytes.Buffer) (RowEncoder, RowDecoder){
+		"ndjson": func(b *bytes.Buffer) (RowEncoder, RowDecoder) { return NewNDJSONEncoder(b), NewNDJSONDecoder(b) },
+		"csv":    func(b *bytes.Buffer) (RowEncoder, RowDecoder) { return NewCSVEncoder(b, nil), NewCSVDecoder(b) },
+	}
+	for name, codec := range codecs {
+		var b bytes.Buffer
+		enc, dec := codec(&b)
+		for _, row := range rows {
+			if err := enc.Encode(row); err != nil {
+				t.Fatal(name+":", err)
+			}
+		}
+		if err := enc.Flush(); err != nil {
+			t.Fatal(name+":", err)
+		}
+		if name == "ndjson" && !bytes.Contains(b.Bytes(), []byte(`"name":"","note":null`)) {
+			t.Error("NULL not written as null:", b.String())
+		}
+		if !bytes.Contains(b.Bytes(), []byte("/wANCg==")) {
+			t.Error(name+": binary value not written as base64:", b.String())
+		}
+		got := []map[string]string{}
+		for {
+			row, err := dec.Decode()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(name+":", err)
+			}
+			got = append(got, row)
+		}
+		if !reflect.DeepEqual(got, rows) {
+			t.Error(name+":", got)
+		}
+	}
+
+	dec := NewNDJSONDecoder(bytes.NewBufferString(`{"id":"1","_base64":"id"}`))
+	if _, err := dec.Decode(); !errors.Is(err, ErrInvalidArgument) {
+		t.Error("invalid base64:", err)
+	}
+
+	enc := NewCSVEncoder(&bytes.Buffer{}, []string{"id"})
+	if err := enc.Encode(map[string]string{"id": "1", "name": "a"}); !errors.Is(err, ErrInvalidArgument) {
+		t.Error("field not in header:", err)
+	}
+}
+
+func TestImportNulls(t *testing.T) {
+	row := map[string]string{"id": "1", ExportNullColumn: "name"}
+	if nulls := importNulls(row); len(nulls) != 1 || nulls[0] != "name" || len(row) != 2 || row["name"] != "" {
+		t.Error("importNulls:", nulls, row)
+	}
+	row = map[string]string{"id": "1", ExportNullColumn: ""}
+	if nulls := importNulls(row); nulls != nil || len(row) != 1 {
+		t.Error("no NULL:", nulls, row)
+	}
+}
+
+func TestImportOrigin(t *testing.T) {
+	s := &Setting{SqlConfigs: make([]SQLConfig, 2)}
+	row := map[string]string{"id": "5", "name": "a", ExportShardColumn: "1"}
+	if origin, err := s.importOrigin(row, "id"); err != nil || origin != 1 {
+		t.Error("origin:", origin, err)
+	}
+	if !reflect.DeepEqual(row, map[string]string{"id": "5", "name": "a"}) {
+		t.Error("row:", row)
+	}
+
+	row = map[string]string{"id": "5", "name": "a", ExportShardColumn: "3"}
+	if origin, err := s.importOrigin(row, "id"); err != nil || origin != -1 {
+		t.Error("reassigned origin:", origin, err)
+	}
+	if !reflect.DeepEqual(row, map[string]string{"name": "a"}) {
+		t.Error("reassigned row keeps its primary key:", row)
+	}
+
+	if _, err := s.importOrigin(map[string]string{"id": "x"}, "id"); !errors.Is(err, ErrInvalidKey) {
+		t.Error("no SEKey:", err)
+	}
+	if _, err := s.importOrigin(map[string]string{"a b": "1"}, ""); !errors.Is(err, ErrInvalidArgument) {
+		t.Error("bad column:", err)
+	}
+}
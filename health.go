@@ -0,0 +1,634 @@
+package weSubDatabase
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-sql-driver/mysql"
+)
+
+// 熔断器状态
+//
+// Circuit breaker state
+type CircuitState int
+
+const (
+	//	关闭: 正常请求
+	//
+	//	Closed: requests are allowed
+	CircuitClosed CircuitState = iota
+	//	打开: 等待退避时间结束前不请求
+	//
+	//	Open: no requests until the backoff time has elapsed
+	CircuitOpen
+	//	半开: 允许一次试探请求
+	//
+	//	Half-open: one trial request is allowed
+	CircuitHalfOpen
+)
+
+func (c CircuitState) String() string {
+	switch c {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+func (c CircuitState) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// 端点类型
+//
+// Endpoint kind
+const (
+	EndpointMySQL        = "mysql"
+	EndpointMySQLReplica = "mysql_replica"
+	EndpointRedis        = "redis"
+)
+
+// 单个数据库端点的熔断器
+//
+// Circuit breaker of a single database endpoint
+type circuitBreaker struct {
+	state     CircuitState
+	failures  int
+	backoff   time.Duration
+	openedAt  time.Time
+	lastError error
+	lastCheck time.Time
+	// 兼容旧的失败时间字段, 熔断器打开时指向打开的时间
+	// Compatible with the old fail time fields, points to the open time while open
+	failTime **time.Time
+}
+
+// 数据库端点的状态
+//
+// State of a database endpoint
+type ShardState struct {
+	//	端点类型: mysql, mysql_replica, redis
+	//
+	//	Endpoint kind: mysql, mysql_replica, redis
+	Kind string `json:"kind"`
+	//	在配置中的位置
+	//
+	//	Location in the configuration
+	Item int `json:"item"`
+	//	副本在配置中的位置, 非副本时为 -1
+	//
+	//	Location of the replica in the configuration, -1 when not a replica
+	Replica int `json:"replica"`
+	//	数据库名
+	//
+	//	Database name
+	Name string `json:"name"`
+	//	地址
+	//
+	//	Address
+	Addr string `json:"addr"`
+	//	熔断器状态
+	//
+	//	Circuit breaker state
+	State CircuitState `json:"state"`
+	//	连续失败次数
+	//
+	//	Number of consecutive failures
+	Failures int `json:"failures"`
+	//	最后一次错误
+	//
+	//	Last error
+	LastError string `json:"last_error,omitempty"`
+	//	最后一次检查的时间
+	//
+	//	Last check time
+	LastCheck time.Time `json:"last_check"`
+	//	下一次允许试探的时间, 关闭状态时为零值
+	//
+	//	Next time a trial is allowed, zero when closed
+	NextRetry time.Time `json:"next_retry"`
+}
+
+// 初始化熔断器, 在 New 中调用
+//
+// Initialize the circuit breakers, called in New
+func (s *Setting) initBreakers() {
+	s.mysqlBreakers = make([]*circuitBreaker, len(s.SqlConfigs))
+	s.replicaBreakers = make([][]*circuitBreaker, len(s.SqlConfigs))
+	for i := range s.SqlConfigs {
+		s.mysqlBreakers[i] = &circuitBreaker{failTime: &s.ConnectFailTime[i]}
+		s.replicaBreakers[i] = make([]*circuitBreaker, len(s.SqlConfigs[i].Replicas))
+		for r := range s.SqlConfigs[i].Replicas {
+			s.replicaBreakers[i][r] = &circuitBreaker{failTime: &s.ReplicaFailTime[i][r]}
+		}
+	}
+	s.redisBreakers = make([]*circuitBreaker, len(s.RedisConfigs))
+	for i := range s.RedisConfigs {
+		s.redisBreakers[i] = &circuitBreaker{failTime: &s.RedisConnectFailTime[i]}
+	}
+}
+
+// 根据端点获取熔断器, 不存在时返回 nil
+//
+// Get the circuit breaker of the endpoint, nil is returned when it does not exist
+func (s *Setting) breaker(kind string, item int, replica int) *circuitBreaker {
+	switch kind {
+	case EndpointMySQL:
+		if item >= 0 && item < len(s.mysqlBreakers) {
+			return s.mysqlBreakers[item]
+		}
+	case EndpointMySQLReplica:
+		if item >= 0 && item < len(s.replicaBreakers) && replica >= 0 && replica < len(s.replicaBreakers[item]) {
+			return s.replicaBreakers[item][replica]
+		}
+	case EndpointRedis:
+		if item >= 0 && item < len(s.redisBreakers) {
+			return s.redisBreakers[item]
+		}
+	}
+	return nil
+}
+
+// 第 n 次打开时的退避时间
+//
+// Backoff time when opened for the nth time
+func (s *Setting) backoff(prev time.Duration) time.Duration {
+	base := time.Millisecond * time.Duration(s.ConnectAgainTime)
+	max := time.Millisecond * time.Duration(s.MaxConnectAgainTime)
+	if max < base {
+		max = base
+	}
+	if prev <= 0 {
+		return base
+	}
+	next := prev * 2
+	if next > max || next <= 0 {
+		next = max
+	}
+	return next
+}
+
+// ===============
+//
+//	判断端点是否可以请求, 只读取熔断器状态, 不占用半开状态的试探
+//	打开时退避时间结束后, 或半开时试探没有返回结果且超过一个退避时间后为 true
+//	kind		string	"端点类型"
+//	item		int	"在配置中的位置"
+//	replica		int	"副本在配置中的位置"
+//	return		bool	"是否可以请求"
+//
+// ===============
+//
+//	Determine whether the endpoint can be requested, it only reads the
+//	breaker state and does not take the half-open trial
+//	True when open and the backoff time has elapsed, or when half-open and
+//	the trial has not reported back for one backoff time
+//	kind		string	"Endpoint kind"
+//	item		int	"Location in the configuration"
+//	replica		int	"Location of the replica in the configuration"
+//	return		bool	"Whether it can be requested"
+func (s *Setting) allowEndpoint(kind string, item int, replica int) bool {
+	if s.canTry(kind, item, replica) {
+		return true
+	}
+	s.instrument().EndpointSkipped(kind, item, replica)
+	return false
+}
+
+func (s *Setting) canTry(kind string, item int, replica int) bool {
+	b := s.breaker(kind, item, replica)
+	if b == nil {
+		return true
+	}
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	s.syncFailTime(b)
+	if b.state == CircuitClosed {
+		return true
+	}
+	return !time.Now().Before(b.openedAt.Add(b.backoff))
+}
+
+// ===============
+//
+//	连接端点前占用试探: 退避时间结束后进入半开状态, 只有占用试探的调用可以连接
+//	只在真正连接端点的地方调用, 连接后必须调用 reportEndpoint
+//	kind		string	"端点类型"
+//	item		int	"在配置中的位置"
+//	replica		int	"副本在配置中的位置"
+//	return		bool	"是否可以连接"
+//
+// ===============
+//
+//	Take the trial before connecting to the endpoint: after the backoff time
+//	it enters the half-open state and only the call holding the trial may connect
+//	Only called where the endpoint is really connected, reportEndpoint must
+//	be called after connecting
+//	kind		string	"Endpoint kind"
+//	item		int	"Location in the configuration"
+//	replica		int	"Location of the replica in the configuration"
+//	return		bool	"Whether it can be connected"
+func (s *Setting) tryAcquire(kind string, item int, replica int) bool {
+	if s.acquireBreaker(kind, item, replica) {
+		return true
+	}
+	s.instrument().EndpointSkipped(kind, item, replica)
+	return false
+}
+
+func (s *Setting) acquireBreaker(kind string, item int, replica int) bool {
+	b := s.breaker(kind, item, replica)
+	if b == nil {
+		return true
+	}
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	s.syncFailTime(b)
+	if b.state == CircuitClosed {
+		return true
+	}
+	// 半开时试探请求没有返回结果, 等待一个退避时间后再次试探
+	// When half-open and the trial did not report back, try again after one backoff time
+	tn := time.Now()
+	if tn.Before(b.openedAt.Add(b.backoff)) {
+		return false
+	}
+	b.state = CircuitHalfOpen
+	b.openedAt = tn
+	return true
+}
+
+// 熔断器拒绝连接数据库时的错误
+//
+// Error when the breaker refuses to connect to the database
+func errBreakerOpen(item int) error {
+	return fmt.Errorf("%w: mysql database %d", ErrShardUnavailable, item)
+}
+
+// 外部直接设置了失败时间时打开熔断器, 调用时持有 healthMu
+//
+// Open the breaker when the fail time was set directly from outside, called with healthMu held
+func (s *Setting) syncFailTime(b *circuitBreaker) {
+	if b.state == CircuitClosed && *b.failTime != nil {
+		b.state = CircuitOpen
+		b.openedAt = **b.failTime
+		b.backoff = s.backoff(0)
+	}
+}
+
+// ===============
+//
+//	记录连接端点的结果, 成功时关闭熔断器, 失败时计入失败次数
+//	kind		string	"端点类型"
+//	item		int	"在配置中的位置"
+//	replica		int	"副本在配置中的位置"
+//	err		error	"连接的错误"
+//
+// ===============
+//
+//	Record the result of connecting to the endpoint, the breaker is closed
+//	on success and failures are counted
+//	kind		string	"Endpoint kind"
+//	item		int	"Location in the configuration"
+//	replica		int	"Location of the replica in the configuration"
+//	err		error	"Error of the connection"
+func (s *Setting) reportEndpoint(kind string, item int, replica int, err error) {
+	b := s.breaker(kind, item, replica)
+	if b == nil {
+		return
+	}
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	tn := time.Now()
+	b.lastCheck = tn
+	if err == nil {
+		if b.state != CircuitClosed && s.Logger != nil {
+			s.Logger.Info("circuit breaker closed", "kind", kind, "shard", item, "replica", replica)
+		}
+		b.state = CircuitClosed
+		b.failures = 0
+		b.backoff = 0
+		b.lastError = nil
+		*b.failTime = nil
+		return
+	}
+	b.failures++
+	b.lastError = err
+	threshold := s.FailureThreshold
+	if threshold <= 0 {
+		threshold = 1
+	}
+	switch b.state {
+	case CircuitClosed:
+		if b.failures < threshold {
+			return
+		}
+		b.backoff = s.backoff(0)
+	case CircuitOpen, CircuitHalfOpen:
+		b.backoff = s.backoff(b.backoff)
+	}
+	b.state = CircuitOpen
+	b.openedAt = tn
+	*b.failTime = &tn
+	if s.Logger != nil {
+		s.Logger.Warn("circuit breaker opened", "kind", kind, "shard", item, "replica", replica, "failures", b.failures, "backoff", b.backoff, "error", err)
+	}
+}
+
+// ===============
+//
+//	记录在连接上执行语句的结果
+//	只有连接类错误计入失败次数, 其他错误(如SQL语法错误)说明端点正常
+//	db		*MysqlDB	"执行语句的连接"
+//	err		error		"语句的错误"
+//
+// ===============
+//
+//	Record the result of running a statement on the connection
+//	Only connection errors count as failures, other errors (such as SQL
+//	syntax errors) mean the endpoint is healthy
+//	db		*MysqlDB	"Connection that ran the statement"
+//	err		error		"Error of the statement"
+func (s *Setting) reportQuery(db *MysqlDB, err error) {
+	if db == nil {
+		return
+	}
+	if !isConnError(err) {
+		err = nil
+	}
+	if db.Replica >= 0 {
+		s.reportEndpoint(EndpointMySQLReplica, db.DBItem, db.Replica, err)
+	} else {
+		s.reportEndpoint(EndpointMySQL, db.DBItem, -1, err)
+	}
+}
+
+// ===============
+//
+//	判断错误是否为连接类错误
+//	err		error	"错误"
+//	return		bool	"是否为连接类错误"
+//
+// ===============
+//
+//	Determine whether the error is a connection error
+//	err		error	"Error"
+//	return		bool	"Whether it is a connection error"
+func isConnError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var lagErr *replicaLagError
+	if errors.As(err, &lagErr) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		// 连接过多, 服务器关闭中, 只读模式
+		// Too many connections, server shutdown, read only mode
+		case 1040, 1053, 1290, 1836:
+			return true
+		}
+		return false
+	}
+	return false
+}
+
+// 副本复制延迟过大或未在复制
+//
+// The replica lags too much or is not replicating
+type replicaLagError struct {
+	lag int
+	max int
+	err error
+}
+
+func (e *replicaLagError) Error() string {
+	if e.err != nil {
+		return "replica lag check failed: " + e.err.Error()
+	}
+	return fmt.Sprintf("replica lag %ds exceeds the limit of %ds", e.lag, e.max)
+}
+
+func (e *replicaLagError) Unwrap() error {
+	return e.err
+}
+
+// 根据 Redis 命令的结果更新熔断器
+//
+// Update the circuit breaker from the results of Redis commands
+type redisHealthHook struct {
+	s    *Setting
+	item int
+}
+
+func (h redisHealthHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h redisHealthHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	h.report(cmd.Err())
+	return nil
+}
+
+func (h redisHealthHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h redisHealthHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	for _, cmd := range cmds {
+		if cmd.Err() != nil {
+			h.report(cmd.Err())
+			return nil
+		}
+	}
+	h.report(nil)
+	return nil
+}
+
+func (h redisHealthHook) report(err error) {
+	var netErr net.Error
+	if err != nil && !errors.Is(err, io.EOF) && !errors.As(err, &netErr) {
+		// redis.Nil 等命令错误说明端点正常
+		// Command errors such as redis.Nil mean the endpoint is healthy
+		err = nil
+	}
+	h.s.reportEndpoint(EndpointRedis, h.item, -1, err)
+}
+
+// ===============
+//
+//	获取全部数据库端点的状态, 用于监控面板
+//	return		[]ShardState	"端点状态"
+//
+// ===============
+//
+//	Get the state of all database endpoints, used for dashboards
+//	return		[]ShardState	"Endpoint states"
+func (s *Setting) ShardStates() []ShardState {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	states := []ShardState{}
+	add := func(b *circuitBreaker, state ShardState) {
+		state.State = b.state
+		state.Failures = b.failures
+		state.LastCheck = b.lastCheck
+		if b.lastError != nil {
+			state.LastError = b.lastError.Error()
+		}
+		if b.state != CircuitClosed {
+			state.NextRetry = b.openedAt.Add(b.backoff)
+		}
+		states = append(states, state)
+	}
+	for i, v := range s.SqlConfigs {
+		add(s.mysqlBreakers[i], ShardState{Kind: EndpointMySQL, Item: i, Replica: -1, Name: v.DB, Addr: v.Address + ":" + v.Port})
+		for r, rv := range v.Replicas {
+			name := rv.DB
+			if name == "" {
+				name = v.DB
+			}
+			port := rv.Port
+			if port == "" {
+				port = v.Port
+			}
+			add(s.replicaBreakers[i][r], ShardState{Kind: EndpointMySQLReplica, Item: i, Replica: r, Name: name, Addr: rv.Address + ":" + port})
+		}
+	}
+	for i, v := range s.RedisConfigs {
+		add(s.redisBreakers[i], ShardState{Kind: EndpointRedis, Item: i, Replica: -1, Addr: v.Addr + ":" + v.Port})
+	}
+	return states
+}
+
+// ===============
+//
+//	对全部可以请求的端点执行一次健康检查
+//	使用独立的连接, 不占用连接池
+//
+// ===============
+//
+//	Run one health check on all endpoints that can be requested
+//	Separate connections are used, the connection pool is not occupied
+func (s *Setting) CheckHealth() {
+	var wg sync.WaitGroup
+	check := func(kind string, item int, replica int, ping func() error) {
+		if !s.tryAcquire(kind, item, replica) {
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.reportEndpoint(kind, item, replica, ping())
+		}()
+	}
+	for i, v := range s.SqlConfigs {
+		item := i
+		check(EndpointMySQL, item, -1, func() error {
+			db, err := s.Link(item)
+			if err != nil {
+				return err
+			}
+			db.Close()
+			return nil
+		})
+		for r := range v.Replicas {
+			replica := r
+			maxLag := v.MaxReplicaLag
+			check(EndpointMySQLReplica, item, replica, func() error {
+				db, err := s.LinkReplica(item, replica)
+				if err != nil {
+					return err
+				}
+				defer db.Close()
+				if maxLag > 0 {
+					lag, err := db.ReplicaLag()
+					if err != nil {
+						return &replicaLagError{err: err}
+					}
+					if lag > maxLag {
+						return &replicaLagError{lag: lag, max: maxLag}
+					}
+				}
+				return nil
+			})
+		}
+	}
+	for i := range s.RedisConfigs {
+		item := i
+		check(EndpointRedis, item, -1, func() error {
+			db, err := s.RedisLink(item, 0)
+			if err != nil {
+				return err
+			}
+			db.Close()
+			return nil
+		})
+	}
+	wg.Wait()
+}
+
+// ===============
+//
+//	启动后台健康检查, 已启动时先停止
+//	interval	time.Duration	"检查间隔"
+//
+// ===============
+//
+//	Start the background health check, it is stopped first when already started
+//	interval	time.Duration	"Check interval"
+func (s *Setting) StartHealthCheck(interval time.Duration) {
+	s.StopHealthCheck()
+	stop := make(chan struct{})
+	s.healthMu.Lock()
+	s.healthStop = stop
+	s.healthMu.Unlock()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				s.CheckHealth()
+			}
+		}
+	}()
+}
+
+// ===============
+//
+//	停止后台健康检查
+//
+// ===============
+//
+//	Stop the background health check
+func (s *Setting) StopHealthCheck() {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	if s.healthStop != nil {
+		close(s.healthStop)
+		s.healthStop = nil
+	}
+}
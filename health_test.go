@@ -0,0 +1,120 @@
+package weSubDatabase
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	sqlSetting, err := New(testJsonStr, OptionRetryTime(20), OptionMaxRetryTime(50), OptionFailureThreshold(2))
+	if err != nil {
+		t.Error("initialization failed:", err)
+		return
+	}
+	connErr := fmt.Errorf("dial: %w", errTestConn{})
+
+	sqlSetting.reportEndpoint(EndpointMySQL, 1, -1, connErr)
+	if !sqlSetting.IsRetryConnect(1) {
+		t.Error("breaker should stay closed below the failure threshold")
+	}
+	sqlSetting.reportEndpoint(EndpointMySQL, 1, -1, connErr)
+	if sqlSetting.IsRetryConnect(1) {
+		t.Error("breaker should be open after reaching the failure threshold")
+	}
+	if sqlSetting.ConnectFailTime[1] == nil {
+		t.Error("ConnectFailTime should be set while the breaker is open")
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	if !sqlSetting.IsRetryConnect(1) || !sqlSetting.IsRetryConnect(1) {
+		t.Error("checking after the backoff should not take the trial")
+	}
+	if !sqlSetting.tryAcquire(EndpointMySQL, 1, -1) {
+		t.Error("one trial should be allowed after the backoff")
+	}
+	if sqlSetting.IsRetryConnect(1) || sqlSetting.tryAcquire(EndpointMySQL, 1, -1) {
+		t.Error("only one trial should be allowed while half-open")
+	}
+	sqlSetting.reportEndpoint(EndpointMySQL, 1, -1, connErr)
+	state := sqlSetting.ShardStates()[1]
+	if state.State != CircuitOpen || state.NextRetry.Sub(state.LastCheck) != 40*time.Millisecond {
+		t.Error("failed trial should double the backoff:", state.State, state.NextRetry.Sub(state.LastCheck))
+	}
+
+	time.Sleep(45 * time.Millisecond)
+	if !sqlSetting.tryAcquire(EndpointMySQL, 1, -1) {
+		t.Error("one trial should be allowed after the backoff")
+	}
+	sqlSetting.reportEndpoint(EndpointMySQL, 1, -1, nil)
+	if sqlSetting.ShardStates()[1].State != CircuitClosed || sqlSetting.ConnectFailTime[1] != nil {
+		t.Error("successful trial should close the breaker")
+	}
+
+	tn := time.Now()
+	sqlSetting.ConnectFailTime[2] = &tn
+	if sqlSetting.IsRetryConnect(2) {
+		t.Error("setting ConnectFailTime directly should open the breaker")
+	}
+}
+
+func TestReadBreakers(t *testing.T) {
+	sqlSetting, err := New(testJsonStr, OptionRetryTime(20), OptionMaxRetryTime(50))
+	if err != nil {
+		t.Error("initialization failed:", err)
+		return
+	}
+	sqlSetting.SqlConfigs[0].Replicas = []ReplicaConfig{{Address: "127.0.0.2"}}
+	sqlSetting.ReplicaFailTime[0] = make([]*time.Time, 1)
+	sqlSetting.initBreakers()
+	connErr := fmt.Errorf("dial: %w", errTestConn{})
+
+	sqlSetting.reportEndpoint(EndpointMySQLReplica, 0, 0, connErr)
+	time.Sleep(25 * time.Millisecond)
+	sqlSetting.reportEndpoint(EndpointMySQL, 0, -1, connErr)
+
+	// 主库打开, 副本的退避时间已结束
+	// The primary is open, the backoff of the replica has elapsed
+	if !sqlSetting.IsRetryRead(0) {
+		t.Error("database 0 should be readable through the replica")
+	}
+	if sqlSetting.IsRetryConnect(0) {
+		t.Error("primary should still be open")
+	}
+	if order := sqlSetting.pickReplicas(0); len(order) != 1 {
+		t.Error("replica should still be picked after the read check:", order)
+	}
+	if !sqlSetting.tryAcquire(EndpointMySQLReplica, 0, 0) {
+		t.Error("the replica trial should be taken when dialing")
+	}
+	if state := sqlSetting.ShardStates()[1]; state.Kind != EndpointMySQLReplica || state.State != CircuitHalfOpen {
+		t.Error("replica should be half-open:", state.Kind, state.State)
+	}
+	if order := sqlSetting.pickReplicas(0); len(order) != 0 {
+		t.Error("half-open replica with a trial in flight should not be picked:", order)
+	}
+	if sqlSetting.tryAcquire(EndpointMySQL, 0, -1) {
+		t.Error("falling back to the open primary should be refused")
+	}
+	if state := sqlSetting.ShardStates()[0]; state.State != CircuitOpen || state.Failures != 1 {
+		t.Error("refused primary should not count a failure:", state.State, state.Failures)
+	}
+}
+
+func TestIsConnError(t *testing.T) {
+	if !isConnError(fmt.Errorf("query: %w", errTestConn{})) {
+		t.Error("network errors are connection errors")
+	}
+	if isConnError(fmt.Errorf("Error 1064: You have an error in your SQL syntax")) {
+		t.Error("syntax errors are not connection errors")
+	}
+	if !isConnError(&replicaLagError{lag: 10, max: 5}) {
+		t.Error("replica lag errors are connection errors")
+	}
+}
+
+type errTestConn struct{}
+
+func (errTestConn) Error() string   { return "connection refused" }
+func (errTestConn) Timeout() bool   { return false }
+func (errTestConn) Temporary() bool { return false }
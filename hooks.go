@@ -0,0 +1,227 @@
+package weSubDatabase
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// 在一个数据库上执行的SQL语句, 钩子可以修改 SQL 和 Args
+//
+// A SQL statement run on one database, hooks can modify SQL and Args
+type Statement struct {
+	//	调用方的上下文
+	//
+	//	Context of the caller
+	Context context.Context
+	//	数据库在配置中的位置
+	//
+	//	Location of the database in the configuration
+	Shard int
+	//	副本在配置中的位置, -1 为主库
+	//
+	//	Location of the replica in the configuration, -1 is the primary
+	Replica int
+	//	数据库名
+	//
+	//	Database name
+	DB string
+	//	操作, 如 query, exec, add, update, delete
+	//
+	//	Operation, such as query, exec, add, update, delete
+	Op string
+	//	表名, 直接调用 QueryCMD 和 ExecCMD 时为空
+	//
+	//	Table name, empty when QueryCMD and ExecCMD are called directly
+	Table string
+	//	SQL指令
+	//
+	//	SQL instruction
+	SQL string
+	//	绑定的参数
+	//
+	//	Bound arguments
+	Args []interface{}
+	//	是否为查询, 查询的结果写入 Rows
+	//
+	//	Whether it is a query, the result of a query is written to Rows
+	IsQuery bool
+	//	查询结果, 流式查询时为 nil
+	//
+	//	Query result, nil for streamed queries
+	Rows []map[string]string
+	//	最后插入的ID
+	//
+	//	Last insert ID
+	LastInsertId int64
+	//	影响的行数
+	//
+	//	Number of rows affected
+	RowsAffected int64
+	//	流式查询: 只打开 *sql.Rows, 由调用方读取
+	//
+	//	Streamed query: only the *sql.Rows is opened, the caller reads it
+	stream  bool
+	sqlRows *sql.Rows
+}
+
+// 返回或影响的行数
+//
+// Rows returned or affected
+func (stmt *Statement) rowCount() int64 {
+	if stmt.IsQuery {
+		return int64(len(stmt.Rows))
+	}
+	return stmt.RowsAffected
+}
+
+// 执行语句, 结果写入 stmt
+//
+// Run the statement, the result is written to stmt
+type StatementFunc func(stmt *Statement) error
+
+// 语句的钩子, 调用 next 执行语句, 不调用 next 并返回错误时否决语句,
+// 否决时建议返回包装了 ErrStatementVetoed 的错误
+//
+// Hook of a statement, next runs the statement, returning an error without
+// calling next vetoes the statement, wrapping ErrStatementVetoed is
+// recommended when vetoing
+type Hook func(stmt *Statement, next StatementFunc) error
+
+// ===============
+//
+//	设置 QueryCMD 和 ExecCMD 绑定的参数, 对应SQL指令中的 ?
+//	args		...interface{}	"参数"
+//
+// ===============
+//
+//	Set the arguments bound by QueryCMD and ExecCMD, they match the ? in
+//	the SQL instruction
+//	args		...interface{}	"Arguments"
+func OArgs(args ...interface{}) IsShowPrintO {
+	return func(o *Option) {
+		o.Args = args
+	}
+}
+
+// ===============
+//
+//	注册语句的钩子, 按注册顺序执行, 先注册的在最外层
+//	只对注册之后建立的连接生效
+//	hooks		...Hook		"钩子"
+//
+// ===============
+//
+//	Register statement hooks, they run in registration order with the first
+//	registered one outermost
+//	Only connections created after registering are affected
+//	hooks		...Hook		"Hooks"
+func (s *Setting) AddHook(hooks ...Hook) {
+	s.hookMu.Lock()
+	defer s.hookMu.Unlock()
+	s.hooks = append(append([]Hook{}, s.hooks...), hooks...)
+}
+
+func (s *Setting) getHooks() []Hook {
+	s.hookMu.RLock()
+	defer s.hookMu.RUnlock()
+	return s.hooks
+}
+
+// ===============
+//
+//	经过钩子执行语句
+//	stmt		*Statement	"语句, 结果写入其中"
+//	inner		...Hook		"在注册的钩子之后执行的内置钩子"
+//	return		error		"错误信息"
+//
+// ===============
+//
+//	Run the statement through the hooks
+//	stmt		*Statement	"Statement, the result is written to it"
+//	inner		...Hook		"Built-in hooks run after the registered hooks"
+//	return		error		"Error message"
+func (db *MysqlDB) run(stmt *Statement, inner ...Hook) error {
+	hooks := append(append([]Hook{}, db.hooks...), inner...)
+	var call func(i int, stmt *Statement) error
+	call = func(i int, stmt *Statement) error {
+		if i == len(hooks) {
+			return db.do(stmt)
+		}
+		return hooks[i](stmt, func(stmt *Statement) error {
+			return call(i+1, stmt)
+		})
+	}
+	return call(0, stmt)
+}
+
+func (db *MysqlDB) do(stmt *Statement) error {
+	ctx := stmt.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var err error
+	if stmt.stream {
+		stmt.sqlRows, err = db.DB.QueryContext(ctx, stmt.SQL, stmt.Args...)
+		return err
+	}
+	if stmt.IsQuery {
+		stmt.Rows, err = db.query(ctx, stmt.SQL, stmt.Args...)
+		return err
+	}
+	stmt.LastInsertId, stmt.RowsAffected, err = db.exec(ctx, stmt.SQL, stmt.Args...)
+	return err
+}
+
+// 创建在连接上执行的语句
+//
+// Create a statement run on the connection
+func (db *MysqlDB) statement(ctx context.Context, meta stmtMeta, sqlStr string, args []interface{}, isQuery bool) *Statement {
+	return &Statement{
+		Context: ctx,
+		Shard:   db.DBItem,
+		Replica: db.Replica,
+		DB:      db.Name,
+		Op:      meta.op,
+		Table:   meta.table,
+		SQL:     sqlStr,
+		Args:    args,
+		IsQuery: isQuery,
+	}
+}
+
+// 内置钩子: 输出日志
+//
+// Built-in hook: logging
+func logHook(logger *slog.Logger) Hook {
+	return func(stmt *Statement, next StatementFunc) error {
+		start := time.Now()
+		err := next(stmt)
+		logStmt(logger, stmt, time.Since(start), err)
+		return err
+	}
+}
+
+// 内置钩子: 输出日志并记录监控
+//
+// Built-in hook: logging and instrumentation
+func (s *Setting) observeHook(logger *slog.Logger) Hook {
+	return func(stmt *Statement, next StatementFunc) error {
+		start := time.Now()
+		err := next(stmt)
+		duration := time.Since(start)
+		logStmt(logger, stmt, duration, err)
+		s.instrument().StatementDone(StatementStat{
+			Op:       stmt.Op,
+			Table:    stmt.Table,
+			Shard:    stmt.Shard,
+			Replica:  stmt.Replica,
+			DB:       stmt.DB,
+			Duration: duration,
+			Rows:     stmt.rowCount(),
+			Err:      err,
+		})
+		return err
+	}
+}
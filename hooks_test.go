@@ -0,0 +1,56 @@
+package weSubDatabase
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	sqlSetting, err := New(testJsonStr)
+	if err != nil {
+		t.Error("initialization failed:", err)
+		return
+	}
+	var order []string
+	sqlSetting.AddHook(
+		func(stmt *Statement, next StatementFunc) error {
+			order = append(order, "first")
+			stmt.SQL = strings.Replace(stmt.SQL, "SELECT *", "SELECT `id`", 1)
+			err := next(stmt)
+			order = append(order, "first done")
+			return err
+		},
+		func(stmt *Statement, next StatementFunc) error {
+			order = append(order, "second")
+			stmt.Args = append(stmt.Args, 7)
+			return next(stmt)
+		},
+	)
+	var seen Statement
+	sqlSetting.AddHook(func(stmt *Statement, next StatementFunc) error {
+		seen = *stmt
+		return ErrStatementVetoed
+	})
+	db := &MysqlDB{Name: "db0", DBItem: 0, Replica: -1, hooks: sqlSetting.getHooks()}
+
+	rows, err := db.QueryCMD("SELECT * FROM `data` WHERE `id`=?", nil, OArgs(1))
+	if !errors.Is(err, ErrStatementVetoed) || ErrorType(err) != "vetoed" || rows != nil {
+		t.Error("veto error:", err)
+	}
+	if strings.Join(order, ",") != "first,second,first done" {
+		t.Error("hook order:", order)
+	}
+	if seen.SQL != "SELECT `id` FROM `data` WHERE `id`=?" || len(seen.Args) != 2 || seen.Args[0] != 1 || seen.Args[1] != 7 {
+		t.Error("modified statement:", seen.SQL, seen.Args)
+	}
+	if seen.Shard != 0 || seen.DB != "db0" || seen.Op != "query" || !seen.IsQuery {
+		t.Error("statement fields:", seen)
+	}
+
+	order = nil
+	_, _, err = db.ExecCMD("DELETE FROM `data`", nil)
+	if !errors.Is(err, ErrStatementVetoed) || seen.Op != "exec" || seen.IsQuery {
+		t.Error("exec veto:", err, seen)
+	}
+}
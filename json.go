@@ -115,13 +115,69 @@ type SQLConfig struct {
 	Address  string `json:"mysql_addr"`
 	Port     string `json:"mysql_port"`
 	DB       string `json:"mysql_db"`
+	//	只读副本, 查询会优先发送到副本
+	//
+	//	Read replicas, queries are sent to the replicas first
+	Replicas []ReplicaConfig `json:"mysql_replicas"`
+	//	副本允许的最大复制延迟(秒), 0 为不检查
+	//
+	//	Maximum replication lag allowed for a replica (seconds), 0 is not checked
+	MaxReplicaLag int `json:"mysql_max_replica_lag"`
+}
+
+type ReplicaConfig struct {
+	//	为空时使用主库的配置
+	//
+	//	The primary configuration is used when empty
+	User     string `json:"mysql_user"`
+	Password string `json:"mysql_pwd"`
+	Address  string `json:"mysql_addr"`
+	Port     string `json:"mysql_port"`
+	DB       string `json:"mysql_db"`
+	//	权重, 小于等于 0 时为 1
+	//
+	//	Weight, 1 when less than or equal to 0
+	Weight int `json:"weight"`
 }
 
 type RedisConfig struct {
 	Addr     string `json:"redis_addr"`
 	Port     string `json:"redis_port"`
+	User     string `json:"redis_user"`
 	Password string `json:"redis_pwd"`
 	MaxDB    int    `json:"redis_max_db"`
+	//	TLS 配置, 为 nil 时不使用 TLS
+	//
+	//	TLS configuration, TLS is not used when nil
+	TLS *RedisTLSConfig `json:"redis_tls"`
+	//	Sentinel 主节点名, 不为空时使用 Sentinel 模式
+	//
+	//	Sentinel master name, Sentinel mode is used when it is not empty
+	SentinelMaster   string   `json:"redis_sentinel_master"`
+	SentinelAddrs    []string `json:"redis_sentinel_addrs"`
+	SentinelUser     string   `json:"redis_sentinel_user"`
+	SentinelPassword string   `json:"redis_sentinel_pwd"`
+	//	Cluster 种子节点, 不为空时使用 Cluster 模式
+	//
+	//	Cluster seed nodes, Cluster mode is used when it is not empty
+	ClusterAddrs []string `json:"redis_cluster_addrs"`
+}
+
+type RedisTLSConfig struct {
+	//	CA 证书文件路径, 为空时使用系统证书
+	//
+	//	CA bundle file path, the system pool is used when empty
+	CAFile string `json:"ca_file"`
+	//	客户端证书文件路径 (双向认证)
+	//
+	//	Client certificate file path (mutual TLS)
+	CertFile string `json:"cert_file"`
+	//	客户端私钥文件路径 (双向认证)
+	//
+	//	Client private key file path (mutual TLS)
+	KeyFile            string `json:"key_file"`
+	ServerName         string `json:"server_name"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
 }
 
 type MaxLinkNumber struct {
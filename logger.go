@@ -0,0 +1,288 @@
+package weSubDatabase
+
+import (
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// ===============
+//
+//	设置日志处理器, 为 nil 时不输出日志
+//	SQL 语句以 Debug 级别输出, 失败以 Error 级别输出,
+//	熔断打开以 Warn 级别输出, 恢复以 Info 级别输出, 连接与断开以 Debug 级别输出
+//	handler		slog.Handler	"日志处理器"
+//
+// ===============
+//
+//	Set the log handler, nothing is logged when it is nil
+//	SQL statements are logged at Debug level, failures at Error level,
+//	opened circuit breakers at Warn level, recovered ones at Info level,
+//	connects and disconnects at Debug level
+//	handler		slog.Handler	"Log handler"
+func (s *Setting) SetLogHandler(handler slog.Handler) {
+	if handler == nil {
+		s.Logger = nil
+		return
+	}
+	s.Logger = slog.New(handler)
+}
+
+// 语句的描述, 用于日志
+//
+// Description of a statement, used for logging
+type stmtMeta struct {
+	//	操作, 如 query, add, update, delete
+	//
+	//	Operation, such as query, add, update, delete
+	op string
+	//	表名
+	//
+	//	Table name
+	table string
+}
+
+// ===============
+//
+//	生成一次调用的日志对象
+//	旧的 Debug 和 IsShowPrint 参数作为额外的处理器输出全部级别的日志
+//	Debug		*log.Logger	"调试输出"
+//	IsShowPrint	bool		"是否输出到控制台"
+//	return		*slog.Logger	"日志对象, 不为 nil"
+//
+// ===============
+//
+//	Create the logger for one call
+//	The old Debug and IsShowPrint parameters are adapted into extra
+//	handlers that log every level
+//	Debug		*log.Logger	"Debug output"
+//	IsShowPrint	bool		"Whether to output to the console"
+//	return		*slog.Logger	"Logger, never nil"
+func (s *Setting) logger(Debug *log.Logger, IsShowPrint bool) *slog.Logger {
+	return newLogger(s.Logger, Debug, IsShowPrint)
+}
+
+func newLogger(base *slog.Logger, Debug *log.Logger, IsShowPrint bool) *slog.Logger {
+	var handlers multiHandler
+	if base != nil {
+		handlers = append(handlers, base.Handler())
+	}
+	if Debug != nil {
+		handlers = append(handlers, adapterHandler(debugWriter{Debug}))
+	}
+	if IsShowPrint {
+		handlers = append(handlers, adapterHandler(os.Stdout))
+	}
+	switch len(handlers) {
+	case 0:
+		return slog.New(discardHandler{})
+	case 1:
+		return slog.New(handlers[0])
+	}
+	return slog.New(handlers)
+}
+
+// ===============
+//
+//	输出一条SQL语句的日志
+//	logger		*slog.Logger	"日志对象"
+//	stmt		*Statement	"语句, SQL 中的值会被隐去"
+//	duration	time.Duration	"执行时间"
+//	err		error		"错误信息"
+//
+// ===============
+//
+//	Log one SQL statement
+//	logger		*slog.Logger	"Logger"
+//	stmt		*Statement	"Statement, values in the SQL are redacted"
+//	duration	time.Duration	"Duration"
+//	err		error		"Error message"
+func logStmt(logger *slog.Logger, stmt *Statement, duration time.Duration, err error) {
+	level := slog.LevelDebug
+	if err != nil {
+		level = slog.LevelError
+	}
+	ctx := stmt.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("op", stmt.Op),
+		slog.String("table", stmt.Table),
+		slog.Int("shard", stmt.Shard),
+		slog.String("db", stmt.DB),
+	}
+	if stmt.Replica >= 0 {
+		attrs = append(attrs, slog.Int("replica", stmt.Replica))
+	}
+	attrs = append(attrs,
+		slog.Duration("duration", duration),
+		slog.Int64("rows", stmt.rowCount()),
+		slog.String("sql", redactSQL(stmt.SQL)),
+	)
+	if len(stmt.Args) > 0 {
+		attrs = append(attrs, slog.Int("args", len(stmt.Args)))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	logger.LogAttrs(ctx, level, "mysql "+stmt.Op, attrs...)
+}
+
+// ===============
+//
+//	隐去SQL指令中的字符串和数字值, 替换为 ?
+//	sqlStr		string	"SQL指令"
+//	return		string	"隐去值后的SQL指令"
+//
+// ===============
+//
+//	Redact the string and number values in the SQL instruction, they are replaced with ?
+//	sqlStr		string	"SQL instruction"
+//	return		string	"SQL instruction with the values redacted"
+func redactSQL(sqlStr string) string {
+	var b strings.Builder
+	b.Grow(len(sqlStr))
+	isWord := func(c byte) bool {
+		return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+	}
+	for i := 0; i < len(sqlStr); i++ {
+		c := sqlStr[i]
+		switch {
+		case c == '`':
+			// 标识符原样输出
+			// Identifiers are kept as they are
+			j := strings.IndexByte(sqlStr[i+1:], '`')
+			if j < 0 {
+				b.WriteString(sqlStr[i:])
+				return b.String()
+			}
+			b.WriteString(sqlStr[i : i+j+2])
+			i += j + 1
+		case c == '\'' || c == '"':
+			j := i + 1
+			for ; j < len(sqlStr); j++ {
+				if sqlStr[j] == '\\' {
+					j++
+					continue
+				}
+				if sqlStr[j] == c {
+					if j+1 < len(sqlStr) && sqlStr[j+1] == c {
+						j++
+						continue
+					}
+					break
+				}
+			}
+			b.WriteByte('?')
+			i = j
+		case c >= '0' && c <= '9' && (i == 0 || !isWord(sqlStr[i-1])):
+			j := i + 1
+			for j < len(sqlStr) && (isWord(sqlStr[j]) || sqlStr[j] == '.') {
+				j++
+			}
+			b.WriteByte('?')
+			i = j - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// 同时输出到多个处理器
+//
+// Handles records with several handlers
+type multiHandler []slog.Handler
+
+func (h multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range h {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var firstErr error
+	for _, handler := range h {
+		if !handler.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := handler.Handle(ctx, r.Clone()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (h multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(multiHandler, 0, len(h))
+	for _, handler := range h {
+		handlers = append(handlers, handler.WithAttrs(attrs))
+	}
+	return handlers
+}
+
+func (h multiHandler) WithGroup(name string) slog.Handler {
+	handlers := make(multiHandler, 0, len(h))
+	for _, handler := range h {
+		handlers = append(handlers, handler.WithGroup(name))
+	}
+	return handlers
+}
+
+// 不输出任何日志
+//
+// Logs nothing
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// ===============
+//
+//	旧参数使用的处理器, 输出全部级别, 不输出时间
+//	w		io.Writer	"输出"
+//	return		slog.Handler	"日志处理器"
+//
+// ===============
+//
+//	Handler used by the old parameters, every level is logged without the time
+//	w		io.Writer	"Output"
+//	return		slog.Handler	"Log handler"
+func adapterHandler(w io.Writer) slog.Handler {
+	return slog.NewTextHandler(w, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+}
+
+// 通过 *log.Logger 输出, 保留其前缀和时间格式
+//
+// Writes through *log.Logger, keeping its prefix and time format
+type debugWriter struct {
+	l *log.Logger
+}
+
+func (w debugWriter) Write(p []byte) (int, error) {
+	if err := w.l.Output(2, string(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,62 @@
+package weSubDatabase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedactSQL(t *testing.T) {
+	cases := map[string]string{
+		"SELECT * FROM `t1` WHERE `id` IN ('1','2') LIMIT 10":       "SELECT * FROM `t1` WHERE `id` IN (?,?) LIMIT ?",
+		"INSERT INTO `data` (`name`) VALUES ('it''s'),(\"a\\\"b\")": "INSERT INTO `data` (`name`) VALUES (?),(?)",
+		"UPDATE `data` SET `v`=CASE `id` WHEN '3' THEN 1.5 END":     "UPDATE `data` SET `v`=CASE `id` WHEN ? THEN ? END",
+		"SELECT MAX(`id`) AS maxid FROM data2 WHERE x1 = -7":        "SELECT MAX(`id`) AS maxid FROM data2 WHERE x1 = -?",
+	}
+	for in, want := range cases {
+		if got := redactSQL(in); got != want {
+			t.Errorf("redactSQL(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestLogger(t *testing.T) {
+	sqlSetting, err := New(testJsonStr)
+	if err != nil {
+		t.Error("initialization failed:", err)
+		return
+	}
+	var out bytes.Buffer
+	sqlSetting.SetLogHandler(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelError}))
+	var debugOut bytes.Buffer
+	logger := sqlSetting.logger(log.New(&debugOut, "[debug] ", 0), false)
+
+	db := &MysqlDB{Name: "db1", DBItem: 1, Replica: -1}
+	stmt := db.statement(context.Background(), stmtMeta{op: "query", table: "data"}, "SELECT * FROM `data` WHERE `name`='secret'", nil, true)
+	stmt.Rows = make([]map[string]string, 3)
+	logStmt(logger, stmt, time.Millisecond, nil)
+	if out.Len() != 0 {
+		t.Error("debug entry should be filtered by the handler level:", out.String())
+	}
+	if !strings.HasPrefix(debugOut.String(), "[debug] ") || !strings.Contains(debugOut.String(), "rows=3") || strings.Contains(debugOut.String(), "secret") {
+		t.Error("Debug adapter output:", debugOut.String())
+	}
+
+	stmt = db.statement(context.Background(), stmtMeta{op: "exec", table: "data"}, "DELETE FROM `data`", nil, false)
+	logStmt(logger, stmt, time.Millisecond, errors.New("boom"))
+	for _, want := range []string{`"level":"ERROR"`, `"op":"exec"`, `"table":"data"`, `"shard":1`, `"db":"db1"`, `"error":"boom"`, `"duration"`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("entry %s does not contain %s", out.String(), want)
+		}
+	}
+
+	sqlSetting.SetLogHandler(nil)
+	if sqlSetting.logger(nil, false).Enabled(context.Background(), slog.LevelError) {
+		t.Error("logger without handlers should be disabled")
+	}
+}
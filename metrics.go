@@ -0,0 +1,327 @@
+package weSubDatabase
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-sql-driver/mysql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// 监控接口, 设置到 Setting.Instrumentation 后在各操作中调用
+// 实现需要是并发安全的
+//
+// Instrumentation interface, called from the operations after it is set
+// to Setting.Instrumentation
+// Implementations must be safe for concurrent use
+type Instrumentation interface {
+	//	一条SQL语句执行完成
+	//
+	//	A SQL statement has finished
+	StatementDone(stat StatementStat)
+	//	等待连接池空位一次
+	//
+	//	Waited once for a free slot in the connection pool
+	PoolWait(kind string, item int)
+	//	因熔断跳过一次端点
+	//
+	//	Skipped an endpoint once because of the circuit breaker
+	EndpointSkipped(kind string, item int, replica int)
+	//	一条 Redis 命令执行完成
+	//
+	//	A Redis command has finished
+	RedisCommandDone(item int, command string, duration time.Duration, err error)
+}
+
+// 一条SQL语句的执行情况
+//
+// How a SQL statement ran
+type StatementStat struct {
+	//	操作, 如 query, add, update, delete
+	//
+	//	Operation, such as query, add, update, delete
+	Op string
+	//	表名
+	//
+	//	Table name
+	Table string
+	//	数据库在配置中的位置
+	//
+	//	Location of the database in the configuration
+	Shard int
+	//	副本在配置中的位置, -1 为主库
+	//
+	//	Location of the replica in the configuration, -1 is the primary
+	Replica int
+	//	数据库名
+	//
+	//	Database name
+	DB string
+	//	执行时间
+	//
+	//	Duration
+	Duration time.Duration
+	//	返回或影响的行数
+	//
+	//	Rows returned or affected
+	Rows int64
+	//	错误信息
+	//
+	//	Error message
+	Err error
+}
+
+// 没有设置 Instrumentation 时使用
+//
+// Used when no Instrumentation is set
+type noInstrumentation struct{}
+
+func (noInstrumentation) StatementDone(StatementStat)                        {}
+func (noInstrumentation) PoolWait(string, int)                               {}
+func (noInstrumentation) EndpointSkipped(string, int, int)                   {}
+func (noInstrumentation) RedisCommandDone(int, string, time.Duration, error) {}
+
+func (s *Setting) instrument() Instrumentation {
+	if s.Instrumentation == nil {
+		return noInstrumentation{}
+	}
+	return s.Instrumentation
+}
+
+// ===============
+//
+//	错误的类型, 用于监控的标签
+//	err		error	"错误信息"
+//	return		string	"错误类型, 如 injection, pool_exhausted,
+//				 shard_unavailable, vetoed, version_conflict,
+//				 schema_behind, timeout, connection, mysql_1062,
+//				 redis_nil, other"
+//
+// ===============
+//
+//	Type of the error, used as a label for monitoring
+//	err		error	"Error message"
+//	return		string	"Error type, such as injection, pool_exhausted,
+//				 shard_unavailable, vetoed, version_conflict,
+//				 schema_behind, timeout, connection, mysql_1062,
+//				 redis_nil, other"
+func ErrorType(err error) string {
+	var mysqlErr *mysql.MySQLError
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrInjectionDetected):
+		return "injection"
+	case errors.Is(err, ErrPoolExhausted):
+		return "pool_exhausted"
+	case errors.Is(err, ErrShardUnavailable):
+		return "shard_unavailable"
+	case errors.Is(err, ErrIncompleteResult):
+		return "incomplete_result"
+	case errors.Is(err, ErrStatementVetoed):
+		return "vetoed"
+	case errors.Is(err, ErrVersionConflict):
+		return "version_conflict"
+	case errors.Is(err, ErrSchemaBehind):
+		return "schema_behind"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, redis.Nil):
+		return "redis_nil"
+	case errors.As(err, &mysqlErr):
+		return "mysql_" + strconv.Itoa(int(mysqlErr.Number))
+	case errors.Is(err, driver.ErrBadConn), isConnError(err):
+		return "connection"
+	}
+	return "other"
+}
+
+// 基于 OpenTelemetry 的监控
+//
+// Instrumentation based on OpenTelemetry
+type otelMetrics struct {
+	stmtDuration  metric.Float64Histogram
+	stmtErrors    metric.Int64Counter
+	poolWaits     metric.Int64Counter
+	skips         metric.Int64Counter
+	redisDuration metric.Float64Histogram
+	redisErrors   metric.Int64Counter
+}
+
+// ===============
+//
+//	启用基于 OpenTelemetry 的监控, 设置 Setting.Instrumentation
+//	记录的指标:
+//		wesubdb.statement.duration	SQL语句执行时间(秒)
+//		wesubdb.statement.errors	SQL语句错误次数, 按 error.type 区分
+//		wesubdb.pool.waits		等待连接池空位的次数
+//		wesubdb.pool.in_use		使用中的连接数
+//		wesubdb.pool.idle		连接池空位数
+//		wesubdb.endpoint.skipped	因熔断跳过端点的次数
+//		wesubdb.circuit.state		熔断器状态 0:关闭 1:打开 2:半开
+//		wesubdb.redis.command.duration	Redis 命令执行时间(秒)
+//		wesubdb.redis.command.errors	Redis 命令错误次数
+//	meter		metric.Meter	"OpenTelemetry Meter"
+//	return		error		"错误信息"
+//
+// ===============
+//
+//	Enable instrumentation based on OpenTelemetry, Setting.Instrumentation is set
+//	Recorded metrics:
+//		wesubdb.statement.duration	SQL statement duration (seconds)
+//		wesubdb.statement.errors	SQL statement errors by error.type
+//		wesubdb.pool.waits		Waits for a free slot in the pool
+//		wesubdb.pool.in_use		Connections in use
+//		wesubdb.pool.idle		Free slots in the pool
+//		wesubdb.endpoint.skipped	Endpoints skipped by the circuit breaker
+//		wesubdb.circuit.state		Circuit breaker state 0:closed 1:open 2:half-open
+//		wesubdb.redis.command.duration	Redis command duration (seconds)
+//		wesubdb.redis.command.errors	Redis command errors
+//	meter		metric.Meter	"OpenTelemetry Meter"
+//	return		error		"Error message"
+func (s *Setting) EnableMetrics(meter metric.Meter) error {
+	var (
+		m   otelMetrics
+		err error
+	)
+	if m.stmtDuration, err = meter.Float64Histogram("wesubdb.statement.duration", metric.WithUnit("s"), metric.WithDescription("SQL statement duration")); err != nil {
+		return err
+	}
+	if m.stmtErrors, err = meter.Int64Counter("wesubdb.statement.errors", metric.WithDescription("SQL statement errors")); err != nil {
+		return err
+	}
+	if m.poolWaits, err = meter.Int64Counter("wesubdb.pool.waits", metric.WithDescription("Waits for a free slot in the connection pool")); err != nil {
+		return err
+	}
+	if m.skips, err = meter.Int64Counter("wesubdb.endpoint.skipped", metric.WithDescription("Endpoints skipped by the circuit breaker")); err != nil {
+		return err
+	}
+	if m.redisDuration, err = meter.Float64Histogram("wesubdb.redis.command.duration", metric.WithUnit("s"), metric.WithDescription("Redis command duration")); err != nil {
+		return err
+	}
+	if m.redisErrors, err = meter.Int64Counter("wesubdb.redis.command.errors", metric.WithDescription("Redis command errors")); err != nil {
+		return err
+	}
+	inUse, err := meter.Int64ObservableGauge("wesubdb.pool.in_use", metric.WithDescription("Connections in use"))
+	if err != nil {
+		return err
+	}
+	idle, err := meter.Int64ObservableGauge("wesubdb.pool.idle", metric.WithDescription("Free slots in the connection pool"))
+	if err != nil {
+		return err
+	}
+	circuit, err := meter.Int64ObservableGauge("wesubdb.circuit.state", metric.WithDescription("Circuit breaker state, 0 closed, 1 open, 2 half-open"))
+	if err != nil {
+		return err
+	}
+	_, err = meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		mysqlAttr := metric.WithAttributes(attribute.String("kind", EndpointMySQL))
+		redisAttr := metric.WithAttributes(attribute.String("kind", EndpointRedis))
+		s.poolMu.Lock()
+		linkNum := s.LinkNum
+		s.poolMu.Unlock()
+		o.ObserveInt64(inUse, int64(linkNum), mysqlAttr)
+		o.ObserveInt64(idle, int64(s.MaxLink-linkNum), mysqlAttr)
+		o.ObserveInt64(inUse, int64(s.RedisLinkNum), redisAttr)
+		o.ObserveInt64(idle, int64(s.RedisMaxLink-s.RedisLinkNum), redisAttr)
+		for _, state := range s.ShardStates() {
+			o.ObserveInt64(circuit, int64(state.State), metric.WithAttributes(
+				attribute.String("kind", state.Kind),
+				attribute.Int("shard", state.Item),
+				attribute.Int("replica", state.Replica),
+			))
+		}
+		return nil
+	}, inUse, idle, circuit)
+	if err != nil {
+		return err
+	}
+	s.Instrumentation = &m
+	return nil
+}
+
+func (m *otelMetrics) StatementDone(stat StatementStat) {
+	ctx := context.Background()
+	attrs := []attribute.KeyValue{
+		attribute.String("op", stat.Op),
+		attribute.Int("shard", stat.Shard),
+		attribute.String("db", stat.DB),
+	}
+	m.stmtDuration.Record(ctx, stat.Duration.Seconds(), metric.WithAttributes(attrs...))
+	if stat.Err != nil {
+		m.stmtErrors.Add(ctx, 1, metric.WithAttributes(append(attrs, attribute.String("error.type", ErrorType(stat.Err)))...))
+	}
+}
+
+func (m *otelMetrics) PoolWait(kind string, item int) {
+	m.poolWaits.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("kind", kind),
+		attribute.Int("shard", item),
+	))
+}
+
+func (m *otelMetrics) EndpointSkipped(kind string, item int, replica int) {
+	m.skips.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("kind", kind),
+		attribute.Int("shard", item),
+		attribute.Int("replica", replica),
+	))
+}
+
+func (m *otelMetrics) RedisCommandDone(item int, command string, duration time.Duration, err error) {
+	ctx := context.Background()
+	attrs := []attribute.KeyValue{
+		attribute.Int("shard", item),
+		attribute.String("command", command),
+	}
+	m.redisDuration.Record(ctx, duration.Seconds(), metric.WithAttributes(attrs...))
+	if err != nil && err != redis.Nil {
+		m.redisErrors.Add(ctx, 1, metric.WithAttributes(append(attrs, attribute.String("error.type", ErrorType(err)))...))
+	}
+}
+
+type redisStartKey struct{}
+
+// 记录 Redis 命令的执行时间
+//
+// Record the duration of Redis commands
+type redisMetricsHook struct {
+	s    *Setting
+	item int
+}
+
+func (h redisMetricsHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+func (h redisMetricsHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if start, ok := ctx.Value(redisStartKey{}).(time.Time); ok {
+		h.s.instrument().RedisCommandDone(h.item, cmd.Name(), time.Since(start), cmd.Err())
+	}
+	return nil
+}
+
+func (h redisMetricsHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+func (h redisMetricsHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	start, ok := ctx.Value(redisStartKey{}).(time.Time)
+	if !ok {
+		return nil
+	}
+	var err error
+	for _, cmd := range cmds {
+		if cmd.Err() != nil && cmd.Err() != redis.Nil {
+			err = cmd.Err()
+			break
+		}
+	}
+	h.s.instrument().RedisCommandDone(h.item, "pipeline", time.Since(start), err)
+	return nil
+}
@@ -0,0 +1,74 @@
+package weSubDatabase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestMetrics(t *testing.T) {
+	sqlSetting, err := New(testJsonStr)
+	if err != nil {
+		t.Error("initialization failed:", err)
+		return
+	}
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	if err := sqlSetting.EnableMetrics(provider.Meter("weSubDatabase")); err != nil {
+		t.Error("EnableMetrics failed:", err)
+		return
+	}
+
+	db := &MysqlDB{Name: "db0", DBItem: 0, Replica: -1}
+	logger := sqlSetting.logger(nil, false)
+	observe := sqlSetting.observeHook(logger)
+	observe(db.statement(context.Background(), stmtMeta{op: "query", table: "data"}, "SELECT 1", nil, true), func(stmt *Statement) error {
+		stmt.Rows = []map[string]string{{"1": "1"}}
+		return nil
+	})
+	observe(db.statement(context.Background(), stmtMeta{op: "query", table: "data"}, "SELECT 1", nil, true), func(stmt *Statement) error {
+		return fmt.Errorf("wrapped: %w", ErrPoolExhausted)
+	})
+	tn := time.Now()
+	sqlSetting.ConnectFailTime[1] = &tn
+	if sqlSetting.IsRetryConnect(1) {
+		t.Error("database 1 should be skipped")
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Error("collect failed:", err)
+		return
+	}
+	found := map[string]metricdata.Aggregation{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			found[m.Name] = m.Data
+		}
+	}
+	if h, ok := found["wesubdb.statement.duration"].(metricdata.Histogram[float64]); !ok || h.DataPoints[0].Count != 2 {
+		t.Error("statement duration:", found["wesubdb.statement.duration"])
+	}
+	if c, ok := found["wesubdb.statement.errors"].(metricdata.Sum[int64]); !ok || c.DataPoints[0].Value != 1 {
+		t.Error("statement errors:", found["wesubdb.statement.errors"])
+	} else if v, _ := c.DataPoints[0].Attributes.Value("error.type"); v.AsString() != "pool_exhausted" {
+		t.Error("error type:", v.AsString())
+	}
+	if c, ok := found["wesubdb.endpoint.skipped"].(metricdata.Sum[int64]); !ok || c.DataPoints[0].Value != 1 {
+		t.Error("skipped endpoints:", found["wesubdb.endpoint.skipped"])
+	}
+	if g, ok := found["wesubdb.circuit.state"].(metricdata.Gauge[int64]); !ok || len(g.DataPoints) != len(sqlSetting.ShardStates()) {
+		t.Error("circuit state:", found["wesubdb.circuit.state"])
+	}
+	if _, ok := found["wesubdb.pool.idle"]; !ok {
+		t.Error("pool gauges are missing")
+	}
+	if ErrorType(errors.New("x")) != "other" || ErrorType(nil) != "" {
+		t.Error("ErrorType")
+	}
+}
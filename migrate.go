@@ -0,0 +1,786 @@
+package weSubDatabase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"log/slog"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// 记录已执行迁移的表, 每个数据库一张
+//
+// Table recording the migrations that ran, one per database
+const migrationTable = "schema_migrations"
+
+// 创建 schema_migrations 的指令, 只在执行迁移时使用
+//
+// Instruction creating schema_migrations, only used when running migrations
+const migrationTableSQL = "CREATE TABLE IF NOT EXISTS `" + migrationTable + "` (`version` BIGINT NOT NULL PRIMARY KEY, `name` VARCHAR(255) NOT NULL DEFAULT '', `applied_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP)"
+
+// 等待其他进程的迁移锁的秒数
+//
+// Seconds to wait for the migration lock of another process
+const migrationLockTimeout = 60
+
+// 严格模式下落后的数据库再次读取 schema_migrations 的间隔
+//
+// Interval before a database that is behind reads schema_migrations again in strict mode
+const schemaBehindRecheck = 10 * time.Second
+
+// 严格模式缓存的一个数据库的结构版本
+//
+// Schema version of one database cached for strict mode
+type schemaStatus struct {
+	//	未执行的迁移数目
+	//
+	//	Number of pending migrations
+	pending int
+	//	读取的时间
+	//
+	//	Time it was read
+	checked time.Time
+}
+
+// 迁移文件名, 如 0001_create_data.up.sql
+//
+// Migration file name, such as 0001_create_data.up.sql
+var migrationFile = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// 一个版本的结构迁移
+//
+// Schema migration of one version
+type Migration struct {
+	//	版本号, 大于 0 且不重复, 按从小到大执行
+	//
+	//	Version, above 0 and unique, run from the smallest
+	Version int64
+	//	名称
+	//
+	//	Name
+	Name string
+	//	升级的SQL指令, 多条指令用 ; 分隔
+	//
+	//	SQL instructions of the upgrade, separated by ;
+	Up string
+	//	回滚的SQL指令, 多条指令用 ; 分隔
+	//
+	//	SQL instructions of the rollback, separated by ;
+	Down string
+	//	升级函数, 不为 nil 时代替 Up
+	//	db 只有一个持有迁移锁的连接, 事务中不要再直接使用 db.DB
+	//
+	//	Upgrade function, replaces Up when not nil
+	//	db has a single connection holding the migration lock, do not use
+	//	db.DB directly inside a transaction
+	UpFunc func(ctx context.Context, db *MysqlDB) error
+	//	回滚函数, 不为 nil 时代替 Down, db 与 UpFunc 相同
+	//
+	//	Rollback function, replaces Down when not nil, db is the same as for UpFunc
+	DownFunc func(ctx context.Context, db *MysqlDB) error
+}
+
+// 一个数据库的迁移状态
+//
+// Migration status of one database
+type ShardMigration struct {
+	//	数据库在配置中的位置
+	//
+	//	Location of the database in the configuration
+	Shard int
+	//	数据库名
+	//
+	//	Database name
+	DB string
+	//	当前版本, 即已执行的最大版本, 0 为没有执行过
+	//
+	//	Current version, the largest version that ran, 0 when none ran
+	Version int64
+	//	已执行的版本
+	//
+	//	Versions that ran
+	Applied []int64
+	//	已注册但未执行的版本
+	//
+	//	Registered versions that did not run
+	Pending []int64
+	//	已执行但未注册的版本
+	//
+	//	Versions that ran but are not registered
+	Unknown []int64
+	//	本次调用执行的版本, 回滚时为回滚的版本
+	//
+	//	Versions run by this call, the versions rolled back for a rollback
+	Ran []int64
+	//	错误信息
+	//
+	//	Error message
+	Err error
+}
+
+// 所有数据库的迁移状态
+//
+// Migration status of all databases
+type MigrationReport struct {
+	//	已注册的最大版本
+	//
+	//	Largest registered version
+	Latest int64
+	//	每个数据库的状态
+	//
+	//	Status of each database
+	Shards []ShardMigration
+}
+
+// ===============
+//
+//	落后的数据库: 有未执行的迁移或无法读取状态
+//	return		[]int		"数据库在配置中的位置"
+//
+// ===============
+//
+//	Databases that are behind: a migration did not run or the status
+//	cannot be read
+//	return		[]int		"Location of the databases in the configuration"
+func (r *MigrationReport) Behind() []int {
+	behind := []int{}
+	for _, shard := range r.Shards {
+		if shard.Err != nil || len(shard.Pending) > 0 {
+			behind = append(behind, shard.Shard)
+		}
+	}
+	return behind
+}
+
+// ===============
+//
+//	结构漂移的数据库: 执行过未注册的版本, 或跳过了比当前版本小的迁移
+//	return		[]int		"数据库在配置中的位置"
+//
+// ===============
+//
+//	Databases whose schema drifted: a version that is not registered ran,
+//	or a migration below the current version was skipped
+//	return		[]int		"Location of the databases in the configuration"
+func (r *MigrationReport) Drifted() []int {
+	drifted := []int{}
+	for _, shard := range r.Shards {
+		if shard.Err != nil {
+			continue
+		}
+		if len(shard.Unknown) > 0 || (len(shard.Pending) > 0 && shard.Pending[0] < shard.Version) {
+			drifted = append(drifted, shard.Shard)
+		}
+	}
+	return drifted
+}
+
+// ===============
+//
+//	从目录中读取迁移文件, 文件名为 版本_名称.up.sql 和 版本_名称.down.sql
+//	fsys		fs.FS		"文件系统, 如 os.DirFS 或 embed.FS"
+//	dir		string		"目录"
+//	return 1	[]Migration	"按版本排序的迁移"
+//	return 2	error		"错误信息"
+//
+// ===============
+//
+//	Read migration files from a directory, the file names are
+//	version_name.up.sql and version_name.down.sql
+//	fsys		fs.FS		"File system, such as os.DirFS or embed.FS"
+//	dir		string		"Directory"
+//	return 1	[]Migration	"Migrations sorted by version"
+//	return 2	error		"Error message"
+func LoadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: migration file %s", ErrInvalidArgument, entry.Name())
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("%w: migration %d is named both %q and %q", ErrInvalidArgument, version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+	migrations := []Migration{}
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// ===============
+//
+//	注册迁移, 代替之前注册的迁移
+//	migrations	...Migration	"迁移"
+//	return		error		"错误信息"
+//
+// ===============
+//
+//	Register migrations, replacing the ones registered before
+//	migrations	...Migration	"Migrations"
+//	return		error		"Error message"
+func (s *Setting) SetMigrations(migrations ...Migration) error {
+	sorted := append([]Migration{}, migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i, m := range sorted {
+		if m.Version <= 0 {
+			return fmt.Errorf("%w: migration version %d", ErrInvalidArgument, m.Version)
+		}
+		if i > 0 && sorted[i-1].Version == m.Version {
+			return fmt.Errorf("%w: migration version %d is registered twice", ErrInvalidArgument, m.Version)
+		}
+		if m.UpFunc == nil && strings.TrimSpace(m.Up) == "" {
+			return fmt.Errorf("%w: migration %d has no up", ErrInvalidArgument, m.Version)
+		}
+	}
+	s.migrationMu.Lock()
+	s.migrations = sorted
+	s.schemaPending = map[int]schemaStatus{}
+	s.migrationMu.Unlock()
+	return nil
+}
+
+func (s *Setting) getMigrations() []Migration {
+	s.migrationMu.RLock()
+	defer s.migrationMu.RUnlock()
+	return s.migrations
+}
+
+// ===============
+//
+//	读取所有数据库的迁移状态
+//	Debug		*log.Logger		"调试输出"
+//	options		[]IsShowPrintO		"配置"
+//		IsShowPrint	bool			"是否输出到控制台"
+//		Context		context.Context		"调用方的上下文"
+//	return 1	MigrationReport		"迁移状态"
+//	return 2	Errors			"错误信息"
+//
+// ===============
+//
+//	Read the migration status of all databases
+//	Debug		*log.Logger		"Debug output"
+//	options		[]IsShowPrintO		"Configuration"
+//		IsShowPrint	bool			"Whether to output to the console"
+//		Context		context.Context		"Context of the caller"
+//	return 1	MigrationReport		"Migration status"
+//	return 2	Errors			"Error message"
+func (s *Setting) MigrationStatus(Debug *log.Logger, options ...IsShowPrintO) (report MigrationReport, errs Errors) {
+	return s.migrate("MigrationStatus", 0, Debug, options, nil)
+}
+
+// ===============
+//
+//	在所有数据库上执行未执行的迁移, 直到 target
+//	DDL 不能回滚, 某个迁移失败时这个数据库停止, 其他数据库继续
+//	每个数据库上用 GET_LOCK 加锁, 同时只有一个进程执行迁移
+//	target		int64			"目标版本, 0 为最新"
+//	Debug		*log.Logger		"调试输出"
+//	options		[]IsShowPrintO		"配置"
+//		IsShowPrint	bool			"是否输出到控制台"
+//		Context		context.Context		"调用方的上下文"
+//	return 1	MigrationReport		"执行后的迁移状态"
+//	return 2	Errors			"错误信息"
+//
+// ===============
+//
+//	Run the pending migrations up to target on all databases
+//	DDL cannot be rolled back, a database stops at the migration that
+//	failed and the other databases go on
+//	Each database is locked with GET_LOCK, only one process runs the
+//	migrations at a time
+//	target		int64			"Target version, 0 is the latest"
+//	Debug		*log.Logger		"Debug output"
+//	options		[]IsShowPrintO		"Configuration"
+//		IsShowPrint	bool			"Whether to output to the console"
+//		Context		context.Context		"Context of the caller"
+//	return 1	MigrationReport		"Migration status after the run"
+//	return 2	Errors			"Error message"
+func (s *Setting) MigrateUp(target int64, Debug *log.Logger, options ...IsShowPrintO) (report MigrationReport, errs Errors) {
+	return s.migrate("MigrateUp", target, Debug, options, func(ctx context.Context, db *MysqlDB, shard *ShardMigration, migrations []Migration, logger *slog.Logger) error {
+		for _, m := range migrations {
+			if !containsVersion(shard.Pending, m.Version) || (target > 0 && m.Version > target) {
+				continue
+			}
+			if err := s.runMigration(ctx, db, m, true, logger); err != nil {
+				return err
+			}
+			shard.Ran = append(shard.Ran, m.Version)
+		}
+		return nil
+	})
+}
+
+// ===============
+//
+//	在所有数据库上回滚大于 target 的迁移, 从大到小执行
+//	target		int64			"回滚后的版本, 0 为回滚全部"
+//	Debug		*log.Logger		"调试输出"
+//	options		[]IsShowPrintO		"配置"
+//		IsShowPrint	bool			"是否输出到控制台"
+//		Context		context.Context		"调用方的上下文"
+//	return 1	MigrationReport		"回滚后的迁移状态"
+//	return 2	Errors			"错误信息"
+//
+// ===============
+//
+//	Roll back the migrations above target on all databases, from the largest
+//	target		int64			"Version after the rollback, 0 rolls back all"
+//	Debug		*log.Logger		"Debug output"
+//	options		[]IsShowPrintO		"Configuration"
+//		IsShowPrint	bool			"Whether to output to the console"
+//		Context		context.Context		"Context of the caller"
+//	return 1	MigrationReport		"Migration status after the rollback"
+//	return 2	Errors			"Error message"
+func (s *Setting) MigrateDown(target int64, Debug *log.Logger, options ...IsShowPrintO) (report MigrationReport, errs Errors) {
+	return s.migrate("MigrateDown", target, Debug, options, func(ctx context.Context, db *MysqlDB, shard *ShardMigration, migrations []Migration, logger *slog.Logger) error {
+		for i := len(shard.Applied) - 1; i >= 0; i-- {
+			version := shard.Applied[i]
+			if version <= target {
+				break
+			}
+			k := sort.Search(len(migrations), func(k int) bool { return migrations[k].Version >= version })
+			if k == len(migrations) || migrations[k].Version != version {
+				return fmt.Errorf("%w: version %d is not registered and cannot be rolled back", ErrInvalidArgument, version)
+			}
+			if err := s.runMigration(ctx, db, migrations[k], false, logger); err != nil {
+				return err
+			}
+			shard.Ran = append(shard.Ran, version)
+		}
+		return nil
+	})
+}
+
+// ===============
+//
+//	读取每个数据库的迁移状态, run 不为 nil 时加锁后执行并重新读取
+//	name		string		"调用名称"
+//	target		int64		"目标版本"
+//	Debug		*log.Logger	"调试输出"
+//	options		[]IsShowPrintO	"配置"
+//	run		func		"在一个数据库上执行迁移"
+//	return 1	MigrationReport	"迁移状态"
+//	return 2	Errors		"错误信息"
+//
+// ===============
+//
+//	Read the migration status of each database, when run is not nil it runs
+//	under the lock and the status is read again
+//	name		string		"Call name"
+//	target		int64		"Target version"
+//	Debug		*log.Logger	"Debug output"
+//	options		[]IsShowPrintO	"Configuration"
+//	run		func		"Run the migrations on one database"
+//	return 1	MigrationReport	"Migration status"
+//	return 2	Errors		"Error message"
+func (s *Setting) migrate(name string, target int64, Debug *log.Logger, options []IsShowPrintO, run func(ctx context.Context, db *MysqlDB, shard *ShardMigration, migrations []Migration, logger *slog.Logger) error) (report MigrationReport, errs Errors) {
+	option := &Option{
+		IsShowPrint: false,
+	}
+	for _, o := range options {
+		o(option)
+	}
+	logger := s.logger(Debug, option.IsShowPrint)
+	ctx, span := s.startCall(option, name, migrationTable)
+	defer func() { endSpan(span, errs.Err()) }()
+	if target < 0 {
+		return report, Errors{fmt.Errorf("%w: target version %d", ErrInvalidArgument, target)}
+	}
+	migrations := s.getMigrations()
+	if len(migrations) > 0 {
+		report.Latest = migrations[len(migrations)-1].Version
+	}
+	for i := range s.SqlConfigs {
+		var (
+			shard ShardMigration
+			err   error
+		)
+		if run == nil {
+			shard, err = s.shardMigration(ctx, i, nil, migrations, logger)
+		} else {
+			shard, err = s.migrateShard(ctx, i, migrations, run, logger)
+			if len(shard.Ran) > 0 {
+				logger.Info("mysql migrations ran", "op", name, "shard", i, "versions", shard.Ran, "version", shard.Version)
+			}
+		}
+		if err != nil {
+			err = s.shardError(i, "", err)
+			shard.Err = err
+			errs = append(errs, err)
+		}
+		report.Shards = append(report.Shards, shard)
+	}
+	if behind := report.Behind(); len(behind) > 0 {
+		logger.Warn("mysql schema behind", "shards", behind, "latest", report.Latest)
+	}
+	if len(errs) > 0 {
+		return report, errs
+	}
+	return report, nil
+}
+
+// ===============
+//
+//	读取一个数据库已执行的版本, 只读, 没有 schema_migrations 表时版本为 0
+//	ctx		context.Context	"父 span 的上下文"
+//	i		int		"数据库在配置中的位置"
+//	db		*MysqlDB	"加锁的连接, 为 nil 时从连接池读取"
+//	migrations	[]Migration	"已注册的迁移"
+//	logger		*slog.Logger	"日志对象"
+//	return 1	ShardMigration	"迁移状态"
+//	return 2	error		"错误信息"
+//
+// ===============
+//
+//	Read the versions that ran on one database, read only, the version is 0
+//	when schema_migrations does not exist
+//	ctx		context.Context	"Context of the parent span"
+//	i		int		"Location of the database in the configuration"
+//	db		*MysqlDB	"Locked connection, read from the pool when nil"
+//	migrations	[]Migration	"Registered migrations"
+//	logger		*slog.Logger	"Logger"
+//	return 1	ShardMigration	"Migration status"
+//	return 2	error		"Error message"
+func (s *Setting) shardMigration(ctx context.Context, i int, db *MysqlDB, migrations []Migration, logger *slog.Logger) (ShardMigration, error) {
+	shard := ShardMigration{Shard: i, DB: s.SqlConfigs[i].DB, Applied: []int64{}, Pending: []int64{}, Unknown: []int64{}}
+	meta := stmtMeta{op: "query", table: migrationTable}
+	sqlStr := "SELECT `version` FROM `" + migrationTable + "` ORDER BY `version`"
+	var (
+		qd  []map[string]string
+		err error
+	)
+	if db != nil {
+		var stmt *Statement
+		stmt, err = s.runOn(ctx, db, meta, sqlStr, nil, true, logger)
+		qd = stmt.Rows
+	} else {
+		if !s.IsRetryConnect(i) {
+			return shard, ErrShardUnavailable
+		}
+		reqd := make(chan []map[string]string)
+		reerr := make(chan error)
+		go s.go_query(ctx, i, meta, sqlStr, nil, reqd, reerr, true, logger)
+		qd = <-reqd
+		err = <-reerr
+	}
+	if err != nil && !isTableMissing(err) {
+		return shard, err
+	}
+	for _, row := range qd {
+		version, err := strconv.ParseInt(row["version"], 10, 64)
+		if err != nil {
+			return shard, fmt.Errorf("%s version %q: %w", migrationTable, row["version"], err)
+		}
+		shard.Applied = append(shard.Applied, version)
+		if version > shard.Version {
+			shard.Version = version
+		}
+	}
+	registered := []int64{}
+	for _, m := range migrations {
+		registered = append(registered, m.Version)
+		if !containsVersion(shard.Applied, m.Version) {
+			shard.Pending = append(shard.Pending, m.Version)
+		}
+	}
+	for _, version := range shard.Applied {
+		if !containsVersion(registered, version) {
+			shard.Unknown = append(shard.Unknown, version)
+		}
+	}
+	s.migrationMu.Lock()
+	if s.schemaPending == nil {
+		s.schemaPending = map[int]schemaStatus{}
+	}
+	s.schemaPending[i] = schemaStatus{pending: len(shard.Pending), checked: time.Now()}
+	s.migrationMu.Unlock()
+	return shard, nil
+}
+
+// ===============
+//
+//	在一个数据库的一个连接上用 GET_LOCK 加锁, 创建 schema_migrations,
+//	重新读取状态后执行迁移, 结束后释放锁
+//	GET_LOCK 属于连接, 迁移的所有指令都在这个连接上执行
+//	ctx		context.Context	"父 span 的上下文"
+//	i		int		"数据库在配置中的位置"
+//	migrations	[]Migration	"已注册的迁移"
+//	run		func		"在一个数据库上执行迁移"
+//	logger		*slog.Logger	"日志对象"
+//	return 1	ShardMigration	"执行后的迁移状态"
+//	return 2	error		"错误信息"
+//
+// ===============
+//
+//	Lock one connection of one database with GET_LOCK, create
+//	schema_migrations, read the status again and run the migrations, the
+//	lock is released at the end
+//	GET_LOCK belongs to the connection, every instruction of the migrations
+//	runs on this connection
+//	ctx		context.Context	"Context of the parent span"
+//	i		int		"Location of the database in the configuration"
+//	migrations	[]Migration	"Registered migrations"
+//	run		func		"Run the migrations on one database"
+//	logger		*slog.Logger	"Logger"
+//	return 1	ShardMigration	"Migration status after the run"
+//	return 2	error		"Error message"
+func (s *Setting) migrateShard(ctx context.Context, i int, migrations []Migration, run func(ctx context.Context, db *MysqlDB, shard *ShardMigration, migrations []Migration, logger *slog.Logger) error, logger *slog.Logger) (ShardMigration, error) {
+	shard := ShardMigration{Shard: i, DB: s.SqlConfigs[i].DB, Applied: []int64{}, Pending: []int64{}, Unknown: []int64{}}
+	mI, err := s.MysqlIsRun(i, olLogger(logger))
+	if err != nil {
+		s.MysqlClose(mI)
+		return shard, err
+	}
+	defer s.MysqlClose(mI, IsShowPrintO(olLogger(logger)))
+	db := s.MySQLDB[mI]
+	db.DB.SetMaxOpenConns(1)
+	db.DB.SetMaxIdleConns(1)
+	meta := stmtMeta{op: "migrate", table: migrationTable}
+	stmt, err := s.runOn(ctx, db, meta, "SELECT GET_LOCK(?,?) AS `locked`", []interface{}{migrationTable, migrationLockTimeout}, true, logger)
+	if err != nil {
+		return shard, err
+	}
+	if len(stmt.Rows) == 0 || stmt.Rows[0]["locked"] != "1" {
+		return shard, fmt.Errorf("%w: %s lock not acquired in %d seconds", context.DeadlineExceeded, migrationTable, migrationLockTimeout)
+	}
+	defer s.runOn(context.WithoutCancel(ctx), db, meta, "SELECT RELEASE_LOCK(?)", []interface{}{migrationTable}, true, logger)
+	if _, err := s.runOn(ctx, db, meta, migrationTableSQL, nil, false, logger); err != nil {
+		return shard, err
+	}
+	// 加锁后重新读取, 其他进程可能已经执行了迁移
+	// Read again under the lock, another process may have run the migrations
+	shard, err = s.shardMigration(ctx, i, db, migrations, logger)
+	if err != nil {
+		return shard, err
+	}
+	err = run(ctx, db, &shard, migrations, logger)
+	ran := shard.Ran
+	if next, readErr := s.shardMigration(ctx, i, db, migrations, logger); readErr == nil {
+		shard = next
+	}
+	shard.Ran = ran
+	return shard, err
+}
+
+// ===============
+//
+//	在一个数据库上执行一个迁移并记录到 schema_migrations
+//	ctx		context.Context	"父 span 的上下文"
+//	db		*MysqlDB	"加锁的连接"
+//	m		Migration	"迁移"
+//	up		bool		"true 为升级, false 为回滚"
+//	logger		*slog.Logger	"日志对象"
+//	return		error		"错误信息"
+//
+// ===============
+//
+//	Run one migration on one database and record it in schema_migrations
+//	ctx		context.Context	"Context of the parent span"
+//	db		*MysqlDB	"Locked connection"
+//	m		Migration	"Migration"
+//	up		bool		"true to upgrade, false to roll back"
+//	logger		*slog.Logger	"Logger"
+//	return		error		"Error message"
+func (s *Setting) runMigration(ctx context.Context, db *MysqlDB, m Migration, up bool, logger *slog.Logger) error {
+	meta := stmtMeta{op: "migrate", table: migrationTable}
+	sqlStr, fn := m.Up, m.UpFunc
+	if !up {
+		sqlStr, fn = m.Down, m.DownFunc
+		if fn == nil && strings.TrimSpace(sqlStr) == "" {
+			return fmt.Errorf("%w: migration %d has no down", ErrInvalidArgument, m.Version)
+		}
+	}
+	if fn != nil {
+		if err := fn(ctx, db); err != nil {
+			return fmt.Errorf("migration %d: %w", m.Version, err)
+		}
+	} else {
+		for _, stmt := range splitStatements(sqlStr) {
+			if _, err := s.runOn(ctx, db, meta, stmt, nil, false, logger); err != nil {
+				return fmt.Errorf("migration %d: %w", m.Version, err)
+			}
+		}
+	}
+	var err error
+	if up {
+		_, err = s.runOn(ctx, db, meta, "INSERT INTO `"+migrationTable+"` (`version`,`name`) VALUES (?,?)", []interface{}{m.Version, m.Name}, false, logger)
+	} else {
+		_, err = s.runOn(ctx, db, meta, "DELETE FROM `"+migrationTable+"` WHERE `version`=?", []interface{}{m.Version}, false, logger)
+	}
+	return err
+}
+
+// 在已连接的数据库上执行一条指令
+//
+// Run one instruction on a connected database
+func (s *Setting) runOn(ctx context.Context, db *MysqlDB, meta stmtMeta, sqlStr string, args []interface{}, isQuery bool, logger *slog.Logger) (*Statement, error) {
+	ctx, span := s.startStmt(ctx, db.DBItem, meta, sqlStr)
+	stmt := db.statement(ctx, meta, sqlStr, args, isQuery)
+	err := db.run(stmt, s.observeHook(logger), s.slowQueryHook(db, logger))
+	endSpan(span, err)
+	s.reportQuery(db, err)
+	if err == nil && !isQuery {
+		s.markWrite(db.DBItem)
+	}
+	return stmt, s.shardError(db.DBItem, sqlStr, err)
+}
+
+// 在一个数据库上执行一条指令
+//
+// Run one instruction on one database
+func (s *Setting) execOne(ctx context.Context, i int, meta stmtMeta, sqlStr string, args []interface{}, logger *slog.Logger) error {
+	sqlStrs, shardArgs := oneShard(len(s.SqlConfigs), i, sqlStr, args)
+	_, errs := s.execShards(ctx, meta, sqlStrs, shardArgs, logger)
+	return errs.Err()
+}
+
+// ===============
+//
+//	严格模式下检查数据库的结构版本, 第一次检查时读取 schema_migrations,
+//	落后的数据库每隔 schemaBehindRecheck 再次读取, 其他进程执行迁移后不再报错
+//	ctx		context.Context	"父 span 的上下文"
+//	i		int		"数据库在配置中的位置"
+//	logger		*slog.Logger	"日志对象"
+//	return		error		"落后时为 ErrSchemaBehind"
+//
+// ===============
+//
+//	Check the schema version of the database in strict mode,
+//	schema_migrations is read on the first check and read again every
+//	schemaBehindRecheck while the database is behind, so it stops failing
+//	once another process has run the migrations
+//	ctx		context.Context	"Context of the parent span"
+//	i		int		"Location of the database in the configuration"
+//	logger		*slog.Logger	"Logger"
+//	return		error		"ErrSchemaBehind when it is behind"
+func (s *Setting) checkSchema(ctx context.Context, i int, logger *slog.Logger) error {
+	if !s.StrictSchema {
+		return nil
+	}
+	migrations := s.getMigrations()
+	if len(migrations) == 0 {
+		return nil
+	}
+	s.migrationMu.RLock()
+	status, ok := s.schemaPending[i]
+	s.migrationMu.RUnlock()
+	if !ok || (status.pending > 0 && time.Since(status.checked) >= schemaBehindRecheck) {
+		shard, err := s.shardMigration(ctx, i, nil, migrations, logger)
+		if err != nil {
+			return err
+		}
+		status.pending = len(shard.Pending)
+	}
+	if status.pending > 0 {
+		return fmt.Errorf("%w: %d migrations pending", ErrSchemaBehind, status.pending)
+	}
+	return nil
+}
+
+// 表不存在, MySQL 错误 1146
+//
+// The table does not exist, MySQL error 1146
+func isTableMissing(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1146
+}
+
+func containsVersion(versions []int64, version int64) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// ===============
+//
+//	按 ; 分割多条SQL指令, 忽略引号和注释中的 ;
+//	sqlStr		string		"SQL指令"
+//	return		[]string	"每条指令, 不含空指令"
+//
+// ===============
+//
+//	Split SQL instructions at ;, the ; in quotes and comments is ignored
+//	sqlStr		string		"SQL instructions"
+//	return		[]string	"Each instruction, empty ones are left out"
+func splitStatements(sqlStr string) []string {
+	stmts := []string{}
+	var b strings.Builder
+	flush := func() {
+		if stmt := strings.TrimSpace(b.String()); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+		b.Reset()
+	}
+	for i := 0; i < len(sqlStr); i++ {
+		c := sqlStr[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			j := i + 1
+			for ; j < len(sqlStr) && sqlStr[j] != c; j++ {
+				if sqlStr[j] == '\\' && c != '`' {
+					j++
+				}
+			}
+			if j >= len(sqlStr) {
+				j = len(sqlStr) - 1
+			}
+			b.WriteString(sqlStr[i : j+1])
+			i = j
+		case c == '#' || (c == '-' && strings.HasPrefix(sqlStr[i:], "-- ")):
+			for i < len(sqlStr) && sqlStr[i] != '\n' {
+				i++
+			}
+			b.WriteByte('\n')
+		case c == '/' && strings.HasPrefix(sqlStr[i:], "/*"):
+			end := strings.Index(sqlStr[i+2:], "*/")
+			if end < 0 {
+				i = len(sqlStr)
+			} else {
+				i += end + 3
+			}
+			b.WriteByte(' ')
+		case c == ';':
+			flush()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	flush()
+	return stmts
+}
@@ -0,0 +1,130 @@
+package weSubDatabase
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestSplitStatements(t *testing.T) {
+	sqlStr := "CREATE TABLE `a;b` (`id` INT); -- drop; this\n" +
+		"INSERT INTO t VALUES ('x;y', \"it\\\"s;\");\n" +
+		"/* ; */ UPDATE t SET v=1 # trailing;\n;;"
+	got := splitStatements(sqlStr)
+	want := []string{
+		"CREATE TABLE `a;b` (`id` INT)",
+		"INSERT INTO t VALUES ('x;y', \"it\\\"s;\")",
+		"UPDATE t SET v=1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatal("splitStatements:", got)
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"db/0002_add_name.up.sql":   {Data: []byte("ALTER TABLE t ADD name VARCHAR(32)")},
+		"db/0002_add_name.down.sql": {Data: []byte("ALTER TABLE t DROP name")},
+		"db/0001_create_t.up.sql":   {Data: []byte("CREATE TABLE t (id INT)")},
+		"db/README.md":              {Data: []byte("not a migration")},
+		"db/0003_sub/0003_x.up.sql": {Data: []byte("SELECT 1")},
+	}
+	migrations, err := LoadMigrations(fsys, "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 {
+		t.Fatal("migrations:", migrations)
+	}
+	if migrations[0].Version != 1 || migrations[0].Name != "create_t" || migrations[0].Down != "" {
+		t.Error("first migration:", migrations[0])
+	}
+	if migrations[1].Version != 2 || migrations[1].Down != "ALTER TABLE t DROP name" {
+		t.Error("second migration:", migrations[1])
+	}
+
+	fsys["db/0002_other.up.sql"] = &fstest.MapFile{Data: []byte("SELECT 1")}
+	if _, err := LoadMigrations(fsys, "db"); !errors.Is(err, ErrInvalidArgument) {
+		t.Error("two names for one version:", err)
+	}
+}
+
+func TestSetMigrations(t *testing.T) {
+	s := &Setting{}
+	cases := [][]Migration{
+		{{Version: 0, Up: "SELECT 1"}},
+		{{Version: 1, Up: "SELECT 1"}, {Version: 1, Up: "SELECT 2"}},
+		{{Version: 1, Up: " "}},
+	}
+	for i, migrations := range cases {
+		if err := s.SetMigrations(migrations...); !errors.Is(err, ErrInvalidArgument) {
+			t.Error("invalid migrations", i, "accepted:", err)
+		}
+	}
+	if err := s.SetMigrations(Migration{Version: 2, Up: "SELECT 2"}, Migration{Version: 1, Up: "SELECT 1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.getMigrations(); got[0].Version != 1 || got[1].Version != 2 {
+		t.Error("migrations not sorted:", got)
+	}
+}
+
+func TestMigrationReport(t *testing.T) {
+	report := MigrationReport{Latest: 3, Shards: []ShardMigration{
+		{Shard: 0, Version: 3},
+		{Shard: 1, Version: 1, Pending: []int64{2, 3}},
+		{Shard: 2, Version: 3, Pending: []int64{2}},
+		{Shard: 3, Version: 4, Unknown: []int64{4}},
+		{Shard: 4, Err: ErrShardUnavailable},
+	}}
+	if got := report.Behind(); !reflect.DeepEqual(got, []int{1, 2, 4}) {
+		t.Error("Behind:", got)
+	}
+	if got := report.Drifted(); !reflect.DeepEqual(got, []int{2, 3}) {
+		t.Error("Drifted:", got)
+	}
+}
+
+func TestMigrationCheckSchema(t *testing.T) {
+	s := &Setting{}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if err := s.SetMigrations(Migration{Version: 1, Up: "SELECT 1"}); err != nil {
+		t.Fatal(err)
+	}
+	s.schemaPending[0] = schemaStatus{pending: 1, checked: time.Now()}
+	s.schemaPending[1] = schemaStatus{pending: 0, checked: time.Now()}
+	if err := s.checkSchema(context.Background(), 0, logger); err != nil {
+		t.Error("not strict:", err)
+	}
+	s.StrictSchema = true
+	if err := s.checkSchema(context.Background(), 0, logger); !errors.Is(err, ErrSchemaBehind) {
+		t.Error("behind:", err)
+	}
+	if err := s.checkSchema(context.Background(), 1, logger); err != nil {
+		t.Error("up to date:", err)
+	}
+	s.SqlConfigs = make([]SQLConfig, 2)
+	st := &shardStream{shard: 0, ch: make(chan map[string]string, 1)}
+	s.streamShard(context.Background(), st, stmtMeta{op: "query"}, "SELECT 1", nil, &Option{}, logger)
+	if _, ok := <-st.ch; ok || !errors.Is(st.err, ErrSchemaBehind) {
+		t.Error("streaming read behind:", st.err)
+	}
+	// 落后的数据库过了 schemaBehindRecheck 后再次读取, 这里没有数据库所以读取失败
+	// A database that is behind is read again after schemaBehindRecheck, it
+	// fails here as there is no database
+	s.MaxLink, s.MySQLDB = 1, make([]*MysqlDB, 1)
+	s.schemaPending[0] = schemaStatus{pending: 1, checked: time.Now().Add(-schemaBehindRecheck)}
+	if err := s.checkSchema(context.Background(), 0, logger); err == nil || errors.Is(err, ErrSchemaBehind) {
+		t.Error("behind status not read again:", err)
+	}
+	if !isTableMissing(&ShardError{Err: &mysql.MySQLError{Number: 1146}}) || isTableMissing(&mysql.MySQLError{Number: 1062}) {
+		t.Error("isTableMissing")
+	}
+}
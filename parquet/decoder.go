@@ -0,0 +1,329 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/0wew0-gh/weSubDatabase"
+)
+
+// 文件中的一个字段
+//
+// Column of the file
+type column struct {
+	name     string
+	optional bool
+}
+
+type decoder struct {
+	r       io.ReaderAt
+	size    int64
+	columns []column
+	groups  []interface{}
+	group   int
+	values  [][]string
+	nulls   [][]bool
+	row     int
+	rows    int
+}
+
+// ===============
+//
+//	读取 NewEncoder 写入的 Parquet 文件, 先读取文件结尾的元数据
+//	只支持 REQUIRED 或 OPTIONAL 的 BYTE_ARRAY 字段, PLAIN 编码, 不压缩
+//	NULL 读取为空字符串, 并记录在 _null 字段中
+//	r		io.ReaderAt	"输入"
+//	size		int64		"文件大小"
+//	return 1	RowDecoder	"解码器"
+//	return 2	error		"错误信息, 不是支持的 Parquet 文件时为 ErrInvalidArgument"
+//
+// ===============
+//
+//	Read a Parquet file written by NewEncoder, the metadata at the end of the
+//	file is read first
+//	Only REQUIRED or OPTIONAL BYTE_ARRAY columns with PLAIN encoding and no
+//	compression are supported
+//	NULL is read as an empty string and recorded in the _null field
+//	r		io.ReaderAt	"Input"
+//	size		int64		"Size of the file"
+//	return 1	RowDecoder	"Decoder"
+//	return 2	error		"Error message, ErrInvalidArgument when it is
+//					 	not a supported Parquet file"
+func NewDecoder(r io.ReaderAt, size int64) (weSubDatabase.RowDecoder, error) {
+	if size < int64(2*len(magic)+4) {
+		return nil, fmt.Errorf("%w: file too small for Parquet", weSubDatabase.ErrInvalidArgument)
+	}
+	tail := make([]byte, 4+len(magic))
+	if _, err := r.ReadAt(tail, size-int64(len(tail))); err != nil {
+		return nil, err
+	}
+	head := make([]byte, len(magic))
+	if _, err := r.ReadAt(head, 0); err != nil {
+		return nil, err
+	}
+	if string(head) != magic || string(tail[4:]) != magic {
+		return nil, fmt.Errorf("%w: not a Parquet file", weSubDatabase.ErrInvalidArgument)
+	}
+	metaSize := int64(binary.LittleEndian.Uint32(tail))
+	if metaSize > size-int64(2*len(magic)+4) {
+		return nil, fmt.Errorf("%w: Parquet metadata size %d", weSubDatabase.ErrInvalidArgument, metaSize)
+	}
+	data := make([]byte, metaSize)
+	if _, err := r.ReadAt(data, size-int64(len(tail))-metaSize); err != nil {
+		return nil, err
+	}
+	meta, err := (&thriftReader{data: data}).readStruct(0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: Parquet metadata: %v", weSubDatabase.ErrInvalidArgument, err)
+	}
+	d := &decoder{r: r, size: size, groups: meta.list(4)}
+	schema := meta.list(2)
+	if len(schema) == 0 {
+		return nil, fmt.Errorf("%w: Parquet file without schema", weSubDatabase.ErrInvalidArgument)
+	}
+	for _, v := range schema[1:] {
+		element, _ := v.(thriftFields)
+		typ, _ := element.int(1)
+		repetition, _ := element.int(3)
+		_, nested := element.int(5)
+		if nested || typ != typeByteArray || (repetition != repetitionRequired && repetition != repetitionOptional) {
+			return nil, fmt.Errorf("%w: Parquet column %q is not a flat BYTE_ARRAY column", weSubDatabase.ErrInvalidArgument, element.string(4))
+		}
+		d.columns = append(d.columns, column{name: element.string(4), optional: repetition == repetitionOptional})
+	}
+	return d, nil
+}
+
+func (d *decoder) Decode() (map[string]string, error) {
+	for d.row >= d.rows {
+		if d.group >= len(d.groups) {
+			return nil, io.EOF
+		}
+		if err := d.readGroup(); err != nil {
+			return nil, err
+		}
+	}
+	row := make(map[string]string, len(d.columns)+1)
+	nulls := []string{}
+	for k, col := range d.columns {
+		row[col.name] = d.values[k][d.row]
+		if d.nulls[k][d.row] {
+			nulls = append(nulls, col.name)
+		}
+	}
+	if len(nulls) > 0 {
+		sort.Strings(nulls)
+		row[weSubDatabase.ExportNullColumn] = strings.Join(nulls, ",")
+	}
+	d.row++
+	return row, nil
+}
+
+// 读取下一个行组的所有字段
+//
+// Read all columns of the next row group
+func (d *decoder) readGroup() error {
+	group, _ := d.groups[d.group].(thriftFields)
+	d.group++
+	rows, _ := group.int(3)
+	chunks := group.list(1)
+	if len(chunks) != len(d.columns) || rows < 0 {
+		return fmt.Errorf("%w: Parquet row group %d does not match the schema", weSubDatabase.ErrInvalidArgument, d.group-1)
+	}
+	d.values = make([][]string, len(d.columns))
+	d.nulls = make([][]bool, len(d.columns))
+	for k, v := range chunks {
+		chunk, _ := v.(thriftFields)
+		values, nulls, err := d.readChunk(chunk.fields(3), d.columns[k])
+		if err != nil {
+			return fmt.Errorf("parquet column %q: %w", d.columns[k].name, err)
+		}
+		if int64(len(values)) != rows {
+			return fmt.Errorf("%w: Parquet column %q has %d values in a row group of %d rows", weSubDatabase.ErrInvalidArgument, d.columns[k].name, len(values), rows)
+		}
+		d.values[k] = values
+		d.nulls[k] = nulls
+	}
+	d.row = 0
+	d.rows = int(rows)
+	return nil
+}
+
+// ===============
+//
+//	读取一个字段块的所有数据页
+//	meta		thriftFields	"字段块的元数据"
+//	col		column		"字段"
+//	return 1	[]string	"值"
+//	return 2	[]bool		"是否为 NULL"
+//	return 3	error		"错误信息"
+//
+// ===============
+//
+//	Read all data pages of a column chunk
+//	meta		thriftFields	"Metadata of the column chunk"
+//	col		column		"Column"
+//	return 1	[]string	"Values"
+//	return 2	[]bool		"Whether each value is NULL"
+//	return 3	error		"Error message"
+func (d *decoder) readChunk(meta thriftFields, col column) ([]string, []bool, error) {
+	codec, _ := meta.int(4)
+	numValues, _ := meta.int(5)
+	size, _ := meta.int(7)
+	offset, _ := meta.int(9)
+	if _, ok := meta.int(11); ok {
+		return nil, nil, fmt.Errorf("%w: dictionary pages are not supported", weSubDatabase.ErrInvalidArgument)
+	}
+	if codec != codecUncompressed {
+		return nil, nil, fmt.Errorf("%w: compression codec %d is not supported", weSubDatabase.ErrInvalidArgument, codec)
+	}
+	if offset < int64(len(magic)) || size < 0 || offset+size > d.size || numValues < 0 || numValues > size*8 {
+		return nil, nil, fmt.Errorf("%w: column chunk at %d of size %d", weSubDatabase.ErrInvalidArgument, offset, size)
+	}
+	data := make([]byte, size)
+	if _, err := d.r.ReadAt(data, offset); err != nil {
+		return nil, nil, err
+	}
+	r := &thriftReader{data: data}
+	values := make([]string, 0, numValues)
+	nulls := make([]bool, 0, numValues)
+	for int64(len(values)) < numValues {
+		header, err := r.readStruct(0)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: page header: %v", weSubDatabase.ErrInvalidArgument, err)
+		}
+		typ, _ := header.int(1)
+		pageSize, _ := header.int(3)
+		page := header.fields(5)
+		n, _ := page.int(1)
+		encoding, _ := page.int(2)
+		if typ != pageData || page == nil || encoding != encodingPlain {
+			return nil, nil, fmt.Errorf("%w: only PLAIN data pages are supported", weSubDatabase.ErrInvalidArgument)
+		}
+		if pageSize < 0 || pageSize > int64(len(data)-r.pos) || n < 0 || n > numValues-int64(len(values)) {
+			return nil, nil, fmt.Errorf("%w: data page of size %d with %d values", weSubDatabase.ErrInvalidArgument, pageSize, n)
+		}
+		pageValues, pageNulls, err := readPage(data[r.pos:r.pos+int(pageSize)], int(n), col.optional)
+		if err != nil {
+			return nil, nil, err
+		}
+		values = append(values, pageValues...)
+		nulls = append(nulls, pageNulls...)
+		r.pos += int(pageSize)
+	}
+	return values, nulls, nil
+}
+
+// ===============
+//
+//	读取一个数据页的定义级别和值
+//	data		[]byte		"数据页的内容"
+//	n		int		"值的数量, 包含 NULL"
+//	optional	bool		"字段是否为 OPTIONAL, 否则没有定义级别"
+//	return 1	[]string	"值"
+//	return 2	[]bool		"是否为 NULL"
+//	return 3	error		"错误信息"
+//
+// ===============
+//
+//	Read the definition levels and values of a data page
+//	data		[]byte		"Content of the data page"
+//	n		int		"Number of values, NULL included"
+//	optional	bool		"Whether the column is OPTIONAL, it has no
+//					 	definition levels otherwise"
+//	return 1	[]string	"Values"
+//	return 2	[]bool		"Whether each value is NULL"
+//	return 3	error		"Error message"
+func readPage(data []byte, n int, optional bool) ([]string, []bool, error) {
+	errShort := fmt.Errorf("%w: data page too short", weSubDatabase.ErrInvalidArgument)
+	nulls := make([]bool, n)
+	if optional {
+		if len(data) < 4 {
+			return nil, nil, errShort
+		}
+		size := int(binary.LittleEndian.Uint32(data))
+		if size > len(data)-4 {
+			return nil, nil, errShort
+		}
+		levels, err := readLevels(data[4:4+size], n)
+		if err != nil {
+			return nil, nil, err
+		}
+		for k, level := range levels {
+			nulls[k] = level == 0
+		}
+		data = data[4+size:]
+	}
+	values := make([]string, n)
+	for k := range values {
+		if nulls[k] {
+			continue
+		}
+		if len(data) < 4 {
+			return nil, nil, errShort
+		}
+		size := int(binary.LittleEndian.Uint32(data))
+		if size > len(data)-4 {
+			return nil, nil, errShort
+		}
+		values[k] = string(data[4 : 4+size])
+		data = data[4+size:]
+	}
+	return values, nulls, nil
+}
+
+// ===============
+//
+//	读取位宽为 1 的 RLE/bit-packing 混合编码的定义级别
+//	data		[]byte		"编码的数据"
+//	n		int		"级别的数量"
+//	return 1	[]byte		"每个值的定义级别"
+//	return 2	error		"错误信息"
+//
+// ===============
+//
+//	Read definition levels in the RLE/bit-packing hybrid encoding with a bit
+//	width of 1
+//	data		[]byte		"Encoded data"
+//	n		int		"Number of levels"
+//	return 1	[]byte		"Definition level of each value"
+//	return 2	error		"Error message"
+func readLevels(data []byte, n int) ([]byte, error) {
+	errLevels := fmt.Errorf("%w: invalid definition levels", weSubDatabase.ErrInvalidArgument)
+	levels := make([]byte, 0, n)
+	for len(levels) < n {
+		header, size := binary.Uvarint(data)
+		if size <= 0 {
+			return nil, errLevels
+		}
+		data = data[size:]
+		count := header >> 1
+		if header&1 == 1 {
+			// bit-packed: count 组, 每组 8 个值占一个字节
+			// bit-packed: count groups, 8 values take one byte per group
+			if count > uint64(len(data)) {
+				return nil, errLevels
+			}
+			for _, b := range data[:count] {
+				for bit := 0; bit < 8 && len(levels) < n; bit++ {
+					levels = append(levels, b>>bit&1)
+				}
+			}
+			data = data[count:]
+			continue
+		}
+		// RLE: count 个相同的值, 值占一个字节
+		// RLE: count repeated values, the value takes one byte
+		if len(data) < 1 || data[0] > 1 {
+			return nil, errLevels
+		}
+		for k := uint64(0); k < count && len(levels) < n; k++ {
+			levels = append(levels, data[0])
+		}
+		data = data[1:]
+	}
+	return levels, nil
+}
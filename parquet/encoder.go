@@ -0,0 +1,320 @@
+// Parquet 格式的 RowEncoder 和 RowDecoder, 不依赖第三方库
+// 每个字段都是 OPTIONAL 的 BYTE_ARRAY, 用 PLAIN 编码, 不压缩, 值原样写入,
+// NULL 按 Parquet 的定义级别写入; 读取时只支持这种写法, 如字典编码和压缩的文件返回错误
+//
+// RowEncoder and RowDecoder for the Parquet format without third-party libraries
+// Every field is an OPTIONAL BYTE_ARRAY with PLAIN encoding and no
+// compression, values are written as they are and NULL is written with the
+// Parquet definition levels; reading only supports files written this way,
+// an error is returned for files using dictionary encoding or compression
+package parquet
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/0wew0-gh/weSubDatabase"
+)
+
+// 文件开头和结尾的标记
+//
+// Marker at the start and the end of the file
+const magic = "PAR1"
+
+// 每个行组的行数, 行组在内存中缓冲
+//
+// Rows of each row group, a row group is buffered in memory
+const rowGroupRows = 10000
+
+// Parquet 元数据中使用的枚举值
+//
+// Enum values used in the Parquet metadata
+const (
+	typeByteArray      = 6
+	repetitionRequired = 0
+	repetitionOptional = 1
+	encodingPlain      = 0
+	encodingRLE        = 3
+	codecUncompressed  = 0
+	pageData           = 0
+)
+
+// Flush 之后再写入时返回
+//
+// Returned when writing after Flush
+var ErrClosed = errors.New("parquet encoder is closed")
+
+// 已写入的一个字段块
+//
+// Column chunk that has been written
+type columnChunk struct {
+	offset int64
+	size   int64
+	values int64
+}
+
+// 已写入的一个行组
+//
+// Row group that has been written
+type rowGroup struct {
+	columns []columnChunk
+	size    int64
+	rows    int64
+}
+
+type encoder struct {
+	w       *bufio.Writer
+	offset  int64
+	columns []string
+	values  [][]string
+	nulls   [][]bool
+	rows    int
+	groups  []rowGroup
+	header  bool
+	closed  bool
+}
+
+// ===============
+//
+//	Parquet 编码器, 行组在内存中缓冲, Flush 时写入文件的元数据, 之后不能再写入
+//	_null 字段中的字段写为 NULL, 不写入 _null 字段
+//	w		io.Writer	"输出"
+//	columns		[]string	"字段和顺序, nil 时使用第一行按名称排序的字段"
+//	return		RowEncoder	"编码器"
+//
+// ===============
+//
+//	Parquet encoder, row groups are buffered in memory and the metadata of
+//	the file is written by Flush, nothing can be written after it
+//	The columns in the _null field are written as NULL, the _null field
+//	itself is not written
+//	w		io.Writer	"Output"
+//	columns		[]string	"Fields and their order, nil uses the fields
+//					 	of the first row sorted by name"
+//	return		RowEncoder	"Encoder"
+func NewEncoder(w io.Writer, columns []string) weSubDatabase.RowEncoder {
+	return &encoder{w: bufio.NewWriter(w), columns: columns}
+}
+
+func (e *encoder) write(data []byte) error {
+	n, err := e.w.Write(data)
+	e.offset += int64(n)
+	return err
+}
+
+func (e *encoder) start() error {
+	if e.header {
+		return nil
+	}
+	e.header = true
+	e.values = make([][]string, len(e.columns))
+	e.nulls = make([][]bool, len(e.columns))
+	return e.write([]byte(magic))
+}
+
+func (e *encoder) Encode(row map[string]string) error {
+	if e.closed {
+		return ErrClosed
+	}
+	if !e.header && e.columns == nil {
+		for col := range row {
+			if col != weSubDatabase.ExportNullColumn {
+				e.columns = append(e.columns, col)
+			}
+		}
+		sort.Strings(e.columns)
+	}
+	if err := e.start(); err != nil {
+		return err
+	}
+	nulls := []string{}
+	if v := row[weSubDatabase.ExportNullColumn]; v != "" {
+		nulls = strings.Split(v, ",")
+	}
+	n := 0
+	for k, col := range e.columns {
+		v, ok := row[col]
+		if ok {
+			n++
+		}
+		e.values[k] = append(e.values[k], v)
+		e.nulls[k] = append(e.nulls[k], containsString(nulls, col))
+	}
+	if _, ok := row[weSubDatabase.ExportNullColumn]; ok {
+		n++
+	}
+	if n != len(row) {
+		for k := range e.columns {
+			e.values[k] = e.values[k][:e.rows]
+			e.nulls[k] = e.nulls[k][:e.rows]
+		}
+		return fmt.Errorf("%w: the row has fields that are not in the Parquet schema", weSubDatabase.ErrInvalidArgument)
+	}
+	e.rows++
+	if e.rows >= rowGroupRows {
+		return e.writeGroup()
+	}
+	return nil
+}
+
+// 写入缓冲的行组, 每个字段一个数据页
+//
+// Write the buffered row group, one data page per column
+func (e *encoder) writeGroup() error {
+	if e.rows == 0 {
+		return nil
+	}
+	group := rowGroup{rows: int64(e.rows)}
+	for k := range e.columns {
+		data := pageValues(e.values[k], e.nulls[k])
+		w := newThriftWriter()
+		w.i32(1, pageData)
+		w.i32(2, int32(len(data)))
+		w.i32(3, int32(len(data)))
+		w.begin(5)
+		w.i32(1, int32(e.rows))
+		w.i32(2, encodingPlain)
+		w.i32(3, encodingRLE)
+		w.i32(4, encodingRLE)
+		w.end()
+		header := w.bytes()
+		chunk := columnChunk{offset: e.offset, size: int64(len(header) + len(data)), values: int64(e.rows)}
+		if err := e.write(header); err != nil {
+			return err
+		}
+		if err := e.write(data); err != nil {
+			return err
+		}
+		group.columns = append(group.columns, chunk)
+		group.size += chunk.size
+		e.values[k] = e.values[k][:0]
+		e.nulls[k] = e.nulls[k][:0]
+	}
+	e.groups = append(e.groups, group)
+	e.rows = 0
+	return nil
+}
+
+// ===============
+//
+//	生成数据页的内容: 用 RLE/bit-packing 混合编码的定义级别, 然后是不为 NULL 的值
+//	values		[]string	"值"
+//	nulls		[]bool		"是否为 NULL"
+//	return		[]byte		"数据页的内容"
+//
+// ===============
+//
+//	Create the content of a data page: the definition levels in the
+//	RLE/bit-packing hybrid encoding, followed by the values that are not NULL
+//	values		[]string	"Values"
+//	nulls		[]bool		"Whether each value is NULL"
+//	return		[]byte		"Content of the data page"
+func pageValues(values []string, nulls []bool) []byte {
+	// 定义级别只有 0 和 1, 每 8 个值为一个字节的 bit-packed 组
+	// Definition levels are only 0 and 1, every 8 values are a bit-packed group of one byte
+	groups := (len(values) + 7) / 8
+	levels := binary.AppendUvarint(nil, uint64(groups)<<1|1)
+	packed := make([]byte, groups)
+	for k, isNull := range nulls {
+		if !isNull {
+			packed[k/8] |= 1 << (k % 8)
+		}
+	}
+	levels = append(levels, packed...)
+	data := binary.LittleEndian.AppendUint32(nil, uint32(len(levels)))
+	data = append(data, levels...)
+	for k, v := range values {
+		if !nulls[k] {
+			data = binary.LittleEndian.AppendUint32(data, uint32(len(v)))
+			data = append(data, v...)
+		}
+	}
+	return data
+}
+
+// 写入剩下的行和文件的元数据
+//
+// Write the remaining rows and the metadata of the file
+func (e *encoder) Flush() error {
+	if e.closed {
+		return ErrClosed
+	}
+	if err := e.start(); err != nil {
+		return err
+	}
+	if err := e.writeGroup(); err != nil {
+		return err
+	}
+	e.closed = true
+	var numRows int64
+	for _, group := range e.groups {
+		numRows += group.rows
+	}
+	w := newThriftWriter()
+	w.i32(1, 1)
+	w.list(2, thriftStruct, len(e.columns)+1)
+	w.beginValue()
+	w.binary(4, "schema")
+	w.i32(5, int32(len(e.columns)))
+	w.end()
+	for _, col := range e.columns {
+		w.beginValue()
+		w.i32(1, typeByteArray)
+		w.i32(3, repetitionOptional)
+		w.binary(4, col)
+		w.end()
+	}
+	w.i64(3, numRows)
+	w.list(4, thriftStruct, len(e.groups))
+	for _, group := range e.groups {
+		w.beginValue()
+		w.list(1, thriftStruct, len(group.columns))
+		for k, chunk := range group.columns {
+			w.beginValue()
+			w.i64(2, chunk.offset)
+			w.begin(3)
+			w.i32(1, typeByteArray)
+			w.list(2, thriftI32, 2)
+			w.zigzag(encodingPlain)
+			w.zigzag(encodingRLE)
+			w.list(3, thriftBinary, 1)
+			w.binaryValue(e.columns[k])
+			w.i32(4, codecUncompressed)
+			w.i64(5, chunk.values)
+			w.i64(6, chunk.size)
+			w.i64(7, chunk.size)
+			w.i64(9, chunk.offset)
+			w.end()
+			w.end()
+		}
+		w.i64(2, group.size)
+		w.i64(3, group.rows)
+		w.end()
+	}
+	w.binary(6, "weSubDatabase")
+	meta := w.bytes()
+	if err := e.write(meta); err != nil {
+		return err
+	}
+	if err := e.write(binary.LittleEndian.AppendUint32(nil, uint32(len(meta)))); err != nil {
+		return err
+	}
+	if err := e.write([]byte(magic)); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+func containsString(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package parquet
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/0wew0-gh/weSubDatabase"
+)
+
+func TestParquetCodec(t *testing.T) {
+	rows := []map[string]string{
+		{"id": "1", "name": "a,\"b\"\nc", "note": "", "_shard": "0", "_null": "note"},
+		{"id": "2", "name": "", "note": "", "_shard": "1", "_null": "name,note"},
+		{"id": "3", "name": "\xff\x00\r\n", "note": "x", "_shard": "1"},
+	}
+	// 多于一个行组
+	// More than one row group
+	for k := 4; k <= rowGroupRows+5; k++ {
+		rows = append(rows, map[string]string{"id": strconv.Itoa(k), "name": "n", "note": "", "_shard": "0"})
+	}
+	var b bytes.Buffer
+	enc := NewEncoder(&b, nil)
+	for _, row := range rows {
+		if err := enc.Encode(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode(rows[0]); !errors.Is(err, ErrClosed) {
+		t.Error("encode after flush:", err)
+	}
+	dec, err := NewDecoder(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []map[string]string{}
+	for {
+		row, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, row)
+	}
+	if !reflect.DeepEqual(got, rows) {
+		t.Error("rows:", len(got), got[:3])
+	}
+	if len(dec.(*decoder).groups) != 2 {
+		t.Error("row groups:", len(dec.(*decoder).groups))
+	}
+
+	enc = NewEncoder(&bytes.Buffer{}, []string{"id"})
+	if err := enc.Encode(map[string]string{"id": "1", "name": "a"}); !errors.Is(err, weSubDatabase.ErrInvalidArgument) {
+		t.Error("field not in schema:", err)
+	}
+}
+
+func TestParquetEmptyAndWide(t *testing.T) {
+	var b bytes.Buffer
+	if err := NewEncoder(&b, nil).Flush(); err != nil {
+		t.Fatal(err)
+	}
+	dec, err := NewDecoder(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Error("empty file:", err)
+	}
+
+	// 超过 15 个元素的列表和字段编号
+	// Lists with more than 15 elements
+	row := map[string]string{}
+	for k := 0; k < 20; k++ {
+		row["c"+strconv.Itoa(k)] = strconv.Itoa(k)
+	}
+	b.Reset()
+	enc := NewEncoder(&b, nil)
+	if err := enc.Encode(row); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	dec, err = NewDecoder(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := dec.Decode(); err != nil || !reflect.DeepEqual(got, row) {
+		t.Error("wide row:", got, err)
+	}
+}
+
+func TestParquetInvalid(t *testing.T) {
+	for _, data := range []string{"", "PAR1PAR1", "PAR1\x00\x00\x00\x00PAR1", "PAR1\x05\x15\x00\x00\x05\x00\x00\x00PAR1", "CSV1\x00\x00\x00\x00\x00PAR1"} {
+		if _, err := NewDecoder(bytes.NewReader([]byte(data)), int64(len(data))); !errors.Is(err, weSubDatabase.ErrInvalidArgument) {
+			t.Error("invalid file:", strconv.Quote(data), err)
+		}
+	}
+}
+
+func TestParquetThrift(t *testing.T) {
+	w := newThriftWriter()
+	w.i32(1, -3)
+	w.i64(20, 1<<40)
+	w.begin(21)
+	w.binary(1, "ab")
+	w.end()
+	w.list(22, thriftI32, 2)
+	w.zigzag(1)
+	w.zigzag(2)
+	data := w.bytes()
+	want := []byte{0x15, 0x05, 0x06, 0x28, 0x80, 0x80, 0x80, 0x80, 0x80, 0x40, 0x1c, 0x18, 0x02, 'a', 'b', 0x00, 0x19, 0x25, 0x02, 0x04, 0x00}
+	if !bytes.Equal(data, want) {
+		t.Errorf("thrift: % x", data)
+	}
+	fields, err := (&thriftReader{data: data}).readStruct(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := fields.int(1); v != -3 {
+		t.Error("i32:", v)
+	}
+	if v, _ := fields.int(20); v != 1<<40 {
+		t.Error("i64:", v)
+	}
+	if v := fields.fields(21).string(1); v != "ab" {
+		t.Error("struct:", v)
+	}
+	if v := fields.list(22); !reflect.DeepEqual(v, []interface{}{int64(1), int64(2)}) {
+		t.Error("list:", v)
+	}
+}
@@ -0,0 +1,322 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/0wew0-gh/weSubDatabase"
+)
+
+// Thrift compact 协议的类型
+//
+// Types of the Thrift compact protocol
+const (
+	thriftBoolTrue  = 1
+	thriftBoolFalse = 2
+	thriftByte      = 3
+	thriftI16       = 4
+	thriftI32       = 5
+	thriftI64       = 6
+	thriftDouble    = 7
+	thriftBinary    = 8
+	thriftList      = 9
+	thriftSet       = 10
+	thriftMap       = 11
+	thriftStruct    = 12
+)
+
+// 读取元数据时结构体嵌套的最大深度
+//
+// Maximum nesting depth of structs when reading the metadata
+const thriftMaxDepth = 32
+
+// Thrift compact 协议的编码器, 只实现 Parquet 元数据需要的类型
+//
+// Encoder of the Thrift compact protocol, only the types needed by the
+// Parquet metadata are implemented
+type thriftWriter struct {
+	buf []byte
+	// 每层结构体上一个字段的编号
+	// Id of the last field of every struct level
+	last []int16
+}
+
+func newThriftWriter() *thriftWriter {
+	return &thriftWriter{last: []int16{0}}
+}
+
+func (w *thriftWriter) varint(v uint64) {
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+
+func (w *thriftWriter) zigzag(v int64) {
+	w.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (w *thriftWriter) field(typ byte, id int16) {
+	last := w.last[len(w.last)-1]
+	if d := id - last; d > 0 && d <= 15 {
+		w.buf = append(w.buf, byte(d)<<4|typ)
+	} else {
+		w.buf = append(w.buf, typ)
+		w.zigzag(int64(id))
+	}
+	w.last[len(w.last)-1] = id
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(thriftI32, id)
+	w.zigzag(int64(v))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(thriftI64, id)
+	w.zigzag(v)
+}
+
+func (w *thriftWriter) binary(id int16, v string) {
+	w.field(thriftBinary, id)
+	w.binaryValue(v)
+}
+
+func (w *thriftWriter) binaryValue(v string) {
+	w.varint(uint64(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+// 开始一个字段为结构体, 用 end 结束
+//
+// Begin a struct field, ended with end
+func (w *thriftWriter) begin(id int16) {
+	w.field(thriftStruct, id)
+	w.beginValue()
+}
+
+// 开始列表中的一个结构体, 用 end 结束
+//
+// Begin a struct in a list, ended with end
+func (w *thriftWriter) beginValue() {
+	w.last = append(w.last, 0)
+}
+
+func (w *thriftWriter) end() {
+	w.buf = append(w.buf, 0)
+	w.last = w.last[:len(w.last)-1]
+}
+
+func (w *thriftWriter) list(id int16, elem byte, n int) {
+	w.field(thriftList, id)
+	if n < 15 {
+		w.buf = append(w.buf, byte(n)<<4|elem)
+		return
+	}
+	w.buf = append(w.buf, 0xf0|elem)
+	w.varint(uint64(n))
+}
+
+// 写入最外层结构体的结束标记, 返回编码后的数据
+//
+// Write the stop marker of the outermost struct and return the encoded data
+func (w *thriftWriter) bytes() []byte {
+	w.buf = append(w.buf, 0)
+	return w.buf
+}
+
+// 读取的结构体, 键为字段编号; 整数读取为 int64, binary 为 []byte,
+// list 和 set 为 []interface{}, map 为 [][2]interface{}
+//
+// Struct read, keyed by field id; integers are read as int64, binary as
+// []byte, list and set as []interface{} and map as [][2]interface{}
+type thriftFields map[int16]interface{}
+
+func (f thriftFields) int(id int16) (int64, bool) {
+	v, ok := f[id].(int64)
+	return v, ok
+}
+
+func (f thriftFields) string(id int16) string {
+	v, _ := f[id].([]byte)
+	return string(v)
+}
+
+func (f thriftFields) list(id int16) []interface{} {
+	v, _ := f[id].([]interface{})
+	return v
+}
+
+func (f thriftFields) fields(id int16) thriftFields {
+	v, _ := f[id].(thriftFields)
+	return v
+}
+
+var errThriftShort = errors.New("unexpected end of Thrift data")
+
+// Thrift compact 协议的解码器, 不认识的字段照常读取, 由调用方忽略
+//
+// Decoder of the Thrift compact protocol, unknown fields are read as usual
+// and ignored by the caller
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *thriftReader) byte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, errThriftShort
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *thriftReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		return 0, errThriftShort
+	}
+	r.pos += n
+	return v, nil
+}
+
+func (r *thriftReader) zigzag() (int64, error) {
+	v, err := r.varint()
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+func (r *thriftReader) size() (int, error) {
+	n, err := r.varint()
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(r.data)-r.pos) {
+		return 0, errThriftShort
+	}
+	return int(n), nil
+}
+
+// ===============
+//
+//	读取一个结构体, 直到结束标记
+//	depth		int		"嵌套深度"
+//	return 1	thriftFields	"字段"
+//	return 2	error		"错误信息"
+//
+// ===============
+//
+//	Read a struct up to its stop marker
+//	depth		int		"Nesting depth"
+//	return 1	thriftFields	"Fields"
+//	return 2	error		"Error message"
+func (r *thriftReader) readStruct(depth int) (thriftFields, error) {
+	if depth > thriftMaxDepth {
+		return nil, fmt.Errorf("%w: Thrift structs nested too deeply", weSubDatabase.ErrInvalidArgument)
+	}
+	fields := thriftFields{}
+	var last int16
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if b == 0 {
+			return fields, nil
+		}
+		typ := b & 0x0f
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			v, err := r.zigzag()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v)
+		}
+		last = id
+		switch typ {
+		case thriftBoolTrue:
+			fields[id] = true
+		case thriftBoolFalse:
+			fields[id] = false
+		default:
+			if fields[id], err = r.readValue(typ, depth); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (r *thriftReader) readValue(typ byte, depth int) (interface{}, error) {
+	switch typ {
+	case thriftBoolTrue, thriftBoolFalse:
+		// 列表中的布尔值占一个字节
+		// A bool in a list takes one byte
+		b, err := r.byte()
+		return b == thriftBoolTrue, err
+	case thriftByte:
+		b, err := r.byte()
+		return int64(int8(b)), err
+	case thriftI16, thriftI32, thriftI64:
+		return r.zigzag()
+	case thriftDouble:
+		if len(r.data)-r.pos < 8 {
+			return nil, errThriftShort
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(r.data[r.pos:]))
+		r.pos += 8
+		return v, nil
+	case thriftBinary:
+		n, err := r.size()
+		if err != nil {
+			return nil, err
+		}
+		v := r.data[r.pos : r.pos+n]
+		r.pos += n
+		return v, nil
+	case thriftList, thriftSet:
+		b, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		n := int(b >> 4)
+		if n == 15 {
+			if n, err = r.size(); err != nil {
+				return nil, err
+			}
+		}
+		list := make([]interface{}, 0, n)
+		for k := 0; k < n; k++ {
+			v, err := r.readValue(b&0x0f, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case thriftMap:
+		n, err := r.size()
+		if err != nil || n == 0 {
+			return [][2]interface{}{}, err
+		}
+		b, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		entries := make([][2]interface{}, 0, n)
+		for k := 0; k < n; k++ {
+			key, err := r.readValue(b>>4, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			value, err := r.readValue(b&0x0f, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, [2]interface{}{key, value})
+		}
+		return entries, nil
+	case thriftStruct:
+		return r.readStruct(depth + 1)
+	}
+	return nil, fmt.Errorf("%w: unknown Thrift type %d", weSubDatabase.ErrInvalidArgument, typ)
+}
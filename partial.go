@@ -0,0 +1,173 @@
+package weSubDatabase
+
+import (
+	"fmt"
+	"sort"
+)
+
+// 数据库不可用时的部分结果策略
+//
+// Partial result policy when databases are unavailable
+type PartialPolicy int
+
+const (
+	//	返回部分结果 (默认)
+	//
+	//	Return partial results (default)
+	PartialAllow PartialPolicy = iota
+	//	任意数据库缺失时失败
+	//
+	//	Fail if any database is missing
+	PartialFail
+	//	应答的数据库数目达到法定数目时返回结果
+	//
+	//	Return results when the number of answered databases reaches the quorum
+	PartialQuorum
+)
+
+// 一次分发查询中各数据库的应答情况
+//
+// How each database answered in one fan-out query
+type ShardReport struct {
+	//	应答的数据库在配置中的位置
+	//
+	//	Location of the databases that answered in the configuration
+	Answered []int
+	//	因熔断而跳过的数据库在配置中的位置
+	//
+	//	Location of the databases skipped because of the circuit breaker in the configuration
+	Skipped []int
+	//	查询失败的数据库在配置中的位置
+	//
+	//	Location of the databases whose query failed in the configuration
+	Failed []int
+}
+
+// ===============
+//
+//	结果是否完整
+//	return		bool	"没有跳过和失败的数据库时为 true"
+//
+// ===============
+//
+//	Whether the result is complete
+//	return		bool	"true when no database was skipped or failed"
+func (r *ShardReport) Complete() bool {
+	return len(r.Skipped) == 0 && len(r.Failed) == 0
+}
+
+// ===============
+//
+//	缺失的数据库
+//	return		[]int	"跳过和失败的数据库在配置中的位置"
+//
+// ===============
+//
+//	Missing databases
+//	return		[]int	"Location of the skipped and failed databases
+//					 	in the configuration"
+func (r *ShardReport) Missing() []int {
+	missing := append(append([]int{}, r.Skipped...), r.Failed...)
+	sort.Ints(missing)
+	return missing
+}
+
+// ===============
+//
+//	设置部分结果策略
+//	PartialPolicy	PartialPolicy	"部分结果策略"
+//
+// ===============
+//
+//	Set the partial result policy
+//	PartialPolicy	PartialPolicy	"Partial result policy"
+func OPartialPolicy(PartialPolicy PartialPolicy) IsShowPrintO {
+	return func(o *Option) {
+		o.PartialPolicy = PartialPolicy
+	}
+}
+
+// ===============
+//
+//	要求应答的数据库达到法定数目, 小于等于 0 时为目标数据库的多数
+//	Quorum		int	"法定数目"
+//
+// ===============
+//
+//	Require the answered databases to reach a quorum, a majority of the
+//	target databases when less than or equal to 0
+//	Quorum		int	"Quorum"
+func OQuorum(Quorum int) IsShowPrintO {
+	return func(o *Option) {
+		o.PartialPolicy = PartialQuorum
+		o.Quorum = Quorum
+	}
+}
+
+// ===============
+//
+//	接收各数据库的应答情况
+//	Report		*ShardReport	"查询结束后写入应答情况"
+//
+// ===============
+//
+//	Receive how each database answered
+//	Report		*ShardReport	"How the databases answered is written
+//									after the query"
+func OShardReport(Report *ShardReport) IsShowPrintO {
+	return func(o *Option) {
+		o.Report = Report
+	}
+}
+
+// ===============
+//
+//	根据部分结果策略检查应答情况
+//	report		*ShardReport	"应答情况"
+//	return		error		"不满足策略时返回错误"
+//
+// ===============
+//
+//	Check how the databases answered against the partial result policy
+//	report		*ShardReport	"How the databases answered"
+//	return		error		"Error when the policy is not satisfied"
+func (o *Option) checkPartial(report *ShardReport) error {
+	switch o.PartialPolicy {
+	case PartialFail:
+		if !report.Complete() {
+			return fmt.Errorf("%w: missing databases %v", ErrIncompleteResult, report.Missing())
+		}
+	case PartialQuorum:
+		quorum := o.Quorum
+		if quorum <= 0 {
+			quorum = (len(report.Answered)+len(report.Skipped)+len(report.Failed))/2 + 1
+		}
+		if len(report.Answered) < quorum {
+			return fmt.Errorf("%w: %d of %d required databases answered, missing databases %v", ErrIncompleteResult, len(report.Answered), quorum, report.Missing())
+		}
+	}
+	return nil
+}
+
+// 在查询前检查跳过的数据库, PartialFail 时不必再查询其他数据库
+//
+// Check the skipped databases before querying, with PartialFail there is
+// no need to query the other databases
+func (o *Option) checkSkipped(report *ShardReport) error {
+	if o.PartialPolicy != PartialFail || len(report.Skipped) == 0 {
+		return nil
+	}
+	return o.finishReport(report)
+}
+
+// 写入应答情况并根据策略检查
+//
+// Write how the databases answered and check it against the policy
+func (o *Option) finishReport(report *ShardReport) error {
+	sort.Ints(report.Answered)
+	sort.Ints(report.Failed)
+	if o.Report != nil {
+		*o.Report = *report
+	}
+	return o.checkPartial(report)
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"time"
+
+	"github.com/go-redis/redis/v8"
 )
 
 // <類>
@@ -47,11 +49,14 @@ func OLRedisWaitTime(WaitTime int) RedisO {
 // ===============
 //
 //	设置是输出到控制台
+//	日志同时以文本格式输出到控制台, 建议使用 Setting.SetLogHandler
 //	IsShowPrint	bool	"是否输出到控制台"
 //
 // ===============
 //
 //	Set whether to output to the console
+//	Logs are also written to the console as text, Setting.SetLogHandler
+//	is recommended
 //	IsShowPrint	bool	"Whether to output to the console"
 func OLRedisIsShowPrint(IsShowPrint bool) RedisO {
 	return func(o *Option) {
@@ -136,6 +141,10 @@ func (s *Setting) RedisIsRun(item int, dbID int, options ...RedisO) (int, error)
 	for _, o := range options {
 		o(option)
 	}
+	if !s.canTry(EndpointRedis, item, -1) {
+		s.instrument().EndpointSkipped(EndpointRedis, item, -1)
+		return -1, fmt.Errorf("%w: redis database %d", ErrShardUnavailable, item)
+	}
 	if s.RedisLinkNum >= s.RedisMaxLink {
 		WaitCount := 0
 		for {
@@ -143,16 +152,21 @@ func (s *Setting) RedisIsRun(item int, dbID int, options ...RedisO) (int, error)
 				break
 			}
 			if WaitCount > option.WaitCount {
-				return -1, fmt.Errorf("MySQL connections are full")
+				return -1, fmt.Errorf("%w: Redis connections are full", ErrPoolExhausted)
 			}
 			WaitCount += 1
+			s.instrument().PoolWait(EndpointRedis, item)
 			time.Sleep(time.Duration(option.WaitTime) * time.Millisecond)
 		}
 	}
+	if !s.tryAcquire(EndpointRedis, item, -1) {
+		return -1, fmt.Errorf("%w: redis database %d", ErrShardUnavailable, item)
+	}
 	wRedisDB, err := s.RedisLink(item, dbID)
+	s.reportEndpoint(EndpointRedis, item, -1, err)
+	logger := s.logger(nil, option.IsShowPrint)
 	if err != nil {
-		tn := time.Now()
-		s.RedisConnectFailTime[item] = &tn
+		logger.Error("redis connect", "shard", item, "redis_db", dbID, "error", err)
 		return -1, err
 	}
 	ii := 0
@@ -164,9 +178,7 @@ func (s *Setting) RedisIsRun(item int, dbID int, options ...RedisO) (int, error)
 	}
 	s.RedisLinkNum += 1
 	s.RedisDB[ii] = wRedisDB
-	if option.IsShowPrint {
-		println("Redis DB", item, "connection successful!")
-	}
+	logger.Debug("redis connect", "shard", item, "redis_db", dbID, "conns", s.RedisLinkNum)
 	return ii, nil
 }
 
@@ -196,9 +208,7 @@ func (s *Setting) RedisClose(i int, options ...IsShowPrintO) {
 		for _, o := range options {
 			o(option)
 		}
-		if option.IsShowPrint {
-			println("MySQL Close Connection! Current number of connections:", s.RedisLinkNum)
-		}
+		s.logger(nil, option.IsShowPrint).Debug("redis close", "conns", s.RedisLinkNum)
 	}
 }
 
@@ -227,9 +237,9 @@ func (rDB *RedisDB) SetValue(key string, val string, options ...RedisO) error {
 	}
 	var err error
 	if option.AutoDeleteTime == 0 {
-		err = rDB.DB.Set(ctx, key, val, 0).Err()
+		err = rDB.DB.Set(option.getContext(), key, val, 0).Err()
 	} else {
-		err = rDB.DB.Set(ctx, key, val, time.Duration(option.AutoDeleteTime)*time.Second).Err()
+		err = rDB.DB.Set(option.getContext(), key, val, time.Duration(option.AutoDeleteTime)*time.Second).Err()
 	}
 	return err
 }
@@ -257,12 +267,12 @@ func (rDB *RedisDB) GetString(key string, options ...RedisO) (string, error) {
 	for _, o := range options {
 		o(option)
 	}
-	val, err := rDB.DB.Get(ctx, key).Result()
+	val, err := rDB.DB.Get(option.getContext(), key).Result()
 	if err != nil {
 		return "", err
 	}
 	if option.IsDelete {
-		err = rDB.DB.Del(ctx, key).Err()
+		err = rDB.DB.Del(option.getContext(), key).Err()
 		if err != nil {
 			return "", err
 		}
@@ -306,23 +316,20 @@ func (rDB *RedisDB) GetStringAll(keyPattern string, options ...RedisO) (map[stri
 		data map[string]string = make(map[string]string)
 		err  error             = nil
 	)
-	iter := rDB.DB.Scan(ctx, 0, keyPattern, 0).Iterator()
-	for iter.Next(ctx) {
-		var (
-			key string
-			val string
-		)
-		key = iter.Val()
-		val, err = rDB.GetString(key, options...)
+	err = rDB.scanKeys(option.getContext(), keyPattern, func(key string) error {
+		val, err := rDB.GetString(key, options...)
 		if err != nil {
 			if option.IsErrorStop {
-				return nil, err
+				return err
 			}
 		} else {
 			data[key] = val
 		}
+		return nil
+	})
+	if err != nil && option.IsErrorStop {
+		return nil, err
 	}
-	err = iter.Err()
 	return data, err
 }
 
@@ -341,7 +348,20 @@ func (rDB *RedisDB) GetStringAll(keyPattern string, options ...RedisO) (map[stri
 //	return 1	[]string	"Key"
 //	return 2	error		"Error message"
 func (rDB *RedisDB) Keys(keyPattern string) ([]string, error) {
-	keys, err := rDB.DB.Keys(ctx, keyPattern).Result()
+	var (
+		keys []string
+		err  error
+	)
+	if _, ok := rDB.DB.(*redis.ClusterClient); ok {
+		// KEYS 只会发送到一个节点
+		// KEYS is only sent to a single node
+		err = rDB.scanKeys(ctx, keyPattern, func(key string) error {
+			keys = append(keys, key)
+			return nil
+		})
+	} else {
+		keys, err = rDB.DB.Keys(ctx, keyPattern).Result()
+	}
 	if err == nil {
 		//排序
 		for i := 0; i < len(keys); i++ {
@@ -382,7 +402,7 @@ func (rDB *RedisDB) Del(keys []string, options ...RedisO) error {
 	}
 	var err error
 	for _, k := range keys {
-		err = rDB.DB.Del(ctx, k).Err()
+		err = rDB.DB.Del(option.getContext(), k).Err()
 		if err != nil && option.IsErrorStop {
 			return err
 		}
@@ -415,15 +435,16 @@ func (rDB *RedisDB) DelMulti(keyPattern string, options ...RedisO) error {
 	for _, o := range options {
 		o(option)
 	}
-	var err error
-	iter := rDB.DB.Scan(ctx, 0, keyPattern, 0).Iterator()
-	for iter.Next(ctx) {
-		key := iter.Val()
-		err = rDB.DB.Del(ctx, key).Err()
-		if err != nil && option.IsErrorStop {
-			return err
+	var delErr error
+	err := rDB.scanKeys(option.getContext(), keyPattern, func(key string) error {
+		delErr = rDB.DB.Del(option.getContext(), key).Err()
+		if delErr != nil && option.IsErrorStop {
+			return delErr
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-	err = iter.Err()
-	return err
+	return delErr
 }
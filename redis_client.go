@@ -0,0 +1,154 @@
+package weSubDatabase
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ===============
+//
+//	根据配置创建 Redis 客户端
+//	Cluster 种子节点不为空时创建 Cluster 客户端,
+//	Sentinel 主节点名不为空时创建 Sentinel 客户端,
+//	否则创建单机客户端
+//	config		*RedisConfig		"Redis 配置"
+//	dbID		int			"需要连接的数据库ID"
+//	return 1	redis.UniversalClient	"Redis 客户端"
+//	return 2	error			"错误信息"
+//
+// ===============
+//
+//	Create a Redis client according to the configuration
+//	A Cluster client is created when the cluster seed nodes are not empty,
+//	a Sentinel client is created when the Sentinel master name is not empty,
+//	otherwise a single node client is created
+//	config		*RedisConfig		"Redis configuration"
+//	dbID		int			"Database ID to be connected"
+//	return 1	redis.UniversalClient	"Redis client"
+//	return 2	error			"Error message"
+func newRedisClient(config *RedisConfig, dbID int) (redis.UniversalClient, error) {
+	tlsConfig, err := config.TLS.tlsConfig(config.Addr)
+	if err != nil {
+		return nil, err
+	}
+	options := &redis.UniversalOptions{
+		DB:               dbID,
+		Username:         config.User,
+		Password:         config.Password,
+		SentinelUsername: config.SentinelUser,
+		SentinelPassword: config.SentinelPassword,
+		TLSConfig:        tlsConfig,
+	}
+	switch {
+	case len(config.ClusterAddrs) > 0:
+		options.Addrs = config.ClusterAddrs
+		return redis.NewClusterClient(options.Cluster()), nil
+	case config.SentinelMaster != "":
+		if len(config.SentinelAddrs) == 0 {
+			return nil, fmt.Errorf("redis Open Error: %w: sentinel addresses are empty", ErrConfigEmpty)
+		}
+		options.Addrs = config.SentinelAddrs
+		options.MasterName = config.SentinelMaster
+		return redis.NewFailoverClient(options.Failover()), nil
+	default:
+		options.Addrs = []string{config.Addr + ":" + config.Port}
+		return redis.NewClient(options.Simple()), nil
+	}
+}
+
+// ===============
+//
+//	根据 TLS 配置生成 *tls.Config, 配置为 nil 时返回 nil
+//	defaultServerName	string		"ServerName 为空时使用的主机名"
+//	return 1		*tls.Config	"TLS 配置"
+//	return 2		error		"错误信息"
+//
+// ===============
+//
+//	Generate *tls.Config from the TLS configuration, nil is returned when the configuration is nil
+//	defaultServerName	string		"Host name used when
+//											ServerName is empty"
+//	return 1		*tls.Config	"TLS configuration"
+//	return 2		error		"Error message"
+func (c *RedisTLSConfig) tlsConfig(defaultServerName string) (*tls.Config, error) {
+	if c == nil {
+		return nil, nil
+	}
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if config.ServerName == "" {
+		config.ServerName = defaultServerName
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("redis TLS Error: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("redis TLS Error: no certificate found in %s", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("redis TLS Error: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// ===============
+//
+//	遍历匹配的键, Cluster 模式下遍历全部主节点
+//	ctx		context.Context		"上下文"
+//	keyPattern	string			"键(支持通配符,如:*)"
+//	fn		func(string) error	"对每个键调用, 返回错误时停止"
+//	return		error			"错误信息"
+//
+// ===============
+//
+//	Iterate over the matching keys, all master nodes are scanned in Cluster mode
+//	ctx		context.Context		"Context"
+//	keyPattern	string			"Key (supports wildcards,
+//											such as: *)"
+//	fn		func(string) error	"Called for each key, stops
+//											when an error is returned"
+//	return		error			"Error message"
+func (rDB *RedisDB) scanKeys(ctx context.Context, keyPattern string, fn func(key string) error) error {
+	scan := func(ctx context.Context, client redis.Cmdable, fn func(string) error) error {
+		iter := client.Scan(ctx, 0, keyPattern, 0).Iterator()
+		for iter.Next(ctx) {
+			if err := fn(iter.Val()); err != nil {
+				return err
+			}
+		}
+		return iter.Err()
+	}
+	cluster, ok := rDB.DB.(*redis.ClusterClient)
+	if !ok {
+		return scan(ctx, rDB.DB, fn)
+	}
+	// ForEachMaster 并发执行, fn 不一定是并发安全的
+	// ForEachMaster runs concurrently, fn is not necessarily safe for concurrent use
+	var mu sync.Mutex
+	lockedFn := func(key string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return fn(key)
+	}
+	return cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+		return scan(ctx, client, lockedFn)
+	})
+}
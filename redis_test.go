@@ -3,6 +3,8 @@ package weSubDatabase
 import (
 	"fmt"
 	"testing"
+
+	"github.com/go-redis/redis/v8"
 )
 
 func TestRedis(t *testing.T) {
@@ -82,3 +84,44 @@ func TestRedis(t *testing.T) {
 	fmt.Println("Redis vals:", val)
 	setting.RedisClose(rI)
 }
+
+func TestRedisClientMode(t *testing.T) {
+	config := RedisConfig{Addr: "127.0.0.1", Port: "6379", MaxDB: 15}
+	client, err := newRedisClient(&config, 1)
+	if err != nil {
+		t.Error("single client failed:", err)
+		return
+	}
+	if _, ok := client.(*redis.Client); !ok {
+		t.Errorf("single client type: %T", client)
+	}
+	client.Close()
+
+	config.SentinelMaster = "mymaster"
+	if _, err = newRedisClient(&config, 1); err == nil {
+		t.Error("sentinel client without addresses should fail")
+	}
+	config.SentinelAddrs = []string{"127.0.0.1:26379"}
+	client, err = newRedisClient(&config, 1)
+	if err != nil {
+		t.Error("sentinel client failed:", err)
+		return
+	}
+	client.Close()
+
+	config.ClusterAddrs = []string{"127.0.0.1:7000", "127.0.0.1:7001"}
+	client, err = newRedisClient(&config, 0)
+	if err != nil {
+		t.Error("cluster client failed:", err)
+		return
+	}
+	if _, ok := client.(*redis.ClusterClient); !ok {
+		t.Errorf("cluster client type: %T", client)
+	}
+	client.Close()
+
+	config.TLS = &RedisTLSConfig{CAFile: "not_exist.pem"}
+	if _, err = newRedisClient(&config, 0); err == nil {
+		t.Error("missing CA file should fail")
+	}
+}
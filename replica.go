@@ -0,0 +1,254 @@
+package weSubDatabase
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// ===============
+//
+//	连接数据库的只读副本
+//	item		int		"数据库在配置中的位置"
+//	replica		int		"副本在配置中的位置"
+//	return 1	*MysqlDB	"连接池中的位置"
+//	return 2	error		"错误信息"
+//
+// ===============
+//
+//	Connect to a read replica of the database
+//	item		int		"Location of the database in the
+//									configuration"
+//	replica		int		"Location of the replica in the
+//									configuration"
+//	return 1	*MysqlDB	"Location in the connection pool"
+//	return 2	error		"Error message"
+func (s *Setting) LinkReplica(item int, replica int) (*MysqlDB, error) {
+	if item < 0 || item >= len(s.SqlConfigs) {
+		return nil, fmt.Errorf("MySQL Open Error: %w: database %d", ErrOutOfRange, item)
+	}
+	primary := &s.SqlConfigs[item]
+	if replica < 0 || replica >= len(primary.Replicas) {
+		return nil, fmt.Errorf("MySQL Open Error: %w: replica %d", ErrOutOfRange, replica)
+	}
+	r := primary.Replicas[replica]
+	if r.User == "" {
+		r.User = primary.User
+		r.Password = primary.Password
+	}
+	if r.Port == "" {
+		r.Port = primary.Port
+	}
+	if r.DB == "" {
+		r.DB = primary.DB
+	}
+	sqldb, err := openMySQL(r.User, r.Password, r.Address, r.Port, r.DB)
+	if err != nil {
+		return nil, err
+	}
+	return &MysqlDB{Name: r.DB, DBItem: item, Replica: replica, DB: sqldb, hooks: s.getHooks()}, nil
+}
+
+// ===============
+//
+//	判断是否可以连接副本
+//	item		int	"数据库在配置中的位置"
+//	replica		int	"副本在配置中的位置"
+//	return		bool	"是否可以尝试连接"
+//
+// ===============
+//
+//	Determine whether the replica can be connected
+//	item		int	"Location of the database in the configuration"
+//	replica		int	"Location of the replica in the configuration"
+//	return		bool	"Whether to try to connect"
+func (s *Setting) IsRetryReplica(item int, replica int) bool {
+	return s.allowEndpoint(EndpointMySQLReplica, item, replica)
+}
+
+// ===============
+//
+//	判断数据库是否可以读取, 主库或任意副本可以尝试连接时为 true
+//	item		int	"数据库在配置中的位置"
+//	return		bool	"是否可以读取"
+//
+// ===============
+//
+//	Determine whether the database can be read, true when the primary or
+//	any replica can be tried
+//	item		int	"Location of the database in the configuration"
+//	return		bool	"Whether it can be read"
+func (s *Setting) IsRetryRead(item int) bool {
+	if s.canTry(EndpointMySQL, item, -1) {
+		return true
+	}
+	for r := range s.SqlConfigs[item].Replicas {
+		if s.canTry(EndpointMySQLReplica, item, r) {
+			return true
+		}
+	}
+	s.instrument().EndpointSkipped(EndpointMySQL, item, -1)
+	return false
+}
+
+// 记录数据库的写入时间
+//
+// Record the write time of the database
+func (s *Setting) markWrite(item int) {
+	if s.ReadYourWritesTime <= 0 {
+		return
+	}
+	s.replicaMu.Lock()
+	s.lastWriteTime[item] = time.Now()
+	s.replicaMu.Unlock()
+}
+
+// 写入后的时间窗口内只读取主库
+//
+// Only read from the primary within the time window after a write
+func (s *Setting) isPinnedToPrimary(item int) bool {
+	if s.ReadYourWritesTime <= 0 {
+		return false
+	}
+	s.replicaMu.Lock()
+	defer s.replicaMu.Unlock()
+	lastWrite := s.lastWriteTime[item]
+	if lastWrite.IsZero() {
+		return false
+	}
+	return time.Since(lastWrite) < time.Millisecond*time.Duration(s.ReadYourWritesTime)
+}
+
+// ===============
+//
+//	按权重随机排列可以尝试连接的副本
+//	item		int	"数据库在配置中的位置"
+//	return		[]int	"副本在配置中的位置"
+//
+// ===============
+//
+//	Order the replicas that can be tried randomly by weight
+//	item		int	"Location of the database in the configuration"
+//	return		[]int	"Location of the replicas in the configuration"
+func (s *Setting) pickReplicas(item int) []int {
+	var (
+		candidates []int
+		weights    []int
+		total      int
+	)
+	for r, v := range s.SqlConfigs[item].Replicas {
+		if !s.canTry(EndpointMySQLReplica, item, r) {
+			continue
+		}
+		weight := v.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		candidates = append(candidates, r)
+		weights = append(weights, weight)
+		total += weight
+	}
+	order := make([]int, 0, len(candidates))
+	for len(candidates) > 0 {
+		n := rand.Intn(total)
+		j := 0
+		for ; j < len(weights)-1; j++ {
+			if n < weights[j] {
+				break
+			}
+			n -= weights[j]
+		}
+		order = append(order, candidates[j])
+		total -= weights[j]
+		candidates = append(candidates[:j], candidates[j+1:]...)
+		weights = append(weights[:j], weights[j+1:]...)
+	}
+	return order
+}
+
+// ===============
+//
+//	连接用于读取的数据库
+//	优先连接健康且延迟未超过限制的副本, 都不可用时连接主库
+//	item		int		"数据库在配置中的位置"
+//	return 1	*MysqlDB	"数据库连接"
+//	return 2	error		"错误信息"
+//
+// ===============
+//
+//	Connect to the database used for reading
+//	Healthy replicas within the lag limit are preferred, the primary is used
+//	when none of them are available
+//	item		int		"Location of the database in the
+//									configuration"
+//	return 1	*MysqlDB	"Database connection"
+//	return 2	error		"Error message"
+func (s *Setting) linkRead(item int) (*MysqlDB, error) {
+	if !s.isPinnedToPrimary(item) {
+		maxLag := s.SqlConfigs[item].MaxReplicaLag
+		for _, r := range s.pickReplicas(item) {
+			if !s.tryAcquire(EndpointMySQLReplica, item, r) {
+				continue
+			}
+			db, err := s.LinkReplica(item, r)
+			if err != nil {
+				s.reportEndpoint(EndpointMySQLReplica, item, r, err)
+				continue
+			}
+			if maxLag > 0 {
+				lag, err := db.ReplicaLag()
+				if err != nil {
+					db.Close()
+					s.reportEndpoint(EndpointMySQLReplica, item, r, &replicaLagError{err: err})
+					continue
+				}
+				if lag > maxLag {
+					db.Close()
+					s.reportEndpoint(EndpointMySQLReplica, item, r, &replicaLagError{lag: lag, max: maxLag})
+					continue
+				}
+			}
+			return db, nil
+		}
+	}
+	if !s.tryAcquire(EndpointMySQL, item, -1) {
+		return nil, errBreakerOpen(item)
+	}
+	return s.Link(item)
+}
+
+// ===============
+//
+//	查询副本的复制延迟
+//	return 1	int	"复制延迟(秒)"
+//	return 2	error	"错误信息, 未在复制时返回错误"
+//
+// ===============
+//
+//	Query the replication lag of the replica
+//	return 1	int	"Replication lag (seconds)"
+//	return 2	error	"Error message, an error is returned when
+//					 	replication is not running"
+func (db *MysqlDB) ReplicaLag() (int, error) {
+	qd, err := db.QueryCMD("SHOW REPLICA STATUS", nil)
+	if err != nil {
+		// MySQL 8.0.22 之前的版本
+		// Versions before MySQL 8.0.22
+		qd, err = db.QueryCMD("SHOW SLAVE STATUS", nil)
+	}
+	if err != nil {
+		return -1, err
+	}
+	if len(qd) == 0 {
+		return -1, fmt.Errorf("replication is not configured on %s", db.Name)
+	}
+	lagStr, ok := qd[0]["Seconds_Behind_Source"]
+	if !ok {
+		lagStr = qd[0]["Seconds_Behind_Master"]
+	}
+	if lagStr == "" {
+		return -1, fmt.Errorf("replication is not running on %s", db.Name)
+	}
+	return strconv.Atoi(lagStr)
+}
@@ -0,0 +1,584 @@
+package weSubDatabase
+
+import (
+	"container/heap"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 每个数据库缓冲的行数, 缓冲满时停止读取该数据库
+//
+// Rows buffered per database, reading from that database pauses when the buffer is full
+const rowsBuffer = 64
+
+// 流式读取的查询结果, 用法与 *sql.Rows 相同:
+//
+//	rows, err := s.QueryRows(...)
+//	if err != nil { ... }
+//	defer rows.Close()
+//	for rows.Next() {
+//		row := rows.Row()
+//	}
+//	if errs := rows.Err(); errs != nil { ... }
+//
+// Query result read as a stream, used the same way as *sql.Rows
+type Rows struct {
+	s          *Setting
+	primaryKey string
+	option     *Option
+	report     *ShardReport
+	cancel     context.CancelFunc
+	span       trace.Span
+	wg         sync.WaitGroup
+	streams    []*shardStream
+	orders     []rowOrder
+	heads      rowHeap
+	started    bool
+	cur        int
+	row        map[string]string
+	skip       int
+	limit      int
+	errs       Errors
+	done       bool
+	closed     bool
+}
+
+// 一个数据库的行
+//
+// Rows of one database
+type shardStream struct {
+	shard int
+	ch    chan map[string]string
+	// 在 ch 关闭前写入
+	// Written before ch is closed
+	err error
+	// 是否已记录应答情况
+	// Whether how it answered has been recorded
+	finished bool
+}
+
+// ===============
+//
+//	根据 *Setting 从数据库集中流式查询, 不会把全部结果读入内存
+//	有排序时各数据库按相同的顺序返回, 合并时按堆排序, 否则依次读取各数据库
+//	合并时两边都是数字时按数字比较, 否则按字节比较, 与数据库的排序规则可能不同
+//	读取结束或不再需要时必须调用 Close, 提前 Close 会停止各数据库的查询
+//	table		string			"表名"
+//	from		string			"查询字段
+//											空字符串为默认值 *"
+//	primaryKey	string			"主键
+//											空字符串时不加密"
+//	where		string			"查询条件"
+//	order		string			"排序, 如 "`id` DESC,`time` ASC""
+//	Debug		*log.Logger		"调试日志对象"
+//	options		[]IsShowPrintO		"配置"
+//		IsShowPrint	bool			"是否输出到控制台"
+//		Context		context.Context		"调用方的上下文"
+//		IsReadPrimary	bool			"是否只从主库读取"
+//		PartialPolicy	PartialPolicy		"部分结果策略"
+//		Quorum		int			"法定数目"
+//		Report		*ShardReport		"接收各数据库的应答情况"
+//	return 1	*Rows			"查询结果"
+//	return 2	error			"错误信息"
+//
+// ===============
+//
+//	According to *Setting, query the database set as a stream, the result
+//	is not read into memory at once
+//	With an order each database returns rows in the same order and they are
+//	merged with a heap, otherwise the databases are read one after another
+//	The merge compares as numbers when both sides are numbers, otherwise as
+//	bytes, which may differ from the collation of the database
+//	Close must be called when done, closing early stops the queries
+//	table		string			"Table name"
+//	from		string			"Query field
+//											Empty string is the default
+//											value *"
+//	primaryKey	string			"Primary key
+//											Empty string is not
+//											encrypted"
+//	where		string			"Query condition"
+//	order		string			"Sorting, such as
+//											"`id` DESC,`time` ASC""
+//	Debug		*log.Logger		"Debug log object"
+//	options		[]IsShowPrintO		"Configuration"
+//		IsShowPrint	bool			"Whether to output to the
+//											console"
+//		Context		context.Context		"Context of the caller"
+//		IsReadPrimary	bool			"Whether to read only from
+//											the primary"
+//		PartialPolicy	PartialPolicy		"Partial result policy"
+//		Quorum		int			"Quorum"
+//		Report		*ShardReport		"Receives how each database
+//											answered"
+//	return 1	*Rows			"Query result"
+//	return 2	error			"Error message"
+func (s *Setting) QueryRows(table string, from string, primaryKey string, where string, order string, Debug *log.Logger, options ...IsShowPrintO) (*Rows, error) {
+	option := &Option{
+		IsShowPrint: false,
+	}
+	for _, o := range options {
+		o(option)
+	}
+	logger := s.logger(Debug, option.IsShowPrint)
+	orders, err := parseOrders(order, nil)
+	if err != nil {
+		return nil, err
+	}
+	ctx, span := s.startCall(option, "QueryRows", table)
+	where = s.aliveWhere(table, where, option)
+	sqlStr := "SELECT "
+	if from != "" {
+		sqlStr += from + " FROM "
+	} else {
+		sqlStr += "* FROM "
+	}
+	sqlStr += "`" + table + "`"
+	if where != "" {
+		sqlStr += " WHERE " + where
+	}
+	if order != "" {
+		sqlStr += " ORDER BY " + order
+	}
+
+	isContinues, report := s.readableShards(nil)
+	if err := option.checkSkipped(report); err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	sqlStrs := make([]string, len(s.SqlConfigs))
+	for i := 0; i < len(s.SqlConfigs); i++ {
+		if isContinues[i] {
+			sqlStrs[i] = sqlStr
+		}
+	}
+	return s.openRows(ctx, span, stmtMeta{op: "query", table: table}, sqlStrs, nil, orders, primaryKey, option, report, logger), nil
+}
+
+// ===============
+//
+//	开始流式读取各数据库
+//	ctx		context.Context	"父 span 的上下文"
+//	span		trace.Span	"父 span, 在 Close 时结束"
+//	meta		stmtMeta	"语句的描述"
+//	sqlStrs		[]string	"每个数据库的SQL指令, 空字符串为不查询"
+//	args		[][]interface{}	"每个数据库绑定的参数, 可以为 nil"
+//	orders		[]rowOrder	"合并时的排序"
+//	primaryKey	string		"主键, 空字符串时不加密"
+//	option		*Option		"配置"
+//	report		*ShardReport	"应答情况"
+//	logger		*slog.Logger	"日志对象"
+//	return		*Rows		"查询结果"
+//
+// ===============
+//
+//	Start reading the databases as streams
+//	ctx		context.Context	"Context of the parent span"
+//	span		trace.Span	"Parent span, ended by Close"
+//	meta		stmtMeta	"Description of the statement"
+//	sqlStrs		[]string	"SQL instruction of each database, not
+//					 	queried when empty"
+//	args		[][]interface{}	"Arguments bound on each database, can be nil"
+//	orders		[]rowOrder	"Sorting of the merge"
+//	primaryKey	string		"Primary key, not encrypted when empty"
+//	option		*Option		"Configuration"
+//	report		*ShardReport	"How the databases answered"
+//	logger		*slog.Logger	"Logger"
+//	return		*Rows		"Query result"
+func (s *Setting) openRows(ctx context.Context, span trace.Span, meta stmtMeta, sqlStrs []string, args [][]interface{}, orders []rowOrder, primaryKey string, option *Option, report *ShardReport, logger *slog.Logger) *Rows {
+	ctx, cancel := context.WithCancel(ctx)
+	r := &Rows{
+		s:          s,
+		primaryKey: primaryKey,
+		option:     option,
+		report:     report,
+		cancel:     cancel,
+		span:       span,
+		orders:     orders,
+		limit:      -1,
+	}
+	for i, sqlStr := range sqlStrs {
+		if sqlStr == "" {
+			continue
+		}
+		sqlStr := sqlStr
+		var shardArgs []interface{}
+		if i < len(args) {
+			shardArgs = args[i]
+		}
+		st := &shardStream{shard: i, ch: make(chan map[string]string, rowsBuffer)}
+		r.streams = append(r.streams, st)
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			s.streamShard(ctx, st, meta, sqlStr, shardArgs, option, logger)
+		}()
+	}
+	return r
+}
+
+// ===============
+//
+//	读取一个数据库的行并写入缓冲, 上下文取消时停止并关闭 *sql.Rows
+//	严格模式下结构落后时不读取
+//	ctx		context.Context	"上下文, 由 Rows.Close 取消"
+//	st		*shardStream	"数据库的行"
+//	meta		stmtMeta	"语句的描述"
+//	sqlStr		string		"SQL指令"
+//	args		[]interface{}	"绑定的参数"
+//	option		*Option		"配置, 使用 IsReadPrimary 和 nullColumn"
+//	logger		*slog.Logger	"日志对象"
+//
+// ===============
+//
+//	Read the rows of one database into the buffer, it stops and closes the
+//	*sql.Rows when the context is cancelled
+//	Nothing is read when the schema is behind in strict mode
+//	ctx		context.Context	"Context, cancelled by Rows.Close"
+//	st		*shardStream	"Rows of the database"
+//	meta		stmtMeta	"Description of the statement"
+//	sqlStr		string		"SQL instruction"
+//	args		[]interface{}	"Bound arguments"
+//	option		*Option		"Configuration, IsReadPrimary and nullColumn
+//					 	are used"
+//	logger		*slog.Logger	"Logger"
+func (s *Setting) streamShard(ctx context.Context, st *shardStream, meta stmtMeta, sqlStr string, args []interface{}, option *Option, logger *slog.Logger) {
+	defer close(st.ch)
+	if err := s.checkSchema(ctx, st.shard, logger); err != nil {
+		st.err = s.shardError(st.shard, "", err)
+		return
+	}
+	ctx, span := s.startStmt(ctx, st.shard, meta, sqlStr)
+	mI, err := s.MysqlIsRun(st.shard, olLogger(logger), OLReadOnly(!option.IsReadPrimary))
+	if err != nil {
+		endSpan(span, err)
+		s.MysqlClose(mI)
+		st.err = s.shardError(st.shard, "", err)
+		return
+	}
+	db := s.MySQLDB[mI]
+	span.SetAttributes(attribute.Int("wesubdb.replica", db.Replica))
+	stmt := db.statement(ctx, meta, sqlStr, args, true)
+	stmt.stream = true
+	err = db.run(stmt, s.observeHook(logger))
+	if err == nil {
+		dbI := strconv.Itoa(st.shard)
+		err = scanRows(stmt.sqlRows, option.nullColumn, func(row map[string]string) bool {
+			row["db"] = dbI
+			select {
+			case st.ch <- row:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}
+	if ctx.Err() != nil && (err == nil || errors.Is(err, context.Canceled)) {
+		// 提前关闭, 不是数据库的错误
+		// Closed early, not an error of the database
+		err = nil
+	}
+	endSpan(span, err)
+	s.reportQuery(db, err)
+	s.MysqlClose(mI, IsShowPrintO(olLogger(logger)))
+	st.err = s.shardError(st.shard, sqlStr, err)
+}
+
+// ===============
+//
+//	逐行读取 *sql.Rows, 结束时关闭
+//	NULL 读取为空字符串, nullColumn 不为空时把 NULL 的字段名用 , 连接写入这个字段
+//	query		*sql.Rows			"查询结果"
+//	nullColumn	string				"记录 NULL 字段名的字段, 可以为空字符串"
+//	fn		func(map[string]string) bool	"处理一行, 返回 false 时停止"
+//	return		error				"错误信息"
+//
+// ===============
+//
+//	Read *sql.Rows row by row, it is closed at the end
+//	NULL is read as an empty string, when nullColumn is not empty the names
+//	of the NULL columns are joined with , into that field
+//	query		*sql.Rows			"Query result"
+//	nullColumn	string				"Field recording the NULL column
+//							 	names, can be empty"
+//	fn		func(map[string]string) bool	"Handles a row, stops when it returns false"
+//	return		error				"Error message"
+func scanRows(query *sql.Rows, nullColumn string, fn func(row map[string]string) bool) error {
+	defer query.Close()
+	cols, err := query.Columns()
+	if err != nil {
+		return err
+	}
+	values := make([][]byte, len(cols))
+	scans := make([]interface{}, len(cols))
+	for i := range values {
+		scans[i] = &values[i]
+	}
+	for query.Next() {
+		if err := query.Scan(scans...); err != nil {
+			return err
+		}
+		row := make(map[string]string, len(cols)+2)
+		nulls := []string{}
+		for k, v := range values {
+			row[cols[k]] = string(v)
+			if v == nil && nullColumn != "" {
+				nulls = append(nulls, cols[k])
+			}
+		}
+		if nullColumn != "" {
+			row[nullColumn] = strings.Join(nulls, ",")
+		}
+		if !fn(row) {
+			return nil
+		}
+	}
+	return query.Err()
+}
+
+// ===============
+//
+//	读取下一行
+//	return		bool	"有下一行时为 true, 结束或出错时为 false"
+//
+// ===============
+//
+//	Advance to the next row
+//	return		bool	"true when there is a next row, false at the end or on error"
+func (r *Rows) Next() bool {
+	if r.closed || r.done {
+		return false
+	}
+	if r.limit == 0 {
+		// 已读取到分页的行数, 未读完的数据库也视为应答
+		// The page is full, unfinished databases are counted as answered
+		for _, st := range r.streams {
+			if !st.finished {
+				st.finished = true
+				r.report.Answered = append(r.report.Answered, st.shard)
+			}
+		}
+		r.finish()
+		return false
+	}
+	var row map[string]string
+	for {
+		if len(r.orders) == 0 {
+			row = r.nextInOrder()
+		} else {
+			row = r.nextMerged()
+		}
+		if row == nil {
+			r.finish()
+			return false
+		}
+		if r.skip == 0 {
+			break
+		}
+		r.skip--
+	}
+	if r.limit > 0 {
+		r.limit--
+	}
+	if r.primaryKey != "" {
+		row = r.s.EncryptPrimaryKey([]map[string]string{row}, r.primaryKey)[0]
+	}
+	r.row = row
+	return true
+}
+
+// 依次读取各数据库
+//
+// Read the databases one after another
+func (r *Rows) nextInOrder() map[string]string {
+	for r.cur < len(r.streams) {
+		st := r.streams[r.cur]
+		if row, ok := <-st.ch; ok {
+			return row
+		}
+		if !r.shardDone(st) {
+			return nil
+		}
+		r.cur++
+	}
+	return nil
+}
+
+// 按堆合并各数据库
+//
+// Merge the databases with a heap
+func (r *Rows) nextMerged() map[string]string {
+	if !r.started {
+		r.started = true
+		for i := range r.streams {
+			if !r.pull(i) {
+				return nil
+			}
+		}
+		heap.Init(&r.heads)
+	}
+	if r.heads.Len() == 0 {
+		return nil
+	}
+	head := r.heads.items[0]
+	row, ok := <-r.streams[head.stream].ch
+	if ok {
+		r.heads.items[0].row = row
+		heap.Fix(&r.heads, 0)
+	} else {
+		heap.Pop(&r.heads)
+		if !r.shardDone(r.streams[head.stream]) {
+			return nil
+		}
+	}
+	return head.row
+}
+
+// 读取一个数据库的第一行放入堆
+//
+// Put the first row of a database into the heap
+func (r *Rows) pull(i int) bool {
+	row, ok := <-r.streams[i].ch
+	if ok {
+		r.heads.orders = r.orders
+		r.heads.items = append(r.heads.items, rowHead{row: row, stream: i})
+		return true
+	}
+	return r.shardDone(r.streams[i])
+}
+
+// 一个数据库读取结束, 记录应答情况, PartialFail 时出错返回 false
+//
+// A database is finished, how it answered is recorded, false is returned
+// on error with PartialFail
+func (r *Rows) shardDone(st *shardStream) bool {
+	st.finished = true
+	if st.err == nil {
+		r.report.Answered = append(r.report.Answered, st.shard)
+		return true
+	}
+	r.report.Failed = append(r.report.Failed, st.shard)
+	r.errs = append(r.errs, st.err)
+	if r.option.PartialPolicy == PartialFail {
+		r.finish()
+		return false
+	}
+	return true
+}
+
+// 全部读取结束
+//
+// Everything has been read
+func (r *Rows) finish() {
+	if r.done {
+		return
+	}
+	r.done = true
+	if err := r.option.finishReport(r.report); err != nil {
+		r.errs = append(r.errs, err)
+	}
+}
+
+// ===============
+//
+//	当前行, 在 Next 返回 true 后调用
+//	return		map[string]string	"当前行, db 字段为数据库在配置中的位置"
+//
+// ===============
+//
+//	Current row, called after Next returns true
+//	return		map[string]string	"Current row, the db field is the
+//						location of the database in the configuration"
+func (r *Rows) Row() map[string]string {
+	return r.row
+}
+
+// ===============
+//
+//	读取中的错误
+//	return		Errors	"错误信息, 没有错误时为 nil"
+//
+// ===============
+//
+//	Errors while reading
+//	return		Errors	"Error message, nil when there is no error"
+func (r *Rows) Err() Errors {
+	if len(r.errs) == 0 {
+		return nil
+	}
+	return r.errs
+}
+
+// ===============
+//
+//	停止读取, 关闭各数据库的 *sql.Rows 并归还连接, 可以多次调用
+//	return		error	"读取中的错误"
+//
+// ===============
+//
+//	Stop reading, the *sql.Rows of each database is closed and the
+//	connections are returned, can be called more than once
+//	return		error	"Errors while reading"
+func (r *Rows) Close() error {
+	if r.closed {
+		return r.Err().Err()
+	}
+	r.closed = true
+	r.cancel()
+	for _, st := range r.streams {
+		for range st.ch {
+		}
+	}
+	r.wg.Wait()
+	r.row = nil
+	endSpan(r.span, r.Err().Err())
+	return r.Err().Err()
+}
+
+// 堆中一个数据库的当前行
+//
+// Current row of one database in the heap
+type rowHead struct {
+	row    map[string]string
+	stream int
+}
+
+type rowHeap struct {
+	items  []rowHead
+	orders []rowOrder
+}
+
+func (h *rowHeap) Len() int { return len(h.items) }
+
+func (h *rowHeap) Less(i, j int) bool {
+	for _, o := range h.orders {
+		c := compareValues(h.items[i].row[o.column], h.items[j].row[o.column])
+		if c == 0 {
+			continue
+		}
+		if o.desc {
+			return c > 0
+		}
+		return c < 0
+	}
+	return h.items[i].stream < h.items[j].stream
+}
+
+func (h *rowHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *rowHeap) Push(x interface{}) { h.items = append(h.items, x.(rowHead)) }
+
+func (h *rowHeap) Pop() interface{} {
+	item := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return item
+}
@@ -0,0 +1,132 @@
+package weSubDatabase
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 用已写入的缓冲代替数据库
+// Buffers filled in advance stand in for the databases
+func testRows(orders []rowOrder, policy PartialPolicy, shards ...[]string) *Rows {
+	r := &Rows{
+		option: &Option{PartialPolicy: policy},
+		report: &ShardReport{},
+		cancel: func() {},
+		span:   trace.SpanFromContext(context.Background()),
+		orders: orders,
+		limit:  -1,
+	}
+	for i, ids := range shards {
+		st := &shardStream{shard: i, ch: make(chan map[string]string, len(ids))}
+		for _, id := range ids {
+			if id == "error" {
+				st.err = &ShardError{Shard: i, Err: ErrShardUnavailable}
+				break
+			}
+			st.ch <- map[string]string{"id": id, "db": strconv.Itoa(i)}
+		}
+		close(st.ch)
+		r.streams = append(r.streams, st)
+	}
+	return r
+}
+
+func readIDs(r *Rows) string {
+	ids := ""
+	for r.Next() {
+		ids += r.Row()["id"] + ","
+	}
+	return ids
+}
+
+func TestRowsMerge(t *testing.T) {
+	r := testRows([]rowOrder{{column: "id", desc: true}}, PartialAllow, []string{"9", "5", "1"}, []string{"10", "2"}, nil, []string{"7", "6"})
+	if ids := readIDs(r); ids != "10,9,7,6,5,2,1," {
+		t.Error("ordered merge:", ids)
+	}
+	if err := r.Close(); err != nil || len(r.report.Answered) != 4 {
+		t.Error("close:", err, r.report)
+	}
+
+	r = testRows(nil, PartialAllow, []string{"3", "1"}, []string{"error"}, []string{"2"})
+	if ids := readIDs(r); ids != "3,1,2," {
+		t.Error("unordered read:", ids)
+	}
+	if errs := r.Err(); len(errs) != 1 || !errors.Is(errs, ErrShardUnavailable) {
+		t.Error("errors:", errs)
+	}
+
+	var report ShardReport
+	r = testRows([]rowOrder{{column: "id"}}, PartialFail, []string{"1", "4"}, []string{"error"})
+	r.option.Report = &report
+	if ids := readIDs(r); ids != "" || !errors.Is(r.Err(), ErrIncompleteResult) {
+		t.Error("PartialFail:", ids, r.Err())
+	}
+	if len(report.Failed) != 1 || report.Failed[0] != 1 {
+		t.Error("PartialFail report:", report)
+	}
+
+	r = testRows([]rowOrder{{column: "id"}}, PartialFail, []string{"1", "3", "5"}, []string{"2", "4"})
+	r.skip, r.limit = 1, 2
+	if ids := readIDs(r); ids != "2,3," || r.Err() != nil || len(r.report.Answered) != 2 {
+		t.Error("skip and limit:", ids, r.Err(), r.report)
+	}
+
+	r = testRows([]rowOrder{{column: "id"}}, PartialAllow, []string{"1", "3"}, []string{"2"})
+	if !r.Next() || r.Row()["id"] != "1" {
+		t.Error("first row:", r.Row())
+	}
+	r.Close()
+	if r.Next() || r.Row() != nil {
+		t.Error("Next after Close")
+	}
+}
+
+func TestRowsConcurrentPool(t *testing.T) {
+	s := &Setting{MaxLink: 3, MySQLDB: make([]*MysqlDB, 3)}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for k := 0; k < 50; k++ {
+				if err := s.reserveLink(i, &Option{WaitCount: 1000, WaitTime: 1}); err != nil {
+					t.Error("reserve:", err)
+					return
+				}
+				db := &MysqlDB{DBItem: i}
+				mI, conns := s.putLink(db)
+				if conns > s.MaxLink || s.MySQLDB[mI] != db {
+					t.Error("place taken by another connection:", mI, conns)
+				}
+				s.MysqlClose(mI)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if s.LinkNum != 0 {
+		t.Error("connections left:", s.LinkNum)
+	}
+
+	// 所有数据库同时流式读取, 用 -race 运行
+	// All databases are read as streams at once, run with -race
+	sqlSetting, err := New(testJsonStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := sqlSetting.QueryRows("data", "", "", "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+	if sqlSetting.LinkNum != 0 {
+		t.Error("connections left after streaming:", sqlSetting.LinkNum)
+	}
+}
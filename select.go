@@ -1,13 +1,14 @@
 package weSubDatabase
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
+	"log/slog"
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -22,8 +23,14 @@ import (
 //	Debug		*log.Logger		"调试输出"
 //	options		[]IsShowPrintO		"配置"
 //		IsShowPrint	bool			"是否输出到控制台"
+//		Context		context.Context		"调用方的上下文"
+//		IsReadPrimary	bool			"是否只从主库读取"
+//		PartialPolicy	PartialPolicy		"部分结果策略"
+//		Quorum		int			"法定数目"
+//		Report		*ShardReport		"接收各数据库的应答情况"
+//		WithDeleted	bool			"是否包含软删除的行"
 //	return 1	[]map[string]string	"查询到的数据"
-//	return 2	[]error			"错误信息"
+//	return 2	Errors			"错误信息"
 //
 // ===============
 //
@@ -37,32 +44,47 @@ import (
 //	options		[]IsShowPrintO		"Configuration"
 //		IsShowPrint	bool			"Whether to output to the
 //											console"
+//		Context		context.Context		"Context of the caller"
+//		IsReadPrimary	bool			"Whether to read only from
+//											the primary"
+//		PartialPolicy	PartialPolicy		"Partial result policy"
+//		Quorum		int			"Quorum"
+//		Report		*ShardReport		"Receives how each database
+//											answered"
+//		WithDeleted	bool			"Whether to include soft-deleted
+//											rows"
 //	return 1	[]map[string]string	"query data"
-//	return 2	[]error			"error message"
-func (s *Setting) QueryID(table string, from string, primaryKey string, ids []string, order string, Debug *log.Logger, options ...IsShowPrintO) ([]map[string]string, []error) {
+//	return 2	Errors			"error message"
+func (s *Setting) QueryID(table string, from string, primaryKey string, ids []string, order string, Debug *log.Logger, options ...IsShowPrintO) (queryDatas []map[string]string, errs Errors) {
 	option := &Option{
 		IsShowPrint: false,
 	}
 	for _, o := range options {
 		o(option)
 	}
+	logger := s.logger(Debug, option.IsShowPrint)
+	ctx, span := s.startCall(option, "QueryID", table)
+	defer func() { endSpan(span, errs.Err()) }()
 	dbIList, idList, _ := s.DecryptID(primaryKey, ids)
-	var (
-		queryDatas []map[string]string
-		errs       []error
-	)
-	for i := 0; i < len(s.SqlConfigs); i++ {
-		errs = append(errs, nil)
+	orderKey := "id"
+	orderSort := "ASC"
+	if order != "" {
+		os := strings.Split(order, " ")
+		if len(os) != 2 {
+			return nil, Errors{fmt.Errorf("%w: %q, expected \"column ASC|DESC\"", ErrInvalidOrder, order)}
+		}
+		orderKey = strings.ReplaceAll(os[0], "`", "")
+		orderSort = os[1]
+	}
+	isContinues, report := s.readableShards(dbIList)
+	if err := option.checkSkipped(report); err != nil {
+		return nil, Errors{err}
 	}
-	var wg sync.WaitGroup
+	sqlStrs := make([]string, len(s.SqlConfigs))
 	for i := 0; i < len(s.SqlConfigs); i++ {
-		if !dbIList[i] {
-			continue
-		}
-		if !s.IsRetryConnect(i) {
+		if !isContinues[i] {
 			continue
 		}
-		wg.Add(1)
 		sqlStr := "SELECT "
 		if from == "" {
 			sqlStr += "*"
@@ -72,54 +94,23 @@ func (s *Setting) QueryID(table string, from string, primaryKey string, ids []st
 		sqlStr += " FROM `" + table + "` WHERE `" + primaryKey + "` IN ('"
 		whereIN := strings.Join(idList[i], "','")
 		if errStr := CheckString(whereIN); len(errStr) > 0 {
-			errs[i] = fmt.Errorf("SQL injection: %s", whereIN)
-			wg.Done()
+			errs = append(errs, s.shardError(i, "", fmt.Errorf("%w: %s", ErrInjectionDetected, whereIN)))
+			report.Failed = append(report.Failed, i)
 			continue
 		}
 		sqlStr += whereIN + "')"
+		if alive := s.aliveWhere(table, "", option); alive != "" {
+			sqlStr += " AND " + alive
+		}
 		if order != "" {
 			sqlStr += " ORDER BY " + order
 		}
-		chanQD := make(chan []map[string]string)
-		chanErr := make(chan error)
-		go s.go_query(i, sqlStr, chanQD, chanErr, option.IsShowPrint, Debug)
-		rI := false
-		rE := false
-		for {
-			select {
-			case reqd := <-chanQD:
-				if !rI {
-					for _, v := range reqd {
-						v["db"] = strconv.Itoa(i)
-						queryDatas = append(queryDatas, v)
-					}
-					close(chanQD)
-					rI = true
-				}
-			case reErr := <-chanErr:
-				if !rE {
-					errs = append(errs, reErr)
-					close(chanErr)
-					rE = true
-				}
-			}
-			if rI && rE {
-				break
-			}
-			time.Sleep(time.Millisecond * 50)
-		}
-		wg.Done()
+		sqlStrs[i] = sqlStr
 	}
-	wg.Wait()
-	orderKey := "id"
-	orderSort := "ASC"
-	if order != "" {
-		os := strings.Split(order, " ")
-		if len(os) != 2 {
-			return nil, []error{fmt.Errorf("order error")}
-		}
-		orderKey = strings.ReplaceAll(os[0], "`", "")
-		orderSort = os[1]
+	queryDatas, qErrs := s.queryShards(ctx, stmtMeta{op: "query", table: table}, sqlStrs, nil, report, option, logger)
+	errs = append(errs, qErrs...)
+	if err := option.finishReport(report); err != nil {
+		return nil, append(errs, err)
 	}
 	sort.Slice(queryDatas, func(i, j int) bool {
 		switch orderKey {
@@ -157,10 +148,8 @@ func (s *Setting) QueryID(table string, from string, primaryKey string, ids []st
 		}
 	})
 	queryDatas = s.EncryptPrimaryKey(queryDatas, primaryKey)
-	for _, v := range errs {
-		if v != nil {
-			return queryDatas, errs
-		}
+	if len(errs) > 0 {
+		return queryDatas, errs
 	}
 	return queryDatas, nil
 }
@@ -180,8 +169,15 @@ func (s *Setting) QueryID(table string, from string, primaryKey string, ids []st
 //	Debug		*log.Logger		"调试日志对象"
 //	options		[]IsShowPrintO		"配置"
 //		IsShowPrint	bool			"是否输出到控制台"
+//		Context		context.Context		"调用方的上下文"
+//		IsReadPrimary	bool			"是否只从主库读取"
+//		PartialPolicy	PartialPolicy		"部分结果策略"
+//		Quorum		int			"法定数目"
+//		Report		*ShardReport		"接收各数据库的应答情况"
+//		Distinct	bool			"是否去掉各数据库之间重复的行"
+//		WithDeleted	bool			"是否包含软删除的行"
 //	return 1	[]map[string]string	"查询结果"
-//	return 2	[]error			"错误信息"
+//	return 2	Errors			"错误信息"
 //
 // ===============
 //
@@ -202,15 +198,33 @@ func (s *Setting) QueryID(table string, from string, primaryKey string, ids []st
 //	options		[]IsShowPrintO		"Configuration"
 //		IsShowPrint	bool			"Whether to output to the
 //											console"
+//		Context		context.Context		"Context of the caller"
+//		IsReadPrimary	bool			"Whether to read only from
+//											the primary"
+//		PartialPolicy	PartialPolicy		"Partial result policy"
+//		Quorum		int			"Quorum"
+//		Report		*ShardReport		"Receives how each database
+//											answered"
+//		Distinct	bool			"Whether to remove rows repeated
+//											across the databases"
+//		WithDeleted	bool			"Whether to include soft-deleted
+//											rows"
 //	return 1	[]map[string]string	"Query result"
-//	return 2	[]error			"Error message"
-func (s *Setting) Query(table string, from string, primaryKey string, where string, order string, limit string, Debug *log.Logger, options ...IsShowPrintO) ([]map[string]string, []error) {
+//	return 2	Errors			"Error message"
+func (s *Setting) Query(table string, from string, primaryKey string, where string, order string, limit string, Debug *log.Logger, options ...IsShowPrintO) (queryDatas []map[string]string, errs Errors) {
 	option := &Option{
 		IsShowPrint: false,
 	}
 	for _, o := range options {
 		o(option)
 	}
+	logger := s.logger(Debug, option.IsShowPrint)
+	ctx, span := s.startCall(option, "Query", table)
+	defer func() { endSpan(span, errs.Err()) }()
+	if option.Distinct {
+		from = distinctFrom(from)
+	}
+	where = s.aliveWhere(table, where, option)
 	sqlStr := "SELECT "
 	if from != "" {
 		sqlStr += from + " FROM "
@@ -227,15 +241,15 @@ func (s *Setting) Query(table string, from string, primaryKey string, where stri
 		sqlStr += " ORDER BY " + order
 		os := strings.Split(order, " ")
 		if len(os) != 2 {
-			return nil, []error{fmt.Errorf("order error")}
+			return nil, Errors{fmt.Errorf("%w: %q, expected \"column ASC|DESC\"", ErrInvalidOrder, order)}
 		}
 		orderKey = strings.ReplaceAll(os[0], "`", "")
 		orderSort = os[1]
 	}
 
-	var isContinues []bool
-	for i := 0; i < len(s.ConnectFailTime); i++ {
-		isContinues = append(isContinues, s.IsRetryConnect(i))
+	isContinues, report := s.readableShards(nil)
+	if err := option.checkSkipped(report); err != nil {
+		return nil, Errors{err}
 	}
 
 	if limit == "" {
@@ -257,50 +271,24 @@ func (s *Setting) Query(table string, from string, primaryKey string, where stri
 		}
 	}
 
-	var (
-		queryDatas []map[string]string
-		errs       []error
-	)
-	var wg *sync.WaitGroup = new(sync.WaitGroup)
+	sqlStrs := make([]string, len(s.SqlConfigs))
 	for i := 0; i < len(s.SqlConfigs); i++ {
-		if !s.IsRetryConnect(i) {
-			continue
-		}
 		if !isContinues[i] {
 			continue
 		}
-		wg.Add(1)
-		chanQD := make(chan []map[string]string)
-		chanErr := make(chan error)
-		go s.go_query(i, sqlStr+" LIMIT "+limitList[i], chanQD, chanErr, option.IsShowPrint, Debug)
-		rI := false
-		rE := false
-		for {
-			select {
-			case reqd := <-chanQD:
-				if !rI {
-					for _, v := range reqd {
-						v["db"] = strconv.Itoa(i)
-						queryDatas = append(queryDatas, v)
-					}
-					close(chanQD)
-					rI = true
-				}
-			case reErr := <-chanErr:
-				if !rE {
-					errs = append(errs, reErr)
-					close(chanErr)
-					rE = true
-				}
-			}
-			if rI && rE {
-				break
-			}
-			time.Sleep(time.Millisecond * 50)
+		if i < len(limitList) && limitList[i] != "" {
+			sqlStrs[i] = sqlStr + " LIMIT " + limitList[i]
+		} else {
+			sqlStrs[i] = sqlStr
 		}
-		wg.Done()
 	}
-	wg.Wait()
+	queryDatas, errs = s.queryShards(ctx, stmtMeta{op: "query", table: table}, sqlStrs, nil, report, option, logger)
+	if err := option.finishReport(report); err != nil {
+		return nil, append(errs, err)
+	}
+	if option.Distinct {
+		queryDatas = distinctRows(queryDatas, primaryKey)
+	}
 	sort.Slice(queryDatas, func(i, j int) bool {
 		switch orderKey {
 		case "id":
@@ -337,10 +325,8 @@ func (s *Setting) Query(table string, from string, primaryKey string, where stri
 		}
 	})
 	queryDatas = s.EncryptPrimaryKey(queryDatas, primaryKey)
-	for _, v := range errs {
-		if v != nil {
-			return queryDatas, errs
-		}
+	if len(errs) > 0 {
+		return queryDatas, errs
 	}
 	return queryDatas, nil
 }
@@ -353,6 +339,11 @@ func (s *Setting) Query(table string, from string, primaryKey string, where stri
 //	Debug		*log.Logger	"Debug 日志对象"
 //	options		[]IsShowPrintO	"配置"
 //		IsShowPrint	bool		"是否输出到控制台"
+//		Context		context.Context		"调用方的上下文"
+//		IsReadPrimary	bool		"是否只从主库读取"
+//		PartialPolicy	PartialPolicy	"部分结果策略"
+//		Quorum		int		"法定数目"
+//		Report		*ShardReport	"接收各数据库的应答情况"
 //	return 1	int		"下一条数据所在的数据库索引"
 //	return 2	int		"下一条数据的 ID"
 //	return 3	error		"错误信息"
@@ -367,64 +358,42 @@ func (s *Setting) Query(table string, from string, primaryKey string, where stri
 //	options		[]IsShowPrintO	"Configuration"
 //		IsShowPrint	bool		"Whether to output to the
 //									console"
+//		Context		context.Context		"Context of the caller"
+//		IsReadPrimary	bool		"Whether to read only from
+//									the primary"
+//		PartialPolicy	PartialPolicy	"Partial result policy"
+//		Quorum		int		"Quorum"
+//		Report		*ShardReport	"Receives how each database
+//									answered"
 //	return 1	int		"The database index where the next
 //									data is located"
 //	return 2	int		"ID of the next data"
 //	return 3	error		"Error message"
-func (s *Setting) SelectLastID(table string, primaryKey string, Debug *log.Logger, options ...IsShowPrintO) (int, int, error) {
+func (s *Setting) SelectLastID(table string, primaryKey string, Debug *log.Logger, options ...IsShowPrintO) (dbI int, maxID int, err error) {
 	option := &Option{
 		IsShowPrint: false,
 	}
 	for _, o := range options {
 		o(option)
 	}
+	logger := s.logger(Debug, option.IsShowPrint)
+	ctx, span := s.startCall(option, "SelectLastID", table)
+	defer func() { endSpan(span, err) }()
 	sqlStr := "SELECT MAX(`" + primaryKey + "`) FROM `" + table + "`"
-	var (
-		queryDatas []map[string]string
-		errs       []error
-	)
-	for i := 0; i < len(s.SqlConfigs); i++ {
-		errs = append(errs, nil)
+	isContinues, report := s.readableShards(nil)
+	if err := option.checkSkipped(report); err != nil {
+		return -1, 1, err
 	}
-	var wg *sync.WaitGroup = new(sync.WaitGroup)
+	sqlStrs := make([]string, len(s.SqlConfigs))
 	for i := 0; i < len(s.SqlConfigs); i++ {
-		if !s.IsRetryConnect(i) {
-			continue
-		}
-		wg.Add(1)
-		chanQD := make(chan []map[string]string)
-		chanErr := make(chan error)
-		go s.go_query(i, sqlStr, chanQD, chanErr, option.IsShowPrint, Debug)
-		rI := false
-		rE := false
-		for {
-			select {
-			case reqd := <-chanQD:
-				if !rI {
-					for _, v := range reqd {
-						v["db"] = strconv.Itoa(i)
-						queryDatas = append(queryDatas, v)
-					}
-					close(chanQD)
-					rI = true
-				}
-			case reErr := <-chanErr:
-				if !rE {
-					errs = append(errs, reErr)
-					close(chanErr)
-					rE = true
-				}
-			}
-			if rI && rE {
-				break
-			}
-			time.Sleep(time.Millisecond * 50)
+		if isContinues[i] {
+			sqlStrs[i] = sqlStr
 		}
-		wg.Done()
 	}
-	wg.Wait()
-	var maxID int
-	var dbI int
+	queryDatas, errs := s.queryShards(ctx, stmtMeta{op: "query", table: table}, sqlStrs, nil, report, option, logger)
+	if err := option.finishReport(report); err != nil {
+		return -1, 1, err
+	}
 	for _, v := range queryDatas {
 		if v["MAX(`"+primaryKey+"`)"] == "" {
 			continue
@@ -447,18 +416,107 @@ func (s *Setting) SelectLastID(table string, primaryKey string, Debug *log.Logge
 		dbI = 0
 	}
 	maxID += 1
-	// 防止连接不上的数据库中存在最大值
-	for i := 0; i < len(s.ConnectFailTime); i++ {
-		if s.ConnectFailTime[i] != nil {
-			maxID += 1
+	// 防止缺失的数据库中存在最大值
+	maxID += len(report.Skipped) + len(report.Failed)
+	return dbI, maxID, errs.Err()
+}
+
+// ===============
+//
+//	判断目标数据库是否可以读取
+//	targets		[]bool		"需要查询的数据库, 为 nil 时查询全部数据库"
+//	return 1	[]bool		"是否可以读取"
+//	return 2	*ShardReport	"应答情况, 记录了跳过的数据库"
+//
+// ===============
+//
+//	Determine whether the target databases can be read
+//	targets		[]bool		"Databases to be queried, all databases
+//									are queried when nil"
+//	return 1	[]bool		"Whether it can be read"
+//	return 2	*ShardReport	"How the databases answered, the
+//									skipped databases are recorded"
+func (s *Setting) readableShards(targets []bool) ([]bool, *ShardReport) {
+	isContinues := make([]bool, len(s.SqlConfigs))
+	report := &ShardReport{}
+	for i := 0; i < len(s.SqlConfigs); i++ {
+		if targets != nil && !targets[i] {
+			continue
+		}
+		if s.IsRetryRead(i) {
+			isContinues[i] = true
+		} else {
+			report.Skipped = append(report.Skipped, i)
 		}
 	}
-	for _, v := range errs {
-		if v != nil {
-			return dbI, maxID, v
+	return isContinues, report
+}
+
+// ===============
+//
+//	向多个数据库分发查询, 查询结果的 db 字段为数据库在配置中的位置
+//	ctx		context.Context		"父 span 的上下文"
+//	meta		stmtMeta		"语句的描述"
+//	sqlStrs		[]string		"每个数据库的SQL指令, 空字符串为不查询"
+//	args		[][]interface{}		"每个数据库绑定的参数, 可以为 nil"
+//	report		*ShardReport		"记录应答和失败的数据库"
+//	option		*Option			"配置"
+//	logger		*slog.Logger		"日志对象"
+//	return 1	[]map[string]string	"查询结果"
+//	return 2	Errors			"错误信息"
+//
+// ===============
+//
+//	Fan a query out to several databases, the db field of the query result
+//	is the location of the database in the configuration
+//	ctx		context.Context		"Context of the parent span"
+//	meta		stmtMeta		"Description of the statement"
+//	sqlStrs		[]string		"SQL instruction of each
+//											database, not queried when
+//											empty"
+//	args		[][]interface{}		"Arguments bound on each
+//											database, can be nil"
+//	report		*ShardReport		"Records the databases that
+//											answered or failed"
+//	option		*Option			"Configuration"
+//	logger		*slog.Logger		"Logger"
+//	return 1	[]map[string]string	"Query result"
+//	return 2	Errors			"Error message"
+func (s *Setting) queryShards(ctx context.Context, meta stmtMeta, sqlStrs []string, args [][]interface{}, report *ShardReport, option *Option, logger *slog.Logger) ([]map[string]string, Errors) {
+	var (
+		queryDatas []map[string]string
+		errs       Errors
+	)
+	for i, sqlStr := range sqlStrs {
+		if sqlStr == "" {
+			continue
+		}
+		if err := s.checkSchema(ctx, i, logger); err != nil {
+			report.Failed = append(report.Failed, i)
+			errs = append(errs, s.shardError(i, "", err))
+			continue
+		}
+		chanQD := make(chan []map[string]string)
+		chanErr := make(chan error)
+		var shardArgs []interface{}
+		if i < len(args) {
+			shardArgs = args[i]
+		}
+		go s.go_query(ctx, i, meta, sqlStr, shardArgs, chanQD, chanErr, option.IsReadPrimary, logger)
+		reqd := <-chanQD
+		reErr := <-chanErr
+		if reErr != nil {
+			report.Failed = append(report.Failed, i)
+			errs = append(errs, reErr)
+			continue
+		}
+		report.Answered = append(report.Answered, i)
+		for _, v := range reqd {
+			v["db"] = strconv.Itoa(i)
+			queryDatas = append(queryDatas, v)
 		}
 	}
-	return dbI, maxID, nil
+	return queryDatas, errs
 }
 
 // ===============
@@ -477,38 +535,16 @@ func (s *Setting) SelectLastID(table string, primaryKey string, Debug *log.Logge
 //	return 1	[]map[string]string	"query result"
 //	return 2	error			"error message"
 func handleQD(query *sql.Rows, Debug *log.Logger) ([]map[string]string, error) {
-	//读出查询出的列字段名
-	cols, _ := query.Columns()
-	//values是每个列的值，这里获取到byte里
-	values := make([][]byte, len(cols))
-	//query.Scan的参数，因为每次查询出来的列是不定长的，用len(cols)定住当次查询的长度
-	scans := make([]interface{}, len(cols))
-	//让每一行数据都填充到[][]byte里面
-	for i := range values {
-		scans[i] = &values[i]
-	}
-
-	//最后得到的map
 	results := []map[string]string{}
-	i := 0
-	for query.Next() { //循环，让游标往下推
-		if err := query.Scan(scans...); err != nil { //query.Scan查询出来的不定长值放到scans[i] = &values[i],也就是每行都放在values里
-			if Debug != nil {
-				Debug.Println(err)
-			}
-			return []map[string]string{}, err
-		}
-		row := map[string]string{} //每行数据
-		for k, v := range values { //每行数据是放在values里面，现在把它挪到row里
-			key := cols[k]
-			row[key] = string(v)
-		}
-		i++
+	err := scanRows(query, "", func(row map[string]string) bool {
 		results = append(results, row)
+		return true
+	})
+	if err != nil {
+		if Debug != nil {
+			Debug.Println(err)
+		}
+		return []map[string]string{}, err
 	}
-
-	//关闭结果集（释放连接）
-	query.Close()
-
 	return results, nil
 }
@@ -1,13 +1,17 @@
 package weSubDatabase
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/0wew0-gh/simpleEncryption"
 	"github.com/go-redis/redis/v8"
 	_ "github.com/go-sql-driver/mysql"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Setting struct {
@@ -51,6 +55,109 @@ type Setting struct {
 	//
 	//	The last time the connection failed, used to determine whether to reconnect
 	ConnectFailTime []*time.Time
+	//	副本上次连接失败或延迟过大的时间, 用于判断是否需要重新连接
+	//
+	//	The last time the replica failed to connect or lagged too much,
+	//	used to determine whether to reconnect
+	ReplicaFailTime [][]*time.Time
+	//	重新连接的最大时间间隔(毫秒), 连续失败时退避时间从 ConnectAgainTime 开始翻倍, 直到此值
+	//
+	//	Maximum time interval for reconnecting (milliseconds), on consecutive
+	//	failures the backoff doubles from ConnectAgainTime up to this value
+	MaxConnectAgainTime int
+	//	连续失败多少次后熔断
+	//
+	//	Number of consecutive failures before the circuit breaker opens
+	FailureThreshold int
+	//	写入后在此时间内(毫秒)查询该数据库时只使用主库, 0 为不启用
+	//
+	//	Within this time (milliseconds) after a write, queries to that
+	//	database only use the primary, 0 is disabled
+	ReadYourWritesTime int
+	//	日志, 为 nil 时不输出, 可以使用 SetLogHandler 设置
+	//
+	//	Logger, nothing is logged when it is nil, can be set with SetLogHandler
+	Logger *slog.Logger
+	//	监控, 为 nil 时不记录, 可以使用 EnableMetrics 设置
+	//
+	//	Instrumentation, nothing is recorded when it is nil, can be set with EnableMetrics
+	Instrumentation Instrumentation
+	//	链路追踪, 为 nil 时使用全局的 otel.GetTracerProvider()
+	//
+	//	Tracing, the global otel.GetTracerProvider() is used when it is nil
+	TracerProvider trace.TracerProvider
+	//	慢查询的时间(毫秒), Query, QueryID, Update, Delete 在单个数据库上的
+	//	语句超过此时间时记录, 0 为不启用
+	//
+	//	Slow query time (milliseconds), statements of Query, QueryID, Update
+	//	and Delete on a single database exceeding it are recorded, 0 is disabled
+	SlowQueryTime int
+	//	慢查询时执行的 EXPLAIN 类型
+	//
+	//	Kind of EXPLAIN run for slow queries
+	SlowQueryExplain ExplainMode
+	//	慢查询的回调, 为 nil 时只输出到日志
+	//
+	//	Callback of slow queries, only logged when it is nil
+	OnSlowQuery func(slow SlowQuery)
+	//	Add 和 AddForPrimary 每条 INSERT 的最大行数, 0 为不限制
+	//
+	//	Maximum rows per INSERT of Add and AddForPrimary, 0 is unlimited
+	InsertChunkRows int
+	//	Add 和 AddForPrimary 每条 INSERT 的值的最大字节数, 应小于 max_allowed_packet, 0 为不限制
+	//
+	//	Maximum bytes of values per INSERT of Add and AddForPrimary, should be
+	//	below max_allowed_packet, 0 is unlimited
+	InsertChunkBytes int
+	//	严格模式: 结构版本落后于已注册迁移的数据库不参与查询, 见 SetMigrations
+	//
+	//	Strict mode: databases whose schema version is behind the registered
+	//	migrations are left out of queries, see SetMigrations
+	StrictSchema bool
+	//	上次写入的时间
+	//
+	//	The last write time
+	lastWriteTime []time.Time
+	//	保护 LinkNum 和 MySQLDB
+	//
+	//	Protects LinkNum and MySQLDB
+	poolMu sync.Mutex
+	//	保护副本状态
+	//
+	//	Protects the replica state
+	replicaMu sync.Mutex
+	//	各数据库的自增设置, 计算多行插入的ID时使用
+	//
+	//	Auto increment settings of each database, used to compute the IDs of multi-row inserts
+	autoInc   map[int]autoIncrement
+	autoIncMu sync.Mutex
+	//	各表的软删除配置
+	//
+	//	Soft delete configuration of each table
+	softDeletes  map[string]SoftDelete
+	softDeleteMu sync.RWMutex
+	//	已注册的迁移和各数据库未执行的迁移数目
+	//
+	//	Registered migrations and the number of pending migrations of each database
+	migrations    []Migration
+	schemaPending map[int]schemaStatus
+	migrationMu   sync.RWMutex
+	//	熔断器
+	//
+	//	Circuit breakers
+	mysqlBreakers   []*circuitBreaker
+	replicaBreakers [][]*circuitBreaker
+	redisBreakers   []*circuitBreaker
+	//	保护熔断器与健康检查
+	//
+	//	Protects the circuit breakers and the health check
+	healthMu   sync.Mutex
+	healthStop chan struct{}
+	//	语句的钩子
+	//
+	//	Statement hooks
+	hooks  []Hook
+	hookMu sync.RWMutex
 
 	//	Redis配置
 	//
@@ -91,10 +198,19 @@ type MysqlDB struct {
 	//
 	//	The location of the currently connected database in the configuration
 	DBItem int
+	//	当前连接的副本在配置中的位置, -1 为主库
+	//
+	//	The location of the currently connected replica in the configuration,
+	//	-1 is the primary
+	Replica int
 	//	数据库连接
 	//
 	//	Database connection
 	DB *sql.DB
+	//	语句的钩子
+	//
+	//	Statement hooks
+	hooks []Hook
 }
 
 type RedisDB struct {
@@ -106,10 +222,11 @@ type RedisDB struct {
 	//
 	//	The location of the currently connected database in the configuration
 	DBItem int
-	//	数据库连接
+	//	数据库连接, 根据配置可以是单机、Sentinel 或 Cluster 客户端
 	//
-	//	Database connection
-	DB *redis.Client
+	//	Database connection, a single node, Sentinel or Cluster client
+	//	depending on the configuration
+	DB redis.UniversalClient
 }
 
 type Option struct {
@@ -129,10 +246,113 @@ type Option struct {
 	//
 	//	Retry time
 	RetryTime int
+	//	最大重试时间
+	//
+	//	Maximum retry time
+	MaxRetryTime int
+	//	熔断前的连续失败次数
+	//
+	//	Number of consecutive failures before the circuit breaker opens
+	FailureThreshold int
 	//	是否输出到控制台
+	//	Deprecated: 使用 Setting.Logger
 	//
 	//	Whether to output to the console
+	//	Deprecated: use Setting.Logger
 	IsShowPrint bool
+	//	调用方的上下文, 用于链路追踪
+	//
+	//	Context of the caller, used for tracing
+	Context context.Context
+	//	QueryCMD 和 ExecCMD 绑定的参数
+	//
+	//	Arguments bound by QueryCMD and ExecCMD
+	Args []interface{}
+	//	本次调用的日志
+	//
+	//	Logger of this call
+	logger *slog.Logger
+	//	流式读取时记录 NULL 字段名的字段, 为空字符串时不记录
+	//
+	//	Field recording the names of the NULL columns on a streaming read,
+	//	not recorded when empty
+	nullColumn string
+	//	是否连接只读副本
+	//
+	//	Whether to connect to a read replica
+	IsReadOnly bool
+	//	是否只从主库读取
+	//
+	//	Whether to read only from the primary
+	IsReadPrimary bool
+	//	部分结果策略
+	//
+	//	Partial result policy
+	PartialPolicy PartialPolicy
+	//	法定数目
+	//
+	//	Quorum
+	Quorum int
+	//	接收各数据库的应答情况
+	//
+	//	Receives how each database answered
+	Report *ShardReport
+	//	接收 Add 和 AddForPrimary 每一行的插入结果
+	//
+	//	Receives the insert result of every row of Add and AddForPrimary
+	AddedRows *[]AddedRow
+	//	无法保证多行插入的ID连续时的处理方式
+	//
+	//	What to do when the IDs of a multi-row insert may not be consecutive
+	IDFallback IDFallback
+	//	查询时是否包含软删除的行
+	//
+	//	Whether queries include soft-deleted rows
+	WithDeleted bool
+	//	Update 检查的版本字段, 为空时不检查
+	//
+	//	Version column checked by Update, not checked when empty
+	VersionColumn string
+	//	版本字段的类型
+	//
+	//	Kind of the version column
+	VersionMode VersionMode
+	//	与 ids 对应的当前版本
+	//
+	//	Current versions matching ids
+	Versions []string
+	//	接收版本不一致的加密主键
+	//
+	//	Receives the encrypted primary keys whose version did not match
+	Conflicts *[]string
+	//	UpdateWhere 和 DeleteWhere 只统计匹配的行数, 不修改
+	//
+	//	UpdateWhere and DeleteWhere only count the matching rows without changing them
+	DryRun bool
+	//	UpdateWhere 和 DeleteWhere 是否允许空的条件
+	//
+	//	Whether UpdateWhere and DeleteWhere allow an empty condition
+	AllowEmptyWhere bool
+	//	VerifyBroadcast 作为标准的数据库
+	//
+	//	Database VerifyBroadcast treats as the source of truth
+	SourceShard int
+	//	VerifyBroadcast 是否用标准数据库的数据修复不一致的分块
+	//
+	//	Whether VerifyBroadcast repairs the differing chunks from the source of truth
+	Repair bool
+	//	是否去掉各数据库之间重复的行
+	//
+	//	Whether to remove rows repeated across the databases
+	Distinct bool
+	//	Upsert 时更新的字段, 为 nil 时更新除主键外的所有字段
+	//
+	//	Columns updated by Upsert, all columns except the primary key when nil
+	UpsertKeys []string
+	//	Upsert 时每行单独执行, 以得到每行的结果
+	//
+	//	Upsert runs each row on its own to get the result of every row
+	UpsertEachRow bool
 	//	Redis专用：在查詢完成後刪除此條目
 	//
 	//	Redis special: delete this entry after the query is completed
@@ -167,12 +387,45 @@ func OptionRetryTime(RetryTime int) RetryTimeO {
 	}
 }
 
+// ===============
+//
+//	设置最大重试时间, 连续失败时重试等待时间翻倍直到此值
+//	MaxRetryTime	int	"最大重试等待时间(ms)"
+//
+// ===============
+//
+//	Set the maximum retry time, on consecutive failures the retry wait time
+//	doubles up to this value
+//	MaxRetryTime	int	"Maximum retry wait time(ms)"
+func OptionMaxRetryTime(MaxRetryTime int) RetryTimeO {
+	return func(o *Option) {
+		o.MaxRetryTime = MaxRetryTime
+	}
+}
+
+// ===============
+//
+//	设置熔断前的连续失败次数
+//	FailureThreshold	int	"连续失败次数"
+//
+// ===============
+//
+//	Set the number of consecutive failures before the circuit breaker opens
+//	FailureThreshold	int	"Number of consecutive failures"
+func OptionFailureThreshold(FailureThreshold int) RetryTimeO {
+	return func(o *Option) {
+		o.FailureThreshold = FailureThreshold
+	}
+}
+
 // ===============
 //
 //	数据库配置
 //	configString	string		"配置文件字符串"
 //	options		[]RetryTimeO	"配置"
 //		RetryTime	int		"重试时间(ms)"
+//		MaxRetryTime	int		"最大重试时间(ms)"
+//		FailureThreshold	int	"熔断前的连续失败次数"
 //	return 1	*Setting	"数据库配置对象"
 //	return 2	error		"错误信息"
 //
@@ -182,11 +435,16 @@ func OptionRetryTime(RetryTime int) RetryTimeO {
 //	configString	string		"Configuration file string"
 //	options		[]RetryTimeO	"Configuration"
 //		RetryTime	int		"Retry time(ms)"
+//		MaxRetryTime	int		"Maximum retry time(ms)"
+//		FailureThreshold	int	"Number of consecutive failures
+//							before the circuit breaker opens"
 //	return 1	*Setting	"Database configuration object"
 //	return 2	error		"Error message"
 func New(configString string, options ...RetryTimeO) (*Setting, error) {
 	option := &Option{
-		RetryTime: 60000,
+		RetryTime:        60000,
+		MaxRetryTime:     960000,
+		FailureThreshold: 1,
 	}
 	for _, o := range options {
 		o(option)
@@ -214,8 +472,10 @@ func New(configString string, options ...RetryTimeO) (*Setting, error) {
 	for i := 0; i < setting.MaxLink; i++ {
 		mySQLDBs = append(mySQLDBs, nil)
 	}
+	replicaFailTime := [][]*time.Time{}
 	for i := 0; i < len(setting.SqlConfigs); i++ {
 		connectFailTime = append(connectFailTime, nil)
+		replicaFailTime = append(replicaFailTime, make([]*time.Time, len(setting.SqlConfigs[i].Replicas)))
 	}
 	setting.MySQLDB = mySQLDBs
 	if config.Contrast.Key != nil {
@@ -226,7 +486,11 @@ func New(configString string, options ...RetryTimeO) (*Setting, error) {
 		setting.SEKey = se
 	}
 	setting.ConnectAgainTime = option.RetryTime
+	setting.MaxConnectAgainTime = option.MaxRetryTime
+	setting.FailureThreshold = option.FailureThreshold
 	setting.ConnectFailTime = connectFailTime
+	setting.ReplicaFailTime = replicaFailTime
+	setting.lastWriteTime = make([]time.Time, len(setting.SqlConfigs))
 
 	redisDBs := []*RedisDB{}
 	redisConnectFailTime := []*time.Time{}
@@ -238,6 +502,7 @@ func New(configString string, options ...RetryTimeO) (*Setting, error) {
 	}
 	setting.RedisDB = redisDBs
 	setting.RedisConnectFailTime = redisConnectFailTime
+	setting.initBreakers()
 
 	return &setting, nil
 }
@@ -258,22 +523,41 @@ func New(configString string, options ...RetryTimeO) (*Setting, error) {
 //	return 2	error		"Error message"
 func (s *Setting) Link(item int) (*MysqlDB, error) {
 	if s.SqlConfigs == nil || len(s.SqlConfigs) == 0 {
-		return nil, fmt.Errorf("MySQL Open Error: %s", "配置为空")
+		return nil, fmt.Errorf("MySQL Open Error: %w", ErrConfigEmpty)
+	}
+	if item < 0 || item >= len(s.SqlConfigs) {
+		return nil, fmt.Errorf("MySQL Open Error: %w: database %d", ErrOutOfRange, item)
 	}
 	var (
 		sqlJson *SQLConfig
 		err     error
 	)
 	sqlJson = &s.SqlConfigs[item]
-	var sqlsetting string = fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", sqlJson.User, sqlJson.Password, sqlJson.Address, sqlJson.Port, sqlJson.DB)
+	sqldb, err := openMySQL(sqlJson.User, sqlJson.Password, sqlJson.Address, sqlJson.Port, sqlJson.DB)
+	if err != nil {
+		return nil, err
+	}
+	return &MysqlDB{Name: sqlJson.DB, DBItem: item, Replica: -1, DB: sqldb, hooks: s.getHooks()}, nil
+}
+
+// ===============
+//
+//	打开并测试MySQL连接
+//
+// ===============
+//
+//	Open and ping a MySQL connection
+func openMySQL(user string, password string, address string, port string, db string) (*sql.DB, error) {
+	var sqlsetting string = fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", user, password, address, port, db)
 	sqldb, err := sql.Open("mysql", sqlsetting)
 	if err != nil {
 		return nil, err
 	}
 	if err := sqldb.Ping(); err != nil {
+		sqldb.Close()
 		return nil, err
 	}
-	return &MysqlDB{Name: sqlJson.DB, DBItem: item, DB: sqldb}, nil
+	return sqldb, nil
 }
 
 // ===============
@@ -289,6 +573,7 @@ func (db *MysqlDB) Close() {
 		db.DB = nil
 		db.Name = ""
 		db.DBItem = -1
+		db.Replica = -1
 	}
 }
 
@@ -310,7 +595,10 @@ func (db *MysqlDB) Close() {
 //	return 2	error		"Error message"
 func (s *Setting) RedisLink(item int, dbID int) (*RedisDB, error) {
 	if s.RedisConfigs == nil || len(s.RedisConfigs) == 0 {
-		return nil, fmt.Errorf("redis Open Error: %s", "配置为空")
+		return nil, fmt.Errorf("redis Open Error: %w", ErrConfigEmpty)
+	}
+	if item < 0 || item >= len(s.RedisConfigs) {
+		return nil, fmt.Errorf("redis Open Error: %w: database %d", ErrOutOfRange, item)
 	}
 	var (
 		redisJson *RedisConfig
@@ -318,17 +606,23 @@ func (s *Setting) RedisLink(item int, dbID int) (*RedisDB, error) {
 	)
 	redisJson = &s.RedisConfigs[item]
 	if !(0 <= dbID && dbID <= redisJson.MaxDB) {
-		return nil, fmt.Errorf("redis Open Error: %s", "数据库ID超出范围")
+		return nil, fmt.Errorf("redis Open Error: %w: database ID %d", ErrOutOfRange, dbID)
+	}
+	if len(redisJson.ClusterAddrs) > 0 && dbID != 0 {
+		return nil, fmt.Errorf("redis Open Error: %w: cluster mode only supports database 0", ErrOutOfRange)
+	}
+	redisdb, err := newRedisClient(redisJson, dbID)
+	if err != nil {
+		return nil, err
 	}
-	redisdb := redis.NewClient(&redis.Options{
-		Addr:     redisJson.Addr + ":" + redisJson.Port,
-		Password: redisJson.Password,
-		DB:       dbID,
-	})
 	_, err = redisdb.Ping(redisdb.Context()).Result()
 	if err != nil {
+		redisdb.Close()
 		return nil, err
 	}
+	redisdb.AddHook(redisHealthHook{s: s, item: item})
+	redisdb.AddHook(redisMetricsHook{s: s, item: item})
+	redisdb.AddHook(redisTracingHook{s: s, item: item})
 	return &RedisDB{Addr: redisJson.Addr, DBItem: item, DB: redisdb}, nil
 }
 
@@ -0,0 +1,182 @@
+package weSubDatabase
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// 慢查询时执行的 EXPLAIN 类型
+//
+// Kind of EXPLAIN run for slow queries
+type ExplainMode int
+
+const (
+	//	不执行 EXPLAIN
+	//
+	//	EXPLAIN is not run
+	ExplainNone ExplainMode = iota
+	//	执行 EXPLAIN
+	//
+	//	EXPLAIN is run
+	ExplainPlan
+	//	查询执行 EXPLAIN ANALYZE (MySQL 8.0.18+), 会再次执行查询,
+	//	不支持时或非查询语句使用 EXPLAIN
+	//
+	//	EXPLAIN ANALYZE is run for queries (MySQL 8.0.18+), the query is run
+	//	again, EXPLAIN is used when it is unsupported or for non-queries
+	ExplainAnalyze
+)
+
+// 一条慢查询
+//
+// A slow query
+type SlowQuery struct {
+	//	操作, 如 query, update, delete
+	//
+	//	Operation, such as query, update, delete
+	Op string
+	//	表名
+	//
+	//	Table name
+	Table string
+	//	数据库在配置中的位置
+	//
+	//	Location of the database in the configuration
+	Shard int
+	//	副本在配置中的位置, -1 为主库
+	//
+	//	Location of the replica in the configuration, -1 is the primary
+	Replica int
+	//	数据库名
+	//
+	//	Database name
+	DB string
+	//	SQL指令
+	//
+	//	SQL instruction
+	SQL string
+	//	执行时间
+	//
+	//	Duration
+	Duration time.Duration
+	//	返回或影响的行数
+	//
+	//	Rows returned or affected
+	Rows int64
+	//	EXPLAIN 的结果, 没有执行时为 nil
+	//
+	//	Result of EXPLAIN, nil when it was not run
+	Explain []map[string]string
+	//	EXPLAIN 的错误信息
+	//
+	//	Error message of EXPLAIN
+	ExplainErr error
+}
+
+// ===============
+//
+//	内置钩子: 检测慢查询
+//	执行时间超过 SlowQueryTime 时按 SlowQueryExplain 在同一数据库上执行 EXPLAIN,
+//	结果以 Warn 级别输出到日志, 并调用 OnSlowQuery
+//	db		*MysqlDB	"执行语句的连接"
+//	logger		*slog.Logger	"日志对象"
+//	return		Hook		"钩子"
+//
+// ===============
+//
+//	Built-in hook: detect slow queries
+//	When the duration exceeds SlowQueryTime, EXPLAIN is run on the same database
+//	according to SlowQueryExplain, the finding is logged at Warn level and
+//	OnSlowQuery is called
+//	db		*MysqlDB	"Connection that runs the statement"
+//	logger		*slog.Logger	"Logger"
+//	return		Hook		"Hook"
+func (s *Setting) slowQueryHook(db *MysqlDB, logger *slog.Logger) Hook {
+	return func(stmt *Statement, next StatementFunc) error {
+		if s.SlowQueryTime <= 0 {
+			return next(stmt)
+		}
+		start := time.Now()
+		err := next(stmt)
+		duration := time.Since(start)
+		if err != nil || duration < time.Millisecond*time.Duration(s.SlowQueryTime) {
+			return err
+		}
+		slow := SlowQuery{
+			Op:       stmt.Op,
+			Table:    stmt.Table,
+			Shard:    stmt.Shard,
+			Replica:  stmt.Replica,
+			DB:       stmt.DB,
+			SQL:      stmt.SQL,
+			Duration: duration,
+			Rows:     stmt.rowCount(),
+		}
+		if s.SlowQueryExplain != ExplainNone {
+			slow.Explain, slow.ExplainErr = s.explain(db, stmt)
+		}
+		ctx := stmt.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		attrs := []slog.Attr{
+			slog.String("op", slow.Op),
+			slog.String("table", slow.Table),
+			slog.Int("shard", slow.Shard),
+			slog.String("db", slow.DB),
+			slog.Duration("duration", slow.Duration),
+			slog.Int64("rows", slow.Rows),
+			slog.String("sql", redactSQL(slow.SQL)),
+		}
+		if slow.Replica >= 0 {
+			attrs = append(attrs, slog.Int("replica", slow.Replica))
+		}
+		if slow.Explain != nil {
+			attrs = append(attrs, slog.Any("explain", slow.Explain))
+		}
+		if slow.ExplainErr != nil {
+			attrs = append(attrs, slog.Any("explain_error", slow.ExplainErr))
+		}
+		logger.LogAttrs(ctx, slog.LevelWarn, "mysql slow query", attrs...)
+		if s.OnSlowQuery != nil {
+			s.OnSlowQuery(slow)
+		}
+		return err
+	}
+}
+
+// ===============
+//
+//	在执行语句的数据库上执行 EXPLAIN, 不经过钩子
+//	db		*MysqlDB		"执行语句的连接"
+//	stmt		*Statement		"慢语句"
+//	return 1	[]map[string]string	"EXPLAIN 的结果"
+//	return 2	error			"错误信息"
+//
+// ===============
+//
+//	Run EXPLAIN on the database that ran the statement, hooks are skipped
+//	db		*MysqlDB		"Connection that ran the statement"
+//	stmt		*Statement		"Slow statement"
+//	return 1	[]map[string]string	"Result of EXPLAIN"
+//	return 2	error			"Error message"
+func (s *Setting) explain(db *MysqlDB, stmt *Statement) ([]map[string]string, error) {
+	ctx := stmt.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if s.SlowQueryExplain == ExplainAnalyze && stmt.IsQuery && isSelect(stmt.SQL) {
+		rows, err := db.query(ctx, "EXPLAIN ANALYZE "+stmt.SQL, stmt.Args...)
+		if err == nil {
+			return rows, nil
+		}
+	}
+	return db.query(ctx, "EXPLAIN "+stmt.SQL, stmt.Args...)
+}
+
+func isSelect(sqlStr string) bool {
+	sqlStr = strings.TrimSpace(sqlStr)
+	return len(sqlStr) >= 6 && strings.EqualFold(sqlStr[:6], "SELECT")
+}
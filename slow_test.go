@@ -0,0 +1,66 @@
+package weSubDatabase
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowQuery(t *testing.T) {
+	sqlSetting, err := New(testJsonStr)
+	if err != nil {
+		t.Error("initialization failed:", err)
+		return
+	}
+	var out bytes.Buffer
+	sqlSetting.SetLogHandler(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	var found []SlowQuery
+	sqlSetting.OnSlowQuery = func(slow SlowQuery) {
+		found = append(found, slow)
+	}
+	db := &MysqlDB{Name: "db1", DBItem: 1, Replica: -1}
+	hook := sqlSetting.slowQueryHook(db, sqlSetting.logger(nil, false))
+	run := func(sleep time.Duration) {
+		stmt := db.statement(context.Background(), stmtMeta{op: "query", table: "data"}, "SELECT * FROM `data` WHERE `name`='secret'", nil, true)
+		hook(stmt, func(stmt *Statement) error {
+			time.Sleep(sleep)
+			stmt.Rows = make([]map[string]string, 2)
+			return nil
+		})
+	}
+
+	run(time.Millisecond * 20)
+	if len(found) != 0 || out.Len() != 0 {
+		t.Error("slow query detection should be disabled by default")
+	}
+
+	sqlSetting.SlowQueryTime = 10
+	run(0)
+	if len(found) != 0 {
+		t.Error("fast statement reported as slow:", found)
+	}
+	run(time.Millisecond * 20)
+	if len(found) != 1 {
+		t.Error("slow statement not reported:", found)
+		return
+	}
+	slow := found[0]
+	if slow.Shard != 1 || slow.DB != "db1" || slow.Table != "data" || slow.Rows != 2 || slow.Duration < time.Millisecond*10 || slow.Explain != nil {
+		t.Error("slow query fields:", slow)
+	}
+	for _, want := range []string{`"level":"WARN"`, `"msg":"mysql slow query"`, `"shard":1`, `"rows":2`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("entry %s does not contain %s", out.String(), want)
+		}
+	}
+	if strings.Contains(out.String(), "secret") {
+		t.Error("values should be redacted in the log:", out.String())
+	}
+
+	if !isSelect("  select 1") || isSelect("UPDATE `data` SET `a`=1") {
+		t.Error("isSelect")
+	}
+}
@@ -0,0 +1,327 @@
+package weSubDatabase
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 软删除的标记方式
+//
+// How soft-deleted rows are marked
+type SoftDeleteMode int
+
+const (
+	//	时间字段, 如 deleted_at DATETIME NULL, NULL 为未删除, 删除时写入 NOW()
+	//
+	//	Time column, such as deleted_at DATETIME NULL, NULL is not deleted,
+	//	NOW() is written on delete
+	SoftDeleteTime SoftDeleteMode = iota
+	//	标记字段, 如 is_deleted TINYINT, 0 为未删除, 删除时写入 1
+	//
+	//	Flag column, such as is_deleted TINYINT, 0 is not deleted, 1 is written on delete
+	SoftDeleteFlag
+)
+
+// 表的软删除配置
+//
+// Soft delete configuration of a table
+type SoftDelete struct {
+	//	标记删除的字段
+	//
+	//	Column marking the deletion
+	Column string
+	//	标记方式
+	//
+	//	How rows are marked
+	Mode SoftDeleteMode
+}
+
+// 未删除的行的条件
+//
+// Condition of the rows not deleted
+func (sd SoftDelete) alive() string {
+	if sd.Mode == SoftDeleteFlag {
+		return "`" + sd.Column + "`=0"
+	}
+	return "`" + sd.Column + "` IS NULL"
+}
+
+// 标记删除的赋值
+//
+// Assignment marking the deletion
+func (sd SoftDelete) deleted() string {
+	if sd.Mode == SoftDeleteFlag {
+		return "`" + sd.Column + "`=1"
+	}
+	return "`" + sd.Column + "`=NOW()"
+}
+
+// 取消删除标记的赋值
+//
+// Assignment clearing the deletion
+func (sd SoftDelete) restored() string {
+	if sd.Mode == SoftDeleteFlag {
+		return "`" + sd.Column + "`=0"
+	}
+	return "`" + sd.Column + "`=NULL"
+}
+
+// ===============
+//
+//	设置表的软删除, 设置后 Delete 改为标记删除,
+//	Query, QueryID, QueryRows, Aggregate, CountDistinct 和查询构造器不返回已删除的行
+//	Column 为空字符串时取消软删除
+//	table		string		"表名"
+//	sd		SoftDelete	"软删除配置"
+//	return		error		"错误信息"
+//
+// ===============
+//
+//	Set soft delete for the table, Delete then marks rows as deleted, and
+//	Query, QueryID, QueryRows, Aggregate, CountDistinct and the query builder
+//	leave out deleted rows
+//	Soft delete is turned off when Column is empty
+//	table		string		"Table name"
+//	sd		SoftDelete	"Soft delete configuration"
+//	return		error		"Error message"
+func (s *Setting) SetSoftDelete(table string, sd SoftDelete) error {
+	sd.Column = strings.ReplaceAll(strings.TrimSpace(sd.Column), "`", "")
+	s.softDeleteMu.Lock()
+	defer s.softDeleteMu.Unlock()
+	if sd.Column == "" {
+		delete(s.softDeletes, table)
+		return nil
+	}
+	if !isIdentifier(sd.Column) {
+		return fmt.Errorf("%w: column %q", ErrInvalidArgument, sd.Column)
+	}
+	if s.softDeletes == nil {
+		s.softDeletes = map[string]SoftDelete{}
+	}
+	s.softDeletes[table] = sd
+	return nil
+}
+
+// ===============
+//
+//	获取表的软删除配置
+//	table		string		"表名"
+//	return 1	SoftDelete	"软删除配置"
+//	return 2	bool		"是否启用"
+//
+// ===============
+//
+//	Get the soft delete configuration of the table
+//	table		string		"Table name"
+//	return 1	SoftDelete	"Soft delete configuration"
+//	return 2	bool		"Whether it is enabled"
+func (s *Setting) GetSoftDelete(table string) (SoftDelete, bool) {
+	s.softDeleteMu.RLock()
+	defer s.softDeleteMu.RUnlock()
+	sd, ok := s.softDeletes[table]
+	return sd, ok
+}
+
+// ===============
+//
+//	查询时包含软删除的行
+//	WithDeleted	bool	"是否包含已删除的行"
+//
+// ===============
+//
+//	Include soft-deleted rows in queries
+//	WithDeleted	bool	"Whether to include deleted rows"
+func OWithDeleted(WithDeleted bool) IsShowPrintO {
+	return func(o *Option) {
+		o.WithDeleted = WithDeleted
+	}
+}
+
+// 表启用软删除且没有 WithDeleted 时, 在条件中排除已删除的行
+//
+// Leave deleted rows out of the condition when the table has soft delete and WithDeleted is not set
+func (s *Setting) aliveWhere(table string, where string, option *Option) string {
+	if option.WithDeleted {
+		return where
+	}
+	sd, ok := s.GetSoftDelete(table)
+	if !ok {
+		return where
+	}
+	if where == "" {
+		return sd.alive()
+	}
+	return "(" + where + ") AND " + sd.alive()
+}
+
+// ===============
+//
+//	恢复软删除的行
+//	table		string			"表名"
+//	primaryKey	string			"主键"
+//	ids		[]string		"加密后的主键"
+//	Debug		*log.Logger		"调试输出"
+//	options		[]IsShowPrintO		"配置"
+//		IsShowPrint	bool			"是否输出到控制台"
+//		Context		context.Context		"调用方的上下文"
+//	return 1	[]int64			"每个数据库恢复的行数, 未执行的数据库为 -1"
+//	return 2	Errors			"错误信息"
+//
+// ===============
+//
+//	Restore soft-deleted rows
+//	table		string			"Table name"
+//	primaryKey	string			"Primary key"
+//	ids		[]string		"Encrypted primary keys"
+//	Debug		*log.Logger		"Debug output"
+//	options		[]IsShowPrintO		"Configuration"
+//		IsShowPrint	bool			"Whether to output to the console"
+//		Context		context.Context		"Context of the caller"
+//	return 1	[]int64			"Rows restored on each database, -1 for
+//						 	databases not run"
+//	return 2	Errors			"Error message"
+func (s *Setting) Restore(table string, primaryKey string, ids []string, Debug *log.Logger, options ...IsShowPrintO) (reInt []int64, errs Errors) {
+	option := &Option{
+		IsShowPrint: false,
+	}
+	for _, o := range options {
+		o(option)
+	}
+	logger := s.logger(Debug, option.IsShowPrint)
+	ctx, span := s.startCall(option, "Restore", table)
+	defer func() { endSpan(span, errs.Err()) }()
+	sd, ok := s.GetSoftDelete(table)
+	if !ok {
+		return nil, Errors{fmt.Errorf("%w: table %q has no soft delete", ErrInvalidArgument, table)}
+	}
+	if !isIdentifier(primaryKey) {
+		return nil, Errors{fmt.Errorf("%w: primary key %q", ErrInvalidArgument, primaryKey)}
+	}
+	if s.SEKey == nil {
+		return nil, Errors{fmt.Errorf("%w: no key to decrypt ids", ErrInvalidKey)}
+	}
+	_, idList, _ := s.DecryptID(primaryKey, ids)
+	n := 0
+	for _, l := range idList {
+		n += len(l)
+	}
+	if n != len(ids) {
+		return nil, Errors{fmt.Errorf("%w: %d of %d ids cannot be decrypted", ErrInvalidKey, len(ids)-n, len(ids))}
+	}
+	sqlStrs := make([]string, len(s.SqlConfigs))
+	args := make([][]interface{}, len(s.SqlConfigs))
+	for i, shardIDs := range idList {
+		if len(shardIDs) == 0 {
+			continue
+		}
+		sqlStrs[i] = "UPDATE `" + table + "` SET " + sd.restored() + " WHERE `" + primaryKey + "` IN (" + placeholders(len(shardIDs)) + ") AND NOT (" + sd.alive() + ")"
+		for _, id := range shardIDs {
+			args[i] = append(args[i], id)
+		}
+	}
+	return s.execShards(ctx, stmtMeta{op: "restore", table: table}, sqlStrs, args, logger)
+}
+
+// ===============
+//
+//	物理删除所有数据库中软删除超过 olderThan 的行
+//	标记字段没有删除时间, olderThan 必须为 0, 删除所有已标记的行
+//	batch 大于 0 时每条 DELETE 最多删除 batch 行, 重复执行直到删除完
+//	table		string			"表名"
+//	olderThan	time.Duration		"删除多久以前软删除的行"
+//	batch		int			"每批删除的行数, 0 为一次删除"
+//	Debug		*log.Logger		"调试输出"
+//	options		[]IsShowPrintO		"配置"
+//		IsShowPrint	bool			"是否输出到控制台"
+//		Context		context.Context		"调用方的上下文"
+//	return 1	[]int64			"每个数据库删除的行数, 未执行的数据库为 -1"
+//	return 2	Errors			"错误信息"
+//
+// ===============
+//
+//	Physically delete the rows soft-deleted more than olderThan ago on all databases
+//	A flag column has no deletion time, olderThan must be 0 and all marked
+//	rows are deleted
+//	When batch is above 0 each DELETE removes at most batch rows and is
+//	repeated until nothing is left
+//	table		string			"Table name"
+//	olderThan	time.Duration		"How long ago the rows were soft-deleted"
+//	batch		int			"Rows deleted per batch, 0 is all at once"
+//	Debug		*log.Logger		"Debug output"
+//	options		[]IsShowPrintO		"Configuration"
+//		IsShowPrint	bool			"Whether to output to the console"
+//		Context		context.Context		"Context of the caller"
+//	return 1	[]int64			"Rows deleted on each database, -1 for
+//						 	databases not run"
+//	return 2	Errors			"Error message"
+func (s *Setting) Purge(table string, olderThan time.Duration, batch int, Debug *log.Logger, options ...IsShowPrintO) (reInt []int64, errs Errors) {
+	option := &Option{
+		IsShowPrint: false,
+	}
+	for _, o := range options {
+		o(option)
+	}
+	logger := s.logger(Debug, option.IsShowPrint)
+	ctx, span := s.startCall(option, "Purge", table)
+	defer func() { endSpan(span, errs.Err()) }()
+	sd, ok := s.GetSoftDelete(table)
+	if !ok {
+		return nil, Errors{fmt.Errorf("%w: table %q has no soft delete", ErrInvalidArgument, table)}
+	}
+	sqlStr, args, err := purgeSQL(table, olderThan, batch, sd)
+	if err != nil {
+		return nil, Errors{err}
+	}
+	reInt = make([]int64, len(s.SqlConfigs))
+	for i := range reInt {
+		reInt[i] = -1
+	}
+	for i := range s.SqlConfigs {
+		sqlStrs, shardArgs := oneShard(len(s.SqlConfigs), i, sqlStr, args)
+		for {
+			ra, shardErrs := s.execShards(ctx, stmtMeta{op: "purge", table: table}, sqlStrs, shardArgs, logger)
+			if ra[i] >= 0 {
+				if reInt[i] < 0 {
+					reInt[i] = 0
+				}
+				reInt[i] += ra[i]
+			}
+			if len(shardErrs) > 0 {
+				errs = append(errs, shardErrs...)
+				break
+			}
+			if batch <= 0 || ra[i] < int64(batch) || ctx.Err() != nil {
+				break
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return reInt, errs
+	}
+	return reInt, nil
+}
+
+// 生成 Purge 的删除指令
+//
+// Create the delete instruction of Purge
+func purgeSQL(table string, olderThan time.Duration, batch int, sd SoftDelete) (string, []interface{}, error) {
+	if olderThan < 0 || batch < 0 {
+		return "", nil, fmt.Errorf("%w: olderThan and batch must not be negative", ErrInvalidArgument)
+	}
+	sqlStr := "DELETE FROM `" + table + "` WHERE NOT (" + sd.alive() + ")"
+	var args []interface{}
+	if olderThan > 0 {
+		if sd.Mode == SoftDeleteFlag {
+			return "", nil, fmt.Errorf("%w: a flag column has no deletion time, olderThan must be 0", ErrInvalidArgument)
+		}
+		sqlStr += " AND `" + sd.Column + "` < NOW() - INTERVAL ? SECOND"
+		args = append(args, int64(olderThan/time.Second))
+	}
+	if batch > 0 {
+		sqlStr += " LIMIT " + strconv.Itoa(batch)
+	}
+	return sqlStr, args, nil
+}
@@ -0,0 +1,66 @@
+package weSubDatabase
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSoftDelete(t *testing.T) {
+	s := &Setting{SqlConfigs: make([]SQLConfig, 2)}
+	if where := s.aliveWhere("data", "`id`>1", &Option{}); where != "`id`>1" {
+		t.Error("no soft delete:", where)
+	}
+	if err := s.SetSoftDelete("data", SoftDelete{Column: "deleted_at"}); err != nil {
+		t.Fatal(err)
+	}
+	if where := s.aliveWhere("data", "`id`>1 OR `id`<0", &Option{}); where != "(`id`>1 OR `id`<0) AND `deleted_at` IS NULL" {
+		t.Error("alive:", where)
+	}
+	if where := s.aliveWhere("data", "", &Option{WithDeleted: true}); where != "" {
+		t.Error("with deleted:", where)
+	}
+
+	sqlStrs, _, err := s.Table("data").Where("`id` = ?", 1).DeleteSQL()
+	if err != nil || sqlStrs[0] != "UPDATE `data` SET `deleted_at`=NOW() WHERE ((`id` = ?)) AND `deleted_at` IS NULL" {
+		t.Error("builder delete:", sqlStrs, err)
+	}
+	sqlStrs, _, err = s.Table("data").SelectSQL()
+	if err != nil || sqlStrs[1] != "SELECT * FROM `data` WHERE `deleted_at` IS NULL" {
+		t.Error("builder select:", sqlStrs, err)
+	}
+	sqlStrs, _, err = s.Table("data").SelectSQL(OWithDeleted(true))
+	if err != nil || sqlStrs[1] != "SELECT * FROM `data`" {
+		t.Error("builder select with deleted:", sqlStrs, err)
+	}
+
+	sqlStr, args, err := purgeSQL("data", 30*24*time.Hour, 500, SoftDelete{Column: "deleted_at"})
+	if err != nil || sqlStr != "DELETE FROM `data` WHERE NOT (`deleted_at` IS NULL) AND `deleted_at` < NOW() - INTERVAL ? SECOND LIMIT 500" || args[0] != int64(2592000) {
+		t.Error("purge:", sqlStr, args, err)
+	}
+	sqlStr, _, err = purgeSQL("data", 0, 0, SoftDelete{Column: "is_deleted", Mode: SoftDeleteFlag})
+	if err != nil || sqlStr != "DELETE FROM `data` WHERE NOT (`is_deleted`=0)" {
+		t.Error("purge flag:", sqlStr, err)
+	}
+	if _, _, err := purgeSQL("data", time.Hour, 0, SoftDelete{Column: "is_deleted", Mode: SoftDeleteFlag}); !errors.Is(err, ErrInvalidArgument) {
+		t.Error("purge flag with age:", err)
+	}
+
+	if err := s.SetSoftDelete("data", SoftDelete{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.GetSoftDelete("data"); ok {
+		t.Error("soft delete not removed")
+	}
+
+	sqlSetting, err := New(testJsonStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlSetting.SetSoftDelete("data", SoftDelete{Column: "deleted_at"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, errs := sqlSetting.Restore("data", "id", []string{"bad"}, nil); !errors.Is(errs, ErrInvalidKey) {
+		t.Error("restore undecryptable id:", errs)
+	}
+}
@@ -1,9 +1,14 @@
 package weSubDatabase
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // 连接MySQL时的可选配置
@@ -44,11 +49,14 @@ func OLWaitTime(WaitTime int) LinkSQLO {
 // ===============
 //
 //	设置是输出到控制台
+//	日志同时以文本格式输出到控制台, 建议使用 Setting.SetLogHandler
 //	IsShowPrint	bool	"是否输出到控制台"
 //
 // ===============
 //
 //	Set whether to output to the console
+//	Logs are also written to the console as text, Setting.SetLogHandler
+//	is recommended
 //	IsShowPrint	bool	"Whether to output to the console"
 func OLIsShowPrint(IsShowPrint bool) LinkSQLO {
 	return func(o *Option) {
@@ -56,6 +64,31 @@ func OLIsShowPrint(IsShowPrint bool) LinkSQLO {
 	}
 }
 
+// ===============
+//
+//	设置是否连接只读副本, 没有可用的副本时连接主库
+//	IsReadOnly	bool	"是否连接只读副本"
+//
+// ===============
+//
+//	Set whether to connect to a read replica, the primary is connected
+//	when no replica is available
+//	IsReadOnly	bool	"Whether to connect to a read replica"
+func OLReadOnly(IsReadOnly bool) LinkSQLO {
+	return func(o *Option) {
+		o.IsReadOnly = IsReadOnly
+	}
+}
+
+// 使用调用方的日志对象
+//
+// Use the logger of the caller
+func olLogger(logger *slog.Logger) LinkSQLO {
+	return func(o *Option) {
+		o.logger = logger
+	}
+}
+
 // 是否为主键的可选配置
 //
 // Optional configuration for whether it is a primary key
@@ -79,11 +112,14 @@ func OIPKIsPrimaryKey(IsPrimaryKey bool) IsPrimaryKeyO {
 // ===============
 //
 //	是否输出到控制台
+//	日志同时以文本格式输出到控制台, 建议使用 Setting.SetLogHandler
 //	IsShowPrint	bool	"是否输出到控制台"
 //
 // ===============
 //
 //	Whether to output to the console
+//	Logs are also written to the console as text, Setting.SetLogHandler
+//	is recommended
 //	IsShowPrint	bool	"Whether to output to the console"
 func OIPKIsShowPrint(IsShowPrint bool) IsPrimaryKeyO {
 	return func(o *Option) {
@@ -99,11 +135,14 @@ type IsShowPrintO func(*Option)
 // ===============
 //
 //	是否输出到控制台
+//	日志同时以文本格式输出到控制台, 建议使用 Setting.SetLogHandler
 //	IsShowPrint	bool	"是否输出到控制台"
 //
 // ===============
 //
 //	Whether to output to the console
+//	Logs are also written to the console as text, Setting.SetLogHandler
+//	is recommended
 //	IsShowPrint	bool	"Whether to output to the console"
 func OIsShowPrint(IsShowPrint bool) IsShowPrintO {
 	return func(o *Option) {
@@ -111,6 +150,21 @@ func OIsShowPrint(IsShowPrint bool) IsShowPrintO {
 	}
 }
 
+// ===============
+//
+//	是否只从主库读取
+//	IsReadPrimary	bool	"是否只从主库读取"
+//
+// ===============
+//
+//	Whether to read only from the primary
+//	IsReadPrimary	bool	"Whether to read only from the primary"
+func OReadPrimary(IsReadPrimary bool) IsShowPrintO {
+	return func(o *Option) {
+		o.IsReadPrimary = IsReadPrimary
+	}
+}
+
 // ===============
 //
 //	连接MySQL数据库并放入连接池
@@ -118,6 +172,7 @@ func OIsShowPrint(IsShowPrint bool) IsShowPrintO {
 //	options		[]LinkSQLO	"配置"
 //		WaitCount	int		"等待次数"
 //		WaitTime	int		"每次等待时间，单位毫秒"
+//		IsReadOnly	bool		"是否连接只读副本"
 //	return 1	int		"连接池中的位置"
 //	return 2	error		"错误信息"
 //
@@ -130,6 +185,8 @@ func OIsShowPrint(IsShowPrint bool) IsShowPrintO {
 //		WaitCount	int		"Number of waits"
 //		WaitTime	int		"Waiting time per time,
 //									in milliseconds"
+//		IsReadOnly	bool		"Whether to connect to a
+//									read replica"
 //	return 1	int		"Position in the connection pool"
 //	return 2	error		"Error message"
 func (s *Setting) MysqlIsRun(item int, options ...LinkSQLO) (int, error) {
@@ -141,26 +198,72 @@ func (s *Setting) MysqlIsRun(item int, options ...LinkSQLO) (int, error) {
 	for _, o := range options {
 		o(option)
 	}
-	if s.LinkNum >= s.MaxLink {
-		WaitCount := 0
-		for {
-			if s.LinkNum < s.MaxLink {
-				break
-			}
-			if WaitCount > option.WaitCount {
-				return -1, fmt.Errorf("MySQL connections are full")
-			}
-			WaitCount += 1
-			time.Sleep(time.Duration(option.WaitTime) * time.Millisecond)
-		}
+	if err := s.reserveLink(item, option); err != nil {
+		return -1, err
 	}
 	// println("==========\r\nMySQL连接中...")
-	wSQLdb, err := s.Link(item)
+	var (
+		wSQLdb *MysqlDB
+		err    error
+	)
+	if option.IsReadOnly {
+		wSQLdb, err = s.linkRead(item)
+	} else if s.tryAcquire(EndpointMySQL, item, -1) {
+		wSQLdb, err = s.Link(item)
+	} else {
+		err = errBreakerOpen(item)
+	}
+	logger := option.logger
+	if logger == nil {
+		logger = s.logger(nil, option.IsShowPrint)
+	}
 	if err != nil {
-		tn := time.Now()
-		s.ConnectFailTime[item] = &tn
+		if !errors.Is(err, ErrShardUnavailable) {
+			// 熔断器拒绝时没有连接, 不计入失败次数
+			// Nothing was connected when the breaker refused, it is not a failure
+			s.reportEndpoint(EndpointMySQL, item, -1, err)
+		}
+		logger.Error("mysql connect", "shard", item, "error", err)
+		s.poolMu.Lock()
+		s.LinkNum -= 1
+		s.poolMu.Unlock()
 		return -1, err
 	}
+	s.reportQuery(wSQLdb, nil)
+	ii, conns := s.putLink(wSQLdb)
+	logger.Debug("mysql connect", "shard", item, "db", wSQLdb.Name, "replica", wSQLdb.Replica, "conns", conns)
+	return ii, nil
+}
+
+// 占用连接池中的一个位置, 已满时等待, 多个数据库同时连接时不会超过 MaxLink
+//
+// Take a place in the connection pool, waiting while it is full, so
+// concurrent connections do not exceed MaxLink
+func (s *Setting) reserveLink(item int, option *Option) error {
+	WaitCount := 0
+	for {
+		s.poolMu.Lock()
+		if s.LinkNum < s.MaxLink {
+			s.LinkNum += 1
+			s.poolMu.Unlock()
+			return nil
+		}
+		s.poolMu.Unlock()
+		if WaitCount > option.WaitCount {
+			return fmt.Errorf("%w: MySQL connections are full", ErrPoolExhausted)
+		}
+		WaitCount += 1
+		s.instrument().PoolWait(EndpointMySQL, item)
+		time.Sleep(time.Duration(option.WaitTime) * time.Millisecond)
+	}
+}
+
+// 把连接放入占用的位置, 返回连接池中的位置和连接数
+//
+// Put the connection into the reserved place, the location in the pool and the number of connections are returned
+func (s *Setting) putLink(db *MysqlDB) (int, int) {
+	s.poolMu.Lock()
+	defer s.poolMu.Unlock()
 	ii := 0
 	for i := 0; i < len(s.MySQLDB); i++ {
 		if s.MySQLDB[i] == nil {
@@ -168,12 +271,8 @@ func (s *Setting) MysqlIsRun(item int, options ...LinkSQLO) (int, error) {
 			break
 		}
 	}
-	s.LinkNum += 1
-	s.MySQLDB[ii] = wSQLdb
-	if option.IsShowPrint {
-		println("MySQL DB", item, "connection successful!")
-	}
-	return ii, nil
+	s.MySQLDB[ii] = db
+	return ii, s.LinkNum
 }
 
 // ===============
@@ -189,52 +288,78 @@ func (s *Setting) MysqlClose(i int, options ...IsShowPrintO) {
 	if i < 0 || i >= len(s.MySQLDB) {
 		return
 	}
-	if s.MySQLDB[i] != nil {
-		s.MySQLDB[i].Close()
+	s.poolMu.Lock()
+	db := s.MySQLDB[i]
+	if db != nil {
 		s.MySQLDB[i] = nil
 		s.LinkNum -= 1
 		if s.LinkNum < 0 {
 			s.LinkNum = 0
 		}
+	}
+	conns := s.LinkNum
+	s.poolMu.Unlock()
+	if db != nil {
+		db.Close()
 		option := &Option{
 			IsShowPrint: false,
 		}
 		for _, o := range options {
 			o(option)
 		}
-		if option.IsShowPrint {
-			println("MySQL Close Connection! Current number of connections:", s.LinkNum)
+		logger := option.logger
+		if logger == nil {
+			logger = s.logger(nil, option.IsShowPrint)
 		}
+		logger.Debug("mysql close", "conns", conns)
 	}
 }
 
 // ===============
 //
 //	根据 *MysqlDB 查询
+//	i		int				"数据库在配置中的位置"
+//	meta		stmtMeta			"语句的描述"
 //	sqlStr		string				"SQL 语句"
+//	args		[]interface{}			"绑定的参数"
 //	reqd		chan []map[string]string	"查询结果"
 //	reerr		chan error			"错误信息"
-//	Debug		*log.Logger			"Debug 日志对象"
+//	readPrimary	bool				"是否只从主库读取"
+//	logger		*slog.Logger			"日志对象"
 //
 // ===============
 //
 //	According to *MysqlDB query
+//	i		int				"Location of the database in
+//													the configuration"
+//	meta		stmtMeta			"Description of the statement"
 //	sqlStr		string				"SQL statement"
+//	args		[]interface{}			"Bound arguments"
 //	reqd		chan []map[string]string	"query result"
 //	reerr		chan error			"error message"
-//	Debug		*log.Logger			"Debug log object"
-func (s *Setting) go_query(i int, sqlStr string, reqd chan []map[string]string, reerr chan error, IsShowPrint bool, Debug *log.Logger) {
-	mI, err := s.MysqlIsRun(i, OLIsShowPrint(IsShowPrint))
+//	readPrimary	bool				"Whether to read only
+//													from the primary"
+//	logger		*slog.Logger			"Logger"
+func (s *Setting) go_query(ctx context.Context, i int, meta stmtMeta, sqlStr string, args []interface{}, reqd chan []map[string]string, reerr chan error, readPrimary bool, logger *slog.Logger) {
+	ctx, span := s.startStmt(ctx, i, meta, sqlStr)
+	mI, err := s.MysqlIsRun(i, olLogger(logger), OLReadOnly(!readPrimary))
 	if err != nil {
+		endSpan(span, err)
 		s.MysqlClose(mI)
 		reqd <- nil
-		reerr <- err
+		reerr <- s.shardError(i, "", err)
 		return
 	}
-	qd, err := s.MySQLDB[mI].QueryCMD(sqlStr, Debug, OIsShowPrint(IsShowPrint))
-	s.MysqlClose(mI, OIsShowPrint(IsShowPrint))
+	db := s.MySQLDB[mI]
+	span.SetAttributes(attribute.Int("wesubdb.replica", db.Replica))
+	stmt := db.statement(ctx, meta, sqlStr, args, true)
+	err = db.run(stmt, s.observeHook(logger), s.slowQueryHook(db, logger))
+	endSpan(span, err)
+	s.reportQuery(db, err)
+	s.MysqlClose(mI, IsShowPrintO(olLogger(logger)))
+	qd := stmt.Rows
 	reqd <- qd
-	reerr <- err
+	reerr <- s.shardError(i, sqlStr, err)
 }
 
 // ===============
@@ -244,6 +369,7 @@ func (s *Setting) go_query(i int, sqlStr string, reqd chan []map[string]string,
 //	Debug		*log.Logger		"调试输出"
 //	options		[]IsShowPrintO		"配置"
 //		IsShowPrint	bool			"是否输出到控制台"
+//		Args		[]interface{}		"绑定的参数"
 //	return 1	[]map[string]string	"查询结果"
 //	return 2	[]error			"错误信息"
 //
@@ -255,6 +381,7 @@ func (s *Setting) go_query(i int, sqlStr string, reqd chan []map[string]string,
 //	options		[]IsShowPrintO		"Configuration"
 //		IsShowPrint	bool			"Whether to output to the
 //											console"
+//		Args		[]interface{}		"Bound arguments"
 //	return 1	[]map[string]string	"Query result"
 //	return 2	[]error			"Error message"
 func (s *MysqlDB) QueryCMD(sqlStr string, Debug *log.Logger, options ...IsShowPrintO) ([]map[string]string, error) {
@@ -264,58 +391,76 @@ func (s *MysqlDB) QueryCMD(sqlStr string, Debug *log.Logger, options ...IsShowPr
 	for _, o := range options {
 		o(option)
 	}
-	if Debug != nil {
-		Debug.Println("[Query]", sqlStr)
-	}
-	if option.IsShowPrint {
-		fmt.Println("[Query]", sqlStr)
-	}
-	query, err := s.DB.Query(sqlStr)
+	stmt := s.statement(option.getContext(), stmtMeta{op: "query"}, sqlStr, option.Args, true)
+	err := s.run(stmt, logHook(newLogger(nil, Debug, option.IsShowPrint)))
+	return stmt.Rows, err
+}
+
+func (s *MysqlDB) query(ctx context.Context, sqlStr string, args ...interface{}) ([]map[string]string, error) {
+	query, err := s.DB.QueryContext(ctx, sqlStr, args...)
 	if err != nil {
-		if Debug != nil {
-			Debug.Println("MySQL Query Error:", err)
-		}
 		return nil, err
 	}
-	return handleQD(query, Debug)
+	return handleQD(query, nil)
 }
 
 // ===============
 //
 //	根据 *MysqlDB 调用单行SQL查询指令
+//	i		int				"数据库在配置中的位置"
+//	meta		stmtMeta			"语句的描述"
 //	sqlStr		string				"SQL 语句"
-//	reqd		chan []map[string]string	"查询结果"
+//	args		[]interface{}			"绑定的参数"
+//	reLIid		chan int64			"最后插入的ID, 可以为 nil"
+//	reRA		chan int64			"影响的行数, 可以为 nil"
 //	reerr		chan error			"错误信息"
-//	isShowPrint	bool				"是否输出到控制台"
-//	Debug		*log.Logger			"Debug 日志对象"
+//	logger		*slog.Logger			"日志对象"
 //
 // ===============
 //
 //	According to *MysqlDB query
+//	i		int				"Location of the database in
+//													the configuration"
+//	meta		stmtMeta			"Description of the statement"
 //	sqlStr		string				"SQL statement"
-//	reqd		chan []map[string]string	"query result"
+//	args		[]interface{}			"Bound arguments"
+//	reLIid		chan int64			"Last insert ID, can be nil"
+//	reRA		chan int64			"Rows affected, can be nil"
 //	reerr		chan error			"error message"
-//	isShowPrint	bool				"Whether to output
-//													to the console"
-//	Debug		*log.Logger			"Debug log object"
-func (s *Setting) go_exec(i int, sqlStr string, reLIid chan int64, reRA chan int64, reerr chan error, isShowPrint bool, Debug *log.Logger) {
-	mI, err := s.MysqlIsRun(i)
+//	logger		*slog.Logger			"Logger"
+func (s *Setting) go_exec(ctx context.Context, i int, meta stmtMeta, sqlStr string, args []interface{}, reLIid chan int64, reRA chan int64, reerr chan error, logger *slog.Logger) {
+	ctx, span := s.startStmt(ctx, i, meta, sqlStr)
+	mI, err := s.MysqlIsRun(i, olLogger(logger))
 	if err != nil {
+		endSpan(span, err)
 		s.MysqlClose(mI)
-		reLIid <- 0
-		reRA <- 0
-		reerr <- err
+		if reLIid != nil {
+			reLIid <- 0
+		}
+		if reRA != nil {
+			reRA <- 0
+		}
+		reerr <- s.shardError(i, "", err)
 		return
 	}
-	lastInsertId, rowsAffected, err := s.MySQLDB[mI].ExecCMD(sqlStr, Debug, OIsShowPrint(isShowPrint))
-	s.MysqlClose(mI)
+	db := s.MySQLDB[mI]
+	stmt := db.statement(ctx, meta, sqlStr, args, false)
+	err = db.run(stmt, s.observeHook(logger), s.slowQueryHook(db, logger))
+	lastInsertId, rowsAffected := stmt.LastInsertId, stmt.RowsAffected
+	span.SetAttributes(attribute.Int64("db.rows_affected", rowsAffected))
+	endSpan(span, err)
+	s.reportQuery(db, err)
+	s.MysqlClose(mI, IsShowPrintO(olLogger(logger)))
+	if err == nil {
+		s.markWrite(i)
+	}
 	if reLIid != nil {
 		reLIid <- lastInsertId
 	}
 	if reRA != nil {
 		reRA <- rowsAffected
 	}
-	reerr <- err
+	reerr <- s.shardError(i, sqlStr, err)
 }
 
 // ===============
@@ -325,6 +470,7 @@ func (s *Setting) go_exec(i int, sqlStr string, reLIid chan int64, reRA chan int
 //	Debug		*log.Logger		"调试输出"
 //	options		[]IsShowPrintO		"配置"
 //		IsShowPrint	bool			"是否输出到控制台"
+//		Args		[]interface{}		"绑定的参数"
 //	return 1	int64			"插入的行数"
 //	return 2	int64			"影响的行数"
 //	return 3	[]error			"错误信息"
@@ -337,6 +483,7 @@ func (s *Setting) go_exec(i int, sqlStr string, reLIid chan int64, reRA chan int
 //	options		[]IsShowPrintO		"Configuration"
 //	isShowPrint	bool			"Whether to output
 //											to the console"
+//		Args		[]interface{}		"Bound arguments"
 //	return 1	int64			"Number of rows inserted"
 //	return 2	int64			"Number of rows affected"
 //	return 3	[]error			"Error message"
@@ -347,37 +494,28 @@ func (s *MysqlDB) ExecCMD(sqlStr string, Debug *log.Logger, options ...IsShowPri
 	for _, o := range options {
 		o(option)
 	}
-	if Debug != nil {
-		Debug.Println("[ExecCMD]", sqlStr)
-	}
-	if option.IsShowPrint {
-		fmt.Println("[ExecCMD]", sqlStr)
-	}
+	stmt := s.statement(option.getContext(), stmtMeta{op: "exec"}, sqlStr, option.Args, false)
+	err := s.run(stmt, logHook(newLogger(nil, Debug, option.IsShowPrint)))
+	return stmt.LastInsertId, stmt.RowsAffected, err
+}
+
+func (s *MysqlDB) exec(ctx context.Context, sqlStr string, args ...interface{}) (int64, int64, error) {
 	var (
 		lastInsertId int64 = 0
 		rowsAffected int64 = 0
 		err          error = nil
 	)
-	result, err := s.DB.Exec(sqlStr)
+	result, err := s.DB.ExecContext(ctx, sqlStr, args...)
 	if err != nil {
-		if Debug != nil {
-			Debug.Println("MySQL Query Error:", err)
-		}
 		return lastInsertId, rowsAffected, err
 	}
 	lastInsertId, err = result.LastInsertId()
 	if err != nil {
-		if Debug != nil {
-			Debug.Println("lastInsertId Error:", err)
-		}
-		return lastInsertId, rowsAffected, err
+		return lastInsertId, rowsAffected, fmt.Errorf("lastInsertId Error: %w", err)
 	}
 	rowsAffected, err = result.RowsAffected()
 	if err != nil {
-		if Debug != nil {
-			Debug.Println("rowsAffected Error:", err)
-		}
-		return lastInsertId, rowsAffected, err
+		return lastInsertId, rowsAffected, fmt.Errorf("rowsAffected Error: %w", err)
 	}
 	return lastInsertId, rowsAffected, err
 }
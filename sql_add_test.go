@@ -1,6 +1,7 @@
 package weSubDatabase
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"testing"
@@ -69,10 +70,67 @@ func TestSQLAddForPrimary(t *testing.T) {
 	sqlSetting.MysqlClose(mI)
 	println("MySQL test link success")
 
-	inserts, errs := sqlSetting.AddForPrimary("data", []string{"ZXyvqh", "ZXyvri", "ZXyvsf", "ZXyvtg", "ZXyvuh", "ZXyvvi", "ZXywmf", "ZXywng", "ZXywoh", "ZXywpi"}, []string{"id", "data"}, [][]string{{"1", "t1"}, {"2", "t2"}, {"3", "t3"}, {"4", "t4"}, {"5", "t5"}, {"6", "t6"}, {"7", "t7"}, {"8", "t8"}, {"9", "t9"}, {"10", "t10"}}, nil, OIsShowPrint(true))
+	var added []AddedRow
+	inserts, errs := sqlSetting.AddForPrimary("data", []string{"ZXyvqh", "ZXyvri", "ZXyvsf", "ZXyvtg", "ZXyvuh", "ZXyvvi", "ZXywmf", "ZXywng", "ZXywoh", "ZXywpi"}, []string{"id", "data"}, [][]string{{"1", "t1"}, {"2", "t2"}, {"3", "t3"}, {"4", "t4"}, {"5", "t5"}, {"6", "t6"}, {"7", "t7"}, {"8", "t8"}, {"9", "t9"}, {"10", "t10"}}, nil, OIsShowPrint(true), OAddedRows(&added))
 	if errs != nil {
 		fmt.Println(errs)
 		return
 	}
 	fmt.Println("inserts", inserts)
+	if len(added) != 10 || added[0].EncryptedID != "ZXyvqh" {
+		t.Error("added rows:", added)
+	}
+}
+
+func TestChunkRows(t *testing.T) {
+	values := [][]string{{"aaaa"}, {"bb"}, {"cc"}, {"dddddddd"}, {"e"}}
+	items := []int{0, 1, 2, 3, 4}
+	for _, c := range []struct {
+		maxRows, maxBytes int
+		want              string
+	}{
+		{0, 0, "[[0 1 2 3 4]]"},
+		{2, 0, "[[0 1] [2 3] [4]]"},
+		{0, 6, "[[0 1] [2] [3] [4]]"},
+		{2, 8, "[[0 1] [2] [3] [4]]"},
+	} {
+		if got := fmt.Sprint(chunkRows(items, values, c.maxRows, c.maxBytes)); got != c.want {
+			t.Error(c.maxRows, c.maxBytes, got)
+		}
+	}
+	wide := make([][]string, 10000)
+	items = make([]int, len(wide))
+	for i := range wide {
+		wide[i] = make([]string, 7)
+		items[i] = i
+	}
+	if chunks := chunkRows(items, wide, 0, 0); len(chunks) != 2 || len(chunks[0]) != maxPlaceholders/7 {
+		t.Error("placeholder limit:", len(chunks))
+	}
+
+	s := &Setting{}
+	r := AddedRow{Shard: 1}
+	r.setID(s, 10, 2)
+	if r.ID != 12 || r.EncryptedID != "" {
+		t.Error("set id:", r)
+	}
+}
+
+func TestAutoIncrementConsecutive(t *testing.T) {
+	for mode, want := range map[int]bool{-1: false, 0: true, 1: true, 2: false} {
+		if got := (autoIncrement{lockMode: mode}).consecutive(); got != want {
+			t.Error("lock mode", mode, got)
+		}
+	}
+}
+
+func TestCheckValues(t *testing.T) {
+	// 值作为参数绑定, 引号和注释是普通的值
+	// Values are bound as arguments, quotes and comments are plain values
+	if err := checkValues([]string{"name"}, [][]string{{"' OR 1=1 --"}, {"a;b"}}); err != nil {
+		t.Error("bound values:", err)
+	}
+	if err := checkValues([]string{"name"}, [][]string{{"a", "b"}}); !errors.Is(err, ErrInvalidArgument) {
+		t.Error("length:", err)
+	}
 }
@@ -0,0 +1,70 @@
+package weSubDatabase
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPartialPolicy(t *testing.T) {
+	report := &ShardReport{Answered: []int{0, 2}, Skipped: []int{3}, Failed: []int{1}}
+	if report.Complete() {
+		t.Error("report with missing databases is not complete")
+	}
+	if !reflect.DeepEqual(report.Missing(), []int{1, 3}) {
+		t.Error("missing databases:", report.Missing())
+	}
+
+	option := &Option{}
+	if err := option.checkPartial(report); err != nil {
+		t.Error("PartialAllow should not fail:", err)
+	}
+	OPartialPolicy(PartialFail)(option)
+	if err := option.checkPartial(report); !errors.Is(err, ErrIncompleteResult) {
+		t.Error("PartialFail should fail:", err)
+	}
+	OQuorum(2)(option)
+	if err := option.checkPartial(report); err != nil {
+		t.Error("quorum of 2 should be reached:", err)
+	}
+	OQuorum(0)(option)
+	if err := option.checkPartial(report); !errors.Is(err, ErrIncompleteResult) {
+		t.Error("majority of 4 databases should not be reached:", err)
+	}
+}
+
+func TestQuerySkippedShards(t *testing.T) {
+	sqlSetting, err := New(testJsonStr)
+	if err != nil {
+		t.Error("initialization failed:", err)
+		return
+	}
+	tn := time.Now()
+	for i := range sqlSetting.ConnectFailTime {
+		sqlSetting.ConnectFailTime[i] = &tn
+	}
+
+	var report ShardReport
+	qd, errs := sqlSetting.Query("data", "*", "id", "", "`id` DESC", "10", nil, OPartialPolicy(PartialFail), OShardReport(&report))
+	if qd != nil || len(errs) != 1 || !errors.Is(errs[0], ErrIncompleteResult) {
+		t.Error("Query should fail when databases are skipped:", qd, errs)
+	}
+	if !reflect.DeepEqual(report.Skipped, []int{0, 1, 2, 3}) {
+		t.Error("skipped databases:", report.Skipped)
+	}
+
+	report = ShardReport{}
+	qd, errs = sqlSetting.Query("data", "*", "id", "", "`id` DESC", "10", nil, OShardReport(&report))
+	if len(qd) != 0 || errs != nil {
+		t.Error("Query should return an empty partial result:", qd, errs)
+	}
+	if report.Complete() || len(report.Answered) != 0 {
+		t.Error("report:", report)
+	}
+
+	_, _, err = sqlSetting.SelectLastID("data", "id", nil, OQuorum(1))
+	if !errors.Is(err, ErrIncompleteResult) {
+		t.Error("SelectLastID should fail without a quorum:", err)
+	}
+}
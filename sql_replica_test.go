@@ -0,0 +1,61 @@
+package weSubDatabase
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPickReplicas(t *testing.T) {
+	sqlSetting, err := New(testJsonStr)
+	if err != nil {
+		t.Error("initialization failed:", err)
+		return
+	}
+	sqlSetting.SqlConfigs[0].Replicas = []ReplicaConfig{
+		{Address: "127.0.0.2", Weight: 3},
+		{Address: "127.0.0.3", Weight: 1},
+		{Address: "127.0.0.4"},
+	}
+	sqlSetting.ReplicaFailTime[0] = make([]*time.Time, 3)
+	sqlSetting.initBreakers()
+
+	first := map[int]int{}
+	for i := 0; i < 1000; i++ {
+		order := sqlSetting.pickReplicas(0)
+		if len(order) != 3 {
+			t.Fatal("replica order:", order)
+		}
+		first[order[0]]++
+	}
+	if first[0] <= first[1] || first[0] <= first[2] {
+		t.Error("weighted replica should be picked first most often:", first)
+	}
+
+	tn := time.Now()
+	sqlSetting.ReplicaFailTime[0][0] = &tn
+	for _, r := range sqlSetting.pickReplicas(0) {
+		if r == 0 {
+			t.Error("failed replica should be skipped")
+		}
+	}
+}
+
+func TestReadYourWrites(t *testing.T) {
+	sqlSetting, err := New(testJsonStr)
+	if err != nil {
+		t.Error("initialization failed:", err)
+		return
+	}
+	sqlSetting.markWrite(1)
+	if sqlSetting.isPinnedToPrimary(1) {
+		t.Error("read your writes is disabled by default")
+	}
+	sqlSetting.ReadYourWritesTime = 1000
+	sqlSetting.markWrite(1)
+	if !sqlSetting.isPinnedToPrimary(1) {
+		t.Error("database 1 should be pinned to the primary after a write")
+	}
+	if sqlSetting.isPinnedToPrimary(0) {
+		t.Error("database 0 has not been written")
+	}
+}
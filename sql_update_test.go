@@ -1,6 +1,7 @@
 package weSubDatabase
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 )
@@ -44,3 +45,57 @@ func TestUpdateForKey(t *testing.T) {
 	}
 	fmt.Println("Update success! RowsAffected:", rowsAffected)
 }
+
+func TestVersionSQL(t *testing.T) {
+	sqlStr, err := versionSQL("data", []string{"time", "data"}, "id", "version", VersionCounter)
+	if err != nil || sqlStr != "UPDATE `data` SET `time`=?,`data`=?,`version`=`version`+1 WHERE `id`=? AND `version`=?" {
+		t.Error("counter:", sqlStr, err)
+	}
+	sqlStr, err = versionSQL("data", []string{"data"}, "id", "updated_at", VersionTimestamp)
+	if err != nil || sqlStr != "UPDATE `data` SET `data`=?,`updated_at`=NOW(6) WHERE `id`=? AND `updated_at`=?" {
+		t.Error("timestamp:", sqlStr, err)
+	}
+	if err := timestampPrecision("updated_at", "DATETIME", "6"); err != nil {
+		t.Error("DATETIME(6):", err)
+	}
+	if err := timestampPrecision("updated_at", "timestamp", "6"); err != nil {
+		t.Error("TIMESTAMP(6):", err)
+	}
+	if err := timestampPrecision("updated_at", "datetime", "0"); !errors.Is(err, ErrInvalidArgument) {
+		t.Error("DATETIME:", err)
+	}
+	if err := timestampPrecision("updated_at", "bigint", ""); !errors.Is(err, ErrInvalidArgument) {
+		t.Error("BIGINT:", err)
+	}
+	if _, err := versionSQL("data", []string{"version"}, "id", "version", VersionCounter); err == nil {
+		t.Error("version column set by caller should fail")
+	}
+	if ErrorType(fmt.Errorf("%w: ids[0]", ErrVersionConflict)) != "version_conflict" {
+		t.Error("error type")
+	}
+}
+
+func TestUpdateRowsSQL(t *testing.T) {
+	rows := []RowUpdate{
+		{ID: "a", Values: map[string]string{"name": "A"}},
+		{ID: "b", Values: map[string]string{"status": "1"}},
+		{ID: "a", Values: map[string]string{"name": "A2", "status": "2"}},
+	}
+	merged := mergeRowUpdates([]string{"1", "2", "1"}, []int{0, 1, 2}, rows)
+	if len(merged) != 2 || merged[0].Values["name"] != "A2" || merged[0].Values["status"] != "2" {
+		t.Fatal("merge:", merged)
+	}
+	sqlStr, args := updateRowsSQL("data", "id", merged)
+	want := "UPDATE `data` SET `name`=CASE `id` WHEN ? THEN ? ELSE `name` END,`status`=CASE `id` WHEN ? THEN ? WHEN ? THEN ? ELSE `status` END WHERE `id` IN (?,?)"
+	if sqlStr != want || fmt.Sprint(args) != "[1 A2 1 2 2 1 1 2]" {
+		t.Error("sql:", sqlStr, args)
+	}
+
+	big := make([]RowUpdate, 40000)
+	for i := range big {
+		big[i] = RowUpdate{ID: fmt.Sprint(i), Values: map[string]string{"name": "x"}}
+	}
+	if chunks := chunkRowUpdates(big); len(chunks) != 2 || len(chunks[0]) != maxPlaceholders/3 {
+		t.Error("chunks:", len(chunks), len(chunks[0]))
+	}
+}
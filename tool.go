@@ -5,7 +5,6 @@ import (
 	"math"
 	"regexp"
 	"strconv"
-	"time"
 )
 
 // ===============
@@ -83,7 +82,7 @@ func (s *Setting) DecryptID(primaryKey string, ids []string) ([]bool, [][]string
 			continue
 		}
 		dbIint, err := strconv.Atoi(dbI)
-		if err != nil {
+		if err != nil || dbIint < 0 || dbIint >= len(s.SqlConfigs) {
 			continue
 		}
 		dbIList[dbIint] = true
@@ -96,27 +95,36 @@ func (s *Setting) DecryptID(primaryKey string, ids []string) ([]bool, [][]string
 // ===============
 //
 //	判断是否可以连接数据库
+//	熔断器打开时返回 false, 退避时间结束后允许一次试探
 //	item		int	"需要连接的数据库在配置中的位置"
 //	return 1	bool	"是否可以尝试连接"
 //
 // ===============
 //
 //	Determine whether the database can be connected
+//	false is returned while the circuit breaker is open, one trial is
+//	allowed after the backoff time
 //	item		int	"Location of the database to be
 //					 	connected in the configuration"
 //	return 1	bool	"Whether to try to connect"
 func (s *Setting) IsRetryConnect(item int) bool {
-	if s.ConnectFailTime[item] != nil {
-		tn := time.Now()
-		tend := s.ConnectFailTime[item].Add(time.Millisecond * time.Duration(s.ConnectAgainTime))
-		if tn.After(tend) {
-			s.ConnectFailTime[item] = nil
-			return true
-		} else {
-			return false
-		}
-	}
-	return true
+	return s.allowEndpoint(EndpointMySQL, item, -1)
+}
+
+// ===============
+//
+//	判断是否可以连接Redis数据库
+//	item		int	"需要连接的数据库在配置中的位置"
+//	return 1	bool	"是否可以尝试连接"
+//
+// ===============
+//
+//	Determine whether the Redis database can be connected
+//	item		int	"Location of the database to be
+//					 	connected in the configuration"
+//	return 1	bool	"Whether to try to connect"
+func (s *Setting) IsRetryRedis(item int) bool {
+	return s.allowEndpoint(EndpointRedis, item, -1)
 }
 
 // ===============
@@ -149,6 +157,9 @@ func toLimit(nextDBID int, pageNumber string, limit int, isContinues []bool) []s
 			linkDBCount += 1
 		}
 	}
+	if linkDBCount == 0 {
+		return limitList
+	}
 	maxDBNum := len(isContinues)
 	f := math.Floor(float64(limit) / float64(linkDBCount))
 	remainder := limit % linkDBCount
@@ -0,0 +1,208 @@
+package weSubDatabase
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/0wew0-gh/weSubDatabase"
+
+// ===============
+//
+//	设置调用方的 context.Context, 用于链路追踪
+//	Context		context.Context	"上下文"
+//
+// ===============
+//
+//	Set the context.Context of the caller, used for tracing
+//	Context		context.Context	"Context"
+func OContext(Context context.Context) IsShowPrintO {
+	return func(o *Option) {
+		o.Context = Context
+	}
+}
+
+// ===============
+//
+//	设置调用方的 context.Context, 用于链路追踪
+//	Context		context.Context	"上下文"
+//
+// ===============
+//
+//	Set the context.Context of the caller, used for tracing
+//	Context		context.Context	"Context"
+func OIPKContext(Context context.Context) IsPrimaryKeyO {
+	return func(o *Option) {
+		o.Context = Context
+	}
+}
+
+// ===============
+//
+//	设置调用方的 context.Context, 用于链路追踪和取消 Redis 命令
+//	Context		context.Context	"上下文"
+//
+// ===============
+//
+//	Set the context.Context of the caller, used for tracing and for
+//	cancelling Redis commands
+//	Context		context.Context	"Context"
+func OLRedisContext(Context context.Context) RedisO {
+	return func(o *Option) {
+		o.Context = Context
+	}
+}
+
+// 调用方的上下文, 没有设置时为 context.Background()
+//
+// Context of the caller, context.Background() when not set
+func (o *Option) getContext() context.Context {
+	if o.Context == nil {
+		return ctx
+	}
+	return o.Context
+}
+
+// TracerProvider 为 nil 时使用全局的 otel.GetTracerProvider()
+//
+// The global otel.GetTracerProvider() is used when TracerProvider is nil
+func (s *Setting) tracer() trace.Tracer {
+	tp := s.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(tracerName)
+}
+
+// ===============
+//
+//	开始一次公开调用的父 span
+//	option		*Option		"配置, 使用其中的 Context"
+//	name		string		"方法名, 如 Query"
+//	table		string		"表名"
+//	return 1	context.Context	"包含 span 的上下文"
+//	return 2	trace.Span	"span"
+//
+// ===============
+//
+//	Start the parent span of a public call
+//	option		*Option		"Configuration, its Context is used"
+//	name		string		"Method name, such as Query"
+//	table		string		"Table name"
+//	return 1	context.Context	"Context containing the span"
+//	return 2	trace.Span	"span"
+func (s *Setting) startCall(option *Option, name string, table string) (context.Context, trace.Span) {
+	return s.tracer().Start(option.getContext(), "weSubDatabase."+name,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			attribute.String("db.system", "mysql"),
+			attribute.String("db.sql.table", table),
+		),
+	)
+}
+
+// ===============
+//
+//	开始一条SQL语句的子 span
+//	ctx		context.Context	"父 span 的上下文"
+//	shard		int		"数据库在配置中的位置"
+//	meta		stmtMeta	"语句的描述"
+//	sqlStr		string		"SQL指令, 值会被隐去"
+//	return 1	context.Context	"包含 span 的上下文"
+//	return 2	trace.Span	"span"
+//
+// ===============
+//
+//	Start the child span of a SQL statement
+//	ctx		context.Context	"Context of the parent span"
+//	shard		int		"Location of the database in the configuration"
+//	meta		stmtMeta	"Description of the statement"
+//	sqlStr		string		"SQL instruction, values are redacted"
+//	return 1	context.Context	"Context containing the span"
+//	return 2	trace.Span	"span"
+func (s *Setting) startStmt(ctx context.Context, shard int, meta stmtMeta, sqlStr string) (context.Context, trace.Span) {
+	name := ""
+	if shard >= 0 && shard < len(s.SqlConfigs) {
+		name = s.SqlConfigs[shard].DB
+	}
+	return s.tracer().Start(ctx, meta.op+" "+name+"."+meta.table,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "mysql"),
+			attribute.String("db.name", name),
+			attribute.String("db.operation", meta.op),
+			attribute.String("db.sql.table", meta.table),
+			attribute.String("db.statement", redactSQL(sqlStr)),
+			attribute.Int("wesubdb.shard", shard),
+		),
+	)
+}
+
+// 结束 span, 有错误时记录错误, Errors 需要先调用 Err() 转换
+//
+// End the span, the error is recorded, Errors must be converted with Err() first
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// 为 Redis 命令生成 span
+//
+// Create spans for Redis commands
+type redisTracingHook struct {
+	s    *Setting
+	item int
+}
+
+func (h redisTracingHook) start(ctx context.Context, name string) context.Context {
+	ctx, _ = h.s.tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "redis"),
+			attribute.String("db.operation", name),
+			attribute.Int("wesubdb.shard", h.item),
+		),
+	)
+	return ctx
+}
+
+func (h redisTracingHook) end(ctx context.Context, err error) {
+	if errors.Is(err, redis.Nil) {
+		err = nil
+	}
+	endSpan(trace.SpanFromContext(ctx), err)
+}
+
+func (h redisTracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return h.start(ctx, cmd.Name()), nil
+}
+
+func (h redisTracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	h.end(ctx, cmd.Err())
+	return nil
+}
+
+func (h redisTracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return h.start(ctx, "pipeline"), nil
+}
+
+func (h redisTracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmd.Err() != nil && !errors.Is(cmd.Err(), redis.Nil) {
+			err = cmd.Err()
+			break
+		}
+	}
+	h.end(ctx, err)
+	return nil
+}
@@ -0,0 +1,67 @@
+package weSubDatabase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	sqlSetting, err := New(testJsonStr)
+	if err != nil {
+		t.Error("initialization failed:", err)
+		return
+	}
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	sqlSetting.TracerProvider = provider
+
+	tn := time.Now()
+	for i := 1; i < len(sqlSetting.ConnectFailTime); i++ {
+		sqlSetting.ConnectFailTime[i] = &tn
+	}
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "caller")
+	sqlSetting.Query("data", "*", "id", "", "`id` DESC", "10", nil, OContext(ctx))
+	parent.End()
+
+	spans := exporter.GetSpans()
+	var call, stmt *tracetest.SpanStub
+	for i := range spans {
+		switch spans[i].Name {
+		case "weSubDatabase.Query":
+			call = &spans[i]
+		case "query " + sqlSetting.SqlConfigs[0].DB + ".data":
+			stmt = &spans[i]
+		}
+	}
+	if call == nil || stmt == nil {
+		t.Error("missing spans:", spans.Snapshots())
+		return
+	}
+	if call.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Error("call span should be a child of the caller span")
+	}
+	if stmt.Parent.SpanID() != call.SpanContext.SpanID() {
+		t.Error("statement span should be a child of the call span")
+	}
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range stmt.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	if attrs["db.system"].AsString() != "mysql" || attrs["db.name"].AsString() != sqlSetting.SqlConfigs[0].DB ||
+		attrs["db.statement"].AsString() != "SELECT * FROM `data` ORDER BY `id` DESC LIMIT ?" {
+		t.Error("statement span attributes:", stmt.Attributes)
+	}
+
+	exporter.Reset()
+	sqlSetting.Query("data", "*", "id", "", "`id` DESC", "10", nil, OPartialPolicy(PartialFail))
+	spans = exporter.GetSpans()
+	if len(spans) != 1 || spans[0].Status.Code != codes.Error {
+		t.Error("skipped databases with PartialFail should mark the call span as failed:", spans.Snapshots())
+	}
+}
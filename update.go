@@ -19,8 +19,11 @@ import (
 //	options			[]UpdateOptionConfig	"配置"
 //		IsPrimaryKey	bool			"是否使用主键"
 //		IsShowPrint		bool			"是否输出到控制台"
+//		Context		context.Context		"调用方的上下文"
+//		VersionColumn	string			"乐观锁的版本字段, 见 OIPKVersion"
+//		Conflicts	*[]string		"接收版本不一致的 ids"
 //	return 1		[]int64			"更新的行数"
-//	return 2		error			"错误信息"
+//	return 2		Errors			"错误信息"
 //
 // ===============
 //
@@ -36,10 +39,15 @@ import (
 //													primary key"
 //		IsShowPrint		bool			"Whether to output
 //													to the console"
+//		Context		context.Context		"Context of the caller"
+//		VersionColumn	string			"Version column of optimistic
+//													locking, see OIPKVersion"
+//		Conflicts	*[]string		"Receives the ids whose
+//													version did not match"
 //	return 1		[]int64			"Number of rows
 //													updated"
-//	return 2		error			"Error message"
-func (s *Setting) Update(table string, key []string, value [][]string, forKey string, ids []string, Debug *log.Logger, options ...IsPrimaryKeyO) ([]int64, []error) {
+//	return 2		Errors			"Error message"
+func (s *Setting) Update(table string, key []string, value [][]string, forKey string, ids []string, Debug *log.Logger, options ...IsPrimaryKeyO) (reInt []int64, errs Errors) {
 	option := &Option{
 		IsPrimaryKey: true,
 		IsShowPrint:  false,
@@ -47,6 +55,9 @@ func (s *Setting) Update(table string, key []string, value [][]string, forKey st
 	for _, o := range options {
 		o(option)
 	}
+	logger := s.logger(Debug, option.IsShowPrint)
+	ctx, span := s.startCall(option, "Update", table)
+	defer func() { endSpan(span, errs.Err()) }()
 
 	valueLen := 0
 	for i := 0; i < len(value); i++ {
@@ -56,23 +67,24 @@ func (s *Setting) Update(table string, key []string, value [][]string, forKey st
 				continue
 			}
 			if valueLen != len(value[i]) {
-				return nil, []error{fmt.Errorf("inconsistent quantity of 'value'")}
+				return nil, Errors{fmt.Errorf("%w: inconsistent quantity of 'value'", ErrInvalidArgument)}
 			}
 		}
 	}
 	if len(key) != len(value) {
-		return nil, []error{fmt.Errorf("inconsistent quantity of 'key' and 'value'")}
+		return nil, Errors{fmt.Errorf("%w: inconsistent quantity of 'key' and 'value'", ErrInvalidArgument)}
 	}
 	if len(ids) != valueLen {
-		return nil, []error{fmt.Errorf("inconsistent quantity of 'key' and 'ids'")}
+		return nil, Errors{fmt.Errorf("%w: inconsistent quantity of 'key' and 'ids'", ErrInvalidArgument)}
+	}
+	if forKey == "" {
+		return nil, Errors{fmt.Errorf("%w: 'forKey' is empty", ErrInvalidArgument)}
 	}
 
 	var (
 		dbIList  []bool
 		idList   [][]string
 		itemList [][]int
-		reInt    []int64
-		errs     []error
 	)
 	if option.IsPrimaryKey {
 		dbIList, idList, itemList = s.DecryptID(forKey, ids)
@@ -87,20 +99,22 @@ func (s *Setting) Update(table string, key []string, value [][]string, forKey st
 			itemList = append(itemList, items)
 		}
 	}
+	if option.VersionColumn != "" {
+		return s.updateVersioned(ctx, table, key, value, forKey, ids, dbIList, idList, itemList, option, logger)
+	}
 	for i := 0; i < len(s.SqlConfigs); i++ {
 		reInt = append(reInt, -1)
-		errs = append(errs, nil)
 	}
 	var wg sync.WaitGroup
 	for sqlI := 0; sqlI < len(s.SqlConfigs); sqlI++ {
-		if !s.IsRetryConnect(sqlI) {
+		if !dbIList[sqlI] {
 			continue
 		}
-		wg.Add(1)
-		if !dbIList[sqlI] {
-			wg.Done()
+		if !s.IsRetryConnect(sqlI) {
+			errs = append(errs, s.shardError(sqlI, "", ErrShardUnavailable))
 			continue
 		}
+		wg.Add(1)
 		sqlStr := "UPDATE `" + table + "` SET"
 		setStr := ""
 		idStr := ""
@@ -130,14 +144,10 @@ func (s *Setting) Update(table string, key []string, value [][]string, forKey st
 			idStr += "'" + idList[sqlI][i] + "'"
 		}
 		sqlStr += setStr + " WHERE `" + forKey + "` IN (" + idStr + ")"
-		if forKey == "" {
-			wg.Done()
-			continue
-		}
 
 		chanRA := make(chan int64)
 		chanErr := make(chan error)
-		go s.go_exec(sqlI, sqlStr, nil, chanRA, chanErr, option.IsShowPrint, Debug)
+		go s.go_exec(ctx, sqlI, stmtMeta{op: "update", table: table}, sqlStr, nil, nil, chanRA, chanErr, logger)
 		rRA := false
 		rE := false
 		for {
@@ -150,7 +160,9 @@ func (s *Setting) Update(table string, key []string, value [][]string, forKey st
 				}
 			case reErr := <-chanErr:
 				if !rE {
-					errs = append(errs, reErr)
+					if reErr != nil {
+						errs = append(errs, reErr)
+					}
 					close(chanErr)
 					rE = true
 				}
@@ -163,10 +175,8 @@ func (s *Setting) Update(table string, key []string, value [][]string, forKey st
 		wg.Done()
 	}
 	wg.Wait()
-	for _, v := range errs {
-		if v != nil {
-			return nil, errs
-		}
+	if len(errs) > 0 {
+		return reInt, errs
 	}
 	return reInt, nil
 }
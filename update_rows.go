@@ -0,0 +1,247 @@
+package weSubDatabase
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// MySQL 一条语句最多绑定的参数数目
+//
+// Maximum number of arguments bound to one MySQL statement
+const maxPlaceholders = 65535
+
+// UpdateRows 中的一行
+//
+// One row of UpdateRows
+type RowUpdate struct {
+	//	主键, IsPrimaryKey 时为加密后的主键
+	//
+	//	Primary key, encrypted when IsPrimaryKey
+	ID string
+	//	这一行要修改的字段和值
+	//
+	//	Columns and values changed on this row
+	Values map[string]string
+}
+
+// ===============
+//
+//	按行更新数据, 每行可以修改不同的字段
+//	按数据库分组后每个数据库执行一条 UPDATE ... CASE, 未修改的字段保持原值
+//	同一个ID出现多次时合并, 后面的值覆盖前面的值
+//	table			string			"表名"
+//	primaryKey		string			"主键"
+//	rows			[]RowUpdate		"每行的主键和修改的字段"
+//	Debug			*log.Logger		"调试输出"
+//	options			[]IsPrimaryKeyO		"配置"
+//		IsPrimaryKey	bool			"ID是否为加密后的主键"
+//		IsShowPrint	bool			"是否输出到控制台"
+//		Context		context.Context		"调用方的上下文"
+//	return 1		[]int64			"每个数据库更新的行数, 未更新的数据库为 -1"
+//	return 2		Errors			"错误信息"
+//
+// ===============
+//
+//	Update data by rows, each row can change different columns
+//	Rows are grouped by database and one UPDATE ... CASE is run per
+//	database, columns not changed keep their value
+//	Rows with the same ID are merged, later values override earlier ones
+//	table			string			"Table name"
+//	primaryKey		string			"Primary key"
+//	rows			[]RowUpdate		"Primary key and changed columns of each row"
+//	Debug			*log.Logger		"Debug output"
+//	options			[]IsPrimaryKeyO		"Configuration"
+//		IsPrimaryKey	bool			"Whether ID is the encrypted primary key"
+//		IsShowPrint	bool			"Whether to output to the console"
+//		Context		context.Context		"Context of the caller"
+//	return 1		[]int64			"Rows updated on each database, -1
+//							 	for databases not updated"
+//	return 2		Errors			"Error message"
+func (s *Setting) UpdateRows(table string, primaryKey string, rows []RowUpdate, Debug *log.Logger, options ...IsPrimaryKeyO) (reInt []int64, errs Errors) {
+	option := &Option{
+		IsPrimaryKey: true,
+		IsShowPrint:  false,
+	}
+	for _, o := range options {
+		o(option)
+	}
+	logger := s.logger(Debug, option.IsShowPrint)
+	ctx, span := s.startCall(option, "UpdateRows", table)
+	defer func() { endSpan(span, errs.Err()) }()
+	if !isIdentifier(table) || !isIdentifier(primaryKey) {
+		return nil, Errors{fmt.Errorf("%w: table %q or primary key %q", ErrInvalidArgument, table, primaryKey)}
+	}
+	ids := make([]string, len(rows))
+	for i, row := range rows {
+		if len(row.Values) == 0 {
+			return nil, Errors{fmt.Errorf("%w: rows[%d] has no value", ErrInvalidArgument, i)}
+		}
+		for col := range row.Values {
+			if !isIdentifier(col) || col == primaryKey {
+				return nil, Errors{fmt.Errorf("%w: rows[%d] column %q", ErrInvalidArgument, i, col)}
+			}
+		}
+		ids[i] = row.ID
+	}
+
+	var (
+		idList   [][]string
+		itemList [][]int
+	)
+	if option.IsPrimaryKey {
+		if s.SEKey == nil {
+			return nil, Errors{fmt.Errorf("%w: no key to decrypt ids", ErrInvalidKey)}
+		}
+		_, idList, itemList = s.DecryptID(primaryKey, ids)
+		n := 0
+		for _, items := range itemList {
+			n += len(items)
+		}
+		if n != len(ids) {
+			return nil, Errors{fmt.Errorf("%w: %d of %d ids cannot be decrypted", ErrInvalidKey, len(ids)-n, len(ids))}
+		}
+	} else {
+		for i := 0; i < len(s.SqlConfigs); i++ {
+			idList = append(idList, ids)
+			items := []int{}
+			for j := range ids {
+				items = append(items, j)
+			}
+			itemList = append(itemList, items)
+		}
+	}
+
+	reInt = make([]int64, len(s.SqlConfigs))
+	meta := stmtMeta{op: "update", table: table}
+	for sqlI := range reInt {
+		reInt[sqlI] = -1
+		if len(itemList[sqlI]) == 0 {
+			continue
+		}
+		shardRows := mergeRowUpdates(idList[sqlI], itemList[sqlI], rows)
+		for _, chunk := range chunkRowUpdates(shardRows) {
+			sqlStr, args := updateRowsSQL(table, primaryKey, chunk)
+			sqlStrs, shardArgs := oneShard(len(reInt), sqlI, sqlStr, args)
+			ra, shardErrs := s.execShards(ctx, meta, sqlStrs, shardArgs, logger)
+			if ra[sqlI] >= 0 {
+				if reInt[sqlI] < 0 {
+					reInt[sqlI] = 0
+				}
+				reInt[sqlI] += ra[sqlI]
+			}
+			if len(shardErrs) > 0 {
+				errs = append(errs, shardErrs...)
+				break
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return reInt, errs
+	}
+	return reInt, nil
+}
+
+// 合并一个数据库上相同ID的行, 保持第一次出现的顺序
+//
+// Merge the rows with the same ID on one database, in the order they first appear
+func mergeRowUpdates(ids []string, items []int, rows []RowUpdate) []RowUpdate {
+	merged := []RowUpdate{}
+	index := map[string]int{}
+	for j, item := range items {
+		id := ids[j]
+		k, ok := index[id]
+		if !ok {
+			k = len(merged)
+			index[id] = k
+			merged = append(merged, RowUpdate{ID: id, Values: map[string]string{}})
+		}
+		for col, v := range rows[item].Values {
+			merged[k].Values[col] = v
+		}
+	}
+	return merged
+}
+
+// 按参数数目分批, 每批不超过 maxPlaceholders
+//
+// Split into chunks by the number of arguments, at most maxPlaceholders each
+func chunkRowUpdates(rows []RowUpdate) [][]RowUpdate {
+	chunks := [][]RowUpdate{}
+	start, n := 0, 0
+	for i, row := range rows {
+		// 每个字段 WHEN ? THEN ?, 再加上 IN 中的 ?
+		// WHEN ? THEN ? for each column, plus the ? in IN
+		size := len(row.Values)*2 + 1
+		if i > start && n+size > maxPlaceholders {
+			chunks = append(chunks, rows[start:i])
+			start, n = i, 0
+		}
+		n += size
+	}
+	if start < len(rows) {
+		chunks = append(chunks, rows[start:])
+	}
+	return chunks
+}
+
+// ===============
+//
+//	生成一个数据库的 UPDATE ... CASE 指令
+//	没有修改的行使用 ELSE 保持原值
+//	table		string		"表名"
+//	primaryKey	string		"主键"
+//	rows		[]RowUpdate	"同一数据库上已解密的行"
+//	return 1	string		"SQL指令"
+//	return 2	[]interface{}	"绑定的参数"
+//
+// ===============
+//
+//	Create the UPDATE ... CASE instruction of one database
+//	Rows not changing a column keep its value through ELSE
+//	table		string		"Table name"
+//	primaryKey	string		"Primary key"
+//	rows		[]RowUpdate	"Decrypted rows on the same database"
+//	return 1	string		"SQL instruction"
+//	return 2	[]interface{}	"Bound arguments"
+func updateRowsSQL(table string, primaryKey string, rows []RowUpdate) (string, []interface{}) {
+	columns := []string{}
+	for _, row := range rows {
+		for col := range row.Values {
+			if !containsString(columns, col) {
+				columns = append(columns, col)
+			}
+		}
+	}
+	sort.Strings(columns)
+	args := []interface{}{}
+	sets := []string{}
+	for _, col := range columns {
+		set := "`" + col + "`=CASE `" + primaryKey + "`"
+		for _, row := range rows {
+			v, ok := row.Values[col]
+			if !ok {
+				continue
+			}
+			set += " WHEN ? THEN ?"
+			args = append(args, row.ID, v)
+		}
+		sets = append(sets, set+" ELSE `"+col+"` END")
+	}
+	for _, row := range rows {
+		args = append(args, row.ID)
+	}
+	sqlStr := "UPDATE `" + table + "` SET " + strings.Join(sets, ",") + " WHERE `" + primaryKey + "` IN (" + placeholders(len(rows)) + ")"
+	return sqlStr, args
+}
+
+// 只在一个数据库上执行的指令和参数
+//
+// Instruction and arguments run on only one database
+func oneShard(n int, i int, sqlStr string, args []interface{}) ([]string, [][]interface{}) {
+	sqlStrs := make([]string, n)
+	shardArgs := make([][]interface{}, n)
+	sqlStrs[i], shardArgs[i] = sqlStr, args
+	return sqlStrs, shardArgs
+}
@@ -0,0 +1,299 @@
+package weSubDatabase
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Upsert 中一行的结果
+//
+// Result of one row in Upsert
+type UpsertStatus int
+
+const (
+	//	执行失败或没有执行
+	//
+	//	Failed or not run
+	UpsertFailed UpsertStatus = iota
+	//	插入了新行
+	//
+	//	A new row was inserted
+	UpsertInserted
+	//	更新了已有的行
+	//
+	//	The existing row was updated
+	UpsertUpdated
+	//	已有的行与新值相同, 没有变化
+	//
+	//	The existing row already had the new values, nothing changed
+	UpsertUnchanged
+	//	执行成功, 多行一起执行时无法区分插入和更新
+	//
+	//	Succeeded, inserted and updated cannot be told apart when rows run together
+	UpsertApplied
+)
+
+func (u UpsertStatus) String() string {
+	switch u {
+	case UpsertFailed:
+		return "failed"
+	case UpsertInserted:
+		return "inserted"
+	case UpsertUpdated:
+		return "updated"
+	case UpsertUnchanged:
+		return "unchanged"
+	case UpsertApplied:
+		return "applied"
+	default:
+		return "unknown"
+	}
+}
+
+func (u UpsertStatus) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+// ===============
+//
+//	设置 Upsert 时更新的字段, 不设置时更新除主键外的所有字段
+//	空列表时已有的行不会被修改
+//	UpsertKeys	[]string	"更新的字段"
+//
+// ===============
+//
+//	Set the columns updated by Upsert, all columns except the primary key
+//	are updated when not set
+//	Existing rows are left untouched when the list is empty
+//	UpsertKeys	[]string	"Columns to update"
+func OUpsertKeys(UpsertKeys ...string) IsShowPrintO {
+	return func(o *Option) {
+		if UpsertKeys == nil {
+			UpsertKeys = []string{}
+		}
+		o.UpsertKeys = UpsertKeys
+	}
+}
+
+// ===============
+//
+//	设置 Upsert 每行单独执行, 以返回每行是插入, 更新还是没有变化
+//	UpsertEachRow	bool		"是否每行单独执行"
+//
+// ===============
+//
+//	Set Upsert to run each row on its own, so it returns whether every row
+//	was inserted, updated or unchanged
+//	UpsertEachRow	bool		"Whether each row runs on its own"
+func OUpsertEachRow(UpsertEachRow bool) IsShowPrintO {
+	return func(o *Option) {
+		o.UpsertEachRow = UpsertEachRow
+	}
+}
+
+// ===============
+//
+//	插入或更新: 根据加密后的主键计算数据库下标, 执行 INSERT ... ON DUPLICATE KEY UPDATE
+//	主键已存在时更新 UpsertKeys 中的字段, 否则插入新行
+//	每个数据库的行按 InsertChunkRows 和 InsertChunkBytes 分批, 每批一条多行指令,
+//	一批失败时该批的行为 UpsertFailed, 不影响其他批;
+//	多行的批无法区分每行的结果时为 UpsertApplied, 需要每行的结果时使用 OUpsertEachRow
+//	table		string			"表名"
+//	primaryKey	string			"主键"
+//	ids		[]string		"每行加密后的主键"
+//	keys		[]string		"键名, 不含主键"
+//	values		[][]string		"值"
+//	Debug		*log.Logger		"调试输出"
+//	options		[]IsShowPrintO		"配置"
+//		IsShowPrint	bool			"是否输出到控制台"
+//		Context		context.Context		"调用方的上下文"
+//		UpsertKeys	[]string		"更新的字段"
+//		UpsertEachRow	bool			"是否每行单独执行"
+//	return 1	[]UpsertStatus		"每行的结果"
+//	return 2	Errors			"错误信息"
+//
+// ===============
+//
+//	Insert or update: the database subscript is calculated from the encrypted
+//	primary key and INSERT ... ON DUPLICATE KEY UPDATE is run
+//	The columns in UpsertKeys are updated when the primary key exists,
+//	otherwise a new row is inserted
+//	The rows of each database are split into chunks by InsertChunkRows and
+//	InsertChunkBytes, each chunk is one multi-row instruction, the rows of a
+//	failed chunk are UpsertFailed and the other chunks are not affected;
+//	rows of a multi-row chunk whose results cannot be told apart are
+//	UpsertApplied, use OUpsertEachRow to get the result of every row
+//	table		string			"table name"
+//	primaryKey	string			"Primary key"
+//	ids		[]string		"Encrypted primary key of each row"
+//	keys		[]string		"key name, without the primary key"
+//	values		[][]string		"value"
+//	Debug		*log.Logger		"debug output"
+//	options		[]IsShowPrintO		"Configuration"
+//		IsShowPrint	bool			"Whether to output to the console"
+//		Context		context.Context		"Context of the caller"
+//		UpsertKeys	[]string		"Columns to update"
+//		UpsertEachRow	bool			"Whether each row runs on its own"
+//	return 1	[]UpsertStatus		"Result of each row"
+//	return 2	Errors			"Error message"
+func (s *Setting) Upsert(table string, primaryKey string, ids []string, keys []string, values [][]string, Debug *log.Logger, options ...IsShowPrintO) (status []UpsertStatus, errs Errors) {
+	option := &Option{
+		IsShowPrint: false,
+	}
+	for _, o := range options {
+		o(option)
+	}
+	logger := s.logger(Debug, option.IsShowPrint)
+	ctx, span := s.startCall(option, "Upsert", table)
+	defer func() { endSpan(span, errs.Err()) }()
+	if len(ids) != len(values) {
+		return nil, Errors{fmt.Errorf("%w: the `ids` and `values` lengths are inconsistent", ErrInvalidArgument)}
+	}
+	sqlFormat, err := upsertSQL(table, primaryKey, keys, option.UpsertKeys)
+	if err != nil {
+		return nil, Errors{err}
+	}
+	for i, val := range values {
+		if len(keys) != len(val) {
+			return nil, Errors{fmt.Errorf("%w: values[%d] and keys have different lengths", ErrInvalidArgument, i)}
+		}
+	}
+	if s.SEKey == nil {
+		return nil, Errors{fmt.Errorf("%w: no key to decrypt ids", ErrInvalidKey)}
+	}
+	dbIList, idList, itemList := s.DecryptID(primaryKey, ids)
+	n := 0
+	for _, l := range idList {
+		n += len(l)
+	}
+	if n != len(ids) {
+		return nil, Errors{fmt.Errorf("%w: %d of %d ids cannot be decrypted", ErrInvalidKey, len(ids)-n, len(ids))}
+	}
+
+	status = make([]UpsertStatus, len(values))
+	meta := stmtMeta{op: "upsert", table: table}
+	rowSQL := "(" + placeholders(len(keys)+1) + ")"
+	maxRows := s.InsertChunkRows
+	if option.UpsertEachRow {
+		maxRows = 1
+	}
+	// 每行的参数, 主键在前
+	// Arguments of each row, the primary key first
+	rows := make([][]string, len(values))
+	for sqlI := 0; sqlI < len(dbIList); sqlI++ {
+		if !dbIList[sqlI] {
+			continue
+		}
+		if !s.IsRetryConnect(sqlI) {
+			errs = append(errs, s.shardError(sqlI, "", ErrShardUnavailable))
+			continue
+		}
+		for j, item := range itemList[sqlI] {
+			rows[item] = append([]string{idList[sqlI][j]}, values[item]...)
+		}
+		for _, chunk := range chunkRows(itemList[sqlI], rows, maxRows, s.InsertChunkBytes) {
+			sqlStr := fmt.Sprintf(sqlFormat, strings.TrimSuffix(strings.Repeat(rowSQL+",", len(chunk)), ","))
+			args := make([]interface{}, 0, len(chunk)*(len(keys)+1))
+			for _, item := range chunk {
+				for _, v := range rows[item] {
+					args = append(args, v)
+				}
+			}
+			chanRA := make(chan int64)
+			chanErr := make(chan error)
+			go s.go_exec(ctx, sqlI, meta, sqlStr, args, nil, chanRA, chanErr, logger)
+			rowsAffected := <-chanRA
+			if err := <-chanErr; err != nil {
+				errs = append(errs, fmt.Errorf("ids%v: %w", chunk, err))
+				continue
+			}
+			result := upsertStatus(rowsAffected, len(chunk))
+			for _, item := range chunk {
+				status[item] = result
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return status, errs
+	}
+	return status, nil
+}
+
+// ===============
+//
+//	从一批的影响行数得出每行的结果
+//	MySQL 中插入的行计为 1, 更新的行计为 2, 没有变化的行计为 0
+//	rowsAffected	int64		"影响的行数"
+//	n		int		"这批的行数"
+//	return		UpsertStatus	"这批每行的结果"
+//
+// ===============
+//
+//	Work out the result of each row from the rows affected by a chunk
+//	MySQL counts an inserted row as 1, an updated row as 2 and an unchanged
+//	row as 0
+//	rowsAffected	int64		"Rows affected"
+//	n		int		"Rows in the chunk"
+//	return		UpsertStatus	"Result of each row of the chunk"
+func upsertStatus(rowsAffected int64, n int) UpsertStatus {
+	switch {
+	case rowsAffected == 0:
+		return UpsertUnchanged
+	case rowsAffected == 2*int64(n):
+		return UpsertUpdated
+	case n == 1 && rowsAffected == 1:
+		return UpsertInserted
+	default:
+		return UpsertApplied
+	}
+}
+
+// ===============
+//
+//	生成 INSERT ... ON DUPLICATE KEY UPDATE 指令的格式, 用 fmt.Sprintf 填入 VALUES 的行
+//	table		string		"表名"
+//	primaryKey	string		"主键"
+//	keys		[]string	"键名, 不含主键"
+//	updateKeys	[]string	"更新的字段, nil 为 keys"
+//	return 1	string		"SQL指令的格式"
+//	return 2	error		"错误信息"
+//
+// ===============
+//
+//	Create the format of the INSERT ... ON DUPLICATE KEY UPDATE instruction,
+//	the VALUES rows are filled in with fmt.Sprintf
+//	table		string		"Table name"
+//	primaryKey	string		"Primary key"
+//	keys		[]string	"Key names, without the primary key"
+//	updateKeys	[]string	"Columns to update, keys when nil"
+//	return 1	string		"Format of the SQL instruction"
+//	return 2	error		"Error message"
+func upsertSQL(table string, primaryKey string, keys []string, updateKeys []string) (string, error) {
+	for _, name := range append([]string{table, primaryKey}, keys...) {
+		if !isIdentifier(name) {
+			return "", fmt.Errorf("%w: %q is not a valid name", ErrInvalidArgument, name)
+		}
+	}
+	if containsString(keys, primaryKey) {
+		return "", fmt.Errorf("%w: `keys` must not contain the primary key %q", ErrInvalidArgument, primaryKey)
+	}
+	if updateKeys == nil {
+		updateKeys = keys
+	}
+	sets := []string{}
+	for _, k := range updateKeys {
+		if !containsString(keys, k) {
+			return "", fmt.Errorf("%w: update key %q is not in `keys`", ErrInvalidArgument, k)
+		}
+		sets = append(sets, "`"+k+"`=VALUES(`"+k+"`)")
+	}
+	if len(sets) == 0 {
+		sets = append(sets, "`"+primaryKey+"`=`"+primaryKey+"`")
+	}
+	cols := "`" + primaryKey + "`"
+	for _, k := range keys {
+		cols += ",`" + k + "`"
+	}
+	return "INSERT INTO `" + table + "` (" + cols + ") VALUES %s ON DUPLICATE KEY UPDATE " + strings.Join(sets, ","), nil
+}
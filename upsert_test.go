@@ -0,0 +1,50 @@
+package weSubDatabase
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUpsertSQL(t *testing.T) {
+	sqlStr, err := upsertSQL("data", "id", []string{"name", "time"}, nil)
+	if err != nil || sqlStr != "INSERT INTO `data` (`id`,`name`,`time`) VALUES %s ON DUPLICATE KEY UPDATE `name`=VALUES(`name`),`time`=VALUES(`time`)" {
+		t.Error("all keys:", sqlStr, err)
+	}
+	sqlStr, err = upsertSQL("data", "id", []string{"name", "time"}, []string{"time"})
+	if err != nil || sqlStr != "INSERT INTO `data` (`id`,`name`,`time`) VALUES %s ON DUPLICATE KEY UPDATE `time`=VALUES(`time`)" {
+		t.Error("update keys:", sqlStr, err)
+	}
+	sqlStr, err = upsertSQL("data", "id", []string{"name"}, []string{})
+	if err != nil || sqlStr != "INSERT INTO `data` (`id`,`name`) VALUES %s ON DUPLICATE KEY UPDATE `id`=`id`" {
+		t.Error("insert only:", sqlStr, err)
+	}
+	for name, keys := range map[string][][]string{
+		"primary key": {{"id", "name"}, nil},
+		"unknown key": {{"name"}, {"time"}},
+		"bad name":    {{"name`=1"}, nil},
+	} {
+		if _, err := upsertSQL("data", "id", keys[0], keys[1]); !errors.Is(err, ErrInvalidArgument) {
+			t.Error(name, err)
+		}
+	}
+	for _, c := range []struct {
+		rowsAffected int64
+		n            int
+		want         UpsertStatus
+	}{
+		{1, 1, UpsertInserted},
+		{2, 1, UpsertUpdated},
+		{0, 1, UpsertUnchanged},
+		{0, 3, UpsertUnchanged},
+		{6, 3, UpsertUpdated},
+		{3, 3, UpsertApplied},
+		{4, 3, UpsertApplied},
+	} {
+		if got := upsertStatus(c.rowsAffected, c.n); got != c.want {
+			t.Error("upsertStatus:", c.rowsAffected, c.n, got)
+		}
+	}
+	if UpsertUpdated.String() != "updated" {
+		t.Error("string:", UpsertUpdated)
+	}
+}
@@ -0,0 +1,311 @@
+package weSubDatabase
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Update 检查的版本字段的类型
+//
+// Kind of the version column checked by Update
+type VersionMode int
+
+const (
+	//	整数版本号, 更新时加 1
+	//
+	//	Integer version, 1 is added on update
+	VersionCounter VersionMode = iota
+	//	更新时间, 更新时写入 NOW(6)
+	//	字段必须是 DATETIME(6) 或 TIMESTAMP(6), 精度更低时同一秒内的两次更新
+	//	无法区分, Update 先检查字段的精度, 不满足时返回 ErrInvalidArgument
+	//
+	//	Update time, NOW(6) is written on update
+	//	The column must be DATETIME(6) or TIMESTAMP(6), with a coarser
+	//	precision two updates within the same second cannot be told apart,
+	//	Update checks the precision of the column first and returns
+	//	ErrInvalidArgument when it is not met
+	VersionTimestamp
+)
+
+// ===============
+//
+//	乐观锁: Update 只更新版本与 Versions 一致的行, 并把版本号加 1
+//	版本不一致的行返回 ErrVersionConflict
+//	Column		string		"版本字段, 如 version"
+//	Versions	[]string	"与 ids 对应的当前版本"
+//
+// ===============
+//
+//	Optimistic locking: Update only changes rows whose version matches
+//	Versions, and adds 1 to the version
+//	Rows whose version does not match return ErrVersionConflict
+//	Column		string		"Version column, such as version"
+//	Versions	[]string	"Current versions matching ids"
+func OIPKVersion(Column string, Versions []string) IsPrimaryKeyO {
+	return func(o *Option) {
+		o.VersionColumn = Column
+		o.VersionMode = VersionCounter
+		o.Versions = Versions
+	}
+}
+
+// ===============
+//
+//	乐观锁: Update 只更新更新时间与 Versions 一致的行, 并写入当前时间
+//	版本不一致的行返回 ErrVersionConflict
+//	Column		string		"更新时间字段, 如 updated_at, 必须是 DATETIME(6)
+//					 	或 TIMESTAMP(6)"
+//	Versions	[]string	"与 ids 对应的当前更新时间"
+//
+// ===============
+//
+//	Optimistic locking: Update only changes rows whose update time matches
+//	Versions, and writes the current time
+//	Rows whose version does not match return ErrVersionConflict
+//	Column		string		"Update time column, such as updated_at,
+//					 	it must be DATETIME(6) or TIMESTAMP(6)"
+//	Versions	[]string	"Current update times matching ids"
+func OIPKUpdatedAt(Column string, Versions []string) IsPrimaryKeyO {
+	return func(o *Option) {
+		o.VersionColumn = Column
+		o.VersionMode = VersionTimestamp
+		o.Versions = Versions
+	}
+}
+
+// ===============
+//
+//	接收版本不一致的 ids
+//	Conflicts	*[]string	"Update 结束后写入版本不一致的 ids"
+//
+// ===============
+//
+//	Receive the ids whose version did not match
+//	Conflicts	*[]string	"The ids whose version did not match are
+//								written after Update"
+func OIPKConflicts(Conflicts *[]string) IsPrimaryKeyO {
+	return func(o *Option) {
+		o.Conflicts = Conflicts
+	}
+}
+
+// ===============
+//
+//	生成一行的带版本检查的更新指令
+//	table		string		"表名"
+//	key		[]string	"字段名"
+//	forKey		string		"主键"
+//	column		string		"版本字段"
+//	mode		VersionMode	"版本字段的类型"
+//	return 1	string		"SQL指令, 参数依次为字段值, 主键, 当前版本"
+//	return 2	error		"错误信息"
+//
+// ===============
+//
+//	Create the update instruction of one row with the version check
+//	table		string		"Table name"
+//	key		[]string	"Field names"
+//	forKey		string		"Primary key"
+//	column		string		"Version column"
+//	mode		VersionMode	"Kind of the version column"
+//	return 1	string		"SQL instruction, the arguments are the
+//					 	values, the primary key and the current version"
+//	return 2	error		"Error message"
+func versionSQL(table string, key []string, forKey string, column string, mode VersionMode) (string, error) {
+	for _, name := range append([]string{forKey, column}, key...) {
+		if !isIdentifier(name) {
+			return "", fmt.Errorf("%w: %q is not a valid name", ErrInvalidArgument, name)
+		}
+	}
+	if containsString(key, column) {
+		return "", fmt.Errorf("%w: the version column %q is set by Update", ErrInvalidArgument, column)
+	}
+	sets := []string{}
+	for _, k := range key {
+		sets = append(sets, "`"+k+"`=?")
+	}
+	if mode == VersionTimestamp {
+		sets = append(sets, "`"+column+"`=NOW(6)")
+	} else {
+		sets = append(sets, "`"+column+"`=`"+column+"`+1")
+	}
+	return "UPDATE `" + table + "` SET " + strings.Join(sets, ",") + " WHERE `" + forKey + "`=? AND `" + column + "`=?", nil
+}
+
+// ===============
+//
+//	检查更新时间字段是 DATETIME(6) 或 TIMESTAMP(6)
+//	ctx		context.Context	"父 span 的上下文"
+//	i		int		"数据库下标"
+//	table		string		"表名"
+//	column		string		"更新时间字段"
+//	logger		*slog.Logger	"日志对象"
+//	return		error		"错误信息, 类型或精度不满足时为 ErrInvalidArgument"
+//
+// ===============
+//
+//	Check that the update time column is DATETIME(6) or TIMESTAMP(6)
+//	ctx		context.Context	"Context of the parent span"
+//	i		int		"Database index"
+//	table		string		"Table name"
+//	column		string		"Update time column"
+//	logger		*slog.Logger	"Logger"
+//	return		error		"Error message, ErrInvalidArgument when the
+//					 	type or the precision is not met"
+func (s *Setting) checkTimestampColumn(ctx context.Context, i int, table string, column string, logger *slog.Logger) error {
+	reqd := make(chan []map[string]string)
+	reerr := make(chan error)
+	go s.go_query(ctx, i, stmtMeta{op: "query", table: "information_schema"}, "SELECT DATA_TYPE AS type, DATETIME_PRECISION AS `precision` FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=? AND COLUMN_NAME=?", []interface{}{table, column}, reqd, reerr, true, logger)
+	qd := <-reqd
+	if err := <-reerr; err != nil {
+		return err
+	}
+	if len(qd) == 0 {
+		return s.shardError(i, "", fmt.Errorf("%w: column %q of table %q not found", ErrInvalidArgument, column, table))
+	}
+	return s.shardError(i, "", timestampPrecision(column, qd[0]["type"], qd[0]["precision"]))
+}
+
+// 字段类型不是 DATETIME 或 TIMESTAMP, 或小数秒精度小于 6 时返回 ErrInvalidArgument
+//
+// ErrInvalidArgument is returned when the column type is not DATETIME or
+// TIMESTAMP or its fractional seconds precision is below 6
+func timestampPrecision(column string, dataType string, precision string) error {
+	if t := strings.ToLower(dataType); t != "datetime" && t != "timestamp" {
+		return fmt.Errorf("%w: the update time column %q is %s, not DATETIME(6) or TIMESTAMP(6)", ErrInvalidArgument, column, dataType)
+	}
+	if precision != "6" {
+		return fmt.Errorf("%w: the update time column %q has precision %q, DATETIME(6) or TIMESTAMP(6) is needed", ErrInvalidArgument, column, precision)
+	}
+	return nil
+}
+
+// ===============
+//
+//	逐行执行带版本检查的更新
+//	每个数据库上影响的行数相加, 没有任何数据库更新且没有出错的行为版本不一致
+//	VersionTimestamp 时先检查每个数据库上更新时间字段的精度
+//	ctx		context.Context	"父 span 的上下文"
+//	table		string		"表名"
+//	key		[]string	"字段名"
+//	value		[][]string	"值, value[字段][行]"
+//	forKey		string		"主键"
+//	ids		[]string	"调用方传入的主键"
+//	dbIList		[]bool		"需要更新的数据库"
+//	idList		[][]string	"每个数据库上的主键"
+//	itemList	[][]int		"每个数据库上的主键在 ids 中的位置"
+//	option		*Option		"配置"
+//	logger		*slog.Logger	"日志对象"
+//	return 1	[]int64		"每个数据库更新的行数, 未更新的数据库为 -1"
+//	return 2	Errors		"错误信息"
+//
+// ===============
+//
+//	Run the update with the version check row by row
+//	Rows affected on each database are added up, a row that no database
+//	updated and that had no error is a version conflict
+//	With VersionTimestamp the precision of the update time column is checked
+//	on each database first
+//	ctx		context.Context	"Context of the parent span"
+//	table		string		"Table name"
+//	key		[]string	"Field names"
+//	value		[][]string	"Values, value[field][row]"
+//	forKey		string		"Primary key"
+//	ids		[]string	"Primary keys passed by the caller"
+//	dbIList		[]bool		"Databases to update"
+//	idList		[][]string	"Primary keys on each database"
+//	itemList	[][]int		"Location in ids of the primary keys on each database"
+//	option		*Option		"Configuration"
+//	logger		*slog.Logger	"Logger"
+//	return 1	[]int64		"Rows updated on each database, -1 for
+//					 	databases not updated"
+//	return 2	Errors		"Error message"
+func (s *Setting) updateVersioned(ctx context.Context, table string, key []string, value [][]string, forKey string, ids []string, dbIList []bool, idList [][]string, itemList [][]int, option *Option, logger *slog.Logger) (reInt []int64, errs Errors) {
+	if len(option.Versions) != len(ids) {
+		return nil, Errors{fmt.Errorf("%w: inconsistent quantity of 'ids' and 'Versions'", ErrInvalidArgument)}
+	}
+	sqlStr, err := versionSQL(table, key, forKey, option.VersionColumn, option.VersionMode)
+	if err != nil {
+		return nil, Errors{err}
+	}
+	meta := stmtMeta{op: "update", table: table}
+	updated := make([]bool, len(ids))
+	failed := make([]bool, len(ids))
+	routed := make([]bool, len(ids))
+	for _, items := range itemList {
+		for _, item := range items {
+			routed[item] = true
+		}
+	}
+	for item, ok := range routed {
+		if !ok {
+			failed[item] = true
+			errs = append(errs, fmt.Errorf("%w: ids[%d] has no database", ErrInvalidKey, item))
+		}
+	}
+	for i := 0; i < len(s.SqlConfigs); i++ {
+		reInt = append(reInt, -1)
+	}
+	for sqlI := 0; sqlI < len(s.SqlConfigs); sqlI++ {
+		if !dbIList[sqlI] {
+			continue
+		}
+		if !s.IsRetryConnect(sqlI) {
+			errs = append(errs, s.shardError(sqlI, "", ErrShardUnavailable))
+			for _, item := range itemList[sqlI] {
+				failed[item] = true
+			}
+			continue
+		}
+		if option.VersionMode == VersionTimestamp {
+			if err := s.checkTimestampColumn(ctx, sqlI, table, option.VersionColumn, logger); err != nil {
+				errs = append(errs, err)
+				for _, item := range itemList[sqlI] {
+					failed[item] = true
+				}
+				continue
+			}
+		}
+		reInt[sqlI] = 0
+		for j, item := range itemList[sqlI] {
+			args := []interface{}{}
+			for k := range key {
+				args = append(args, value[k][item])
+			}
+			args = append(args, idList[sqlI][j], option.Versions[item])
+			chanRA := make(chan int64)
+			chanErr := make(chan error)
+			go s.go_exec(ctx, sqlI, meta, sqlStr, args, nil, chanRA, chanErr, logger)
+			rowsAffected := <-chanRA
+			if err := <-chanErr; err != nil {
+				errs = append(errs, err)
+				failed[item] = true
+				continue
+			}
+			reInt[sqlI] += rowsAffected
+			if rowsAffected > 0 {
+				updated[item] = true
+			}
+		}
+	}
+	conflicts := []string{}
+	for item, id := range ids {
+		if updated[item] || failed[item] {
+			continue
+		}
+		conflicts = append(conflicts, id)
+		errs = append(errs, fmt.Errorf("%w: ids[%d] %s", ErrVersionConflict, item, id))
+	}
+	if option.Conflicts != nil {
+		*option.Conflicts = conflicts
+	}
+	if len(conflicts) > 0 {
+		logger.Info("mysql update version conflicts", "table", table, "count", len(conflicts))
+	}
+	if len(errs) > 0 {
+		return reInt, errs
+	}
+	return reInt, nil
+}
@@ -0,0 +1,268 @@
+package weSubDatabase
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// ===============
+//
+//	UpdateWhere 和 DeleteWhere 只统计每个数据库上匹配的行数, 不修改
+//	DryRun		bool	"是否只统计"
+//
+// ===============
+//
+//	UpdateWhere and DeleteWhere only count the matching rows on each
+//	database without changing them
+//	DryRun		bool	"Whether to only count"
+func ODryRun(DryRun bool) IsShowPrintO {
+	return func(o *Option) {
+		o.DryRun = DryRun
+	}
+}
+
+// ===============
+//
+//	允许 UpdateWhere 和 DeleteWhere 使用空的条件, 即修改整张表
+//	AllowEmptyWhere	bool	"是否允许空的条件"
+//
+// ===============
+//
+//	Allow UpdateWhere and DeleteWhere to run with an empty condition, which
+//	changes the whole table
+//	AllowEmptyWhere	bool	"Whether an empty condition is allowed"
+func OAllowEmptyWhere(AllowEmptyWhere bool) IsShowPrintO {
+	return func(o *Option) {
+		o.AllowEmptyWhere = AllowEmptyWhere
+	}
+}
+
+// ===============
+//
+//	按条件更新所有数据库
+//	table		string			"表名"
+//	keys		[]string		"字段名"
+//	values		[]string		"值"
+//	where		string			"条件, 值用 ? 代替"
+//	args		[]interface{}		"条件绑定的参数"
+//	Debug		*log.Logger		"调试输出"
+//	options		[]IsShowPrintO		"配置"
+//		IsShowPrint	bool			"是否输出到控制台"
+//		Context		context.Context		"调用方的上下文"
+//		DryRun		bool			"只统计匹配的行数"
+//		AllowEmptyWhere	bool			"是否允许空的条件"
+//		WithDeleted	bool			"是否包含软删除的行"
+//	return 1	[]int64			"每个数据库更新 (DryRun 时为匹配) 的行数, 未执行的数据库为 -1"
+//	return 2	Errors			"错误信息"
+//
+// ===============
+//
+//	Update all databases by a condition
+//	table		string			"Table name"
+//	keys		[]string		"Field names"
+//	values		[]string		"Values"
+//	where		string			"Condition, values are replaced by ?"
+//	args		[]interface{}		"Arguments bound to the condition"
+//	Debug		*log.Logger		"Debug output"
+//	options		[]IsShowPrintO		"Configuration"
+//		IsShowPrint	bool			"Whether to output to the console"
+//		Context		context.Context		"Context of the caller"
+//		DryRun		bool			"Only count the matching rows"
+//		AllowEmptyWhere	bool			"Whether an empty condition is allowed"
+//		WithDeleted	bool			"Whether to include soft-deleted rows"
+//	return 1	[]int64			"Rows updated (matching with DryRun) on
+//						 	each database, -1 for databases not run"
+//	return 2	Errors			"Error message"
+func (s *Setting) UpdateWhere(table string, keys []string, values []string, where string, args []interface{}, Debug *log.Logger, options ...IsShowPrintO) (reInt []int64, errs Errors) {
+	option := &Option{
+		IsShowPrint: false,
+	}
+	for _, o := range options {
+		o(option)
+	}
+	logger := s.logger(Debug, option.IsShowPrint)
+	ctx, span := s.startCall(option, "UpdateWhere", table)
+	defer func() { endSpan(span, errs.Err()) }()
+	if len(keys) == 0 || len(keys) != len(values) {
+		return nil, Errors{fmt.Errorf("%w: inconsistent quantity of 'keys' and 'values'", ErrInvalidArgument)}
+	}
+	sets := []string{}
+	setArgs := []interface{}{}
+	for i, k := range keys {
+		if !isIdentifier(k) {
+			return nil, Errors{fmt.Errorf("%w: %q is not a valid name", ErrInvalidArgument, k)}
+		}
+		sets = append(sets, "`"+k+"`=?")
+		setArgs = append(setArgs, values[i])
+	}
+	return s.execWhere(ctx, stmtMeta{op: "update", table: table}, "UPDATE `"+table+"` SET "+strings.Join(sets, ","), setArgs, where, args, option, logger)
+}
+
+// ===============
+//
+//	按条件删除所有数据库中的行, 表设置了软删除时只标记删除
+//	table		string			"表名"
+//	where		string			"条件, 值用 ? 代替"
+//	args		[]interface{}		"条件绑定的参数"
+//	Debug		*log.Logger		"调试输出"
+//	options		[]IsShowPrintO		"配置"
+//		IsShowPrint	bool			"是否输出到控制台"
+//		Context		context.Context		"调用方的上下文"
+//		DryRun		bool			"只统计匹配的行数"
+//		AllowEmptyWhere	bool			"是否允许空的条件"
+//	return 1	[]int64			"每个数据库删除 (DryRun 时为匹配) 的行数, 未执行的数据库为 -1"
+//	return 2	Errors			"错误信息"
+//
+// ===============
+//
+//	Delete the rows of all databases by a condition, rows are only marked
+//	as deleted when the table has soft delete
+//	table		string			"Table name"
+//	where		string			"Condition, values are replaced by ?"
+//	args		[]interface{}		"Arguments bound to the condition"
+//	Debug		*log.Logger		"Debug output"
+//	options		[]IsShowPrintO		"Configuration"
+//		IsShowPrint	bool			"Whether to output to the console"
+//		Context		context.Context		"Context of the caller"
+//		DryRun		bool			"Only count the matching rows"
+//		AllowEmptyWhere	bool			"Whether an empty condition is allowed"
+//	return 1	[]int64			"Rows deleted (matching with DryRun) on
+//						 	each database, -1 for databases not run"
+//	return 2	Errors			"Error message"
+func (s *Setting) DeleteWhere(table string, where string, args []interface{}, Debug *log.Logger, options ...IsShowPrintO) (reInt []int64, errs Errors) {
+	option := &Option{
+		IsShowPrint: false,
+	}
+	for _, o := range options {
+		o(option)
+	}
+	logger := s.logger(Debug, option.IsShowPrint)
+	ctx, span := s.startCall(option, "DeleteWhere", table)
+	defer func() { endSpan(span, errs.Err()) }()
+	if sd, ok := s.GetSoftDelete(table); ok {
+		option.WithDeleted = false
+		return s.execWhere(ctx, stmtMeta{op: "delete", table: table}, "UPDATE `"+table+"` SET "+sd.deleted(), nil, where, args, option, logger)
+	}
+	return s.execWhere(ctx, stmtMeta{op: "delete", table: table}, "DELETE FROM `"+table+"`", nil, where, args, option, logger)
+}
+
+// ===============
+//
+//	检查条件后向所有数据库分发 head WHERE where, DryRun 时改为统计匹配的行数
+//	ctx		context.Context	"父 span 的上下文"
+//	meta		stmtMeta	"语句的描述"
+//	head		string		"WHERE 之前的SQL指令"
+//	headArgs	[]interface{}	"head 绑定的参数"
+//	where		string		"条件"
+//	args		[]interface{}	"条件绑定的参数"
+//	option		*Option		"配置, WithDeleted 为 false 时排除软删除的行"
+//	logger		*slog.Logger	"日志对象"
+//	return 1	[]int64		"每个数据库影响或匹配的行数, 未执行的数据库为 -1"
+//	return 2	Errors		"错误信息"
+//
+// ===============
+//
+//	Check the condition and fan head WHERE where out to all databases, the
+//	matching rows are counted instead with DryRun
+//	ctx		context.Context	"Context of the parent span"
+//	meta		stmtMeta	"Description of the statement"
+//	head		string		"SQL instruction before WHERE"
+//	headArgs	[]interface{}	"Arguments bound to head"
+//	where		string		"Condition"
+//	args		[]interface{}	"Arguments bound to the condition"
+//	option		*Option		"Configuration, soft-deleted rows are left
+//					 	out when WithDeleted is false"
+//	logger		*slog.Logger	"Logger"
+//	return 1	[]int64		"Rows affected or matching on each database,
+//					 	-1 for databases not run"
+//	return 2	Errors		"Error message"
+func (s *Setting) execWhere(ctx context.Context, meta stmtMeta, head string, headArgs []interface{}, where string, args []interface{}, option *Option, logger *slog.Logger) ([]int64, Errors) {
+	table := meta.table
+	if !isIdentifier(table) {
+		return nil, Errors{fmt.Errorf("%w: table %q", ErrInvalidArgument, table)}
+	}
+	where = strings.TrimSpace(where)
+	if where == "" && !option.AllowEmptyWhere {
+		return nil, Errors{fmt.Errorf("%w: empty condition, use OAllowEmptyWhere to change the whole table", ErrInvalidArgument)}
+	}
+	if n := countPlaceholders(where); n != len(args) {
+		return nil, Errors{fmt.Errorf("%w: %q has %d placeholders but %d arguments", ErrInvalidArgument, where, n, len(args))}
+	}
+	where = s.aliveWhere(table, where, option)
+	if where != "" {
+		where = " WHERE " + where
+	}
+	sqlStrs := make([]string, len(s.SqlConfigs))
+	shardArgs := make([][]interface{}, len(s.SqlConfigs))
+	if option.DryRun {
+		for i := range sqlStrs {
+			sqlStrs[i] = "SELECT COUNT(*) AS count FROM `" + table + "`" + where
+			shardArgs[i] = args
+		}
+		return s.countShards(ctx, stmtMeta{op: "query", table: table}, sqlStrs, shardArgs, logger)
+	}
+	logger.Debug("mysql conditional write", "op", meta.op, "table", table, "where", redactSQL(where))
+	for i := range sqlStrs {
+		sqlStrs[i] = head + where
+		shardArgs[i] = append(append([]interface{}{}, headArgs...), args...)
+	}
+	return s.execShards(ctx, meta, sqlStrs, shardArgs, logger)
+}
+
+// ===============
+//
+//	在主库上执行 COUNT 查询
+//	ctx		context.Context	"父 span 的上下文"
+//	meta		stmtMeta	"语句的描述"
+//	sqlStrs		[]string	"每个数据库的SQL指令, 结果字段为 count"
+//	args		[][]interface{}	"每个数据库绑定的参数"
+//	logger		*slog.Logger	"日志对象"
+//	return 1	[]int64		"每个数据库的计数, 未执行的数据库为 -1"
+//	return 2	Errors		"错误信息"
+//
+// ===============
+//
+//	Run COUNT queries on the primaries
+//	ctx		context.Context	"Context of the parent span"
+//	meta		stmtMeta	"Description of the statement"
+//	sqlStrs		[]string	"SQL instruction of each database, the result
+//					 	column is count"
+//	args		[][]interface{}	"Arguments bound on each database"
+//	logger		*slog.Logger	"Logger"
+//	return 1	[]int64		"Count of each database, -1 for databases not run"
+//	return 2	Errors		"Error message"
+func (s *Setting) countShards(ctx context.Context, meta stmtMeta, sqlStrs []string, args [][]interface{}, logger *slog.Logger) ([]int64, Errors) {
+	var errs Errors
+	counts := make([]int64, len(sqlStrs))
+	for i, sqlStr := range sqlStrs {
+		counts[i] = -1
+		if sqlStr == "" {
+			continue
+		}
+		if !s.IsRetryConnect(i) {
+			errs = append(errs, s.shardError(i, "", ErrShardUnavailable))
+			continue
+		}
+		reqd := make(chan []map[string]string)
+		reerr := make(chan error)
+		go s.go_query(ctx, i, meta, sqlStr, args[i], reqd, reerr, true, logger)
+		qd := <-reqd
+		if err := <-reerr; err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if len(qd) == 1 {
+			if count, err := strconv.ParseInt(qd[0]["count"], 10, 64); err == nil {
+				counts[i] = count
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return counts, errs
+	}
+	return counts, nil
+}
@@ -0,0 +1,25 @@
+package weSubDatabase
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWhereGuards(t *testing.T) {
+	s := &Setting{SqlConfigs: make([]SQLConfig, 2)}
+	if _, errs := s.DeleteWhere("data", " ", nil, nil); !errors.Is(errs, ErrInvalidArgument) {
+		t.Error("empty where:", errs)
+	}
+	if _, errs := s.UpdateWhere("data", []string{"status"}, []string{"x"}, "", nil, nil); !errors.Is(errs, ErrInvalidArgument) {
+		t.Error("empty where on update:", errs)
+	}
+	if _, errs := s.DeleteWhere("data", "`created` < ? AND `status` = '?'", []interface{}{1, 2}, nil); !errors.Is(errs, ErrInvalidArgument) {
+		t.Error("placeholders:", errs)
+	}
+	if _, errs := s.UpdateWhere("data", []string{"status`=1"}, []string{"x"}, "`id`=?", []interface{}{1}, nil); !errors.Is(errs, ErrInvalidArgument) {
+		t.Error("bad key:", errs)
+	}
+	if _, errs := s.DeleteWhere("data`", "`id`=?", []interface{}{1}, nil); !errors.Is(errs, ErrInvalidArgument) {
+		t.Error("bad table:", errs)
+	}
+}